@@ -8,22 +8,37 @@ package gotinydb
 
 import (
 	"archive/zip"
+	"bytes"
 	"compress/flate"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/protos"
 )
 
 // Open simply opens a new or existing database
 func Open(ctx context.Context, options *Options) (*DB, error) {
 	d := new(DB)
 	d.options = options
+	d.compaction = options.compactionPolicy()
 	d.ctx = ctx
+	d.replicationCtx, d.replicationStop = context.WithCancel(ctx)
+	d.events = new(eventBus)
+	d.diskSpace = &diskSpace{options: options, events: d.events}
+	if options.ReadOnly {
+		d.diskSpace.refused = ErrReadOnly
+	}
+	d.workers = newWorkerPool(ctx, options.WorkerPool)
 
 	if err := d.buildPath(); err != nil {
 		return nil, err
@@ -32,18 +47,87 @@ func Open(ctx context.Context, options *Options) (*DB, error) {
 	if initBadgerErr := d.initBadger(); initBadgerErr != nil {
 		return nil, initBadgerErr
 	}
+	if err := d.initEncryption(); err != nil {
+		d.valueStore.Close()
+		return nil, err
+	}
+	if err := d.initChangeLog(); err != nil {
+		d.valueStore.Close()
+		return nil, err
+	}
+	if options.Metrics != nil {
+		m, err := newMetrics(d, options.Metrics)
+		if err != nil {
+			d.valueStore.Close()
+			return nil, err
+		}
+		d.metrics = m
+	}
 	if loadErr := d.loadCollections(); loadErr != nil {
 		return nil, loadErr
 	}
+	if !options.ReadOnly {
+		if err := d.replayTxIntents(); err != nil {
+			d.Close()
+			return nil, err
+		}
+		for _, c := range d.getCollections() {
+			if err := c.sweepPendingStreams(); err != nil {
+				d.Close()
+				return nil, err
+			}
+		}
+	}
+	if options.Restore != nil && len(d.collections) == 0 {
+		if err := d.ReadBackup(ctx, options.Restore); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
 
 	go d.waitForClose()
+	// The read only databases are not cleaned
+	if !options.ReadOnly {
+		go d.expirationLoop()
+		if options.HistoryRetention > 0 {
+			go d.historyRetentionLoop()
+		}
+		if d.compaction != nil {
+			go d.compactionLoop(d.compaction)
+		}
+	}
+	if options.BackupSchedule != nil && options.BackupSchedule.Interval > 0 && options.BackupSchedule.Target != nil {
+		go d.backupLoop(options.BackupSchedule)
+	}
 
 	return d, nil
 }
 
-// Use build or get a Collection pointer
-func (d *DB) Use(colName string) (*Collection, error) {
-	for _, col := range d.collections {
+// Use build or get a Collection pointer.
+// The collections of the data packs attached with AttachPack are returned read only.
+// The options if given are applied with Collection.SetOptions.
+func (d *DB) Use(colName string, options ...*CollectionOptions) (*Collection, error) {
+	// The hash shards are only used through their HashShardedCollection
+	if strings.Contains(colName, hashShardSeparator) {
+		return nil, ErrCollectionName
+	}
+
+	c, err := d.use(colName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		if err := c.SetOptions(option); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// use gets the collection or builds it with the given ID, a new one if empty
+func (d *DB) use(colName, colID string) (*Collection, error) {
+	for _, col := range d.getCollections() {
 		if col.name == colName {
 			if err := col.loadIndex(); err != nil {
 				return nil, err
@@ -52,7 +136,30 @@ func (d *DB) Use(colName string) (*Collection, error) {
 		}
 	}
 
-	c, loadErr := d.getCollection("", colName)
+	if colName == "" {
+		return nil, fmt.Errorf("name and ID can't be empty")
+	}
+	if c := d.packCollection(colName); c != nil {
+		return c, nil
+	}
+	if d.options.ReadOnly {
+		return nil, ErrNotFound
+	}
+
+	d.collectionsMutex.Lock()
+	defer d.collectionsMutex.Unlock()
+
+	// An other call may have built it meanwhile
+	for _, col := range d.collections {
+		if col.name == colName {
+			return col, nil
+		}
+	}
+	if colID == "" {
+		colID = d.newCollectionID(colName)
+	}
+
+	c, loadErr := d.getCollection(colID, colName)
 	if loadErr != nil {
 		return nil, loadErr
 	}
@@ -65,11 +172,24 @@ func (d *DB) Use(colName string) (*Collection, error) {
 	return c, nil
 }
 
+// Collections returns the collections of the database with their indexes
+func (d *DB) Collections() []CollectionInfo {
+	collections := d.getCollections()
+	ret := make([]CollectionInfo, len(collections))
+	for i, col := range collections {
+		ret[i] = CollectionInfo{
+			Name:    col.name,
+			Indexes: col.Indexes(),
+		}
+	}
+	return ret
+}
+
 // SetOptions update the database configurations
 func (d *DB) SetOptions(options *Options) error {
 	d.options = options
 
-	for _, col := range d.collections {
+	for _, col := range d.getCollections() {
 		col.options = options
 		for _, index := range col.indexes {
 			index.options = options
@@ -78,6 +198,15 @@ func (d *DB) SetOptions(options *Options) error {
 	return nil
 }
 
+// BlindToken returns the token to save in place of the clear value for the fields
+// indexed by a blind token index. It uses the BlindTokenKey of the options.
+func (d *DB) BlindToken(value string) (string, error) {
+	if d.options.BlindTokenKey == nil {
+		return "", ErrMissingBlindTokenKey
+	}
+	return blindToken(d.options.BlindTokenKey, value), nil
+}
+
 // Close close the underneath collections and main store
 func (d *DB) Close() error {
 	if d.closing {
@@ -85,13 +214,30 @@ func (d *DB) Close() error {
 	}
 	d.closing = true
 
+	// The replication does not use the stores anymore once they are closed
+	d.replicationStop()
+	d.replicationWaitGroup.Wait()
+	d.metrics.unregister()
+
 	errors := ""
+	for _, archive := range d.attachedArchives() {
+		if err := archive.Detach(); err != nil {
+			errors = fmt.Sprintf("%s%s\n", errors, err.Error())
+		}
+	}
+	for _, pack := range d.attachedPacks() {
+		if err := pack.Detach(); err != nil {
+			errors = fmt.Sprintf("%s%s\n", errors, err.Error())
+		}
+	}
+	d.collectionsMutex.Lock()
 	for i, col := range d.collections {
 		if err := col.db.Close(); err != nil {
 			errors = fmt.Sprintf("%s%s\n", errors, err.Error())
 		}
 		d.collections[i] = nil
 	}
+	d.collectionsMutex.Unlock()
 
 	if d.valueStore != nil {
 		err := d.valueStore.Close()
@@ -100,21 +246,148 @@ func (d *DB) Close() error {
 		}
 	}
 
+	// The readers of the events are not left waiting for events which will never come
+	d.events.close()
+
 	if errors != "" {
-		return fmt.Errorf(errors)
+		return fmt.Errorf("%s", errors)
 	}
 
 	d.options.Path = ""
 	d.valueStore = nil
+	d.collectionsMutex.Lock()
 	d.collections = nil
+	d.collectionsMutex.Unlock()
 
 	d = nil
 	return nil
 }
 
+// RenameCollection changes the name of the collection. The collection keeps its ID,
+// so its files, its values, its indexes and their history are not moved and the
+// rename is done in one transaction. A new collection with the old name gets an other ID.
+func (d *DB) RenameCollection(oldName, newName string) error {
+	if newName == "" {
+		return fmt.Errorf("name and ID can't be empty")
+	}
+
+	// No collection can be built with the new name meanwhile
+	d.collectionsMutex.Lock()
+	defer d.collectionsMutex.Unlock()
+
+	var c *Collection
+	for _, col := range d.collections {
+		switch col.name {
+		case newName:
+			return ErrCollectionExists
+		case oldName:
+			c = col
+		}
+	}
+	if c == nil {
+		return ErrNotFound
+	}
+
+	if err := c.updateIndex(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("config")).Put([]byte("name"), []byte(newName))
+	}); err != nil {
+		return err
+	}
+
+	c.name = newName
+	return nil
+}
+
+// CopyCollection creates the collection dst with the same indexes as src and copies
+// the documents of src matching the filters of the query, or all of them if q is nil.
+// The documents are read from the store, so the limits and the order of the query are not used.
+// The documents are written in batches of CopyBatchSize. The extractor indexes
+// which have not been set again since the database was opened are not copied.
+// If the copy fails dst is deleted.
+func (d *DB) CopyCollection(src, dst string, q *Query) error {
+	var srcCol *Collection
+	for _, col := range d.getCollections() {
+		switch col.name {
+		case dst:
+			return ErrCollectionExists
+		case src:
+			srcCol = col
+		}
+	}
+	if srcCol == nil {
+		return ErrNotFound
+	}
+
+	dstCol, useErr := d.Use(dst)
+	if useErr != nil {
+		return useErr
+	}
+
+	if err := copyCollection(srcCol, dstCol, q); err != nil {
+		if deleteErr := d.DeleteCollection(dst); deleteErr != nil {
+			d.options.log(LogError, "the partial copy can't be deleted", "collection", dst, "error", deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// copyCollection copies the indexes and the documents of src into dst
+func copyCollection(srcCol, dstCol *Collection, q *Query) error {
+	for _, index := range srcCol.indexes {
+		var err error
+		if index.Extractor {
+			if index.extractor == nil {
+				continue
+			}
+			err = dstCol.SetExtractorIndex(index.Name, index.Type, index.extractor)
+		} else {
+			err = dstCol.SetIndexWithOptions(index.Name, index.Type, index.getOptions(), index.Selector...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	batch := dstCol.NewBatch()
+	iter := srcCol.Iterate(IterOptions{})
+	defer iter.Close()
+	for iter.Next() {
+		contentAsBytes, err := iter.Value()
+		if err != nil {
+			return err
+		}
+
+		if iter.Binary() {
+			// The binary contents are not documents for the queries
+			if q != nil {
+				continue
+			}
+			err = batch.Put(iter.ID(), contentAsBytes)
+		} else {
+			if q != nil && !srcCol.matchQuery(q, contentAsBytes) {
+				continue
+			}
+			err = batch.Put(iter.ID(), json.RawMessage(contentAsBytes))
+		}
+		if err != nil {
+			return err
+		}
+
+		if batch.Len() >= CopyBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return batch.Write()
+}
+
 // DeleteCollection delete the given collection
 func (d *DB) DeleteCollection(collectionName string) error {
 	var c *Collection
+	d.collectionsMutex.Lock()
 	for i, col := range d.collections {
 		if col.name == collectionName {
 			// Save the collection pointer for future cleanup
@@ -126,6 +399,10 @@ func (d *DB) DeleteCollection(collectionName string) error {
 			break
 		}
 	}
+	d.collectionsMutex.Unlock()
+	if c == nil {
+		return ErrNotFound
+	}
 
 	// Close index DB
 	if err := c.db.Close(); err != nil {
@@ -136,11 +413,17 @@ func (d *DB) DeleteCollection(collectionName string) error {
 		return err
 	}
 
+	if err := c.EmptyTrash(); err != nil {
+		return err
+	}
+	if err := c.deleteStreams(); err != nil {
+		return err
+	}
+
 	// Remove stored values 1000 by 1000
 	for {
 		ids, err := c.getStoredIDsAndValues("", 1000, true)
 		if err != nil {
-			fmt.Println("ici", err)
 			return err
 		}
 		if len(ids) == 0 {
@@ -164,14 +447,69 @@ func (d *DB) DeleteCollection(collectionName string) error {
 
 // Backup run a backup to the given archive
 func (d *DB) Backup(path string, since uint64) error {
-	t0 := time.Now()
 	file, openFileErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, FilePermission)
 	if openFileErr != nil {
 		return openFileErr
 	}
 	defer file.Close()
 
-	zipWriter := zip.NewWriter(file)
+	_, err := d.backup(d.ctx, file, since)
+	return err
+}
+
+// WriteBackup streams a full backup to w while the writes continue.
+// The values with their history, the indexes and the configuration are saved
+// as they were when the call started, the archive can be loaded with Load.
+// The backup stops with the error of the context if it is done before the end.
+func (d *DB) WriteBackup(ctx context.Context, w io.Writer) error {
+	_, err := d.backup(ctx, w, 0)
+	return err
+}
+
+// BackupSince streams to w the values written after the given version and returns
+// the version to give to the next call. The full backup is written if since is 0.
+// The incremental archives only have the values, they are loaded with ReadBackup or Load
+// after the previous ones in the same order and the indexes are rebuilt.
+// The documents removed after the version are listed by the archive and removed when it is loaded.
+func (d *DB) BackupSince(ctx context.Context, w io.Writer, since uint64) (version uint64, _ error) {
+	return d.backup(ctx, w, since)
+}
+
+// backup writes the archive into the given writer and returns the version of the last saved value.
+// The result is sent to the event subscriptions.
+func (d *DB) backup(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	version, err := d.writeBackup(ctx, w, since)
+	if err != nil {
+		d.events.emit(&Event{Type: EventBackupFailed, Err: err})
+	} else {
+		d.events.emit(&Event{Type: EventBackupCompleted, Version: version})
+	}
+	return version, err
+}
+
+func (d *DB) writeBackup(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	t0 := time.Now()
+
+	// The values and the indexes are read from the same snapshot to match
+	// and to not see the writes done during the backup
+	snapshot, snapshotErr := d.Snapshot()
+	if snapshotErr != nil {
+		return 0, snapshotErr
+	}
+	defer snapshot.Close()
+
+	var archiveWriter io.Writer = &contextWriter{ctx: ctx, w: w}
+	var encrypter *encryptWriter
+	if d.options.BackupKey != nil {
+		var encryptErr error
+		encrypter, encryptErr = newEncryptWriter(archiveWriter, d.options.BackupKey)
+		if encryptErr != nil {
+			return 0, encryptErr
+		}
+		archiveWriter = encrypter
+	}
+
+	zipWriter := zip.NewWriter(archiveWriter)
 	// Setup compression
 	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
 		return flate.NewWriter(out, flate.BestCompression)
@@ -179,47 +517,119 @@ func (d *DB) Backup(path string, since uint64) error {
 
 	backupFile, createFileErr := zipWriter.Create("archive")
 	if createFileErr != nil {
-		return createFileErr
+		return 0, createFileErr
 	}
 
-	timestamp, backupErr := d.valueStore.Backup(backupFile, since)
+	var timestamp uint64
+	var deletes []*protos.KVPair
+	backupErr := snapshot.use(func() (err error) {
+		timestamp, deletes, err = backupValues(backupFile, snapshot.txn, since)
+		return err
+	})
 	if backupErr != nil {
-		return backupErr
+		return 0, backupErr
+	}
+
+	// The removals are saved to be done after the values when the increments are loaded
+	if len(deletes) != 0 {
+		deletesFile, createFileErr := zipWriter.Create("deletes")
+		if createFileErr != nil {
+			return 0, createFileErr
+		}
+		for _, pair := range deletes {
+			if err := writeBackupPair(deletesFile, pair); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// The indexes are saved with the full backups to skip the rebuild at loading
+	if since == 0 {
+		if err := d.backupIndexes(zipWriter, snapshot); err != nil {
+			return 0, err
+		}
 	}
 
 	configFile, createFileErr := zipWriter.Create("config.json")
 	if createFileErr != nil {
-		return createFileErr
+		return 0, createFileErr
 	}
 
 	archivePointer := d.loadArchive()
 	archivePointer.StartTime = t0
 	archivePointer.EndTime = time.Now()
 	archivePointer.Timestamp = timestamp
+	archivePointer.Since = since
 
 	configAsBytes, marshalErr := json.Marshal(archivePointer)
 	if marshalErr != nil {
-		return marshalErr
+		return 0, marshalErr
 	}
 
 	_, writeErr := configFile.Write(configAsBytes)
 	if writeErr != nil {
-		return writeErr
+		return 0, writeErr
 	}
 
-	return zipWriter.Close()
+	if closeErr := zipWriter.Close(); closeErr != nil {
+		return 0, closeErr
+	}
+
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return timestamp, nil
 }
 
-// Load restor the database from a backup file
+// Load restor the database from a backup file.
+// Encrypted backups are decrypted with the BackupKey of the options.
+// The checksums of the archive are verified before anything is loaded.
 func (d *DB) Load(path string) error {
-	zipReader, openZipErr := zip.OpenReader(path)
+	return d.load(d.ctx, path)
+}
+
+// ReadBackup restores the database from a backup read from r, like the ones written
+// by WriteBackup. The content of r is saved into a temporary file to be verified before it is loaded.
+// The progress is given to the LoadProgress of the options if any.
+func (d *DB) ReadBackup(ctx context.Context, r io.Reader) error {
+	tmpFile, tmpErr := ioutil.TempFile("", "gotinydb-backup-")
+	if tmpErr != nil {
+		return tmpErr
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(tmpFile, &contextReader{ctx: ctx, r: r}); err != nil {
+		return err
+	}
+
+	return d.load(ctx, tmpFile.Name())
+}
+
+func (d *DB) load(ctx context.Context, path string) error {
+	zipReader, closeFunc, openZipErr := d.openArchive(path)
 	if openZipErr != nil {
 		return openZipErr
 	}
+	defer closeFunc()
+
+	progress, checkErr := checkArchive(ctx, zipReader, d.options.LoadProgress)
+	if checkErr != nil {
+		return checkErr
+	}
 
 	config := new(archive)
+	indexDumps := map[string]*zip.File{}
+	var deletes *zip.File
 
 	for _, file := range zipReader.File {
+		if strings.HasPrefix(file.Name, "indexes/") {
+			indexDumps[file.Name] = file
+			continue
+		}
+
 		switch file.Name {
 		case "archive":
 			reader, openErr := file.Open()
@@ -227,31 +637,77 @@ func (d *DB) Load(path string) error {
 				return openErr
 			}
 
-			loadErr := d.valueStore.Load(reader)
+			epoch, epochErr := d.getStoredVersion(replicationEpochKey)
+			if epochErr != nil {
+				return epochErr
+			}
+			loadErr := d.valueStore.Load(progress.reader(ctx, reader))
 			if loadErr != nil {
 				return loadErr
 			}
+			// The change log of the archive continues from its last version,
+			// the replicas which have other versions are copied again
+			if err := d.initChangeLog(); err != nil {
+				return err
+			}
+			if err := d.newReplicationEpoch(epoch); err != nil {
+				return err
+			}
+		case "deletes":
+			deletes = file
 		case "config.json":
 			configReader, openConfigReaderErr := file.Open()
 			if openConfigReaderErr != nil {
 				return openConfigReaderErr
 			}
 
-			decodeErr := json.NewDecoder(configReader).Decode(config)
+			decodeErr := json.NewDecoder(progress.reader(ctx, configReader)).Decode(config)
 			if decodeErr != nil {
 				return decodeErr
 			}
 		}
 	}
 
+	// The removals of an incremental archive are done after its values
+	if deletes != nil {
+		reader, openErr := deletes.Open()
+		if openErr != nil {
+			return openErr
+		}
+		defer reader.Close()
+		if err := d.loadDeletes(progress.reader(ctx, reader)); err != nil {
+			return err
+		}
+	}
+
 	// Add the indexes to the filledup database
 	for _, collectionName := range config.Collections {
-		collection, useCollectionErr := d.Use(collectionName)
+		// The saved values are prefixed by the collection ID which is not built from the name after a rename
+		collection, useCollectionErr := d.use(collectionName, config.CollectionIDs[collectionName])
 		if useCollectionErr != nil {
 			return useCollectionErr
 		}
 		for _, index := range config.Indexes[collectionName] {
-			err := collection.SetIndex(index.Name, index.Type, index.Selector...)
+			// The extractor functions are not saved, they need to be set again by the caller
+			if index.Extractor {
+				continue
+			}
+			if dump, ok := indexDumps[indexDumpName(collection, index)]; ok {
+				if err := loadIndexDump(ctx, collection, dump, progress); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// The values of an incremental archive change the existing indexes
+			if collection.getIndex(index.Name) != nil {
+				if err := collection.RebuildIndex(index.Name); err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := collection.SetIndexWithOptions(index.Name, index.Type, index.getOptions(), index.Selector...)
 			if err != nil {
 				return err
 			}
@@ -260,13 +716,277 @@ func (d *DB) Load(path string) error {
 	return nil
 }
 
+// backupIndexes adds the dump of every index of the snapshot to the archive
+func (d *DB) backupIndexes(zipWriter *zip.Writer, snapshot *Snapshot) error {
+	return snapshot.use(func() error {
+		for _, sc := range snapshot.collections {
+			for _, index := range sc.c.indexes {
+				if index.Extractor {
+					continue
+				}
+
+				dumpFile, createFileErr := zipWriter.Create(indexDumpName(sc.c, index))
+				if createFileErr != nil {
+					return createFileErr
+				}
+				if err := sc.c.exportIndex(sc.tx, index, dumpFile); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// backupValues writes the values of the store transaction from the given version, in the
+// format of badger's Backup so the archives are loaded with Load, and returns the newest version.
+// The versions hidden by a removal, an expiration or a discarding write are not saved,
+// so they do not come back when the archive is loaded.
+// The keys removed from the given version are returned to be removed when the incremental archive is loaded.
+func backupValues(w io.Writer, txn *badger.Txn, since uint64) (version uint64, deletes []*protos.KVPair, _ error) {
+	iter := txn.NewIterator(badger.IteratorOptions{AllVersions: true, PrefetchValues: true})
+	defer iter.Close()
+
+	version = since
+	now := uint64(time.Now().Unix())
+	// The versions of a key are read from the newest
+	var lastKey, hiddenKey []byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		item := iter.Item()
+		newest := !bytes.Equal(item.Key(), lastKey)
+		lastKey = item.KeyCopy(lastKey)
+		if !newest && bytes.Equal(item.Key(), hiddenKey) {
+			continue
+		}
+		hiddenKey = nil
+
+		if item.IsDeletedOrExpired() {
+			hiddenKey = item.KeyCopy(nil)
+			expired := item.ExpiresAt() != 0 && item.ExpiresAt() <= now
+			if since != 0 && newest && !expired && item.Version() >= since {
+				deletes = append(deletes, &protos.KVPair{Key: hiddenKey, Version: item.Version()})
+				if item.Version() > version {
+					version = item.Version()
+				}
+			}
+			continue
+		}
+		if item.DiscardEarlierVersions() {
+			hiddenKey = item.KeyCopy(nil)
+		}
+		if item.Version() < since {
+			continue
+		}
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		pair := &protos.KVPair{
+			Key:       item.KeyCopy(nil),
+			Value:     value,
+			UserMeta:  []byte{item.UserMeta()},
+			Version:   item.Version(),
+			ExpiresAt: item.ExpiresAt(),
+		}
+		if err := writeBackupPair(w, pair); err != nil {
+			return 0, nil, err
+		}
+
+		if item.Version() > version {
+			version = item.Version()
+		}
+	}
+	return version, deletes, nil
+}
+
+// writeBackupPair writes the size of the pair and the pair like badger's Backup
+func writeBackupPair(w io.Writer, pair *protos.KVPair) error {
+	if err := binary.Write(w, binary.LittleEndian, uint64(pair.Size())); err != nil {
+		return err
+	}
+	asBytes, err := pair.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(asBytes)
+	return err
+}
+
+// readBackupPair reads the next pair written by writeBackupPair, io.EOF is returned at the end
+func readBackupPair(r io.Reader) (*protos.KVPair, error) {
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > math.MaxInt32 {
+		return nil, ErrDataCorrupted
+	}
+
+	asBytes := make([]byte, size)
+	if _, err := io.ReadFull(r, asBytes); err != nil {
+		return nil, err
+	}
+	pair := new(protos.KVPair)
+	if err := pair.Unmarshal(asBytes); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// loadDeletes removes the keys listed by an incremental archive
+func (d *DB) loadDeletes(r io.Reader) error {
+	txn, endTxn := d.valueStore.begin(true)
+	defer func() { endTxn() }()
+
+	for {
+		pair, err := readBackupPair(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(pair.Key); err == badger.ErrTxnTooBig {
+			if err := txn.Commit(nil); err != nil {
+				return err
+			}
+			endTxn()
+			txn, endTxn = d.valueStore.begin(true)
+			if err := txn.Delete(pair.Key); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return txn.Commit(nil)
+}
+
+// Write returns the error of the context once it is done
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// indexDumpName returns the name of the index dump inside the archive.
+// The collection ID is used because it has a fixed length.
+func indexDumpName(c *Collection, index *indexType) string {
+	return fmt.Sprintf("indexes/%s/%s", c.id, index.Name)
+}
+
+func loadIndexDump(ctx context.Context, c *Collection, dump *zip.File, progress *loadProgress) error {
+	reader, openErr := dump.Open()
+	if openErr != nil {
+		return openErr
+	}
+	defer reader.Close()
+
+	return c.ImportIndex(progress.reader(ctx, reader))
+}
+
+// checkArchive reads all the files of the archive to verify their checksums
+// and returns the progress of the loading to come
+func checkArchive(ctx context.Context, zipReader *zip.Reader, hook func(loaded, total int64)) (*loadProgress, error) {
+	progress := &loadProgress{hook: hook}
+	for _, file := range zipReader.File {
+		reader, openErr := file.Open()
+		if openErr != nil {
+			return nil, openErr
+		}
+		_, copyErr := io.Copy(ioutil.Discard, &contextReader{ctx: ctx, r: reader})
+		reader.Close()
+		if copyErr != nil {
+			return nil, copyErr
+		}
+
+		progress.total += int64(file.UncompressedSize64)
+	}
+	return progress, nil
+}
+
+// reader returns a reader adding the bytes read from r to the progress
+func (p *loadProgress) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &progressReader{progress: p, r: &contextReader{ctx: ctx, r: r}}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.progress.loaded += int64(n)
+		if r.progress.hook != nil {
+			r.progress.hook(r.progress.loaded, r.progress.total)
+		}
+	}
+	return n, err
+}
+
+// Read returns the error of the context once it is done
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// openArchive opens the zip file of the given path. The encrypted archives are
+// decrypted in memory to never write the clear content on the disk.
+// The returned function closes the file.
+func (d *DB) openArchive(path string) (*zip.Reader, func(), error) {
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, nil, openErr
+	}
+
+	encrypted, reader, checkErr := isEncrypted(file)
+	if checkErr != nil {
+		file.Close()
+		return nil, nil, checkErr
+	}
+
+	if !encrypted {
+		file.Close()
+		zipReader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &zipReader.Reader, func() { zipReader.Close() }, nil
+	}
+	defer file.Close()
+
+	if d.options.BackupKey == nil {
+		return nil, nil, ErrMissingKey
+	}
+
+	decrypter, decryptErr := newDecryptReader(reader, d.options.BackupKey)
+	if decryptErr != nil {
+		return nil, nil, decryptErr
+	}
+
+	clearContent, readErr := ioutil.ReadAll(decrypter)
+	if readErr != nil {
+		return nil, nil, readErr
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(clearContent), int64(len(clearContent)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return zipReader, func() {}, nil
+}
+
 func (d *DB) loadArchive() *archive {
+	collections := d.getCollections()
 	ret := new(archive)
-	ret.Collections = make([]string, len(d.collections))
+	ret.Collections = make([]string, len(collections))
+	ret.CollectionIDs = map[string]string{}
 	ret.Indexes = map[string][]*indexType{}
 
-	for i, collection := range d.collections {
+	for i, collection := range collections {
 		ret.Collections[i] = collection.name
+		ret.CollectionIDs[collection.name] = collection.id
 
 		ret.Indexes[collection.name] = make([]*indexType, len(collection.indexes))
 		for j, index := range collection.indexes {
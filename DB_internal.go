@@ -4,28 +4,72 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync/atomic"
 
 	"github.com/boltdb/bolt"
 	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
 )
 
 func (d *DB) buildPath() error {
+	// The read only databases must exist
+	if d.options.ReadOnly {
+		_, err := os.Stat(d.options.Path + "/collections")
+		return err
+	}
 	return os.MkdirAll(d.options.Path+"/collections", FilePermission)
 }
 
 func (d *DB) initBadger() error {
-	opts := d.options.BadgerOptions
+	// The options are copied to not change the defaults
+	opts := *d.options.BadgerOptions
 	opts.Dir = d.options.Path + "/store"
 	opts.ValueDir = d.options.Path + "/store"
-	db, err := badger.Open(*opts)
+	// The current version is kept with the history
+	opts.NumVersionsToKeep = d.options.historyDepth() + 1
+	opts.ValueLogFileSize = d.valueLogFileSize()
+	if d.options.ReadOnly {
+		opts.ReadOnly = true
+		opts.TableLoadingMode = options.MemoryMap
+		opts.ValueLogLoadingMode = options.MemoryMap
+	}
+	db, err := badger.Open(opts)
 	if err != nil {
 		return err
 	}
 
-	d.valueStore = db
+	d.valueStore = &badgerStore{DB: db}
 	return nil
 }
 
+// View runs fn in a read transaction counted by the stats
+func (s *badgerStore) View(fn func(txn *badger.Txn) error) error {
+	atomic.AddInt64(&s.openTransactions, 1)
+	defer atomic.AddInt64(&s.openTransactions, -1)
+	return s.DB.View(fn)
+}
+
+// Update runs fn in a write transaction counted by the stats
+func (s *badgerStore) Update(fn func(txn *badger.Txn) error) error {
+	atomic.AddInt64(&s.openTransactions, 1)
+	defer atomic.AddInt64(&s.openTransactions, -1)
+	return s.DB.Update(fn)
+}
+
+// begin starts a transaction counted by the stats until the returned function discards it.
+// The function can be called more than once and after Commit.
+func (s *badgerStore) begin(update bool) (*badger.Txn, func()) {
+	atomic.AddInt64(&s.openTransactions, 1)
+	txn := s.DB.NewTransaction(update)
+	ended := int32(0)
+	return txn, func() {
+		txn.Discard()
+		if atomic.CompareAndSwapInt32(&ended, 0, 1) {
+			atomic.AddInt64(&s.openTransactions, -1)
+		}
+	}
+}
+
 func (d *DB) waitForClose() {
 	<-d.ctx.Done()
 	d.Close()
@@ -46,12 +90,30 @@ func (d *DB) loadCollections() error {
 			return err
 		}
 
+		if d.options.WarmUpIndexes {
+			if err := col.warmUpIndexes(); err != nil {
+				return err
+			}
+		}
+
+		d.collectionsMutex.Lock()
 		d.collections = append(d.collections, col)
+		d.collectionsMutex.Unlock()
 	}
 
 	return nil
 }
 
+// getCollections returns a copy of the list of the collections
+func (d *DB) getCollections() []*Collection {
+	d.collectionsMutex.RLock()
+	defer d.collectionsMutex.RUnlock()
+
+	ret := make([]*Collection, len(d.collections))
+	copy(ret, d.collections)
+	return ret
+}
+
 func (d *DB) getCollection(colID, colName string) (*Collection, error) {
 	c := new(Collection)
 	c.store = d.valueStore
@@ -59,8 +121,17 @@ func (d *DB) getCollection(colID, colName string) (*Collection, error) {
 	c.name = colName
 
 	c.options = d.options
+	c.activity = &d.lastActivity
+	c.diskSpace = d.diskSpace
+	c.valueAEAD = d.valueAEAD
+	c.indexCipher = d.indexCipher
+	c.changeLog = d.changeLog
+	c.metrics = d.metrics
+	c.events = d.events
+	c.workers = d.workers
 
 	c.initWriteTransactionChan(d.ctx)
+	c.initAsyncWrites(d.ctx)
 
 	if colID == "" && colName == "" {
 		return nil, fmt.Errorf("name and ID can't be empty")
@@ -72,7 +143,7 @@ func (d *DB) getCollection(colID, colName string) (*Collection, error) {
 	c.name = colName
 	c.ctx = d.ctx
 
-	db, openDBErr := bolt.Open(d.options.Path+"/collections/"+colID, FilePermission, d.options.BoltOptions)
+	db, openDBErr := bolt.Open(d.options.Path+"/collections/"+colID, FilePermission, d.boltOptions())
 	if openDBErr != nil {
 		return nil, openDBErr
 	}
@@ -90,6 +161,7 @@ func (d *DB) getCollection(colID, colName string) (*Collection, error) {
 			if err != nil {
 				return nil, err
 			}
+			d.events.emit(&Event{Type: EventCollectionCreated, Collection: colName})
 			// No error return the new Collection pointer
 			return c, nil
 		}
@@ -101,6 +173,20 @@ func (d *DB) getCollection(colID, colName string) (*Collection, error) {
 	return c, nil
 }
 
+// boltOptions returns the options of the index files, read only with the database
+func (d *DB) boltOptions() *bolt.Options {
+	if !d.options.ReadOnly {
+		return d.options.BoltOptions
+	}
+
+	opts := new(bolt.Options)
+	if d.options.BoltOptions != nil {
+		*opts = *d.options.BoltOptions
+	}
+	opts.ReadOnly = true
+	return opts
+}
+
 func (d *DB) getCollectionsIDs() ([]string, error) {
 	files, err := ioutil.ReadDir(d.options.Path + "/collections")
 	if err != nil {
@@ -113,3 +199,26 @@ func (d *DB) getCollectionsIDs() ([]string, error) {
 	}
 	return ret, nil
 }
+
+// newCollectionID returns the ID of a new collection. It is built from the name
+// unless an other collection uses it or the same store prefix, which happens after a rename.
+// The collections mutex must be locked.
+func (d *DB) newCollectionID(colName string) string {
+	for n := 0; ; n++ {
+		id := buildID(colName)
+		if n != 0 {
+			id = buildID(fmt.Sprintf("%s %d", colName, n))
+		}
+
+		free := true
+		for _, col := range d.collections {
+			if col.id[:4] == id[:4] {
+				free = false
+				break
+			}
+		}
+		if free {
+			return id
+		}
+	}
+}
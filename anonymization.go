@@ -0,0 +1,130 @@
+package gotinydb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// SetAnonymization defines the transformations applied to the fields of the documents
+// when they are exported. It replaces the previous rules and an empty list removes them.
+// The rules are saved with the collection like the indexes. The saved documents
+// are not changed. ErrMissingAnonymizationKey is returned if a rule hashes the
+// values and the options have no AnonymizationKey.
+func (c *Collection) SetAnonymization(rules ...*AnonymizeRule) error {
+	for _, rule := range rules {
+		if rule == nil || len(rule.Selector) == 0 {
+			return fmt.Errorf("the anonymization rules need a selector")
+		}
+		switch rule.Transform {
+		case AnonymizeHash:
+			if c.options.AnonymizationKey == nil {
+				return ErrMissingAnonymizationKey
+			}
+		case AnonymizeRedact, AnonymizeMonth, AnonymizeYear:
+		default:
+			return fmt.Errorf("unknown anonymization %q", rule.Transform)
+		}
+	}
+
+	c.anonymizationMutex.Lock()
+	defer c.anonymizationMutex.Unlock()
+
+	if err := c.updateIndex(func(tx *bolt.Tx) error {
+		rulesAsBytes, _ := json.Marshal(rules)
+		return tx.Bucket([]byte("config")).Put([]byte("anonymization"), rulesAsBytes)
+	}); err != nil {
+		return err
+	}
+	c.anonymization = rules
+	return nil
+}
+
+// Anonymization returns the transformations applied to the exported documents
+func (c *Collection) Anonymization() []*AnonymizeRule {
+	c.anonymizationMutex.RLock()
+	defer c.anonymizationMutex.RUnlock()
+	return c.anonymization
+}
+
+func (c *Collection) getAnonymizationFromConfigBucket() []*AnonymizeRule {
+	rules := []*AnonymizeRule{}
+	c.viewIndex(func(tx *bolt.Tx) error {
+		rulesAsBytes := tx.Bucket([]byte("config")).Get([]byte("anonymization"))
+		json.Unmarshal(rulesAsBytes, &rules)
+
+		return nil
+	})
+	return rules
+}
+
+// anonymizationOf returns the transformation of the selector or an empty string if it has none
+func (c *Collection) anonymizationOf(selector []string) Anonymization {
+	selectorHash := buildSelectorHash(selector)
+	for _, rule := range c.Anonymization() {
+		if buildSelectorHash(rule.Selector) == selectorHash {
+			return rule.Transform
+		}
+	}
+	return ""
+}
+
+// anonymize applies the rules of the collection to the document decoded from the store.
+// ErrMissingAnonymizationKey is returned if the values must be hashed but the options
+// have no AnonymizationKey anymore.
+func (c *Collection) anonymize(object map[string]interface{}) error {
+	for _, rule := range c.Anonymization() {
+		if rule.Transform == AnonymizeHash && c.options.AnonymizationKey == nil {
+			return ErrMissingAnonymizationKey
+		}
+
+		parent, found := getValueFromSelector(object, rule.Selector[:len(rule.Selector)-1])
+		if !found {
+			continue
+		}
+		parentMap, ok := parent.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		fieldName := rule.Selector[len(rule.Selector)-1]
+		value, found := parentMap[fieldName]
+		if !found || value == nil {
+			continue
+		}
+
+		parentMap[fieldName] = rule.Transform.apply(c.options.AnonymizationKey, value)
+	}
+	return nil
+}
+
+// apply returns the transformed value. The redacted values and the dates which can't be parsed are null.
+// The key is the HMAC key of the hashes.
+func (a Anonymization) apply(key []byte, value interface{}) interface{} {
+	switch a {
+	case AnonymizeHash:
+		asBytes, _ := json.Marshal(value)
+		mac := hmac.New(sha256.New, key)
+		mac.Write(asBytes)
+		return hex.EncodeToString(mac.Sum(nil))
+	case AnonymizeMonth, AnonymizeYear:
+		asString, _ := value.(string)
+		t, err := time.Parse(time.RFC3339Nano, asString)
+		if err != nil {
+			return nil
+		}
+
+		t = t.UTC()
+		month := t.Month()
+		if a == AnonymizeYear {
+			month = time.January
+		}
+		return time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+	}
+	return nil
+}
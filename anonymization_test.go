@@ -0,0 +1,132 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestAnonymization(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := c.SetAnonymization(&AnonymizeRule{Selector: []string{"Email"}, Transform: "unknown"}); err == nil {
+		t.Errorf("the unknown transformations must be rejected")
+		return
+	}
+	if err := c.SetAnonymization(&AnonymizeRule{Selector: []string{"Email"}, Transform: AnonymizeHash}); err != ErrMissingAnonymizationKey {
+		t.Errorf("expected %v but had %v", ErrMissingAnonymizationKey, err)
+		return
+	}
+
+	options.AnonymizationKey = []byte("anonymization key")
+	err := c.SetAnonymization(
+		&AnonymizeRule{Selector: []string{"Email"}, Transform: AnonymizeHash},
+		&AnonymizeRule{Selector: []string{"Age"}, Transform: AnonymizeHash},
+		&AnonymizeRule{Selector: []string{"Address", "City"}, Transform: AnonymizeRedact},
+		&AnonymizeRule{Selector: []string{"LastLogin"}, Transform: AnonymizeMonth},
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:2]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The rules are saved
+	db.Close()
+	options.Path = testPath
+	db, openDBErr = Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ = db.Use("testCol")
+	if len(c.Anonymization()) != 4 {
+		t.Errorf("expected 4 rules but had %d", len(c.Anonymization()))
+		return
+	}
+
+	objects := []map[string]interface{}{}
+	for _, user := range users {
+		contentAsBytes, _ := c.GetRaw(user.ID)
+		object, _ := decodeStored(contentAsBytes)
+		if err := c.anonymize(object); err != nil {
+			t.Error(err)
+			return
+		}
+		objects = append(objects, object)
+	}
+
+	email := objects[0]["Email"].(string)
+	if email == users[0].Email || len(email) != 64 || email == objects[1]["Email"] {
+		t.Errorf("the email is not hashed: %q", email)
+		return
+	}
+	if email != AnonymizeHash.apply(options.AnonymizationKey, users[0].Email) || email == AnonymizeHash.apply([]byte("other key"), users[0].Email) {
+		t.Errorf("the email is not hashed with the key")
+		return
+	}
+	if city, found := objects[0]["Address"].(map[string]interface{})["City"]; !found || city != nil {
+		t.Errorf("the city is not redacted: %v", city)
+		return
+	}
+	lastLogin := users[0].LastLogin.UTC()
+	if expected := lastLogin.Format("2006-01") + "-01T00:00:00Z"; objects[0]["LastLogin"] != expected {
+		t.Errorf("expected %q but had %q", expected, objects[0]["LastLogin"])
+		return
+	}
+	// The document is not changed
+	if saved := new(User); true {
+		c.Get(users[0].ID, saved)
+		if saved.Email != users[0].Email {
+			t.Errorf("the saved document has been changed")
+			return
+		}
+	}
+
+	// The hashed numbers are exported as strings
+	buf := new(bytes.Buffer)
+	if err := c.ExportParquet(buf, &Column{Name: "age", Selector: []string{"Age"}}); err != nil {
+		t.Error(err)
+		return
+	}
+	file := buf.Bytes()
+	metaLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := newThriftReader(file[len(file)-8-metaLength : len(file)-8]).readStruct()
+	if ageType := meta[2].([]interface{})[2].(map[int16]interface{})[1].(int32); ageType != parquetByteArray {
+		t.Errorf("expected the type %d but had %d", parquetByteArray, ageType)
+		return
+	}
+
+	// Nothing is exported without the key
+	options.AnonymizationKey = nil
+	if err := c.ExportParquet(new(bytes.Buffer)); err != ErrMissingAnonymizationKey {
+		t.Errorf("expected %v but had %v", ErrMissingAnonymizationKey, err)
+		return
+	}
+}
@@ -0,0 +1,152 @@
+package gotinydb
+
+import (
+	"context"
+)
+
+// initAsyncWrites starts the goroutine saving the writes of PutAsync
+func (c *Collection) initAsyncWrites(ctx context.Context) {
+	queueSize := c.options.AsyncQueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultAsyncQueueSize
+	}
+
+	c.asyncWrites = make(chan *asyncWrite, queueSize)
+	go func() {
+		for {
+			select {
+			case write := <-c.asyncWrites:
+				c.writeAsync(write)
+			case <-ctx.Done():
+				c.drainAsyncWrites()
+				return
+			}
+		}
+	}()
+}
+
+// drainAsyncWrites sends ErrClosed to the writes left in the queue
+// and refuses the next ones
+func (c *Collection) drainAsyncWrites() {
+	c.asyncMutex.Lock()
+	defer c.asyncMutex.Unlock()
+
+	c.asyncClosed = true
+	for {
+		select {
+		case write := <-c.asyncWrites:
+			if write.errChan != nil {
+				write.errChan <- ErrClosed
+			}
+			if write.flushed != nil {
+				close(write.flushed)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// PutAsync queues the saving of the content and returns right away. The returned
+// channel receives the result of the write once it is saved.
+// The queued writes are saved by groups in one transaction. The writes still queued
+// when the context of the database is done are not saved and receive ErrClosed.
+// It waits if the queue is full.
+// Flush waits for the queued writes to be saved.
+func (c *Collection) PutAsync(id string, content interface{}) <-chan error {
+	errChan := make(chan error, 1)
+
+	batch := c.NewBatch()
+	if err := batch.Put(id, content); err != nil {
+		errChan <- err
+		return errChan
+	}
+
+	c.asyncMutex.RLock()
+	defer c.asyncMutex.RUnlock()
+	if c.asyncClosed {
+		errChan <- ErrClosed
+		return errChan
+	}
+
+	select {
+	case c.asyncWrites <- &asyncWrite{operation: batch.operations[0], errChan: errChan}:
+	case <-c.ctx.Done():
+		errChan <- ErrClosed
+	}
+	return errChan
+}
+
+// Flush waits until all the writes queued by PutAsync before the call are saved.
+// The errors of the writes are given by the channels of PutAsync.
+// ErrClosed is returned if the context of the database is done.
+func (c *Collection) Flush() error {
+	done := make(chan struct{})
+
+	c.asyncMutex.RLock()
+	if c.asyncClosed {
+		c.asyncMutex.RUnlock()
+		return ErrClosed
+	}
+	select {
+	case c.asyncWrites <- &asyncWrite{flushed: done}:
+	case <-c.ctx.Done():
+		c.asyncMutex.RUnlock()
+		return ErrClosed
+	}
+	c.asyncMutex.RUnlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-c.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// writeAsync saves the given write and the ones waiting in the queue in one batch
+func (c *Collection) writeAsync(first *asyncWrite) {
+	writes := []*asyncWrite{first}
+	for len(writes) < AsyncWriteBatchSize {
+		select {
+		case write := <-c.asyncWrites:
+			writes = append(writes, write)
+			continue
+		default:
+		}
+		break
+	}
+
+	batch := c.NewBatch()
+	for _, write := range writes {
+		if write.operation != nil {
+			batch.operations = append(batch.operations, write.operation)
+		}
+	}
+
+	if batch.Len() != 0 {
+		if err := batch.Write(); err == nil {
+			for _, write := range writes {
+				if write.errChan != nil {
+					write.errChan <- nil
+				}
+			}
+		} else {
+			// Only the writes which fail alone get an error
+			for _, write := range writes {
+				if write.operation == nil {
+					continue
+				}
+				alone := c.NewBatch()
+				alone.operations = append(alone.operations, write.operation)
+				write.errChan <- alone.Write()
+			}
+		}
+	}
+
+	for _, write := range writes {
+		if write.flushed != nil {
+			close(write.flushed)
+		}
+	}
+}
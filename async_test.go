@@ -0,0 +1,116 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPutAsync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetWriteOnce(true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)
+	errChans := make([]<-chan error, len(users))
+	for i, user := range users {
+		errChans[i] = c.PutAsync(user.ID, user)
+	}
+	// The ID is saved twice, only the second write fails
+	duplicateErrChan := c.PutAsync(users[0].ID, users[0])
+
+	if err := <-c.PutAsync("", users[0]); err != ErrEmptyID {
+		t.Errorf("expected %v but had %v", ErrEmptyID, err)
+		return
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, errChan := range errChans {
+		if err := <-errChan; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := <-duplicateErrChan; err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+
+	if n, _ := c.Count(); n != len(users) {
+		t.Errorf("expected %d documents but had %d", len(users), n)
+		return
+	}
+	response, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() != 1 {
+		t.Errorf("the async writes are not indexed")
+		return
+	}
+}
+
+func TestPutAsyncClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	users := unmarshalDataSet(dataSet1)
+	errChans := make([]<-chan error, len(users))
+	for i, user := range users {
+		errChans[i] = c.PutAsync(user.ID, user)
+	}
+	cancel()
+
+	// Every queued write gets an answer, saved or not
+	for _, errChan := range errChans {
+		select {
+		case <-errChan:
+		case <-time.After(time.Second * 5):
+			t.Errorf("a queued write is never answered")
+			return
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if err := <-c.PutAsync(users[0].ID, users[0]); err != ErrClosed {
+		t.Errorf("expected %v but had %v", ErrClosed, err)
+		return
+	}
+	if err := c.Flush(); err != ErrClosed {
+		t.Errorf("expected %v but had %v", ErrClosed, err)
+		return
+	}
+}
@@ -0,0 +1,463 @@
+package gotinydb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupNameLayout defines how the backups are named on the targets
+const backupNameLayout = "gotinydb-20060102T150405.000Z.zip"
+
+type (
+	// BackupTarget defines a place where the backups are saved and retrieved by name
+	BackupTarget interface {
+		// Put saves the content under the given name
+		Put(name string, content io.Reader) error
+		// Get returns the content saved under the given name
+		Get(name string) (io.ReadCloser, error)
+		// List returns the names of all the saved contents
+		List() ([]string, error)
+		// Delete removes the content saved under the given name
+		Delete(name string) error
+	}
+
+	// DirectoryTarget saves the backups as files of the given directory.
+	// It can be used with any mounted storage.
+	DirectoryTarget struct {
+		Path string
+	}
+
+	// S3Target saves the backups into a bucket of an object store compatible with the S3 API
+	S3Target struct {
+		// Endpoint is the base URL of the service like "https://s3.eu-west-1.amazonaws.com"
+		Endpoint string
+		Region   string
+		Bucket   string
+		// Prefix is added in front of the backup names
+		Prefix string
+
+		AccessKeyID, SecretAccessKey string
+
+		// Client is used to send the requests. If nil http.DefaultClient is used.
+		Client *http.Client
+	}
+
+	// RetentionPolicy defines which backups are kept on a target.
+	// The most recent backup is always kept.
+	RetentionPolicy struct {
+		// Daily is the number of days for which the last backup of the day is kept
+		Daily int
+		// Weekly is the number of weeks for which the last backup of the week is kept
+		Weekly int
+		// Last is the number of the most recent backups kept
+		Last int
+	}
+
+	// BackupSchedule defines the backups saved by the database while it is open
+	BackupSchedule struct {
+		// Interval is the time between two backups
+		Interval time.Duration
+		// Target is where the backups are saved, a DirectoryTarget saves them into a directory
+		Target BackupTarget
+		// Retention if set defines the backups kept on the target after every backup
+		Retention *RetentionPolicy
+	}
+
+	// BackupStatus defines the result of the scheduled backups
+	BackupStatus struct {
+		// Name is the name of the last successful backup on the target
+		Name string
+		// Time is the end of the last successful backup and Duration how long it took
+		Time     time.Time
+		Duration time.Duration
+		// Err is the error of the last backup, nil if it succeeded
+		Err error
+		// Backups and Failures count the scheduled backups since Open
+		Backups, Failures int
+	}
+
+	s3ListResult struct {
+		Contents []struct {
+			Key string
+		}
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+)
+
+// BackupTo saves a full backup to the given target and applies the retention policy if any.
+// It returns the name of the new backup.
+func (d *DB) BackupTo(target BackupTarget, policy *RetentionPolicy) (name string, _ error) {
+	name = time.Now().UTC().Format(backupNameLayout)
+
+	tmpFile, tmpErr := ioutil.TempFile("", "gotinydb-backup-")
+	if tmpErr != nil {
+		return "", tmpErr
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := d.backup(d.ctx, tmpFile, 0); err != nil {
+		return "", err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if err := target.Put(name, tmpFile); err != nil {
+		return "", err
+	}
+
+	if policy == nil {
+		return name, nil
+	}
+
+	names, listErr := target.List()
+	if listErr != nil {
+		return "", listErr
+	}
+	for _, toDelete := range policy.toDelete(names) {
+		if err := target.Delete(toDelete); err != nil {
+			return "", err
+		}
+	}
+
+	return name, nil
+}
+
+// BackupStatus returns the result of the last backup done by the BackupSchedule of the options
+func (d *DB) BackupStatus() BackupStatus {
+	d.backupStatusMutex.Lock()
+	defer d.backupStatusMutex.Unlock()
+	return d.backupStatus
+}
+
+// backupLoop saves the backups of the schedule until the database is closed.
+// The backups are done one after the other, a backup longer than the interval delays the next one.
+func (d *DB) backupLoop(schedule *BackupSchedule) {
+	ticker := time.NewTicker(schedule.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			if d.closing {
+				return
+			}
+
+			start := time.Now()
+			name, err := d.BackupTo(schedule.Target, schedule.Retention)
+
+			d.backupStatusMutex.Lock()
+			d.backupStatus.Err = err
+			if err == nil {
+				d.backupStatus.Name = name
+				d.backupStatus.Time = time.Now()
+				d.backupStatus.Duration = time.Since(start)
+				d.backupStatus.Backups++
+			} else {
+				d.backupStatus.Failures++
+				d.options.log(LogError, "scheduled backup failed", "error", err)
+			}
+			d.backupStatusMutex.Unlock()
+		}
+	}
+}
+
+// LoadFrom restores the database from the backup with the given name saved on the target
+func (d *DB) LoadFrom(target BackupTarget, name string) error {
+	reader, getErr := target.Get(name)
+	if getErr != nil {
+		return getErr
+	}
+	defer reader.Close()
+
+	return d.ReadBackup(d.ctx, reader)
+}
+
+// toDelete returns the backups which are not kept by the policy.
+// The names which are not backup names are ignored.
+func (p *RetentionPolicy) toDelete(names []string) (ret []string) {
+	type backup struct {
+		name string
+		time time.Time
+	}
+
+	backups := []*backup{}
+	for _, name := range names {
+		t, err := time.Parse(backupNameLayout, name)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &backup{name, t})
+	}
+
+	// The most recent first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for i, b := range backups {
+		keep := i == 0 || i < p.Last
+
+		day := b.time.Format("2006-01-02")
+		if !days[day] && len(days) < p.Daily {
+			days[day] = true
+			keep = true
+		}
+
+		year, weekNumber := b.time.ISOWeek()
+		week := fmt.Sprintf("%d-%d", year, weekNumber)
+		if !weeks[week] && len(weeks) < p.Weekly {
+			weeks[week] = true
+			keep = true
+		}
+
+		if !keep {
+			ret = append(ret, b.name)
+		}
+	}
+
+	return ret
+}
+
+// Put implements the BackupTarget interface
+func (t *DirectoryTarget) Put(name string, content io.Reader) error {
+	if err := os.MkdirAll(t.Path, FilePermission); err != nil {
+		return err
+	}
+
+	file, openErr := os.OpenFile(filepath.Join(t.Path, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, FilePermission)
+	if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+
+	_, err := io.Copy(file, content)
+	return err
+}
+
+// Get implements the BackupTarget interface
+func (t *DirectoryTarget) Get(name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(t.Path, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// List implements the BackupTarget interface
+func (t *DirectoryTarget) List() ([]string, error) {
+	files, err := ioutil.ReadDir(t.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	ret := []string{}
+	for _, f := range files {
+		if !f.IsDir() {
+			ret = append(ret, f.Name())
+		}
+	}
+	return ret, nil
+}
+
+// Delete implements the BackupTarget interface
+func (t *DirectoryTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(t.Path, name))
+}
+
+// Put implements the BackupTarget interface
+func (t *S3Target) Put(name string, content io.Reader) error {
+	// The object store needs to know the length of the content
+	seeker, ok := content.(io.ReadSeeker)
+	if !ok {
+		tmpFile, tmpErr := ioutil.TempFile("", "gotinydb-upload-")
+		if tmpErr != nil {
+			return tmpErr
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+
+		if _, err := io.Copy(tmpFile, content); err != nil {
+			return err
+		}
+		seeker = tmpFile
+	}
+
+	size, seekErr := seeker.Seek(0, io.SeekEnd)
+	if seekErr != nil {
+		return seekErr
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := t.do(http.MethodPut, t.Prefix+name, nil, ioutil.NopCloser(seeker), size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get implements the BackupTarget interface
+func (t *S3Target) Get(name string) (io.ReadCloser, error) {
+	resp, err := t.do(http.MethodGet, t.Prefix+name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// List implements the BackupTarget interface
+func (t *S3Target) List() ([]string, error) {
+	ret := []string{}
+
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", t.Prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := t.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		result := new(s3ListResult)
+		decodeErr := xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+
+		for _, content := range result.Contents {
+			ret = append(ret, strings.TrimPrefix(content.Key, t.Prefix))
+		}
+
+		if !result.IsTruncated {
+			return ret, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// Delete implements the BackupTarget interface
+func (t *S3Target) Delete(name string) error {
+	resp, err := t.do(http.MethodDelete, t.Prefix+name, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// do sends the signed request to the bucket and checks the status of the response
+func (t *S3Target) do(method, key string, query url.Values, body io.ReadCloser, size int64) (*http.Response, error) {
+	u, parseErr := url.Parse(strings.TrimSuffix(t.Endpoint, "/"))
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	u.Path = u.Path + "/" + t.Bucket
+	if key != "" {
+		u.Path = u.Path + "/" + key
+	}
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	req, reqErr := http.NewRequest(method, u.String(), nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if body != nil {
+		req.Body = body
+		req.ContentLength = size
+	}
+	t.sign(req, time.Now().UTC())
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, doErr := client.Do(req)
+	if doErr != nil {
+		return nil, doErr
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("object store returned %q: %s", resp.Status, string(message))
+	}
+
+	return resp, nil
+}
+
+// sign adds the AWS signature version 4 headers to the request.
+// The payload is not signed.
+func (t *S3Target) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := day + "/" + t.Region + "/s3/aws4_request"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+t.SecretAccessKey), day)
+	signingKey = hmacSHA256(signingKey, t.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign)),
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,262 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	// Monday 2018-07-02
+	start := time.Date(2018, 7, 2, 12, 0, 0, 0, time.UTC)
+	names := []string{"not a backup"}
+	for i := 0; i < 21; i++ {
+		names = append(names, start.Add(time.Hour*24*time.Duration(i)).Format(backupNameLayout))
+	}
+	// Two backups the last day
+	names = append(names, start.Add(time.Hour*24*20+time.Hour).Format(backupNameLayout))
+
+	toDelete := (&RetentionPolicy{Daily: 3, Weekly: 3}).toDelete(names)
+
+	kept := map[string]bool{}
+	for _, name := range names {
+		kept[name] = true
+	}
+	for _, name := range toDelete {
+		kept[name] = false
+	}
+
+	expected := []string{
+		"not a backup",
+		// Last backups of the three last weeks
+		start.Add(time.Hour * 24 * 6).Format(backupNameLayout),
+		start.Add(time.Hour * 24 * 13).Format(backupNameLayout),
+		// Last backups of the three last days, the last one is also the last of the week
+		start.Add(time.Hour * 24 * 18).Format(backupNameLayout),
+		start.Add(time.Hour * 24 * 19).Format(backupNameLayout),
+		start.Add(time.Hour*24*20 + time.Hour).Format(backupNameLayout),
+	}
+
+	keptList := []string{}
+	for name, isKept := range kept {
+		if isKept {
+			keptList = append(keptList, name)
+		}
+	}
+	sort.Strings(keptList)
+	sort.Strings(expected)
+
+	if !reflect.DeepEqual(keptList, expected) {
+		t.Errorf("kept %v but expected %v", keptList, expected)
+		return
+	}
+
+	if toDelete := (&RetentionPolicy{}).toDelete(names); len(toDelete) != len(names)-2 {
+		t.Errorf("only the last backup should be kept but %d are deleted", len(toDelete))
+		return
+	}
+	if toDelete := (&RetentionPolicy{Last: 4}).toDelete(names); len(toDelete) != len(names)-5 {
+		t.Errorf("the four last backups should be kept but %d are deleted", len(toDelete))
+		return
+	}
+}
+
+func TestBackupSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	target := &DirectoryTarget{Path: testPath + "-backups"}
+	defer os.RemoveAll(target.Path)
+
+	options := NewDefaultOptions(testPath)
+	options.BackupSchedule = &BackupSchedule{
+		Interval:  time.Millisecond * 20,
+		Target:    target,
+		Retention: &RetentionPolicy{Last: 2},
+	}
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	c.Put("id", map[string]string{"Name": "backed up"})
+
+	deadline := time.Now().Add(time.Second * 5)
+	for db.BackupStatus().Backups < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	status := db.BackupStatus()
+	if status.Backups < 3 || status.Err != nil || status.Name == "" {
+		t.Errorf("unexpected status %+v", status)
+		return
+	}
+	if stats, err := db.Stats(); err != nil || stats.Backup.Backups < 3 {
+		t.Errorf("expected the backup status in the stats but had %+v, %v", stats, err)
+		return
+	}
+	names, err := target.List()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// The next backup can be saved and not rotated yet
+	if len(names) > 3 {
+		t.Errorf("only two backups should be kept but there are %v", names)
+		return
+	}
+}
+
+func TestBackupToTargets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	directoryPath := <-getTestPathChan
+	defer os.RemoveAll(directoryPath)
+
+	fakeStore := newFakeS3()
+	server := httptest.NewServer(fakeStore)
+	defer server.Close()
+
+	targets := []BackupTarget{
+		&DirectoryTarget{Path: directoryPath},
+		&S3Target{Endpoint: server.URL, Region: "eu-west-1", Bucket: "backups", Prefix: "db1/", AccessKeyID: "key", SecretAccessKey: "secret"},
+	}
+
+	for _, target := range targets {
+		if _, err := db.BackupTo(target, nil); err != nil {
+			t.Error(err)
+			return
+		}
+		// Make sure the names are different
+		time.Sleep(time.Millisecond * 2)
+		name, err := db.BackupTo(target, &RetentionPolicy{Daily: 1})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		names, listErr := target.List()
+		if listErr != nil {
+			t.Error(listErr)
+			return
+		}
+		if !reflect.DeepEqual(names, []string{name}) {
+			t.Errorf("the target should only have %q but had %v", name, names)
+			return
+		}
+
+		restoredDBPath := <-getTestPathChan
+		defer os.RemoveAll(restoredDBPath)
+		db2, openErr := Open(ctx, NewDefaultOptions(restoredDBPath))
+		if openErr != nil {
+			t.Error(openErr)
+			return
+		}
+		defer db2.Close()
+
+		if err := db2.LoadFrom(target, name); err != nil {
+			t.Error(err)
+			return
+		}
+
+		c, _ := db2.Use("testCol")
+		user := new(User)
+		if err := c.Get("9", user); err != nil {
+			t.Error(err)
+			return
+		}
+		if user.Email != "witt-77@clayton.com" {
+			t.Errorf("restored user has email %q instead of %q", user.Email, "witt-77@clayton.com")
+			return
+		}
+
+		if _, err := target.Get("not found"); err != ErrNotFound {
+			t.Errorf("expected %v but had %v", ErrNotFound, err)
+			return
+		}
+	}
+
+	if fakeStore.unsigned {
+		t.Errorf("some requests were not signed")
+		return
+	}
+}
+
+// fakeS3 is a minimal in memory object store answering like S3 for one bucket
+type fakeS3 struct {
+	sync.Mutex
+	objects  map[string][]byte
+	unsigned bool
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		f.unsigned = true
+	}
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/backups"), "/")
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength <= 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		f.objects[key], _ = ioutil.ReadAll(r.Body)
+	case http.MethodGet:
+		if key == "" {
+			result := s3ListResult{}
+			for objectKey := range f.objects {
+				if strings.HasPrefix(objectKey, r.URL.Query().Get("prefix")) {
+					result.Contents = append(result.Contents, struct{ Key string }{objectKey})
+				}
+			}
+			xml.NewEncoder(w).Encode(result)
+			return
+		}
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case http.MethodDelete:
+		objects := map[string][]byte{}
+		for objectKey, content := range f.objects {
+			if objectKey != key {
+				objects[objectKey] = content
+			}
+		}
+		f.objects = objects
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,181 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// VerifyBackup checks the backup read from r without loading it anywhere.
+// The archive is checked while it is read: the checksums of its files, the signature
+// of every document with its history, and the index dumps which are decoded.
+// The encrypted archives and the values of a database with an EncryptionKey need
+// the keys, they are checked with DB.VerifyBackup.
+// It returns the manifest of the archive or the first problem found.
+func VerifyBackup(r io.Reader) (*BackupManifest, error) {
+	return verifyBackup(context.Background(), r, nil, nil)
+}
+
+// VerifyBackup is like the VerifyBackup function but the archive is decrypted with
+// the BackupKey of the options and the values with the EncryptionKey.
+func (d *DB) VerifyBackup(r io.Reader) (*BackupManifest, error) {
+	return verifyBackup(d.ctx, r, d.options.BackupKey, d.valueAEAD)
+}
+
+func verifyBackup(ctx context.Context, r io.Reader, backupKey []byte, valueAEAD cipher.AEAD) (*BackupManifest, error) {
+	encrypted, reader, checkErr := isEncrypted(&contextReader{ctx: ctx, r: r})
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	if encrypted {
+		if backupKey == nil {
+			return nil, ErrMissingKey
+		}
+		// The chunks are authenticated while they are read
+		decrypter, decryptErr := newDecryptReader(reader, backupKey)
+		if decryptErr != nil {
+			return nil, decryptErr
+		}
+		reader = decrypter
+	}
+
+	v := &backupVerifier{
+		// The values are checked the way the collections read them
+		c:        &Collection{valueAEAD: valueAEAD},
+		config:   new(archive),
+		manifest: &BackupManifest{Collections: map[string]int{}},
+		prefixes: map[string]*verifiedPrefix{},
+	}
+	if err := readZipStream(reader, v.verifyFile); err != nil {
+		return nil, err
+	}
+	return v.result()
+}
+
+// verifyFile checks the file of the archive with the given name
+func (v *backupVerifier) verifyFile(name string, r io.Reader) error {
+	switch {
+	case name == "archive":
+		v.hasValues = true
+		return v.verifyValues(r)
+	case name == "deletes":
+		for {
+			if _, err := readBackupPair(r); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	case name == "config.json":
+		return json.NewDecoder(r).Decode(v.config)
+	case strings.HasPrefix(name, "indexes/"):
+		v.manifest.Indexes++
+		return verifyIndexDump(name, r)
+	}
+	return nil
+}
+
+// verifyValues checks and counts the values by collection prefix
+func (v *backupVerifier) verifyValues(r io.Reader) error {
+	// The versions of a key are saved from the newest
+	var previousKey []byte
+	for {
+		pair, err := readBackupPair(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		key := pair.Key
+		newest := !bytes.Equal(key, previousKey)
+		previousKey = key
+		if len(key) < 5 {
+			continue
+		}
+
+		var meta byte
+		if len(pair.UserMeta) != 0 {
+			meta = pair.UserMeta[0]
+		}
+		switch key[4] {
+		case '_', '~':
+			_, err = v.c.getAndCheckContent(key, meta, pair.Value)
+		case '#', '$':
+			_, err = v.c.openValue(key, pair.Value)
+		default:
+			continue
+		}
+
+		prefix, ok := v.prefixes[string(key[:4])]
+		if !ok {
+			prefix = new(verifiedPrefix)
+			v.prefixes[string(key[:4])] = prefix
+		}
+		if err != nil {
+			if prefix.err == nil {
+				prefix.err = err
+			}
+			continue
+		}
+
+		prefix.values++
+		if key[4] == '_' && newest {
+			prefix.documents++
+		}
+	}
+}
+
+// result returns the manifest once the configuration tells which prefixes are collections
+func (v *backupVerifier) result() (*BackupManifest, error) {
+	if !v.hasValues || v.config.Collections == nil {
+		return nil, fmt.Errorf("the archive has no values or no configuration")
+	}
+
+	manifest := v.manifest
+	manifest.StartTime = v.config.StartTime
+	manifest.EndTime = v.config.EndTime
+	manifest.Timestamp = v.config.Timestamp
+	manifest.Since = v.config.Since
+
+	for _, name := range v.config.Collections {
+		manifest.Collections[name] = 0
+
+		id := v.config.CollectionIDs[name]
+		if len(id) < 4 {
+			continue
+		}
+		prefix, ok := v.prefixes[id[:4]]
+		if !ok {
+			continue
+		}
+		if prefix.err != nil {
+			return nil, prefix.err
+		}
+		manifest.Collections[name] = prefix.documents
+		manifest.Values += prefix.values
+	}
+	return manifest, nil
+}
+
+// verifyIndexDump decodes all the entries of the dump
+func verifyIndexDump(name string, r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	header := new(indexDumpEntry)
+	if err := decoder.Decode(header); err != nil {
+		return fmt.Errorf("index dump %q: %s", name, err)
+	}
+	if header.Index == nil {
+		return fmt.Errorf("index dump %q does not start with the index definition", name)
+	}
+	for decoder.More() {
+		if err := decoder.Decode(new(indexDumpEntry)); err != nil {
+			return fmt.Errorf("index dump %q: %s", name, err)
+		}
+	}
+	return nil
+}
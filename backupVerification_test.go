@@ -0,0 +1,80 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	// A previous version is checked too
+	if err := c.Put(users[0].ID, users[1]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	backup := bytes.NewBuffer(nil)
+	if err := db.WriteBackup(ctx, backup); err != nil {
+		t.Error(err)
+		return
+	}
+
+	manifest, err := db.VerifyBackup(bytes.NewReader(backup.Bytes()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if manifest.Collections["testCol"] != len(users) || manifest.Values != len(users)+1 || manifest.Indexes == 0 || manifest.Timestamp == 0 {
+		t.Errorf("unexpected manifest %+v", manifest)
+		return
+	}
+
+	// The clear archives are checked without a database
+	if standalone, err := VerifyBackup(bytes.NewReader(backup.Bytes())); err != nil || standalone.Values != manifest.Values {
+		t.Errorf("unexpected manifest %+v, %v", standalone, err)
+		return
+	}
+	if _, err := VerifyBackup(bytes.NewReader(backup.Bytes()[:backup.Len()-10])); err == nil {
+		t.Errorf("the truncated archive must not be valid")
+		return
+	}
+
+	// The checksums of the archive
+	corrupted := append([]byte{}, backup.Bytes()...)
+	corrupted[len(corrupted)/3] ^= 0xff
+	if _, err := db.VerifyBackup(bytes.NewReader(corrupted)); err == nil {
+		t.Errorf("the corrupted archive must not be valid")
+		return
+	}
+
+	// The signature of the values
+	if err := db.valueStore.Update(func(txn *badger.Txn) error {
+		return txn.Set(c.buildStoreID(users[2].ID), []byte("not the signed content"))
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	backup.Reset()
+	if err := db.WriteBackup(ctx, backup); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.VerifyBackup(backup); err != ErrDataCorrupted {
+		t.Errorf("expected %v but had %v", ErrDataCorrupted, err)
+		return
+	}
+}
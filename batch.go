@@ -0,0 +1,292 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// NewBatch returns a new empty batch of writes for the collection
+func (c *Collection) NewBatch() *WriteBatch {
+	return &WriteBatch{
+		c:          c,
+		operations: []*batchOperation{},
+	}
+}
+
+// Put adds the saving of the given content to the batch.
+// The content is converted right away but nothing is written before Write is called.
+func (b *WriteBatch) Put(id string, content interface{}) error {
+	if id == "" {
+		return ErrEmptyID
+	}
+
+	content, hookErr := b.c.getHooks().beforePut(id, content)
+	if hookErr != nil {
+		return hookErr
+	}
+
+	operation := &batchOperation{
+		id:               id,
+		contentInterface: content,
+	}
+
+	if bytes, ok := content.([]byte); ok {
+		operation.bin = true
+		operation.contentAsBytes = bytes
+	} else {
+		jsonBytes, marshalErr := json.Marshal(content)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		operation.contentAsBytes = jsonBytes
+
+		if err := b.c.checkSchema(id, operation.contentAsBytes); err != nil {
+			return err
+		}
+	}
+
+	var encodeErr error
+	operation.value, operation.meta, encodeErr = b.c.encodeContent(content, operation.contentAsBytes, operation.bin)
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	b.operations = append(b.operations, operation)
+	return nil
+}
+
+// Delete adds the removal of the given ID to the batch
+func (b *WriteBatch) Delete(id string) error {
+	if id == "" {
+		return ErrEmptyID
+	}
+
+	if err := b.c.getHooks().beforeDelete(id); err != nil {
+		return err
+	}
+
+	b.operations = append(b.operations, &batchOperation{
+		id:     id,
+		delete: true,
+	})
+	return nil
+}
+
+// Len returns the number of writes of the batch
+func (b *WriteBatch) Len() int {
+	return len(b.operations)
+}
+
+// Write commits all the writes of the batch in one store transaction and
+// all the index updates in one index transaction. The writes are done in the order
+// they have been added. The batch is empty after a successful write.
+// If the batch is too big for the store transaction nothing is written and
+// badger.ErrTxnTooBig is returned.
+func (b *WriteBatch) Write() error {
+	ctx, cancel := context.WithTimeout(b.c.ctx, b.c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := b.prepare(ctx); err != nil {
+		return err
+	}
+
+	previousContents := b.getPreviousContents()
+
+	// Ordered with the conditional writes of the queue
+	b.c.writeMutex.Lock()
+	err := b.write(ctx)
+	b.c.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	b.afterWrite(previousContents)
+	return nil
+}
+
+// changeRecords returns the records of the operations of the batch for the change log
+func (b *WriteBatch) changeRecords() []*ChangeRecord {
+	records := make([]*ChangeRecord, len(b.operations))
+	for i, operation := range b.operations {
+		if operation.delete {
+			records[i] = &ChangeRecord{ID: operation.id, Operation: ChangeDelete}
+			continue
+		}
+		records[i] = &ChangeRecord{ID: operation.id, Operation: ChangePut, Content: operation.contentAsBytes, Bin: operation.bin}
+	}
+	for _, record := range records {
+		record.Collection = b.c.name
+		record.Origin = b.origin
+	}
+	return records
+}
+
+// prepare checks that the collection can be written
+func (b *WriteBatch) prepare(ctx context.Context) error {
+	b.c.touch()
+	check := b.c.diskSpace.check
+	if b.replicated {
+		check = b.c.diskSpace.checkFreeSpace
+	}
+	if err := check(); err != nil {
+		return err
+	}
+	for _, operation := range b.operations {
+		if !operation.delete {
+			if err := b.c.checkQuota(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return b.c.waitForMaintenance(ctx)
+}
+
+// afterWrite notifies the listeners and runs the hooks of the written operations
+// and empties the batch
+func (b *WriteBatch) afterWrite(previousContents [][]byte) {
+	b.notifyChanges(previousContents)
+
+	hooks := b.c.getHooks()
+	deletes := 0
+	for _, operation := range b.operations {
+		if operation.delete {
+			hooks.afterDelete(operation.id)
+			deletes++
+		} else {
+			hooks.afterPut(operation.id, operation.contentAsBytes)
+		}
+	}
+	b.c.metrics.count(b.c.name, metricPut, len(b.operations)-deletes)
+	b.c.metrics.count(b.c.name, metricDelete, deletes)
+
+	b.operations = []*batchOperation{}
+}
+
+// write runs the store and the index transactions of the batch.
+// The index transaction is committed once the values are saved,
+// only the store transaction is retried.
+func (b *WriteBatch) write(ctx context.Context) error {
+	tx, txErr := b.c.db.Begin(true)
+	if txErr != nil {
+		return txErr
+	}
+	defer tx.Rollback()
+
+	if err := b.updateIndexes(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := b.c.updateStoreWithChanges(ctx, b.writeValues, b.changeRecords()...); err != nil {
+		return err
+	}
+	b.c.addSize(b.writtenSize())
+	return tx.Commit()
+}
+
+// writtenSize returns an estimation of the bytes saved by the batch
+func (b *WriteBatch) writtenSize() (size int) {
+	for _, operation := range b.operations {
+		if !operation.delete {
+			size += len(b.c.buildStoreID(operation.id)) + len(operation.value)
+		}
+	}
+	return size
+}
+
+// writeValues adds the operations of the batch to the store transaction
+func (b *WriteBatch) writeValues(txn *badger.Txn) error {
+	for _, operation := range b.operations {
+		var err error
+		if operation.delete {
+			err = txn.Delete(b.c.buildTrashID(operation.id))
+			if err == nil {
+				err = txn.Delete(b.c.buildStoreID(operation.id))
+			}
+		} else {
+			if b.c.IsWriteOnce() {
+				err = checkAbsent(txn, b.c.buildStoreID(operation.id))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = b.c.setContent(txn, b.c.buildStoreID(operation.id), operation.value, operation.meta, nil, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPreviousContents returns the content saved before every operation of the batch
+// if the collection has subscriptions or watchers
+func (b *WriteBatch) getPreviousContents() [][]byte {
+	if !b.c.hasListeners() {
+		return nil
+	}
+
+	ret := make([][]byte, len(b.operations))
+	current := map[string][]byte{}
+	for i, operation := range b.operations {
+		previous, found := current[operation.id]
+		if !found {
+			previous = b.c.getPrevious(operation.id)
+		}
+		ret[i] = previous
+
+		if operation.delete {
+			current[operation.id] = nil
+		} else {
+			current[operation.id] = operation.contentAsBytes
+		}
+	}
+	return ret
+}
+
+func (b *WriteBatch) notifyChanges(previousContents [][]byte) {
+	if previousContents == nil {
+		return
+	}
+
+	for i, operation := range b.operations {
+		if operation.delete {
+			if previousContents[i] != nil {
+				b.c.notifyChange(ChangeDelete, operation.id, previousContents[i], nil, false)
+			}
+			continue
+		}
+		b.c.notifyChange(ChangePut, operation.id, previousContents[i], operation.contentAsBytes, operation.bin)
+	}
+}
+
+func (b *WriteBatch) updateIndexes(ctx context.Context, tx *bolt.Tx) error {
+	for _, operation := range b.operations {
+		var err error
+		switch {
+		case operation.delete:
+			if content := b.c.writeOnceContent(ctx, operation.id); content != nil {
+				if err := b.c.unindexContent(tx, operation.id, content); err != nil {
+					return err
+				}
+			}
+			err = recordDeletion(tx, operation.id)
+			if err == nil {
+				err = b.c.unindexDocument(ctx, tx, operation.id)
+			}
+		case operation.bin:
+			err = b.c.cleanRefs(ctx, tx, operation.id)
+		default:
+			err = b.c.indexDocument(ctx, tx, operation.id, operation.contentInterface, operation.contentAsBytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)
+
+	batch := c.NewBatch()
+	for _, user := range users {
+		if err := batch.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// The writes are done in order so the first user is saved with the new email
+	users[0].Email = "updated@tlaloc.com"
+	if err := batch.Put(users[0].ID, users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := batch.Delete(users[1].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := batch.Put("bin", []byte{1, 2, 3}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := batch.Put("", users[2]); err != ErrEmptyID {
+		t.Errorf("expected %v but had %v", ErrEmptyID, err)
+		return
+	}
+
+	// Nothing is written before the call to Write
+	if ids, _ := c.GetIDs("", 10); len(ids) != 0 {
+		t.Errorf("the batch is written before Write: %v", ids)
+		return
+	}
+
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+	if batch.Len() != 0 {
+		t.Errorf("the batch must be empty after the write but has %d operations", batch.Len())
+		return
+	}
+
+	ids, _ := c.GetIDs("", 1000)
+	if len(ids) != len(users) {
+		t.Errorf("expected %d documents but had %d", len(users), len(ids))
+		return
+	}
+	if err := c.Get(users[1].ID, nil); err == nil {
+		t.Errorf("the document %q must be deleted", users[1].ID)
+		return
+	}
+
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo("updated@tlaloc.com")))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if _, id, _ := response.First(); id != users[0].ID {
+		t.Errorf("expected %q but had %q", users[0].ID, id)
+		return
+	}
+
+	report, err := c.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the report is not clean: missing %v, dangling %v", report.Missing, report.Dangling)
+		return
+	}
+}
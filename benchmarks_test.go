@@ -195,7 +195,7 @@ func read(b *testing.B, parallel bool) error {
 	if parallel {
 		b.RunParallel(func(pb *testing.PB) {
 			for pb.Next() {
-				_, err := benchmarkCollection.Get(<-getExistingID, nil)
+				err := benchmarkCollection.Get(<-getExistingID, nil)
 				if err != nil {
 					b.Fatal(err)
 					return
@@ -204,7 +204,7 @@ func read(b *testing.B, parallel bool) error {
 		})
 	} else {
 		for i := 0; i < b.N; i++ {
-			_, err := benchmarkCollection.Get(<-getExistingID, nil)
+			err := benchmarkCollection.Get(<-getExistingID, nil)
 			if err != nil {
 				return err
 			}
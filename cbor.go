@@ -0,0 +1,222 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// NewCBORCodec returns a codec saving the documents in the CBOR format of RFC 7049.
+// Like the MessagePack codec, the documents are encoded from their Go values and
+// read back as the JSON of encoding/json.
+func NewCBORCodec() Codec {
+	return &cborCodec{}
+}
+
+func (c *cborCodec) ID() byte {
+	return CBORCodecID
+}
+
+func (c *cborCodec) Name() string {
+	return "cbor"
+}
+
+func (c *cborCodec) EncodeValue(value interface{}) ([]byte, error) {
+	w := &cborWriter{buffer: bytes.NewBuffer(nil)}
+	if err := writeDocument(w, value); err != nil {
+		return nil, err
+	}
+	return w.buffer.Bytes(), nil
+}
+
+func (c *cborCodec) Encode(content []byte) ([]byte, error) {
+	w := &cborWriter{buffer: bytes.NewBuffer(nil)}
+	if err := encodeDocument(w, content); err != nil {
+		return nil, err
+	}
+	return w.buffer.Bytes(), nil
+}
+
+func (c *cborCodec) Decode(encoded []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := decodeCBORValue(buffer, bytes.NewReader(encoded)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeHead writes the major type and the argument with the shortest size
+func (c *cborWriter) writeHead(major byte, n uint64) {
+	w := c.buffer
+	switch {
+	case n < 24:
+		w.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		w.Write([]byte{major<<5 | 24, byte(n)})
+	case n <= math.MaxUint16:
+		w.WriteByte(major<<5 | 25)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		w.WriteByte(major<<5 | 26)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	default:
+		w.WriteByte(major<<5 | 27)
+		binary.Write(w, binary.BigEndian, n)
+	}
+}
+
+func (c *cborWriter) writeNull() {
+	c.buffer.WriteByte(0xf6)
+}
+
+func (c *cborWriter) writeBool(value bool) {
+	if value {
+		c.buffer.WriteByte(0xf5)
+	} else {
+		c.buffer.WriteByte(0xf4)
+	}
+}
+
+func (c *cborWriter) writeInt(i int64) error {
+	if i < 0 {
+		c.writeHead(1, uint64(-1-i))
+	} else {
+		c.writeHead(0, uint64(i))
+	}
+	return nil
+}
+
+func (c *cborWriter) writeUint(u uint64) error {
+	c.writeHead(0, u)
+	return nil
+}
+
+func (c *cborWriter) writeFloat(f float64, bits int) error {
+	if bits == 32 {
+		c.buffer.WriteByte(0xfa)
+		return binary.Write(c.buffer, binary.BigEndian, float32(f))
+	}
+	if isJSONInteger(f) {
+		return c.writeInt(int64(f))
+	}
+	c.buffer.WriteByte(0xfb)
+	return binary.Write(c.buffer, binary.BigEndian, f)
+}
+
+func (c *cborWriter) writeString(s string) {
+	c.writeHead(3, uint64(len(s)))
+	c.buffer.WriteString(s)
+}
+
+func (c *cborWriter) writeKey(key string) {
+	c.writeString(key)
+}
+
+func (c *cborWriter) openMap(n int) {
+	c.writeHead(5, uint64(n))
+}
+
+func (c *cborWriter) openArray(n int) {
+	c.writeHead(4, uint64(n))
+}
+
+func (c *cborWriter) close() {}
+
+// decodeCBORValue reads the next value of r and writes it as JSON
+func decodeCBORValue(w *bytes.Buffer, r *bytes.Reader) error {
+	t, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	major, info := t>>5, t&0x1f
+	switch t {
+	case 0xf4:
+		w.WriteString("false")
+		return nil
+	case 0xf5:
+		w.WriteString("true")
+		return nil
+	case 0xf6:
+		w.WriteString("null")
+		return nil
+	case 0xfa:
+		n, err := readBigEndianUint(r, 4)
+		if err != nil {
+			return err
+		}
+		w.WriteString(formatJSONFloat(float64(math.Float32frombits(uint32(n))), 32))
+		return nil
+	case 0xfb:
+		n, err := readBigEndianUint(r, 8)
+		if err != nil {
+			return err
+		}
+		w.WriteString(formatJSONFloat(math.Float64frombits(n), 64))
+		return nil
+	}
+
+	if info > 27 || major > 5 || major == 2 {
+		return fmt.Errorf("unsupported CBOR type 0x%x", t)
+	}
+	n := uint64(info)
+	if info >= 24 {
+		n, err = readBigEndianUint(r, 1<<(info-24))
+		if err != nil {
+			return err
+		}
+	}
+
+	switch major {
+	case 0:
+		w.WriteString(strconv.FormatUint(n, 10))
+	case 1:
+		if n > math.MaxInt64 {
+			return fmt.Errorf("the CBOR integer is too small")
+		}
+		w.WriteString(strconv.FormatInt(-1-int64(n), 10))
+	case 3:
+		if n > uint64(r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		s := make([]byte, n)
+		r.Read(s)
+		asBytes, err := json.Marshal(string(s))
+		if err != nil {
+			return err
+		}
+		w.Write(asBytes)
+	case 4, 5:
+		return decodeCBORContainer(w, r, major == 5, n)
+	}
+	return nil
+}
+
+func decodeCBORContainer(w *bytes.Buffer, r *bytes.Reader, isMap bool, n uint64) error {
+	open, end := byte('['), byte(']')
+	if isMap {
+		open, end = '{', '}'
+	}
+
+	w.WriteByte(open)
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if isMap {
+			if err := decodeCBORValue(w, r); err != nil {
+				return err
+			}
+			w.WriteByte(':')
+		}
+		if err := decodeCBORValue(w, r); err != nil {
+			return err
+		}
+	}
+	w.WriteByte(end)
+	return nil
+}
@@ -0,0 +1,69 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// matchID returns if the filter selects the ID of the collection, a nil filter selects everything
+func (f *ChangeFilter) matchID(collection, id string) bool {
+	if f == nil {
+		return true
+	}
+	return strings.HasPrefix(id, f.Prefix) && f.matchCollection(collection)
+}
+
+// matchCollection returns if the filter selects the collection
+func (f *ChangeFilter) matchCollection(collection string) bool {
+	if f == nil || len(f.Collections) == 0 {
+		return true
+	}
+	for _, name := range f.Collections {
+		if name == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContent returns if the filter selects the content, the nil content of a delete
+// is selected only if the filter has no selector and no filters
+func (f *ChangeFilter) matchContent(content []byte) bool {
+	if f == nil || (len(f.Selector) == 0 && len(f.Filters) == 0) {
+		return true
+	}
+	if content == nil {
+		return false
+	}
+
+	object, err := decodeStored(content)
+	if err != nil {
+		return false
+	}
+	for _, filter := range f.Filters {
+		if !filter.matchStored(object) {
+			return false
+		}
+	}
+	if len(f.Selector) == 0 {
+		return true
+	}
+
+	value, ok := getValueFromSelector(object, f.Selector)
+	if !ok {
+		return false
+	}
+	valueAsBytes, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+
+	for _, expected := range f.Values {
+		expectedAsBytes, err := json.Marshal(expected)
+		if err == nil && bytes.Equal(valueAsBytes, expectedAsBytes) {
+			return true
+		}
+	}
+	return false
+}
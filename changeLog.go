@@ -0,0 +1,253 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// changeLogPrefix is the prefix of the records of the change log, followed by their version
+var changeLogPrefix = []byte("\x00changes")
+
+// changeLogVersionKey saves the version of the last record of the change log
+var changeLogVersionKey = []byte("\x00changelog version")
+
+// Changes returns the records of the change log written after sinceVersion, in the order of the writes.
+// The last version returned is the one to give to the next call.
+// The records are saved in the store transactions of the writes, so a committed write always has its record.
+// If the records following sinceVersion have been removed from the log because it is over
+// ChangeLogSize or they are older than ChangeLogRetention, the records still in the log
+// are returned with ErrChangeLogTruncated.
+// The caller needs to read the collections again to be up to date.
+func (d *DB) Changes(sinceVersion uint64) ([]*ChangeRecord, error) {
+	lastVersion := d.changeLog.lastVersion()
+
+	ret := []*ChangeRecord{}
+	err := d.valueStore.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(buildChangeLogID(sinceVersion + 1)); iter.ValidForPrefix(changeLogPrefix); iter.Next() {
+			value, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			record, err := d.decodeChangeRecord(iter.Item().Key(), value)
+			if err != nil {
+				return err
+			}
+			ret = append(ret, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sinceVersion < lastVersion {
+		if len(ret) == 0 || ret[0].Version != sinceVersion+1 {
+			return ret, ErrChangeLogTruncated
+		}
+	}
+	return ret, nil
+}
+
+// initChangeLog reads the version of the last record of the change log
+func (d *DB) initChangeLog() error {
+	if d.changeLog == nil {
+		d.changeLog = new(changeLog)
+	}
+
+	// The records can all have expired
+	savedVersion, err := d.getStoredVersion(changeLogVersionKey)
+	if err != nil {
+		return err
+	}
+	d.changeLog.mutex.Lock()
+	if savedVersion > d.changeLog.version {
+		d.changeLog.version = savedVersion
+	}
+	d.changeLog.mutex.Unlock()
+
+	return d.valueStore.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{Reverse: true})
+		defer iter.Close()
+
+		iter.Seek(buildChangeLogID(^uint64(0)))
+		if !iter.ValidForPrefix(changeLogPrefix) {
+			return nil
+		}
+
+		version := binary.BigEndian.Uint64(iter.Item().Key()[len(changeLogPrefix):])
+		d.changeLog.mutex.Lock()
+		if version > d.changeLog.version {
+			d.changeLog.version = version
+		}
+		d.changeLog.mutex.Unlock()
+		return nil
+	})
+}
+
+func (d *DB) decodeChangeRecord(key, value []byte) (*ChangeRecord, error) {
+	if d.valueAEAD != nil {
+		var err error
+		value, err = openValue(d.valueAEAD, key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := new(ChangeRecord)
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// beginChanges locks the change log to add the records of the writes of a store transaction.
+// The records are added to the transaction by add, so they are committed with the writes or not at all.
+// The log stays locked until end is called with the result of the commit, so the records are
+// committed in the order of their versions and a reader never sees a version before the previous one.
+// It returns nil if the change log is disabled, the methods of a nil writer do nothing.
+func (c *Collection) beginChanges(records ...*ChangeRecord) *changeLogWriter {
+	if c.options.ChangeLogSize <= 0 || c.changeLog == nil || len(records) == 0 {
+		return nil
+	}
+
+	for _, record := range records {
+		if record.Collection == "" {
+			record.Collection = c.name
+		}
+		if !c.options.ChangeLogContents {
+			record.Content = nil
+			record.Bin = false
+		}
+	}
+
+	c.changeLog.mutex.Lock()
+	return &changeLogWriter{
+		l:         c.changeLog,
+		records:   records,
+		size:      uint64(c.options.ChangeLogSize),
+		retention: c.options.ChangeLogRetention,
+		aead:      c.valueAEAD,
+		version:   c.changeLog.version,
+	}
+}
+
+// add saves the records into the transaction. It can be called again with
+// an other transaction if the commit is retried, the versions stay the same.
+func (w *changeLogWriter) add(txn *badger.Txn) error {
+	if w == nil {
+		return nil
+	}
+
+	now := time.Now()
+	version := w.version
+	for _, record := range w.records {
+		version++
+		record.Version = version
+		record.Time = now
+
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		key := buildChangeLogID(version)
+		if w.aead != nil {
+			if value, err = sealValue(w.aead, key, value); err != nil {
+				return err
+			}
+		}
+
+		entry := &badger.Entry{Key: key, Value: value}
+		if w.retention > 0 {
+			entry.ExpiresAt = uint64(now.Add(w.retention).Unix())
+		}
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+
+		// The oldest record is removed to keep the size of the log
+		if version > w.size {
+			if err := txn.Delete(buildChangeLogID(version - w.size)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The version is kept even if all the records have expired
+	versionAsBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(versionAsBytes, version)
+	return txn.SetWithDiscard(changeLogVersionKey, versionAsBytes, 0)
+}
+
+// end moves the version of the log if the records are committed and unlocks it
+func (w *changeLogWriter) end(committed bool) {
+	if w == nil {
+		return
+	}
+	if committed {
+		w.l.version = w.version + uint64(len(w.records))
+	}
+	w.l.mutex.Unlock()
+}
+
+// updateStoreWithChanges is like updateStore but the records are committed with the writes of fn
+func (c *Collection) updateStoreWithChanges(ctx context.Context, fn func(txn *badger.Txn) error, records ...*ChangeRecord) error {
+	changes := c.beginChanges(records...)
+	err := c.updateStore(ctx, func(txn *badger.Txn) error {
+		if err := fn(txn); err != nil {
+			return err
+		}
+		return changes.add(txn)
+	})
+	changes.end(err == nil)
+	return err
+}
+
+// lastVersion returns the version of the last record of the change log
+func (l *changeLog) lastVersion() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.version
+}
+
+// getStoredVersion returns the version saved under the key, zero if there is none
+func (d *DB) getStoredVersion(key []byte) (version uint64, _ error) {
+	return version, d.valueStore.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
+		if len(value) == 8 {
+			version = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+}
+
+// setStoredVersion saves the version under the key
+func (d *DB) setStoredVersion(key []byte, version uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, version)
+	return d.valueStore.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func buildChangeLogID(version uint64) []byte {
+	ret := make([]byte, len(changeLogPrefix)+8)
+	copy(ret, changeLogPrefix)
+	binary.BigEndian.PutUint64(ret[len(changeLogPrefix):], version)
+	return ret
+}
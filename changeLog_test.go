@@ -0,0 +1,173 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.ChangeLogSize = 5
+	options.ChangeLogContents = true
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	c, _ := db.Use("testCol")
+	for _, id := range []string{"a", "b", "c"} {
+		if err := c.Put(id, map[string]string{"ID": id}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	records, err := db.Changes(0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(records) != 3 || records[0].Version != 1 || records[2].Version != 3 {
+		t.Errorf("unexpected records %+v", records)
+		return
+	}
+	if records[2].ID != "c" || records[2].Collection != "testCol" || records[2].Operation != ChangePut || string(records[2].Content) != `{"ID":"c"}` {
+		t.Errorf("unexpected record %+v", records[2])
+		return
+	}
+
+	if err := c.Delete("a"); err != nil {
+		t.Error(err)
+		return
+	}
+	records, err = db.Changes(3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(records) != 1 || records[0].Version != 4 || records[0].ID != "a" || records[0].Operation != ChangeDelete || records[0].Content != nil {
+		t.Errorf("unexpected records %+v", records)
+		return
+	}
+
+	batch := c.NewBatch()
+	batch.Put("d", map[string]string{"ID": "d"})
+	batch.Delete("b")
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The first record is over the size of the log
+	records, err = db.Changes(0)
+	if err != ErrChangeLogTruncated {
+		t.Errorf("expected %v but got %v", ErrChangeLogTruncated, err)
+		return
+	}
+	if len(records) != 5 || records[0].Version != 2 || records[4].Version != 6 || records[4].ID != "b" {
+		t.Errorf("unexpected records %+v", records)
+		return
+	}
+	if records, err = db.Changes(6); err != nil || len(records) != 0 {
+		t.Errorf("unexpected records %+v and error %v", records, err)
+		return
+	}
+
+	// The versions continue after a restart
+	db.Close()
+	options.Path = testPath
+	db, openDBErr = Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ = db.Use("testCol")
+	if err := c.Put("e", map[string]string{"ID": "e"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.RunInTransaction(ctx, func(tx *Tx) error {
+		return tx.Collection("testCol").Put("f", map[string]string{"ID": "f"})
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	records, err = db.Changes(6)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(records) != 2 || records[0].Version != 7 || records[0].ID != "e" || records[1].Version != 8 || records[1].ID != "f" {
+		t.Errorf("unexpected records %+v", records)
+	}
+}
+
+func TestChangeLogRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.ChangeLogSize = 100
+	options.ChangeLogRetention = time.Second
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	c, _ := db.Use("testCol")
+	for _, id := range []string{"a", "b"} {
+		if err := c.Put(id, map[string]string{"ID": id}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if records, err := db.Changes(0); err != nil || len(records) != 2 {
+		t.Errorf("unexpected records %+v and error %v", records, err)
+		return
+	}
+
+	time.Sleep(time.Second * 2)
+	if records, err := db.Changes(0); err != ErrChangeLogTruncated || len(records) != 0 {
+		t.Errorf("expected %v but had %+v and %v", ErrChangeLogTruncated, records, err)
+		return
+	}
+
+	// The versions continue after a restart even if all the records have expired
+	db.Close()
+	options.Path = testPath
+	db, openDBErr = Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ = db.Use("testCol")
+	if err := c.Put("c", map[string]string{"ID": "c"}); err != nil {
+		t.Error(err)
+		return
+	}
+	records, err := db.Changes(2)
+	if err != nil || len(records) != 1 || records[0].Version != 3 {
+		t.Errorf("unexpected records %+v and error %v", records, err)
+	}
+}
@@ -0,0 +1,243 @@
+package gotinydb
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	codecs = map[byte]Codec{
+		FlateCodecID:    NewFlateCodec(flate.DefaultCompression),
+		MsgpackCodecID:  NewMsgpackCodec(),
+		CBORCodecID:     NewCBORCodec(),
+		ProtobufCodecID: NewProtobufCodec(),
+		GobCodecID:      NewGobCodec(),
+	}
+	codecsMutex sync.RWMutex
+)
+
+// RegisterCodec makes the codec available to the collections. The codecs used
+// by the saved values must be registered before the database is opened.
+func RegisterCodec(codec Codec) error {
+	if codec == nil || codec.ID() == 0 || codec.ID()&(timedValueFlag|binaryValueFlag) != 0 {
+		return ErrUnknownCodec
+	}
+
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	if _, found := codecs[codec.ID()]; found {
+		return ErrCodecExists
+	}
+	codecs[codec.ID()] = codec
+	return nil
+}
+
+func getCodec(id byte) (Codec, error) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	codec, found := codecs[id]
+	if !found {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+// NewFlateCodec returns a codec using the deflate compression at the given level
+func NewFlateCodec(level int) Codec {
+	return &flateCodec{level: level}
+}
+
+func (f *flateCodec) ID() byte {
+	return FlateCodecID
+}
+
+func (f *flateCodec) Name() string {
+	return "flate"
+}
+
+func (f *flateCodec) Encode(content []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	writer, err := flate.NewWriter(buffer, f.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (f *flateCodec) Decode(encoded []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(encoded))
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// SetCodec defines the codec used to save the next contents of the collection.
+// Nil saves them without compression. The saved values are not rewritten.
+// The codec is saved by its ID, after the next Open the registered codec with
+// this ID is used and SetCodec needs to be called again for other settings.
+func (c *Collection) SetCodec(codec Codec) error {
+	id := byte(0)
+	if codec != nil {
+		id = codec.ID()
+		if registered, err := getCodec(id); err != nil {
+			return err
+		} else if registered.Name() != codec.Name() {
+			return ErrCodecExists
+		}
+	}
+
+	if err := c.updateIndex(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("config")).Put([]byte("codec"), []byte{id})
+	}); err != nil {
+		return err
+	}
+
+	c.codecMutex.Lock()
+	c.codec = codec
+	c.codecMutex.Unlock()
+	return nil
+}
+
+// SetOptions applies the settings of the collection. The codec is the one of SetCodec.
+func (c *Collection) SetOptions(options *CollectionOptions) error {
+	if options == nil {
+		options = new(CollectionOptions)
+	}
+	return c.SetCodec(options.Codec)
+}
+
+// Codec returns the codec used to save the contents, nil if they are not compressed
+func (c *Collection) Codec() Codec {
+	c.codecMutex.RLock()
+	defer c.codecMutex.RUnlock()
+	return c.codec
+}
+
+func (c *Collection) loadCodec() (err error) {
+	id := byte(0)
+	c.viewIndex(func(tx *bolt.Tx) error {
+		if saved := tx.Bucket([]byte("config")).Get([]byte("codec")); len(saved) == 1 {
+			id = saved[0]
+		}
+		return nil
+	})
+
+	var codec Codec
+	if id != 0 {
+		codec, err = getCodec(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.codecMutex.Lock()
+	c.codec = codec
+	c.codecMutex.Unlock()
+	return nil
+}
+
+// encodeContent returns the content as it is saved into the store with the ID of its codec
+// and binaryValueFlag. The documents are given to the ValueCodec codecs as their Go values.
+// The content is saved as it is if the codec does not make it smaller or can't encode it.
+func (c *Collection) encodeContent(contentInterface interface{}, contentAsBytes []byte, bin bool) (value []byte, meta byte, _ error) {
+	if bin {
+		meta = binaryValueFlag
+	}
+
+	codec := c.Codec()
+	if codec == nil {
+		return signContent(contentAsBytes), meta, nil
+	}
+
+	var encoded []byte
+	var err error
+	if valueCodec, ok := codec.(ValueCodec); ok && !bin {
+		encoded, err = valueCodec.EncodeValue(contentInterface)
+	} else {
+		encoded, err = codec.Encode(contentAsBytes)
+	}
+	if err == ErrNotEncodable || (err == nil && len(encoded) >= len(contentAsBytes)) {
+		return signContent(contentAsBytes), meta, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	// The signature is the one of the content to check the decoding too
+	return append(hashSignature(contentAsBytes), encoded...), meta | codec.ID(), nil
+}
+
+// decodeContent returns the content of the value saved with the given codec
+func decodeContent(codecID byte, encoded []byte) ([]byte, error) {
+	if codecID == 0 {
+		return encoded, nil
+	}
+
+	codec, err := getCodec(codecID)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(encoded)
+}
+
+// CompressionStats reads all the saved contents of the collection to measure the compression
+func (c *Collection) CompressionStats() (*CompressionStats, error) {
+	stats := new(CompressionStats)
+	if codec := c.Codec(); codec != nil {
+		stats.Codec = codec.Name()
+	}
+
+	err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		prefix := []byte(c.id[:4] + "_")
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+
+			value, err := item.Value()
+			if err != nil {
+				return err
+			}
+			content, err := c.getAndCheckContent(item.Key(), item.UserMeta(), value)
+			if err != nil {
+				return err
+			}
+
+			stats.Documents++
+			stats.RawSize += int64(len(content))
+			stats.StoredSize += int64(len(value))
+			if codecOfMeta(item.UserMeta()) != 0 {
+				stats.Compressed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Ratio returns the raw size divided by the stored size, 0 if the collection is empty
+func (s *CompressionStats) Ratio() float64 {
+	if s.StoredSize == 0 {
+		return 0
+	}
+	return float64(s.RawSize) / float64(s.StoredSize)
+}
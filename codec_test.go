@@ -0,0 +1,340 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+type reverseCodec struct{}
+
+func (r *reverseCodec) ID() byte     { return 50 }
+func (r *reverseCodec) Name() string { return "reverse" }
+func (r *reverseCodec) Encode(content []byte) ([]byte, error) {
+	ret := make([]byte, len(content)/2)
+	for i := range ret {
+		ret[i] = content[len(content)-1-i]
+	}
+	return ret, nil
+}
+func (r *reverseCodec) Decode(encoded []byte) ([]byte, error) { return encoded, nil }
+
+func TestCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	logs, _ := db.Use("logs")
+	blobs, _ := db.Use("blobs")
+	if err := logs.SetCodec(NewFlateCodec(9)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := blobs.SetCodec(NewFlateCodec(1)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	logLine := map[string]string{"Message": strings.Repeat("connection accepted from 127.0.0.1 ", 100)}
+	if err := logs.Put("log", logLine); err != nil {
+		t.Error(err)
+		return
+	}
+	blob := make([]byte, 4096)
+	rand.Read(blob)
+	if err := blobs.Put("blob", blob); err != nil {
+		t.Error(err)
+		return
+	}
+
+	db.Close()
+	db, openDBErr = Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	logs, _ = db.Use("logs")
+	blobs, _ = db.Use("blobs")
+	if logs.Codec() == nil || logs.Codec().Name() != "flate" {
+		t.Errorf("the codec is not saved")
+		return
+	}
+
+	readLog := map[string]string{}
+	if err := logs.Get("log", &readLog); err != nil {
+		t.Error(err)
+		return
+	}
+	if readLog["Message"] != logLine["Message"] {
+		t.Errorf("the log is not the same")
+		return
+	}
+	readBlob, getErr := blobs.GetRaw("blob")
+	if getErr != nil {
+		t.Error(getErr)
+		return
+	}
+	if !bytes.Equal(readBlob, blob) {
+		t.Errorf("the blob is not the same")
+		return
+	}
+	var blobDest []byte
+	if err := blobs.Get("blob", &blobDest); err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(blobDest, blob) {
+		t.Errorf("the blob decoded into a slice is not the same")
+		return
+	}
+
+	stats, statsErr := logs.CompressionStats()
+	if statsErr != nil {
+		t.Error(statsErr)
+		return
+	}
+	if stats.Documents != 1 || stats.Compressed != 1 || stats.Ratio() < 10 {
+		t.Errorf("the log should be compressed: %+v", stats)
+		return
+	}
+	// The blob does not compress so it is saved as it is
+	stats, _ = blobs.CompressionStats()
+	if stats.Compressed != 0 || stats.Ratio() >= 1 {
+		t.Errorf("the blob should not be compressed: %+v", stats)
+		return
+	}
+
+	// The contents are checked after the decoding
+	if err := RegisterCodec(&reverseCodec{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := RegisterCodec(&reverseCodec{}); err != ErrCodecExists {
+		t.Errorf("expected %v but had %v", ErrCodecExists, err)
+		return
+	}
+	if err := logs.SetCodec(&reverseCodec{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := logs.Put("broken", logLine); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := logs.Get("broken", nil); err != ErrDataCorrupted {
+		t.Errorf("expected %v but had %v", ErrDataCorrupted, err)
+		return
+	}
+	// The previous values are still readable
+	if err := logs.Get("log", nil); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	codec := NewMsgpackCodec()
+	for _, content := range []string{
+		`{"ID":"1","Age":-300,"Balance":1.5,"Big":12345678901,"Tags":["a",null,true,false],"Empty":{}}`,
+		`[1e+21,0.000001,-70000,"` + strings.Repeat("x", 40) + `",{"Key":[]}]`,
+	} {
+		encoded, err := codec.Encode([]byte(content))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(encoded) >= len(content) {
+			t.Errorf("the content is not encoded: %s", content)
+			return
+		}
+		decoded, err := codec.Decode(encoded)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(decoded) != content {
+			t.Errorf("expected %s but had %s", content, decoded)
+			return
+		}
+	}
+	// The contents which are not read back the same are not encoded
+	for _, content := range []string{`not JSON`, `{"ID": "1"}`, `{"ID":"1"} {}`} {
+		if _, err := codec.Encode([]byte(content)); err != ErrNotEncodable {
+			t.Errorf("%q should not be encoded: %v", content, err)
+			return
+		}
+	}
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetCodec(NewMsgpackCodec()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	stats, _ := c.CompressionStats()
+	if stats.Compressed != len(users) || stats.Ratio() <= 1 {
+		t.Errorf("the users should be encoded: %+v", stats)
+		return
+	}
+
+	// The queries are done on the decoded contents
+	response, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	retrieved := new(User)
+	if _, err := response.One(retrieved); err != nil {
+		t.Error(err)
+		return
+	}
+	if retrieved.ID != users[0].ID || retrieved.Balance != users[0].Balance {
+		t.Errorf("expected %v but had %v", users[0], retrieved)
+		return
+	}
+}
+
+func TestBinaryCodecs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	users := unmarshalDataSet(dataSet1)
+	for _, codec := range []Codec{NewMsgpackCodec(), NewCBORCodec(), NewProtobufCodec(), NewGobCodec()} {
+		// The values are encoded from the Go values and read back as their JSON
+		for _, user := range users {
+			expected, _ := json.Marshal(user)
+			encoded, err := codec.(ValueCodec).EncodeValue(user)
+			if err == ErrNotEncodable && codec.ID() == ProtobufCodecID {
+				// The balances do not fit in a double, they are saved as JSON
+				continue
+			} else if err != nil {
+				t.Error(err)
+				return
+			}
+			decoded, err := codec.Decode(encoded)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(decoded, expected) {
+				t.Errorf("%s: expected %s but had %s", codec.Name(), expected, decoded)
+				return
+			}
+		}
+
+		c, err := db.Use(codec.Name(), &CollectionOptions{Codec: codec})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if c.Codec() == nil || c.Codec().ID() != codec.ID() {
+			t.Errorf("the codec %s is not set", codec.Name())
+			return
+		}
+		if err := setIndexes(c); err != nil {
+			t.Error(err)
+			return
+		}
+
+		batch := c.NewBatch()
+		for i, user := range users {
+			if i%2 == 0 {
+				err = c.Put(user.ID, user)
+			} else {
+				err = batch.Put(user.ID, user)
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if err := batch.Write(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		response, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[1].Email)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		retrieved := new(User)
+		if _, err := response.One(retrieved); err != nil {
+			t.Error(err)
+			return
+		}
+		if retrieved.ID != users[1].ID || retrieved.Balance != users[1].Balance {
+			t.Errorf("%s: expected %v but had %v", codec.Name(), users[1], retrieved)
+			return
+		}
+	}
+
+	protobuf := NewProtobufCodec()
+	encoded, err := protobuf.(ValueCodec).EncodeValue(&Address{City: "Safeway", ZipCode: 92})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if decoded, _ := protobuf.Decode(encoded); string(decoded) != `{"City":"Safeway","ZipCode":92}` {
+		t.Errorf("the address is not read back: %s", decoded)
+		return
+	}
+
+	// The options set the codec back to JSON
+	c, _ := db.Use("msgpack", &CollectionOptions{})
+	if c.Codec() != nil {
+		t.Errorf("the codec should be removed")
+		return
+	}
+	stats, _ := c.CompressionStats()
+	if stats.Compressed == 0 {
+		t.Errorf("the saved values should stay encoded: %+v", stats)
+		return
+	}
+}
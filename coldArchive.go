@@ -0,0 +1,452 @@
+package gotinydb
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	archiveManifestFile = "manifest.json"
+	// archiveDocumentsDir holds one file by document, named by the hash of its ID
+	archiveDocumentsDir = "documents/"
+)
+
+// Archive moves the documents selected by the query with their versions into the archive file dest.
+// The archive is compressed, encrypted with the BackupKey of the options if any, and saved read only.
+// It can be read again with DB.AttachArchive.
+// The documents are removed from the collection once the archive is complete and synced,
+// the ones written again since they were archived are kept. An error before that removes
+// the archive and an error during the removal leaves documents into the archive and
+// the collection, but none is ever lost.
+func (c *Collection) Archive(q *Query, dest string) (int, error) {
+	if q != nil {
+		if err := c.checkQueryFilters(q); err != nil {
+			return 0, err
+		}
+	}
+
+	file, openErr := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if openErr != nil {
+		return 0, openErr
+	}
+	defer file.Close()
+
+	var archiveWriter io.Writer = file
+	var encryptWriter *encryptWriter
+	if c.options.BackupKey != nil {
+		var encryptErr error
+		encryptWriter, encryptErr = newEncryptWriter(file, c.options.BackupKey)
+		if encryptErr != nil {
+			os.Remove(dest)
+			return 0, encryptErr
+		}
+		archiveWriter = encryptWriter
+	}
+
+	zipWriter := zip.NewWriter(archiveWriter)
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+
+	manifest := &ArchiveManifest{
+		Collection: c.name,
+		Time:       time.Now(),
+	}
+	for _, index := range c.Indexes() {
+		// The extractors are functions and can't be saved
+		if !index.Extractor {
+			manifest.Indexes = append(manifest.Indexes, index)
+		}
+	}
+
+	versions, archiveErr := c.archiveDocuments(q, zipWriter, manifest)
+	if archiveErr == nil {
+		archiveErr = closeColdArchive(zipWriter, encryptWriter, file, manifest)
+	}
+	if archiveErr == nil {
+		archiveErr = os.Chmod(dest, 0444)
+	}
+	if archiveErr != nil {
+		// Nothing has been removed from the collection yet
+		file.Close()
+		os.Remove(dest)
+		return 0, archiveErr
+	}
+
+	return c.deleteArchived(versions)
+}
+
+// archiveDocuments writes the documents selected by the query into the archive and returns
+// the versions they had by ID. The collection is not changed.
+func (c *Collection) archiveDocuments(q *Query, zipWriter *zip.Writer, manifest *ArchiveManifest) (map[string]uint64, error) {
+	versions := map[string]uint64{}
+
+	iter := c.Iterate(IterOptions{})
+	defer iter.Close()
+	for iter.Next() {
+		contentAsBytes, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+		if q != nil && !c.matchQuery(q, contentAsBytes) {
+			continue
+		}
+
+		document, version, err := c.archiveDocument(iter.ID())
+		if err != nil {
+			return nil, err
+		}
+		documentFile, err := zipWriter.Create(archiveDocumentName(document.ID))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.NewEncoder(documentFile).Encode(document); err != nil {
+			return nil, err
+		}
+
+		if len(document.Versions) > manifest.MaxVersions {
+			manifest.MaxVersions = len(document.Versions)
+		}
+		manifest.Documents++
+		versions[document.ID] = version
+	}
+	return versions, nil
+}
+
+// deleteArchived removes the archived documents which still have the given versions.
+// The deletes are done in batches of DeleteManyBatchSize.
+func (c *Collection) deleteArchived(versions map[string]uint64) (deleted int, _ error) {
+	batch := c.NewBatch()
+	for id, version := range versions {
+		current, err := c.getVersion(id)
+		if err != nil {
+			return deleted, err
+		}
+		if current != version {
+			continue
+		}
+
+		if err := batch.Delete(id); err != nil {
+			return deleted, err
+		}
+		if batch.Len() >= DeleteManyBatchSize {
+			if err := c.writeDeleteManyBatch(batch, &deleted, nil); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, c.writeDeleteManyBatch(batch, &deleted, nil)
+}
+
+// closeColdArchive writes the manifest, closes the archive and syncs it on the disk
+func closeColdArchive(zipWriter *zip.Writer, encryptWriter *encryptWriter, file *os.File, manifest *ArchiveManifest) error {
+	manifestFile, createErr := zipWriter.Create(archiveManifestFile)
+	if createErr != nil {
+		return createErr
+	}
+	if err := json.NewEncoder(manifestFile).Encode(manifest); err != nil {
+		return err
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+	if encryptWriter != nil {
+		if err := encryptWriter.Close(); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// archiveDocument returns the document with its versions from the oldest
+// and the version of its current content
+func (c *Collection) archiveDocument(id string) (*archivedDocument, uint64, error) {
+	versions, err := c.History(id, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	document := &archivedDocument{ID: id}
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		document.Versions = append(document.Versions, &archivedVersion{
+			Time:    version.Time,
+			Meta:    version.Meta,
+			Deleted: version.Deleted,
+			Bin:     !version.Deleted && !json.Valid(version.Content),
+			Content: version.Content,
+		})
+	}
+	return document, versions[0].Timestamp, nil
+}
+
+// archiveDocumentName returns the name of the file of the document into the archive
+func archiveDocumentName(id string) string {
+	return archiveDocumentsDir + buildID(id)
+}
+
+// AttachArchive opens the archive written by Collection.Archive to read it.
+// The documents are read from the archive file when they are asked, the encrypted
+// archives are decrypted in memory once. The archive is closed by Detach or when
+// the database is closed.
+func (d *DB) AttachArchive(path string) (*AttachedArchive, error) {
+	zipReader, closeArchive, err := d.openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := d.newAttachedArchive(zipReader)
+	if err != nil {
+		closeArchive()
+		return nil, err
+	}
+	archive.Path = path
+	archive.closeArchive = closeArchive
+
+	d.archivesMutex.Lock()
+	d.archives = append(d.archives, archive)
+	d.archivesMutex.Unlock()
+
+	return archive, nil
+}
+
+// newAttachedArchive reads the manifest of the archive and builds its indexes
+func (d *DB) newAttachedArchive(zipReader *zip.Reader) (*AttachedArchive, error) {
+	archive := &AttachedArchive{
+		Manifest: new(ArchiveManifest),
+		parent:   d,
+		files:    map[string]*zip.File{},
+	}
+
+	for _, file := range zipReader.File {
+		if file.Name == archiveManifestFile {
+			if err := readArchiveFile(file, func(r io.Reader) error {
+				return json.NewDecoder(r).Decode(archive.Manifest)
+			}); err != nil {
+				return nil, err
+			}
+		} else if strings.HasPrefix(file.Name, archiveDocumentsDir) {
+			archive.files[file.Name] = file
+			archive.documents = append(archive.documents, file)
+		}
+	}
+	if archive.Manifest.Collection == "" {
+		return nil, fmt.Errorf("the archive has no %s", archiveManifestFile)
+	}
+
+	for _, info := range archive.Manifest.Indexes {
+		index := newIndex(info.Name, info.Type, info.Selector...)
+		if err := index.setOptions(info.Options); err != nil {
+			return nil, err
+		}
+		index.options = d.options
+		archive.indexes = append(archive.indexes, index)
+	}
+	return archive, nil
+}
+
+// readArchiveFile calls fn with the content of the given file of the archive
+func readArchiveFile(file *zip.File, fn func(r io.Reader) error) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return fn(reader)
+}
+
+// readArchivedDocument decodes the document of the given file of the archive
+func readArchivedDocument(file *zip.File) (*archivedDocument, error) {
+	document := new(archivedDocument)
+	if err := readArchiveFile(file, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(document)
+	}); err != nil {
+		return nil, err
+	}
+	if len(document.Versions) == 0 {
+		return nil, fmt.Errorf("the archived document %q has no version", document.ID)
+	}
+	return document, nil
+}
+
+// getDocument returns the archived document of the given ID
+func (a *AttachedArchive) getDocument(id string) (*archivedDocument, error) {
+	if id == "" {
+		return nil, ErrEmptyID
+	}
+
+	file, ok := a.files[archiveDocumentName(id)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	document, err := readArchivedDocument(file)
+	if err != nil {
+		return nil, err
+	}
+	if document.ID != id {
+		return nil, ErrNotFound
+	}
+	return document, nil
+}
+
+// Get decodes the last archived content of the given ID into dest like Collection.Get
+func (a *AttachedArchive) Get(id string, dest interface{}) error {
+	contentAsBytes, err := a.GetRaw(id)
+	if err != nil {
+		return err
+	}
+	return unmarshalContent(contentAsBytes, dest)
+}
+
+// GetRaw returns the last archived content of the given ID
+func (a *AttachedArchive) GetRaw(id string) ([]byte, error) {
+	document, err := a.getDocument(id)
+	if err != nil {
+		return nil, err
+	}
+
+	last := document.Versions[len(document.Versions)-1]
+	if last.Deleted {
+		return nil, ErrNotFound
+	}
+	return last.Content, nil
+}
+
+// History returns the archived versions of the given ID from the newest to the oldest like
+// Collection.History. The versions have no timestamp. limit is the maximum number
+// of versions returned, 0 returns all of them.
+func (a *AttachedArchive) History(id string, limit int) ([]*Version, error) {
+	document, err := a.getDocument(id)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*Version{}
+	for i := len(document.Versions) - 1; i >= 0; i-- {
+		if limit > 0 && len(versions) >= limit {
+			break
+		}
+		version := document.Versions[i]
+		versions = append(versions, &Version{
+			Time:    version.Time,
+			Meta:    version.Meta,
+			Deleted: version.Deleted,
+			Content: version.Content,
+		})
+	}
+	return versions, nil
+}
+
+// Query runs the query on the last archived contents and returns them like Collection.Query.
+// The archive has no index file, so all the documents are read. The filters are run
+// with the indexes of the manifest and without index like the change filters otherwise.
+func (a *AttachedArchive) Query(q *Query) (*Response, error) {
+	if q == nil {
+		return nil, nil
+	}
+	if len(q.filters) <= 0 {
+		return nil, fmt.Errorf("query has not get action")
+	}
+
+	ids := []*idType{}
+	contents := map[string][]byte{}
+	for _, file := range a.documents {
+		document, err := readArchivedDocument(file)
+		if err != nil {
+			return nil, err
+		}
+		last := document.Versions[len(document.Versions)-1]
+		if last.Deleted || last.Bin {
+			continue
+		}
+
+		stored, err := decodeStored(last.Content)
+		if err != nil || !a.match(q, stored, last.Content) {
+			continue
+		}
+
+		ids = append(ids, &idType{
+			ID:           document.ID,
+			values:       map[uint64][]byte{q.order: a.orderValue(q, stored, last.Content)},
+			selectorHash: q.order,
+		})
+		contents[document.ID] = last.Content
+	}
+
+	idsMs := &idsTypeMultiSorter{IDs: ids, invert: !q.ascendent}
+	idsMs.Sort(q.limit)
+
+	response := newResponse(len(idsMs.IDs))
+	response.query = q
+	for i, id := range idsMs.IDs {
+		response.list[i] = &ResponseElem{
+			ID:             id,
+			ContentAsBytes: contents[id.ID],
+		}
+	}
+	return response, nil
+}
+
+// match returns true if the content is in the result of all the filters of the query
+func (a *AttachedArchive) match(q *Query, stored map[string]interface{}, contentAsBytes []byte) bool {
+	for _, filter := range q.filters {
+		match, applied := matchFilterWithIndexes(a.indexes, filter, stored, contentAsBytes)
+		if !applied {
+			match = filter.matchStored(stored)
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// orderValue returns the value the content is ordered by, as the index of the order selector has it
+func (a *AttachedArchive) orderValue(q *Query, stored map[string]interface{}, contentAsBytes []byte) []byte {
+	for _, index := range a.indexes {
+		if index.SelectorHash != q.order {
+			continue
+		}
+		if candidates, ok := index.applyToStored(stored, contentAsBytes); ok {
+			return pickCandidate(candidates, nil)
+		}
+	}
+	return nil
+}
+
+// Detach closes the archive. The archive file is not changed.
+func (a *AttachedArchive) Detach() error {
+	a.parent.archivesMutex.Lock()
+	found := false
+	for i, archive := range a.parent.archives {
+		if archive == a {
+			a.parent.archives = append(a.parent.archives[:i], a.parent.archives[i+1:]...)
+			found = true
+			break
+		}
+	}
+	a.parent.archivesMutex.Unlock()
+	if !found {
+		return ErrArchiveNotAttached
+	}
+
+	a.closeArchive()
+	return nil
+}
+
+// attachedArchives returns a copy of the list of the attached archives
+func (d *DB) attachedArchives() []*AttachedArchive {
+	d.archivesMutex.Lock()
+	defer d.archivesMutex.Unlock()
+	return append([]*AttachedArchive{}, d.archives...)
+}
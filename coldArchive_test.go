@@ -0,0 +1,144 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.BackupKey = make([]byte, 32)
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("balance", IntIndex, "Balance"); err != nil {
+		t.Error(err)
+		return
+	}
+	for i, id := range []string{"a", "b", "c", "d"} {
+		if err := c.Put(id, &User{ID: id, Balance: 10 * (i + 1)}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// a has two versions
+	if err := c.PutWithMeta("a", &User{ID: "a", Balance: 15}, map[string]string{"author": "test"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	archivePath := filepath.Join(testPath, "cold.archive")
+	query := NewQuery().SetFilter(NewFilter(Less).SetSelector("Balance").CompareTo(25))
+	archived, err := c.Archive(query, archivePath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if archived != 2 {
+		t.Errorf("expected 2 archived documents but had %d", archived)
+		return
+	}
+	if err := c.Get("a", nil); err != ErrNotFound {
+		t.Errorf("the archived document is still saved: %v", err)
+		return
+	}
+	if err := c.Get("c", nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := c.Archive(query, archivePath); err == nil {
+		t.Errorf("an existing archive must not be replaced")
+		return
+	}
+
+	archive, err := db.AttachArchive(archivePath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if archive.Manifest.Collection != "testCol" || archive.Manifest.Documents != 2 {
+		t.Errorf("unexpected manifest %+v", archive.Manifest)
+		return
+	}
+
+	user := new(User)
+	if err := archive.Get("a", user); err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Balance != 15 {
+		t.Errorf("expected the last version but had %+v", user)
+		return
+	}
+	versions, err := archive.History("a", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(versions) != 2 || versions[0].Meta["author"] != "test" {
+		t.Errorf("unexpected history %+v", versions)
+		return
+	}
+
+	response, err := archive.Query(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Balance").CompareTo(16)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() != 1 {
+		t.Errorf("expected 1 document but had %d", response.Len())
+		return
+	}
+
+	response, err = archive.Query(NewQuery().SetFilter(NewFilter(Less).SetSelector("ID").CompareTo("z")).SetOrder(true, "Balance"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() != 2 || response.list[0].GetID() != "a" || response.list[1].GetID() != "b" {
+		t.Errorf("unexpected response %+v", response.list)
+		return
+	}
+	if err := archive.Get("c", nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	if err := archive.Detach(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := archive.Detach(); err != ErrArchiveNotAttached {
+		t.Errorf("expected %v but had %v", ErrArchiveNotAttached, err)
+		return
+	}
+
+	// The archives still attached are detached when the database is closed
+	archive, err = db.AttachArchive(archivePath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	db.Close()
+	db = nil
+	if err := archive.Detach(); err != ErrArchiveNotAttached {
+		t.Errorf("expected %v but had %v", ErrArchiveNotAttached, err)
+		return
+	}
+}
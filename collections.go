@@ -1,10 +1,13 @@
 package gotinydb
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/dgraph-io/badger"
@@ -12,12 +15,142 @@ import (
 
 // Put add the given content to database with the given ID
 func (c *Collection) Put(id string, content interface{}) error {
-	ctx, cancel := context.WithTimeout(c.ctx, c.options.TransactionTimeOut)
+	return c.PutContext(c.ctx, id, content)
+}
+
+// PutContext does the same as Put and returns the error of ctx if it is done before the result of
+// the write. The write is skipped if ctx is done before it starts, otherwise it may still be applied.
+// The TransactionTimeOut of the options still applies if ctx has a later deadline.
+func (c *Collection) PutContext(ctx context.Context, id string, content interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.TransactionTimeOut)
 	defer cancel()
 
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	return c.put(ctx, id, content, nil)
+}
+
+// PutWithMeta does the same as Put and saves the given metadata with the version,
+// like the author or the reason of the write. The metadata are returned by History.
+func (c *Collection) PutWithMeta(id string, content interface{}, meta map[string]string) error {
+	return c.PutWithMetaContext(c.ctx, id, content, meta)
+}
+
+// PutWithMetaContext does the same as PutWithMeta with the context of PutContext
+func (c *Collection) PutWithMetaContext(ctx context.Context, id string, content interface{}, meta map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	return c.put(ctx, id, content, &putOptions{metadata: meta})
+}
+
+// PutIfAbsent saves the content only if the ID is not already saved.
+// Otherwise ErrIDExists is returned.
+func (c *Collection) PutIfAbsent(id string, content interface{}) error {
+	return c.PutIfAbsentContext(c.ctx, id, content)
+}
+
+// PutIfAbsentContext does the same as PutIfAbsent with the context of PutContext
+func (c *Collection) PutIfAbsentContext(ctx context.Context, id string, content interface{}) error {
+	return c.PutIfVersionContext(ctx, id, content, 0)
+}
+
+// PutIfVersion saves the content only if the saved version of the ID is expectedVersion.
+// The version is given by Version and 0 means that the ID must not be saved.
+// Otherwise ErrVersionMismatch or ErrIDExists is returned.
+// The check is done in the same queue as Put and the deletes, the batches and the
+// transactions of the collection wait for the end of the write, so nothing can be written in between.
+func (c *Collection) PutIfVersion(id string, content interface{}, expectedVersion uint64) error {
+	return c.PutIfVersionContext(c.ctx, id, content, expectedVersion)
+}
+
+// PutIfVersionContext does the same as PutIfVersion with the context of PutContext
+func (c *Collection) PutIfVersionContext(ctx context.Context, id string, content interface{}, expectedVersion uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	return c.put(ctx, id, content, &putOptions{condition: func() error {
+		version, err := c.getVersion(id)
+		if err != nil {
+			return err
+		}
+
+		if version != expectedVersion {
+			if expectedVersion == 0 {
+				return ErrIDExists
+			}
+			return ErrVersionMismatch
+		}
+		return nil
+	}})
+}
+
+// PutRev is PutIfVersion with the revision returned by GetRev, which is the version of the content.
+// ErrConflict is returned if the content was written since the revision was read,
+// or if the ID is already saved when rev is 0. The content must then be read again.
+func (c *Collection) PutRev(id string, content interface{}, rev uint64) error {
+	err := c.PutIfVersion(id, content, rev)
+	if err == ErrVersionMismatch || err == ErrIDExists {
+		return ErrConflict
+	}
+	return err
+}
+
+// Version returns the version of the saved content of the given ID.
+// It changes at every write and is 0 if the ID is not saved.
+func (c *Collection) Version(id string) (uint64, error) {
+	if id == "" {
+		return 0, ErrEmptyID
+	}
+	return c.getVersion(id)
+}
+
+func (c *Collection) put(ctx context.Context, id string, content interface{}, options *putOptions) error {
+	if options == nil {
+		options = new(putOptions)
+	}
+
+	c.touch()
+	check := c.diskSpace.check
+	if options.replicated {
+		check = c.diskSpace.checkFreeSpace
+	}
+	if err := check(); err != nil {
+		return err
+	}
+	if err := c.checkQuota(); err != nil {
+		return err
+	}
+
+	hooks := c.getHooks()
+	content, hookErr := hooks.beforePut(id, content)
+	if hookErr != nil {
+		return hookErr
+	}
+
 	tr := newTransaction(id)
 	tr.ctx = ctx
 	tr.contentInterface = content
+	tr.condition = options.condition
+	if c.IsWriteOnce() {
+		tr.condition = c.writeOnceCondition(id, options.condition)
+	}
+	tr.operation = options.operation
+	if tr.operation == "" {
+		tr.operation = ChangePut
+	}
+	tr.ttl = options.ttl
+	tr.metadata = options.metadata
 
 	if bytes, ok := content.([]byte); ok {
 		tr.bin = true
@@ -31,92 +164,354 @@ func (c *Collection) Put(id string, content interface{}) error {
 		}
 
 		tr.contentAsBytes = jsonBytes
+
+		if err := c.checkSchema(id, tr.contentAsBytes); err != nil {
+			return err
+		}
+	}
+
+	var encodeErr error
+	tr.value, tr.meta, encodeErr = c.encodeContent(content, tr.contentAsBytes, tr.bin)
+	if encodeErr != nil {
+		return encodeErr
 	}
 
 	// Run the insertion
-	c.writeTransactionChan <- tr
-	// And wait for the end of the insertion
-	s := <-tr.responseChan
+	select {
+	case c.writeTransactionChan <- tr:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// The writer skips the write if ctx is done before it starts,
+	// but once started the write may still be applied after ctx is done.
+	var s error
+	select {
+	case s = <-tr.responseChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		// The queued writes are not done once the database is closed
+		return ErrClosed
+	}
+	if s == nil {
+		hooks.afterPut(id, tr.contentAsBytes)
+	}
 	return s
 }
 
-// Get retrieves the content of the given ID
-func (c *Collection) Get(id string, pointer interface{}) (contentAsBytes []byte, _ error) {
+// Get decodes the content of the given ID into dest, a pointer like for json.Unmarshal.
+// The contents saved as bytes are copied into a *[]byte destination and a nil
+// destination only checks that the ID is saved. GetRaw returns the content as bytes.
+func (c *Collection) Get(id string, dest interface{}) error {
+	return c.GetContext(context.Background(), id, dest)
+}
+
+// GetContext does the same as Get and returns the error of ctx if it is done before the read
+func (c *Collection) GetContext(ctx context.Context, id string, dest interface{}) error {
+	contentAsBytes, err := c.GetRawContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	return unmarshalContent(contentAsBytes, dest)
+}
+
+// GetRaw returns the content of the given ID, decoded by the codec of the collection
+func (c *Collection) GetRaw(id string) ([]byte, error) {
+	return c.GetRawContext(context.Background(), id)
+}
+
+// GetRawContext does the same as GetRaw and returns the error of ctx if it is done before the read
+func (c *Collection) GetRawContext(ctx context.Context, id string) (contentAsBytes []byte, _ error) {
 	if id == "" {
 		return nil, ErrEmptyID
 	}
+	c.touch()
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.options.TransactionTimeOut)
+	ctx, cancel := context.WithTimeout(ctx, c.options.TransactionTimeOut)
 	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	response, getErr := c.get(ctx, id)
 	if getErr != nil {
 		return nil, getErr
 	}
 	contentAsBytes = response[0]
+	c.metrics.count(c.name, metricGet, 1)
 
 	if len(contentAsBytes) == 0 {
 		return nil, fmt.Errorf("content of %q is empty or not present", id)
 	}
+	return contentAsBytes, nil
+}
 
-	if pointer == nil {
-		return contentAsBytes, nil
+// unmarshalContent decodes the content into dest for the Get functions
+func unmarshalContent(contentAsBytes []byte, dest interface{}) error {
+	switch typed := dest.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*typed = append([]byte{}, contentAsBytes...)
+		return nil
 	}
+	return json.Unmarshal(contentAsBytes, dest)
+}
 
-	uMarshalErr := json.Unmarshal(contentAsBytes, pointer)
-	if uMarshalErr != nil {
-		return nil, uMarshalErr
+// GetRev does the same as Get and returns the revision of the content too.
+// The revision is given to PutRev to save an update only if nothing was written in between.
+func (c *Collection) GetRev(id string, pointer interface{}) (rev uint64, contentAsBytes []byte, _ error) {
+	if id == "" {
+		return 0, nil, ErrEmptyID
 	}
+	c.touch()
 
-	return contentAsBytes, nil
+	if err := c.store.View(func(txn *badger.Txn) error {
+		var err error
+		contentAsBytes, err = c.getOne(txn, id)
+		if err != nil {
+			return err
+		}
+
+		item, err := txn.Get(c.buildStoreID(id))
+		if err != nil {
+			return err
+		}
+		rev = item.Version()
+		return nil
+	}); err != nil {
+		return 0, nil, err
+	}
+
+	if err := unmarshalContent(contentAsBytes, pointer); err != nil {
+		return 0, nil, err
+	}
+	return rev, contentAsBytes, nil
 }
 
-// Delete removes the corresponding object if the given ID
+// Delete removes the corresponding object if the given ID.
+// The version kept in the trash by SoftDelete is removed too.
 func (c *Collection) Delete(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), c.options.TransactionTimeOut)
+	return c.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext does the same as Delete and returns the error of ctx if it is done before the delete
+func (c *Collection) DeleteContext(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.TransactionTimeOut)
 	defer cancel()
 
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	return c.delete(ctx, id)
+}
+
+func (c *Collection) delete(ctx context.Context, id string) error {
+	c.touch()
+	if err := c.diskSpace.check(); err != nil {
+		return err
+	}
+
 	if id == "" {
 		return ErrEmptyID
 	}
 
-	if rmStoreErr := c.store.Update(func(txn *badger.Txn) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hooks := c.getHooks()
+	if err := hooks.beforeDelete(id); err != nil {
+		return err
+	}
+
+	previous := c.getPrevious(id)
+	writeOnceContent := c.writeOnceContent(ctx, id)
+
+	// Ordered with the conditional writes of the queue
+	c.writeMutex.Lock()
+	err := c.updateStoreWithChanges(ctx, func(txn *badger.Txn) error {
+		// The soft deleted version is removed too
+		if err := txn.Delete(c.buildTrashID(id)); err != nil {
+			return err
+		}
 		return txn.Delete(c.buildStoreID(id))
-	}); rmStoreErr != nil {
-		return rmStoreErr
+	}, &ChangeRecord{ID: id, Operation: ChangeDelete})
+	if err == nil {
+		err = c.deleteItemFromIndexes(ctx, id, writeOnceContent)
+	}
+	c.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	c.metrics.count(c.name, metricDelete, 1)
+	if err := c.deleteStream(id); err != nil {
+		return err
 	}
 
-	return c.deleteItemFromIndexes(ctx, id)
+	if previous != nil {
+		c.notifyChange(ChangeDelete, id, previous, nil, false)
+	}
+	hooks.afterDelete(id)
+	return nil
 }
 
-// SetIndex enable the collection to index field or sub field
-func (c *Collection) SetIndex(name string, t IndexType, selector ...string) error {
-	i := newIndex(name, t, selector...)
-	i.options = c.options
-	i.getTx = c.db.Begin
+// DeleteMany removes all the documents matching the query and returns the number of deleted documents.
+// The query is run again until it returns nothing, so the deletion is not limited by the query limit.
+// The deletes are done in batches of DeleteManyBatchSize.
+func (c *Collection) DeleteMany(q *Query) (deleted int, _ error) {
+	return c.DeleteManyWithProgress(q, nil)
+}
 
-	if updateErr := c.db.Update(func(tx *bolt.Tx) error {
-		_, createErr := tx.Bucket([]byte("indexes")).CreateBucket([]byte(i.Name))
-		if createErr != nil {
-			return createErr
+// DeleteManyWithProgress does the same as DeleteMany and calls progress with the number
+// of deleted documents after every written batch. The progress function can be nil.
+func (c *Collection) DeleteManyWithProgress(q *Query, progress func(deleted int)) (deleted int, _ error) {
+	for {
+		response, err := c.Query(q)
+		if err != nil {
+			return deleted, err
+		}
+		if response.Len() == 0 {
+			return deleted, nil
+		}
+
+		batch := c.NewBatch()
+		for _, elem := range response.list {
+			if err := batch.Delete(elem.GetID()); err != nil {
+				return deleted, err
+			}
+
+			if batch.Len() >= DeleteManyBatchSize {
+				if err := c.writeDeleteManyBatch(batch, &deleted, progress); err != nil {
+					return deleted, err
+				}
+			}
+		}
+		if err := c.writeDeleteManyBatch(batch, &deleted, progress); err != nil {
+			return deleted, err
 		}
-		return nil
-	}); updateErr != nil {
-		return updateErr
 	}
+}
 
-	c.indexes = append(c.indexes, i)
-	if errSetingIndexIntoConfig := c.setIndexesIntoConfigBucket(i); errSetingIndexIntoConfig != nil {
-		return errSetingIndexIntoConfig
+func (c *Collection) writeDeleteManyBatch(batch *WriteBatch, deleted *int, progress func(deleted int)) error {
+	n := batch.Len()
+	if n == 0 {
+		return nil
 	}
 
-	if err := c.indexAllValues(i); err != nil {
+	if err := batch.Write(); err != nil {
 		return err
 	}
 
+	*deleted += n
+	if progress != nil {
+		progress(*deleted)
+	}
 	return nil
 }
 
+// UpdateMany gives every document returned by the query to the update function
+// and saves the returned contents. It returns the number of updated documents.
+// If the function returns nil the document is not changed. The query is run once,
+// so only the documents in the limits of the query are updated.
+// The writes are done in batches of UpdateManyBatchSize.
+func (c *Collection) UpdateMany(q *Query, update func(id string, content []byte) (interface{}, error)) (updated int, _ error) {
+	response, err := c.Query(q)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := c.NewBatch()
+	for _, elem := range response.list {
+		newContent, err := update(elem.GetID(), elem.ContentAsBytes)
+		if err != nil {
+			return updated, err
+		}
+		if newContent == nil {
+			continue
+		}
+
+		if err := batch.Put(elem.GetID(), newContent); err != nil {
+			return updated, err
+		}
+
+		if batch.Len() >= UpdateManyBatchSize {
+			n := batch.Len()
+			if err := batch.Write(); err != nil {
+				return updated, err
+			}
+			updated += n
+		}
+	}
+
+	n := batch.Len()
+	if err := batch.Write(); err != nil {
+		return updated, err
+	}
+	return updated + n, nil
+}
+
+// SetIndex enable the collection to index field or sub field
+func (c *Collection) SetIndex(name string, t IndexType, selector ...string) error {
+	return c.SetIndexWithOptions(name, t, nil, selector...)
+}
+
+// SetIndexWithOptions does the same as SetIndex but with the given index options.
+// The options can be nil.
+func (c *Collection) SetIndexWithOptions(name string, t IndexType, options *IndexOptions, selector ...string) error {
+	i := newIndex(name, t, selector...)
+	if err := i.setOptions(options); err != nil {
+		return err
+	}
+	return c.setIndex(i)
+}
+
+// SetExtractorIndex enable the collection to index the values returned by the given function.
+// The function receives the document as it is saved and returns the value to index.
+// For StringIndex and HashIndex the value is the string as bytes, for the other types
+// IndexValue gives the expected representation.
+// The queries use the index name as selector.
+// The function is not saved, the index needs to be set again after every Open
+// and it is rebuilt when it is.
+func (c *Collection) SetExtractorIndex(name string, t IndexType, extractor func(doc []byte) ([]byte, bool)) error {
+	if extractor == nil {
+		return fmt.Errorf("the extractor can't be nil")
+	}
+
+	if index := c.getIndex(name); index != nil {
+		if !index.Extractor {
+			return fmt.Errorf("index %q already exists", name)
+		}
+		index.extractor = extractor
+		return c.rebuildIndex(index)
+	}
+
+	i := newIndex(name, t, name)
+	i.Extractor = true
+	i.extractor = extractor
+	return c.setIndex(i)
+}
+
+// Indexes returns the definitions of the indexes of the collection
+func (c *Collection) Indexes() []*IndexInfo {
+	ret := make([]*IndexInfo, len(c.indexes))
+	for i, index := range c.indexes {
+		ret[i] = &IndexInfo{
+			Name:      index.Name,
+			Type:      index.Type,
+			Selector:  index.Selector,
+			Options:   index.getOptions(),
+			Extractor: index.Extractor,
+		}
+		// The name is saved as selector of the extractor indexes
+		if index.Extractor {
+			ret[i].Selector = nil
+		}
+	}
+	return ret
+}
+
 // DeleteIndex remove the index from the collection
 func (c *Collection) DeleteIndex(name string) error {
 	// Find the correct index from the list
@@ -128,7 +523,10 @@ func (c *Collection) DeleteIndex(name string) error {
 			c.indexes = c.indexes[:len(c.indexes)-1]
 
 			// Remove the all index from indexes database
-			return c.db.Update(func(tx *bolt.Tx) error {
+			return c.updateIndex(func(tx *bolt.Tx) error {
+				if err := deleteIndexPostings(tx, name); err != nil {
+					return err
+				}
 				return tx.Bucket([]byte("indexes")).DeleteBucket([]byte(name))
 			})
 		}
@@ -138,19 +536,30 @@ func (c *Collection) DeleteIndex(name string) error {
 }
 
 // Query run the given query to all the collection indexes
-func (c *Collection) Query(q *Query) (response *Response, _ error) {
+func (c *Collection) Query(q *Query) (*Response, error) {
+	return c.query(context.Background(), q, nil)
+}
+
+// QueryContext does the same as Query and stops the query when ctx is done.
+// The timeout of the query still applies if ctx has a later deadline.
+func (c *Collection) QueryContext(ctx context.Context, q *Query) (*Response, error) {
+	return c.query(ctx, q, nil)
+}
+
+// query runs the query with the indexes and the values of the snapshot if view is not nil
+func (c *Collection) query(ctx context.Context, q *Query, view *SnapshotCollection) (response *Response, _ error) {
 	if q == nil {
 		return
 	}
+	c.touch()
 
 	// If no filter the query stops
 	if len(q.filters) <= 0 {
 		return nil, fmt.Errorf("query has not get action")
 	}
 
-	// If no index stop the query
-	if len(c.indexes) <= 0 {
-		return nil, fmt.Errorf("no index in the collection")
+	if err := c.checkQueryFilters(q); err != nil {
+		return nil, err
 	}
 
 	if q.internalLimit > c.options.InternalQueryLimit {
@@ -160,20 +569,125 @@ func (c *Collection) Query(q *Query) (response *Response, _ error) {
 		q.timeout = c.options.QueryTimeOut
 	}
 
+	var trace *QueryTrace
+	if q.trace {
+		trace = new(QueryTrace)
+		start := time.Now()
+		defer func() {
+			if response != nil {
+				trace.Total = time.Since(start)
+				response.trace = trace
+			}
+		}()
+	}
+
+	defer c.metrics.observeQuery(c.name, time.Now())
+
 	// Set a timout
-	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
 	defer cancel()
 
-	tree, err := c.queryGetIDs(ctx, q)
+	tree, err := c.queryGetIDs(ctx, view, q, trace)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.queryCleanAndOrder(ctx, view, q, tree, trace)
+}
+
+// checkQueryFilters checks that the indexes can run the filters of the query
+func (c *Collection) checkQueryFilters(q *Query) error {
+	// If no index stop the query
+	if len(c.indexes) <= 0 {
+		return fmt.Errorf("no index in the collection")
+	}
+
+	for _, index := range c.indexes {
+		for _, filter := range q.filters {
+			if index.doesFilterApplyToIndex(filter) {
+				if err := index.checkFilter(filter); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// QueryIfChanged runs the query and returns ErrNotModified if the response has the given fingerprint.
+// The fingerprint comes from Response.Fingerprint of a previous call.
+func (c *Collection) QueryIfChanged(q *Query, lastFingerprint string) (*Response, error) {
+	response, err := c.Query(q)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.queryCleanAndOrder(ctx, q, tree)
+	if lastFingerprint != "" && response.Fingerprint() == lastFingerprint {
+		return nil, ErrNotModified
+	}
+	return response, nil
+}
+
+// Exists returns true if the given ID is saved. The content is not read.
+func (c *Collection) Exists(id string) (exists bool, _ error) {
+	return c.ExistsContext(context.Background(), id)
+}
+
+// ExistsContext does the same as Exists and returns the error of ctx if it is done before the read
+func (c *Collection) ExistsContext(ctx context.Context, id string) (exists bool, _ error) {
+	if id == "" {
+		return false, ErrEmptyID
+	}
+	c.touch()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	err := c.store.View(func(txn *badger.Txn) error {
+		item, getErr := txn.Get(c.buildStoreID(id))
+		if getErr == badger.ErrKeyNotFound {
+			return nil
+		} else if getErr != nil {
+			return getErr
+		}
+
+		exists = !item.IsDeletedOrExpired()
+		return nil
+	})
+	return exists, err
+}
+
+// Count returns the number of documents of the collection. Only the keys are read.
+func (c *Collection) Count() (n int, _ error) {
+	return c.CountContext(context.Background())
+}
+
+// CountContext does the same as Count and returns the error of ctx if it is done during the count
+func (c *Collection) CountContext(ctx context.Context) (n int, _ error) {
+	c.touch()
+
+	err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		defer iter.Close()
+
+		prefix := []byte(c.id[:4] + "_")
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !iter.Item().IsDeletedOrExpired() {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
 }
 
 // GetIDs returns a list of IDs for the given collection and starting
 // at the given ID. The limit paramiter let caller ask for a portion of the collection.
+// Iterate does the same without loading all the IDs at once.
 func (c *Collection) GetIDs(startID string, limit int) ([]string, error) {
 	records, getElemErr := c.getStoredIDsAndValues(startID, limit, true)
 	if getElemErr != nil {
@@ -189,17 +703,25 @@ func (c *Collection) GetIDs(startID string, limit int) ([]string, error) {
 
 // GetValues returns a list of IDs and values as bytes for the given collection and starting
 // at the given ID. The limit paramiter let caller ask for a portion of the collection.
+// Iterate does the same without loading all the values at once.
 func (c *Collection) GetValues(startID string, limit int) ([]*ResponseElem, error) {
 	return c.getStoredIDsAndValues(startID, limit, false)
 }
 
 // Rollback reset content to a previous version for the given key.
-// The database by default keeps 10 version of the same key.
+// The number of previous versions kept is given by HistoryDepth, 9 by default.
 // previousVersion provide a way to get the wanted version where 0 is the fist previous
 // content and bigger previousVersion is older the content will be.
 // It returns the previous asked version timestamp.
 // Everytime this function is called a new version is added.
 func (c *Collection) Rollback(id string, previousVersion uint) (timestamp uint64, err error) {
+	if c.IsWriteOnce() {
+		return 0, ErrWriteOnce
+	}
+	if int(previousVersion) >= c.HistoryDepth() {
+		return 0, fmt.Errorf("the prior version %d is not kept by the history", previousVersion)
+	}
+
 	var contentAsInterface interface{}
 	found := false
 
@@ -229,7 +751,12 @@ func (c *Collection) Rollback(id string, previousVersion uint) (timestamp uint64
 					return valueErr
 				}
 
-				unmarshalErr := json.Unmarshal(asBytes[8:], &contentAsInterface)
+				contentAsBytes, corrupted := c.getAndCheckContent(item.Key(), item.UserMeta(), asBytes)
+				if corrupted != nil {
+					return corrupted
+				}
+
+				unmarshalErr := json.Unmarshal(contentAsBytes, &contentAsInterface)
 				if unmarshalErr != nil {
 					return unmarshalErr
 				}
@@ -238,6 +765,10 @@ func (c *Collection) Rollback(id string, previousVersion uint) (timestamp uint64
 				found = true
 				return nil
 			}
+			// The earlier versions are removed by the next compaction
+			if iterator.Item().DiscardEarlierVersions() {
+				return nil
+			}
 			previousVersion--
 		}
 		return nil
@@ -250,10 +781,238 @@ func (c *Collection) Rollback(id string, previousVersion uint) (timestamp uint64
 		return 0, fmt.Errorf("the prior version %d was not found", previousVersion)
 	}
 
-	putErr := c.Put(id, contentAsInterface)
+	ctx, cancel := context.WithTimeout(c.ctx, c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return 0, err
+	}
+
+	putErr := c.put(ctx, id, contentAsInterface, &putOptions{operation: ChangeRollback})
 	if putErr != nil {
 		return 0, putErr
 	}
 
 	return timestamp, nil
 }
+
+// History returns the stored versions of the given ID from the newest to the oldest.
+// The timestamps are the ones returned by Rollback and Version. limit is the maximum
+// number of versions returned, 0 returns all the versions kept by the collection.
+// ErrNotFound is returned if the ID has no version.
+func (c *Collection) History(id string, limit int) ([]*Version, error) {
+	versions := []*Version{}
+	storeID := c.buildStoreID(id)
+
+	// The current version is returned with the history
+	if maxVersions := c.HistoryDepth() + 1; limit <= 0 || limit > maxVersions {
+		limit = maxVersions
+	}
+
+	err := c.store.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(
+			badger.IteratorOptions{
+				AllVersions:    true,
+				PrefetchSize:   c.options.BadgerOptions.NumVersionsToKeep,
+				PrefetchValues: true,
+			},
+		)
+		defer iterator.Close()
+
+		for iterator.Seek(storeID); iterator.Valid(); iterator.Next() {
+			item := iterator.Item()
+			if !reflect.DeepEqual(storeID, item.Key()) {
+				return nil
+			}
+			if limit > 0 && len(versions) >= limit {
+				return nil
+			}
+
+			version := &Version{Timestamp: item.Version()}
+			versions = append(versions, version)
+			if item.IsDeletedOrExpired() {
+				version.Deleted = true
+			} else {
+				// The content is kept after the transaction
+				asBytes, valueErr := item.ValueCopy(nil)
+				if valueErr != nil {
+					return valueErr
+				}
+				asBytes, err := c.openValue(item.Key(), asBytes)
+				if err != nil {
+					return err
+				}
+				contentAsBytes, corrupted := c.checkContent(item.UserMeta(), asBytes)
+				if corrupted != nil {
+					return corrupted
+				}
+				version.Time, version.Meta, _ = valueHeader(item.UserMeta(), asBytes)
+				version.Content = contentAsBytes
+			}
+
+			// The earlier versions are removed by the next compaction
+			if item.DiscardEarlierVersions() {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+// RebuildIndex clears the given index and builds it again from the stored documents.
+// It repairs the missing or dangling references reported by VerifyIndexes.
+func (c *Collection) RebuildIndex(name string) error {
+	for _, index := range c.indexes {
+		if index.Name == name {
+			return c.rebuildIndex(index)
+		}
+	}
+
+	return ErrNotFound
+}
+
+// VerifyIndexes checks that all the indexes of the collection match the stored documents.
+// Nothing is changed, use RebuildIndex to repair the indexes listed in the report.
+func (c *Collection) VerifyIndexes() (*IndexReport, error) {
+	report := newIndexReport()
+	if err := c.viewIndex(func(tx *bolt.Tx) error {
+		return c.verifyIndexes(tx, report)
+	}); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ExportIndex writes the definition and the content of the given index to w.
+// The dump can be loaded with ImportIndex to skip the rebuild of the index.
+// The indexes of an encrypted database can't be exported, ErrEncryptedIndexDump is returned.
+func (c *Collection) ExportIndex(name string, w io.Writer) error {
+	if c.indexCipher != nil {
+		return ErrEncryptedIndexDump
+	}
+
+	index := c.getIndex(name)
+	if index == nil {
+		return ErrNotFound
+	}
+
+	return c.viewIndex(func(tx *bolt.Tx) error {
+		return c.exportIndex(tx, index, w)
+	})
+}
+
+// ImportIndex loads an index written by ExportIndex.
+// The index is created if it does not exist, otherwise it must have the same definition
+// and its content is replaced. The dump is not checked against the stored documents,
+// use VerifyIndexes for this.
+// Extractor indexes need to be set with SetExtractorIndex before the import.
+// ErrEncryptedIndexDump is returned if the database has an EncryptionKey.
+func (c *Collection) ImportIndex(r io.Reader) error {
+	if c.indexCipher != nil {
+		return ErrEncryptedIndexDump
+	}
+	return c.importIndex(r)
+}
+
+// Seed loads the documents of the given JSON lines reader into the collection.
+// Every line has the form {"ID": "id", "Content": {...}}.
+// If onlyIfEmpty is true nothing is done when the collection already has documents,
+// this lets the applications ship default data loaded at the first run.
+// The JSON does not keep the Go types, so the numbers are indexed as signed integers
+// and need to be queried with int values. Use SeedAs to index them with the types of a Go value.
+func (c *Collection) Seed(r io.Reader, onlyIfEmpty bool) error {
+	return c.SeedAs(r, onlyIfEmpty, nil)
+}
+
+// SeedAs is like Seed but the content of every line is decoded into the value
+// returned by newDocument before it is saved. The fields are indexed with their
+// Go types, so for example the uint fields are matched by the queries with uint values.
+// newDocument must return a pointer to a new value at every call.
+// If newDocument is nil it does the same as Seed.
+func (c *Collection) SeedAs(r io.Reader, onlyIfEmpty bool, newDocument func() interface{}) error {
+	if onlyIfEmpty {
+		ids, err := c.GetIDs("", 1)
+		if err != nil {
+			return err
+		}
+		if len(ids) != 0 {
+			return nil
+		}
+	}
+
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		record := new(seedRecord)
+		if err := decoder.Decode(record); err != nil {
+			return err
+		}
+		if record.ID == "" {
+			return ErrEmptyID
+		}
+
+		if newDocument == nil {
+			if err := c.Put(record.ID, record.Content); err != nil {
+				return err
+			}
+			continue
+		}
+
+		document := newDocument()
+		if err := json.Unmarshal(record.Content, document); err != nil {
+			return err
+		}
+		if err := c.Put(record.ID, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncTo makes the target collection content the same as this collection.
+// Only the documents which are different are written and the documents
+// which are not in this collection are deleted from the target.
+// The index definitions are not copied, the target indexes the JSON documents with the numbers as signed integers.
+func (c *Collection) SyncTo(target *Collection) error {
+	// Hashes of the target documents, set to nil when the document is found in the source
+	targetHashes := map[string][]byte{}
+	if err := target.forEachStored(func(id string, contentAsBytes []byte) error {
+		targetHashes[id] = buildIDInternal(string(contentAsBytes))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := c.forEachStored(func(id string, contentAsBytes []byte) error {
+		targetHash, found := targetHashes[id]
+		targetHashes[id] = nil
+		if found && bytes.Equal(targetHash, buildIDInternal(string(contentAsBytes))) {
+			return nil
+		}
+
+		if !json.Valid(contentAsBytes) {
+			return target.Put(id, contentAsBytes)
+		}
+		return target.Put(id, json.RawMessage(contentAsBytes))
+	}); err != nil {
+		return err
+	}
+
+	for id, hash := range targetHashes {
+		if hash == nil {
+			continue
+		}
+		if err := target.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package gotinydb
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,7 +18,7 @@ import (
 )
 
 func (c *Collection) loadInfos() error {
-	return c.db.View(func(tx *bolt.Tx) error {
+	return c.viewIndex(func(tx *bolt.Tx) error {
 
 		bucket := tx.Bucket([]byte("config"))
 		if bucket == nil {
@@ -30,7 +33,7 @@ func (c *Collection) loadInfos() error {
 }
 
 func (c *Collection) init(name string) error {
-	return c.db.Update(func(tx *bolt.Tx) error {
+	return c.updateIndex(func(tx *bolt.Tx) error {
 		bucketsToCreate := []string{"config", "indexes", "refs"}
 		for _, bucketName := range bucketsToCreate {
 			if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
@@ -51,7 +54,7 @@ func (c *Collection) init(name string) error {
 
 func (c *Collection) getIndexesFromConfigBucket() []*indexType {
 	indexes := []*indexType{}
-	c.db.View(func(tx *bolt.Tx) error {
+	c.viewIndex(func(tx *bolt.Tx) error {
 		indexesAsBytes := tx.Bucket([]byte("config")).Get([]byte("indexesList"))
 		json.Unmarshal(indexesAsBytes, &indexes)
 
@@ -61,7 +64,7 @@ func (c *Collection) getIndexesFromConfigBucket() []*indexType {
 }
 
 func (c *Collection) setIndexesIntoConfigBucket(index *indexType) error {
-	return c.db.Update(func(tx *bolt.Tx) error {
+	return c.updateIndex(func(tx *bolt.Tx) error {
 		confBucket := tx.Bucket([]byte("config"))
 		indexesAsBytes := confBucket.Get([]byte("indexesList"))
 		indexes := []*indexType{}
@@ -84,6 +87,24 @@ func (c *Collection) setIndexesIntoConfigBucket(index *indexType) error {
 	})
 }
 
+func (c *Collection) getSchemaFromConfigBucket() []*SchemaField {
+	schema := []*SchemaField{}
+	c.viewIndex(func(tx *bolt.Tx) error {
+		schemaAsBytes := tx.Bucket([]byte("config")).Get([]byte("schema"))
+		json.Unmarshal(schemaAsBytes, &schema)
+
+		return nil
+	})
+	return schema
+}
+
+func (c *Collection) setSchemaIntoConfigBucket(schema []*SchemaField) error {
+	return c.updateIndex(func(tx *bolt.Tx) error {
+		schemaAsBytes, _ := json.Marshal(schema)
+		return tx.Bucket([]byte("config")).Put([]byte("schema"), schemaAsBytes)
+	})
+}
+
 func (c *Collection) initWriteTransactionChan(ctx context.Context) {
 	c.writeTransactionChan = make(chan *writeTransaction, 1000)
 	go func() {
@@ -99,6 +120,39 @@ func (c *Collection) initWriteTransactionChan(ctx context.Context) {
 }
 
 func (c *Collection) putTransaction(tr *writeTransaction) {
+	// The caller does not wait anymore
+	if err := tr.ctx.Err(); err != nil {
+		tr.responseChan <- err
+		return
+	}
+
+	// The other writes wait for the end of this one so nothing can be written between the check and the write
+	c.writeMutex.Lock()
+	if tr.condition != nil {
+		if err := tr.condition(); err != nil {
+			c.writeMutex.Unlock()
+			tr.responseChan <- err
+			return
+		}
+	}
+
+	previous := c.getPrevious(tr.id)
+
+	// Respond to the caller with the error if any
+	err := c.writeStoreAndIndexes(tr)
+	c.writeMutex.Unlock()
+	if err == nil {
+		c.metrics.count(c.name, metricPut, 1)
+	}
+	if err == nil && c.hasListeners() {
+		c.notifyChange(tr.operation, tr.id, previous, tr.contentAsBytes, tr.bin)
+	}
+	tr.responseChan <- err
+}
+
+// writeStoreAndIndexes saves the content and updates the indexes in parallel,
+// the two transactions are committed only if both succeed
+func (c *Collection) writeStoreAndIndexes(tr *writeTransaction) error {
 	// Build a waiting groups
 	// This group is to make internal functions wait the otherone
 	wgActions := new(sync.WaitGroup)
@@ -122,8 +176,43 @@ func (c *Collection) putTransaction(tr *writeTransaction) {
 		go c.onlyCleanRefs(tr.ctx, errChan, wgActions, wgCommitted, tr)
 	}
 
-	// Respond to the caller with the error if any
-	tr.responseChan <- waitForDoneErrOrCanceled(tr.ctx, wgCommitted, errChan)
+	return waitForDoneErrOrCanceled(tr.ctx, wgCommitted, errChan)
+}
+
+// viewIndex runs fn in a read transaction of the index file
+func (c *Collection) viewIndex(fn func(tx *bolt.Tx) error) error {
+	c.dbLock.share()
+	defer c.dbLock.unshare()
+	return c.db.View(fn)
+}
+
+// updateIndex runs fn in a write transaction of the index file
+func (c *Collection) updateIndex(fn func(tx *bolt.Tx) error) error {
+	c.dbLock.share()
+	defer c.dbLock.unshare()
+	return c.db.Update(fn)
+}
+
+// beginIndexView starts a read transaction of the index file.
+// The index file is not replaced until the returned function ends it.
+func (c *Collection) beginIndexView() (*bolt.Tx, func(), error) {
+	c.dbLock.share()
+	tx, err := c.db.Begin(false)
+	if err != nil {
+		c.dbLock.unshare()
+		return nil, nil, err
+	}
+	return tx, func() {
+		tx.Rollback()
+		c.dbLock.unshare()
+	}, nil
+}
+
+// indexFilePath returns the path of the index file
+func (c *Collection) indexFilePath() string {
+	c.dbLock.share()
+	defer c.dbLock.unshare()
+	return c.db.Path()
 }
 
 func (c *Collection) buildStoreID(id string) []byte {
@@ -136,47 +225,46 @@ func (c *Collection) putIntoIndexes(ctx context.Context, errChan chan error, wgA
 		errChan <- txErr
 		return txErr
 	}
-	// return c.db.Update(func(tx *bolt.Tx) error {
-	err := c.cleanRefs(ctx, tx, writeTransaction.id)
+
+	err := c.indexDocument(ctx, tx, writeTransaction.id, writeTransaction.contentInterface, writeTransaction.contentAsBytes)
+	if err == nil {
+		err = c.addExpiration(tx, writeTransaction)
+	}
 	if err != nil {
 		errChan <- err
+		tx.Rollback()
 		return err
 	}
 
-	refsBucket := tx.Bucket([]byte("refs"))
-	refsAsBytes := refsBucket.Get(buildBytesID(writeTransaction.id))
-	refs := newRefs()
-	if refsAsBytes != nil && len(refsAsBytes) > 0 {
-		if err := json.Unmarshal(refsAsBytes, refs); err != nil {
-			errChan <- err
-			return err
-		}
+	return c.endOfIndexUpdate(ctx, tx, errChan, wgActions, wgCommitted)
+}
+
+// indexDocument removes the previous references of the document and adds it to the indexes
+func (c *Collection) indexDocument(ctx context.Context, tx *bolt.Tx, id string, contentInterface interface{}, contentAsBytes []byte) error {
+	if c.IsWriteOnce() {
+		return c.indexWriteOnce(tx, id, contentInterface, contentAsBytes)
+	}
+
+	err := c.cleanRefs(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	refs, err := c.getRefs(tx, id)
+	if err != nil {
+		return err
 	}
 
 	if refs.ObjectID == "" {
-		refs.ObjectID = writeTransaction.id
+		refs.ObjectID = id
 	}
 	if refs.ObjectHashID == "" {
-		refs.ObjectHashID = buildID(writeTransaction.id)
+		refs.ObjectHashID = buildID(id)
 	}
 
 	for _, index := range c.indexes {
-		if indexedValue, apply := index.apply(writeTransaction.contentInterface); apply {
-			indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte(index.Name))
-
-			idsAsBytes := indexBucket.Get(indexedValue)
-			ids, parseIDsErr := newIDs(ctx, 0, nil, idsAsBytes)
-			if parseIDsErr != nil {
-				errChan <- parseIDsErr
-				return parseIDsErr
-			}
-
-			id := newID(ctx, writeTransaction.id)
-			ids.AddID(id)
-			idsAsBytes = ids.MustMarshal()
-
-			if err := indexBucket.Put(indexedValue, idsAsBytes); err != nil {
-				errChan <- err
+		if indexedValue, apply := index.applyToDocument(contentInterface, contentAsBytes); apply {
+			if err := index.addToPosting(tx, index.storageKey(indexedValue), id); err != nil {
 				return err
 			}
 
@@ -184,14 +272,7 @@ func (c *Collection) putIntoIndexes(ctx context.Context, errChan chan error, wgA
 		}
 	}
 
-	putErr := refsBucket.Put(refs.IDasBytes(), refs.asBytes())
-	if putErr != nil {
-		errChan <- err
-		return err
-	}
-
-	return c.endOfIndexUpdate(ctx, tx, errChan, wgActions, wgCommitted)
-	// })
+	return c.putRefs(tx, refs)
 }
 
 func (c *Collection) onlyCleanRefs(ctx context.Context, errChan chan error, wgActions, wgCommitted *sync.WaitGroup, writeTransaction *writeTransaction) error {
@@ -202,8 +283,12 @@ func (c *Collection) onlyCleanRefs(ctx context.Context, errChan chan error, wgAc
 	}
 	// return c.db.Update(func(tx *bolt.Tx) error {
 	err := c.cleanRefs(ctx, tx, writeTransaction.id)
+	if err == nil {
+		err = c.addExpiration(tx, writeTransaction)
+	}
 	if err != nil {
 		errChan <- err
+		tx.Rollback()
 		return err
 	}
 
@@ -235,16 +320,10 @@ func (c *Collection) endOfIndexUpdate(ctx context.Context, tx *bolt.Tx, errChan
 }
 
 func (c *Collection) cleanRefs(ctx context.Context, tx *bolt.Tx, idAsString string) error {
-	indexBucket := tx.Bucket([]byte("indexes"))
-	refsBucket := tx.Bucket([]byte("refs"))
-
 	// Get the references of the given ID
-	refsAsBytes := refsBucket.Get(buildBytesID(idAsString))
-	refs := newRefs()
-	if refsAsBytes != nil && len(refsAsBytes) > 0 {
-		if err := json.Unmarshal(refsAsBytes, refs); err != nil {
-			return err
-		}
+	refs, err := c.getRefs(tx, idAsString)
+	if err != nil {
+		return err
 	}
 
 	// Clean every reference of the object In all indexes if present
@@ -252,13 +331,7 @@ func (c *Collection) cleanRefs(ctx context.Context, tx *bolt.Tx, idAsString stri
 		for _, index := range c.indexes {
 			if index.Name == ref.IndexName {
 				// If reference present in this index the reference is cleaned
-				ids, newIDErr := newIDs(ctx, 0, nil, indexBucket.Bucket([]byte(index.Name)).Get(ref.IndexedValue))
-				if newIDErr != nil {
-					return newIDErr
-				}
-				ids.RmID(idAsString)
-				// And saved again after the clean
-				if err := indexBucket.Bucket([]byte(index.Name)).Put(ref.IndexedValue, ids.MustMarshal()); err != nil {
+				if err := index.rmFromPosting(tx, index.storageKey(ref.IndexedValue), idAsString); err != nil {
 					return err
 				}
 			}
@@ -267,7 +340,10 @@ func (c *Collection) cleanRefs(ctx context.Context, tx *bolt.Tx, idAsString stri
 	return nil
 }
 
-func (c *Collection) queryGetIDs(ctx context.Context, q *Query) (*btree.BTree, error) {
+// queryGetIDs runs the filters with the indexes.
+// The indexes are read from the snapshot if view is not nil.
+// The trace is filled if not nil.
+func (c *Collection) queryGetIDs(ctx context.Context, view *SnapshotCollection, q *Query, trace *QueryTrace) (*btree.BTree, error) {
 	// Init the destination
 	tree := btree.New(10)
 
@@ -283,7 +359,7 @@ func (c *Collection) queryGetIDs(ctx context.Context, q *Query) (*btree.BTree, e
 	for _, index := range c.indexes {
 		for _, filter := range q.filters {
 			if index.doesFilterApplyToIndex(filter) {
-				go index.query(ctx, filter, finishedChan)
+				go index.query(ctx, view, filter, finishedChan)
 				nbToDo++
 			}
 		}
@@ -298,6 +374,19 @@ func (c *Collection) queryGetIDs(ctx context.Context, q *Query) (*btree.BTree, e
 		select {
 		case tmpIDs := <-finishedChan:
 			if tmpIDs != nil {
+				if trace != nil {
+					trace.Filters = append(trace.Filters, &FilterTrace{
+						IndexName: tmpIDs.indexName,
+						Operator:  tmpIDs.filter.GetType(),
+						Selector:  tmpIDs.filter.selector,
+						IDs:       len(tmpIDs.IDs),
+						Duration:  tmpIDs.duration,
+					})
+					trace.IDsConsidered += len(tmpIDs.IDs)
+				}
+				c.metrics.observeIndexScan(c.name, tmpIDs.indexName, len(tmpIDs.IDs))
+
+				mergeStart := time.Now()
 				// Add IDs into the response tree
 				for _, id := range tmpIDs.IDs {
 					// Try to get the id from the tree
@@ -311,6 +400,9 @@ func (c *Collection) queryGetIDs(ctx context.Context, q *Query) (*btree.BTree, e
 					// if already increment the counter
 					fromTree.(*idType).Increment()
 				}
+				if trace != nil {
+					trace.Merge += time.Since(mergeStart)
+				}
 			}
 			// Save the fact that one more query has respond
 			nbToDo--
@@ -325,18 +417,32 @@ func (c *Collection) queryGetIDs(ctx context.Context, q *Query) (*btree.BTree, e
 	}
 }
 
-func (c *Collection) queryCleanAndOrder(ctx context.Context, q *Query, tree *btree.BTree) (response *Response, _ error) {
+func (c *Collection) queryCleanAndOrder(ctx context.Context, view *SnapshotCollection, q *Query, tree *btree.BTree, trace *QueryTrace) (response *Response, _ error) {
+	start := time.Now()
 	getRefFunc := func(id string) (refs *refs) {
-		c.db.View(func(tx *bolt.Tx) error {
-			refs, _ = c.getRefs(tx, id)
-			return nil
-		})
+		if view != nil {
+			view.txMutex.Lock()
+			defer view.txMutex.Unlock()
+			refs, _ = c.getRefs(view.tx, id)
+		} else {
+			c.viewIndex(func(tx *bolt.Tx) error {
+				refs, _ = c.getRefs(tx, id)
+				return nil
+			})
+		}
+		if refs == nil {
+			refs = newRefs()
+		}
 		return refs
 	}
 
 	// iterate the response tree to get only IDs which has been found in every index queries
 	occurrenceFunc, idsSlice := occurrenceTreeIterator(len(q.filters), q.internalLimit, q.order, getRefFunc)
 	tree.Ascend(occurrenceFunc)
+	if trace != nil {
+		trace.Select = time.Since(start)
+		start = time.Now()
+	}
 
 	// Build the new sorter
 	idsMs := new(idsTypeMultiSorter)
@@ -354,11 +460,26 @@ func (c *Collection) queryCleanAndOrder(ctx context.Context, q *Query, tree *btr
 	response = newResponse(len(idsMs.IDs))
 	response.query = q
 
+	if trace != nil {
+		trace.Order = time.Since(start)
+		start = time.Now()
+	}
+
 	// Get every content of the query from the database
-	responsesAsBytes, err := c.get(ctx, getIDsAsString(idsSlice.IDs)...)
+	var responsesAsBytes [][]byte
+	var err error
+	if view != nil {
+		responsesAsBytes, err = c.getFromTxn(ctx, view.snapshot.txn, getIDsAsString(idsSlice.IDs)...)
+	} else {
+		responsesAsBytes, err = c.get(ctx, getIDsAsString(idsSlice.IDs)...)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if trace != nil {
+		trace.Fetch = time.Since(start)
+		trace.DocumentsFetched = len(responsesAsBytes)
+	}
 
 	// Range the response values as slice of bytes
 	for i := range responsesAsBytes {
@@ -375,16 +496,18 @@ func (c *Collection) queryCleanAndOrder(ctx context.Context, q *Query, tree *btr
 }
 
 func (c *Collection) putIntoStore(ctx context.Context, errChan chan error, wgActions, wgCommitted *sync.WaitGroup, writeTransaction *writeTransaction) error {
-	txn := c.store.NewTransaction(true)
-	defer txn.Discard()
-
-	hashSignature, _ := intToBytes((highwayhash.Sum64(writeTransaction.contentAsBytes, make([]byte, highwayhash.Size))))
-	contentToWrite := append(hashSignature, writeTransaction.contentAsBytes...)
+	txn, endTxn := c.store.begin(true)
+	defer endTxn()
 
 	storeID := c.buildStoreID(writeTransaction.id)
-	setErr := txn.Set(storeID, contentToWrite)
-	if setErr != nil {
-		err := fmt.Errorf("error inserting %q: %s", writeTransaction.id, setErr.Error())
+	setValue := func(txn *badger.Txn) error {
+		setErr := c.setContent(txn, storeID, writeTransaction.value, writeTransaction.meta, writeTransaction.metadata, writeTransaction.ttl)
+		if setErr != nil {
+			return fmt.Errorf("error inserting %q: %s", writeTransaction.id, setErr.Error())
+		}
+		return nil
+	}
+	if err := setValue(txn); err != nil {
 		errChan <- err
 		return err
 	}
@@ -398,64 +521,237 @@ func (c *Collection) putIntoStore(ctx context.Context, errChan chan error, wgAct
 		return err
 	}
 
-	// Start the commit of the indexes
-	err = txn.Commit(nil)
+	// The record of the change log is committed with the value
+	record := &ChangeRecord{ID: writeTransaction.id, Operation: writeTransaction.operation, Content: writeTransaction.contentAsBytes, Bin: writeTransaction.bin}
+	if writeTransaction.ttl > 0 {
+		record.ExpiresAt = uint64(time.Now().Add(writeTransaction.ttl).Unix())
+	}
+	changes := c.beginChanges(record)
+
+	// Start the commit of the indexes, only the store transaction is done again after a transient error
+	first := true
+	err = c.options.Retry.do(ctx, c.metrics.countRetries(func() error {
+		if first {
+			first = false
+			if err := changes.add(txn); err != nil {
+				return err
+			}
+			return txn.Commit(nil)
+		}
+		return c.store.Update(func(txn *badger.Txn) error {
+			if err := setValue(txn); err != nil {
+				return err
+			}
+			return changes.add(txn)
+		})
+	}))
+	changes.end(err == nil)
 	if err != nil {
+		select {
+		case errChan <- err:
+		default:
+		}
 		return err
 	}
 
+	c.addSize(len(storeID) + len(writeTransaction.value))
+
 	// Propagate the commit done status
 	wgCommitted.Done()
 
 	return nil
 }
 
-func (c *Collection) get(ctx context.Context, ids ...string) ([][]byte, error) {
-	ret := make([][]byte, len(ids))
+// setContent saves the encoded content with the time of the write and the metadata.
+// The write-once collections and the collections without history discard the previous versions.
+// If ttl is set the content expires after it, the previous versions are then always kept
+// because the store can't discard them with an expiration.
+func (c *Collection) setContent(txn *badger.Txn, storeID, value []byte, codecID byte, metadata map[string]string, ttl time.Duration) error {
+	now := time.Now()
+	value, meta := addValueHeader(now, metadata, value, codecID)
+	value, err := c.sealValue(storeID, value)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return txn.SetEntry(&badger.Entry{
+			Key:       storeID,
+			Value:     value,
+			UserMeta:  meta,
+			ExpiresAt: uint64(now.Add(ttl).Unix()),
+		})
+	}
+	if c.IsWriteOnce() || c.HistoryDepth() == 0 {
+		return txn.SetWithDiscard(storeID, value, meta)
+	}
+	return txn.SetWithMeta(storeID, value, meta)
+}
+
+// getVersion returns the version of the saved content or 0 if the ID is not saved
+func (c *Collection) getVersion(id string) (version uint64, _ error) {
+	err := c.store.View(func(txn *badger.Txn) error {
+		item, getError := txn.Get(c.buildStoreID(id))
+		if getError == badger.ErrKeyNotFound {
+			return nil
+		} else if getError != nil {
+			return getError
+		}
+
+		if !item.IsDeletedOrExpired() {
+			version = item.Version()
+		}
+		return nil
+	})
+	return version, err
+}
+
+// signContent returns the content as it is saved into the store, after its hash signature
+func signContent(contentAsBytes []byte) []byte {
+	return append(hashSignature(contentAsBytes), contentAsBytes...)
+}
+
+// hashSignature returns the 8 bytes signature saved before the contents
+func hashSignature(contentAsBytes []byte) []byte {
+	signature, _ := intToBytes((highwayhash.Sum64(contentAsBytes, make([]byte, highwayhash.Size))))
+	return signature
+}
+
+func (c *Collection) get(ctx context.Context, ids ...string) (ret [][]byte, _ error) {
 	if err := c.store.View(func(txn *badger.Txn) error {
-		for i, id := range ids {
-			idAsBytes := c.buildStoreID(id)
-			item, getError := txn.Get(idAsBytes)
-			if getError != nil {
-				if getError == badger.ErrKeyNotFound {
-					return ErrNotFound
-				}
-				return getError
-			}
+		var err error
+		ret, err = c.getFromTxn(ctx, txn, ids...)
+		return err
+	}); err != nil {
+		return nil, err
+	}
 
-			if item.IsDeletedOrExpired() {
-				return ErrNotFound
-			}
+	return ret, nil
+}
 
-			contentAndHashSignatureAsBytes, getValErr := item.Value()
-			if getValErr != nil {
-				return getValErr
-			}
+// getFromTxn reads the contents of the IDs with the given store transaction
+func (c *Collection) getFromTxn(ctx context.Context, txn *badger.Txn, ids ...string) ([][]byte, error) {
+	ret := make([][]byte, len(ids))
+	if err := func() error {
+		if len(ids) == 1 {
+			contentAsBytes, err := c.getOne(txn, ids[0])
+			ret[0] = contentAsBytes
+			return err
+		}
 
-			contentAsBytes, corrupted := c.getAndCheckContent(contentAndHashSignatureAsBytes)
-			if corrupted != nil {
-				return corrupted
-			}
+		// The documents are read in the order of the keys to read the store sequentially
+		positions := make([]int, len(ids))
+		for i := range positions {
+			positions[i] = i
+		}
+		sort.Slice(positions, func(i, j int) bool {
+			return ids[positions[i]] < ids[positions[j]]
+		})
 
-			ret[i] = contentAsBytes
+		for start := 0; start < len(positions); start += FetchBatchSize {
+			end := start + FetchBatchSize
+			if end > len(positions) {
+				end = len(positions)
+			}
+			if err := c.getBatch(ctx, txn, ids, positions[start:end], ret); err != nil {
+				return err
+			}
 		}
 		return nil
-	}); err != nil {
+	}(); err != nil {
 		return nil, err
 	}
 
 	return ret, nil
 }
 
-func (c *Collection) getAndCheckContent(contentAndHashSignatureAsBytes []byte) (content []byte, _ error) {
+// getOne reads the content of the given ID
+func (c *Collection) getOne(txn *badger.Txn, id string) ([]byte, error) {
+	item, getError := txn.Get(c.buildStoreID(id))
+	if getError != nil {
+		if getError == badger.ErrKeyNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, getError
+	}
+
+	if item.IsDeletedOrExpired() {
+		return nil, ErrNotFound
+	}
+
+	contentAndHashSignatureAsBytes, getValErr := item.Value()
+	if getValErr != nil {
+		return nil, getValErr
+	}
+
+	return c.getAndCheckContent(item.Key(), item.UserMeta(), contentAndHashSignatureAsBytes)
+}
+
+// getBatch reads the contents of the IDs at the given positions which are sorted by ID.
+// One iterator prefetches the values of the batch and seeks forward to the next wanted key.
+func (c *Collection) getBatch(ctx context.Context, txn *badger.Txn, ids []string, positions []int, ret [][]byte) error {
+	iterator := txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: true,
+		PrefetchSize:   len(positions),
+	})
+	defer iterator.Close()
+
+	iterator.Seek(c.buildStoreID(ids[positions[0]]))
+	for _, position := range positions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		storeID := c.buildStoreID(ids[position])
+		if iterator.Valid() && bytes.Compare(iterator.Item().Key(), storeID) < 0 {
+			iterator.Seek(storeID)
+		}
+		if !iterator.Valid() || !bytes.Equal(iterator.Item().Key(), storeID) {
+			return ErrNotFound
+		}
+
+		item := iterator.Item()
+		if item.IsDeletedOrExpired() {
+			return ErrNotFound
+		}
+
+		// The values of the iterator are not kept after the next item
+		contentAndHashSignatureAsBytes, getValErr := item.ValueCopy(nil)
+		if getValErr != nil {
+			return getValErr
+		}
+
+		contentAsBytes, corrupted := c.getAndCheckContent(item.Key(), item.UserMeta(), contentAndHashSignatureAsBytes)
+		if corrupted != nil {
+			return corrupted
+		}
+		ret[position] = contentAsBytes
+	}
+	return nil
+}
+
+// getAndCheckContent decrypts the value saved under the key and calls checkContent
+func (c *Collection) getAndCheckContent(key []byte, meta byte, value []byte) (content []byte, _ error) {
+	value, err := c.openValue(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.checkContent(meta, value)
+}
+
+// checkContent decodes the clear value with the codec of the given ID and checks its signature
+func (c *Collection) checkContent(meta byte, contentAndHashSignatureAsBytes []byte) (content []byte, _ error) {
+	codecID, contentAndHashSignatureAsBytes := splitValueHeader(meta, contentAndHashSignatureAsBytes)
 	if len(contentAndHashSignatureAsBytes) <= 8 {
-		fmt.Println("contentAndHashSignatureAsBytes", len(contentAndHashSignatureAsBytes), contentAndHashSignatureAsBytes)
+		c.options.log(LogDebug, "saved value too short", "collection", c.name, "length", len(contentAndHashSignatureAsBytes))
 		return nil, ErrDataCorrupted
 	}
 
 	savedSignature := contentAndHashSignatureAsBytes[:8]
-	contentAsBytes := contentAndHashSignatureAsBytes[8:]
-	retrievedSignature, _ := intToBytes(highwayhash.Sum64(contentAsBytes, make([]byte, highwayhash.Size)))
+	contentAsBytes, decodeErr := decodeContent(codecID, contentAndHashSignatureAsBytes[8:])
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	retrievedSignature := hashSignature(contentAsBytes)
 
 	if !reflect.DeepEqual(savedSignature, retrievedSignature) {
 		return nil, ErrDataCorrupted
@@ -468,40 +764,78 @@ func (c *Collection) loadIndex() error {
 	indexes := c.getIndexesFromConfigBucket()
 	for _, index := range indexes {
 		index.options = c.options
-		index.getTx = c.db.Begin
+		index.cipher = c.indexCipher
+		index.beginView = c.beginIndexView
 	}
 	c.indexes = indexes
 
-	return nil
-}
+	c.schema = c.getSchemaFromConfigBucket()
+	anonymization := c.getAnonymizationFromConfigBucket()
+	c.anonymizationMutex.Lock()
+	c.anonymization = anonymization
+	c.anonymizationMutex.Unlock()
+	c.loadWriteOnce()
+	c.loadHistoryDepth()
+	c.loadQuota()
 
-func (c *Collection) deleteItemFromIndexes(ctx context.Context, id string) error {
-	return c.db.Update(func(tx *bolt.Tx) error {
-		refs, getRefsErr := c.getRefs(tx, id)
-		if getRefsErr != nil {
-			return getRefsErr
-		}
+	return c.loadCodec()
+}
 
-		for _, ref := range refs.Refs {
-			indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte(ref.IndexName))
-			ids, err := newIDs(ctx, 0, nil, indexBucket.Get(ref.IndexedValue))
-			if err != nil {
+// deleteItemFromIndexes removes the ID from the indexes. The content is given
+// for the write-once collections which do not save the references.
+func (c *Collection) deleteItemFromIndexes(ctx context.Context, id string, contentAsBytes []byte) error {
+	return c.updateIndex(func(tx *bolt.Tx) error {
+		if contentAsBytes != nil {
+			if err := c.unindexContent(tx, id, contentAsBytes); err != nil {
 				return err
 			}
+		}
+		if err := recordDeletion(tx, id); err != nil {
+			return err
+		}
+		// The documents saved before the collection was set write-once have references
+		return c.unindexDocument(ctx, tx, id)
+	})
+}
 
-			ids.RmID(id)
+// unindexDocument removes the given ID from all the indexes it is referenced in
+func (c *Collection) unindexDocument(ctx context.Context, tx *bolt.Tx, id string) error {
+	refs, getRefsErr := c.getRefs(tx, id)
+	if getRefsErr != nil {
+		return getRefsErr
+	}
 
-			indexBucket.Put(ref.IndexedValue, ids.MustMarshal())
+	for _, ref := range refs.Refs {
+		index := c.getIndex(ref.IndexName)
+		if index == nil {
+			continue
 		}
 
-		return nil
-	})
+		if err := index.rmFromPosting(tx, index.storageKey(ref.IndexedValue), id); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (c *Collection) getRefs(tx *bolt.Tx, id string) (*refs, error) {
-	refsBucket := tx.Bucket([]byte("refs"))
+	key := buildBytesID(id)
+	return c.refsFromDB(key, tx.Bucket([]byte("refs")).Get(key))
+}
+
+// refsFromDB returns the references saved under the key.
+// If the database has an EncryptionKey they are opened first.
+func (c *Collection) refsFromDB(key, refsAsBytes []byte) (*refs, error) {
+	if len(refsAsBytes) == 0 {
+		return newRefs(), nil
+	}
+
+	refsAsBytes, err := c.indexCipher.open(key, refsAsBytes)
+	if err != nil {
+		return nil, err
+	}
 
-	refsAsBytes := refsBucket.Get(buildBytesID(id))
 	refs := newRefsFromDB(refsAsBytes)
 	if refs == nil {
 		return nil, fmt.Errorf("references mal formed: %s", string(refsAsBytes))
@@ -509,13 +843,36 @@ func (c *Collection) getRefs(tx *bolt.Tx, id string) (*refs, error) {
 	return refs, nil
 }
 
+// putRefs saves the references, sealed if the database has an EncryptionKey
+func (c *Collection) putRefs(tx *bolt.Tx, refs *refs) error {
+	refsAsBytes, err := c.indexCipher.seal(refs.IDasBytes(), refs.asBytes())
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("refs")).Put(refs.IDasBytes(), refsAsBytes)
+}
+
 // getStoredIDs returns all ids if it does not exceed the limit.
 // This will not returned the ID used to set the value inside the collection
 // It returns the id used to set the value inside the store
-func (c *Collection) getStoredIDsAndValues(starter string, limit int, IDsOnly bool) ([]*ResponseElem, error) {
+func (c *Collection) getStoredIDsAndValues(starter string, limit int, IDsOnly bool) (response []*ResponseElem, _ error) {
+	err := c.store.View(func(txn *badger.Txn) error {
+		var err error
+		response, err = c.getStoredIDsAndValuesFromTxn(txn, starter, limit, IDsOnly)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// getStoredIDsAndValuesFromTxn does the same as getStoredIDsAndValues with the given store transaction
+func (c *Collection) getStoredIDsAndValuesFromTxn(txn *badger.Txn, starter string, limit int, IDsOnly bool) ([]*ResponseElem, error) {
 	response := make([]*ResponseElem, limit)
 
-	err := c.store.View(func(txn *badger.Txn) error {
+	err := func() error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
@@ -552,7 +909,7 @@ func (c *Collection) getStoredIDsAndValues(starter string, limit int, IDsOnly bo
 				}
 
 				var corrupted error
-				responseItem.ContentAsBytes, corrupted = c.getAndCheckContent(responseItem.ContentAsBytes)
+				responseItem.ContentAsBytes, corrupted = c.getAndCheckContent(item.Key(), item.UserMeta(), responseItem.ContentAsBytes)
 				if corrupted != nil {
 					return corrupted
 				}
@@ -566,7 +923,7 @@ func (c *Collection) getStoredIDsAndValues(starter string, limit int, IDsOnly bo
 		// Clean the end of the slice if not full
 		response = response[:count]
 		return nil
-	})
+	}()
 	if err != nil {
 		return nil, err
 	}
@@ -574,57 +931,432 @@ func (c *Collection) getStoredIDsAndValues(starter string, limit int, IDsOnly bo
 	return response, nil
 }
 
-func (c *Collection) indexAllValues(i *indexType) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func (c *Collection) setIndex(i *indexType) error {
+	i.options = c.options
+	i.cipher = c.indexCipher
+	i.beginView = c.beginIndexView
+
+	if updateErr := c.updateIndex(func(tx *bolt.Tx) error {
+		_, createErr := tx.Bucket([]byte("indexes")).CreateBucket([]byte(i.Name))
+		if createErr != nil {
+			return createErr
+		}
+		return nil
+	}); updateErr != nil {
+		return updateErr
+	}
+
+	c.indexes = append(c.indexes, i)
+	if errSetingIndexIntoConfig := c.setIndexesIntoConfigBucket(i); errSetingIndexIntoConfig != nil {
+		return errSetingIndexIntoConfig
+	}
+
+	if err := c.rebuildIndex(i); err != nil {
+		return err
+	}
+	c.events.emit(&Event{Type: EventIndexBuilt, Collection: c.name, Index: i.Name})
+	return nil
+}
+
+// warmUpIndexes reads all the keys and values of the indexes and references
+// to have them into the page cache before the first queries
+func (c *Collection) warmUpIndexes() error {
+	return c.viewIndex(func(tx *bolt.Tx) error {
+		read := 0
+		readAll := func(key, value []byte) error {
+			read += len(key) + len(value)
+			return nil
+		}
 
-	errChan := make(chan error, 0)
+		indexesBucket := tx.Bucket([]byte("indexes"))
+		if err := indexesBucket.ForEach(func(name, _ []byte) error {
+			indexBucket := indexesBucket.Bucket(name)
+			if indexBucket == nil {
+				return nil
+			}
+			return indexBucket.ForEach(readAll)
+		}); err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte("refs")).ForEach(readAll)
+	})
+}
+
+// getIndex returns the index with the given name or nil if not found
+func (c *Collection) getIndex(name string) *indexType {
+	for _, index := range c.indexes {
+		if index.Name == name {
+			return index
+		}
+	}
+	return nil
+}
 
+// forEachStored calls fn for every document saved into the collection.
+// The documents are read from the store 100 by 100.
+func (c *Collection) forEachStored(fn func(id string, contentAsBytes []byte) error) error {
 	lastID := ""
+	for {
+		savedElements, getErr := c.getStoredIDsAndValues(lastID, 100, false)
+		if getErr != nil {
+			return getErr
+		}
 
-newLoop:
-	savedElements, getErr := c.getStoredIDsAndValues(lastID, 10, false)
-	if getErr != nil {
-		return getErr
+		for _, savedElement := range savedElements {
+			// The starter is returned again at the beginning of the next list
+			if lastID != "" && savedElement.ID.ID == lastID {
+				continue
+			}
+
+			if err := fn(savedElement.ID.ID, savedElement.ContentAsBytes); err != nil {
+				return err
+			}
+		}
+
+		if len(savedElements) < 100 {
+			return nil
+		}
+		lastID = savedElements[len(savedElements)-1].ID.ID
 	}
+}
+
+// rebuildIndex indexes the saved documents again with the workers of the database
+func (c *Collection) rebuildIndex(i *indexType) error {
+	return c.workers.run(c.ctx, func() error {
+		return c.buildIndex(i)
+	})
+}
+
+// buildIndexes builds all the indexes of the collection again from the saved documents.
+// The extractor indexes not set since the opening are built by SetExtractorIndex.
+func (c *Collection) buildIndexes() error {
+	for _, index := range c.indexes {
+		if !index.isReady() {
+			continue
+		}
+		if err := c.buildIndex(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildIndex empties the index and adds all the saved documents in one transaction
+func (c *Collection) buildIndex(i *indexType) error {
+	return c.updateIndex(func(tx *bolt.Tx) error {
+		indexesBucket := tx.Bucket([]byte("indexes"))
+		refsBucket := tx.Bucket([]byte("refs"))
+
+		if err := indexesBucket.DeleteBucket([]byte(i.Name)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if err := deleteIndexPostings(tx, i.Name); err != nil {
+			return err
+		}
+		if _, createErr := indexesBucket.CreateBucket([]byte(i.Name)); createErr != nil {
+			return createErr
+		}
+
+		storedIDs := map[string]bool{}
+
+		err := c.forEachStored(func(id string, contentAsBytes []byte) error {
+			storedIDs[id] = true
+
+			refs, getRefsErr := c.getRefs(tx, id)
+			if getRefsErr != nil {
+				return getRefsErr
+			}
+			if refs.ObjectID == "" {
+				refs.ObjectID = id
+				refs.ObjectHashID = buildID(id)
+			}
+
+			object, _ := decodeStored(contentAsBytes)
+			candidates, apply := i.applyToStored(object, contentAsBytes)
+			if !apply {
+				refs.rmIndexedValue(i.Name)
+				return c.putRefs(tx, refs)
+			}
+
+			indexedValue := pickCandidate(candidates, refs.getIndexedValue(i.Name))
+
+			if err := i.addToPosting(tx, i.storageKey(indexedValue), id); err != nil {
+				return err
+			}
+
+			refs.setIndexedValue(i.Name, i.SelectorHash, indexedValue)
+			return c.putRefs(tx, refs)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Clean the references of the documents which are not stored anymore
+		toClean := []*refs{}
+		if err := refsBucket.ForEach(func(key, refsAsBytes []byte) error {
+			refs, err := c.refsFromDB(key, refsAsBytes)
+			if err != nil {
+				return err
+			}
+			if !storedIDs[refs.ObjectID] && refs.getIndexedValue(i.Name) != nil {
+				toClean = append(toClean, refs)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, refs := range toClean {
+			refs.rmIndexedValue(i.Name)
+			if err := c.putRefs(tx, refs); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (c *Collection) verifyIndexes(tx *bolt.Tx, report *IndexReport) error {
+	// Keeps the stored IDs to find dangling references in a second pass
+	storedIDs := map[string]bool{}
+	// The write-once collections have no references, the indexed values
+	// are found from the content and kept for the second pass
+	writeOnceValues := map[string]map[string][]byte{}
+
+	err := c.forEachStored(func(id string, contentAsBytes []byte) error {
+		storedIDs[id] = true
+		report.Documents++
+
+		refs, getRefsErr := c.getRefs(tx, id)
+		if getRefsErr != nil {
+			return getRefsErr
+		}
+
+		object, _ := decodeStored(contentAsBytes)
+		for _, index := range c.indexes {
+			if !index.isReady() {
+				continue
+			}
+
+			indexedValue := refs.getIndexedValue(index.Name)
+
+			candidates, apply := index.applyToStored(object, contentAsBytes)
+			if !apply {
+				if indexedValue != nil {
+					report.addDangling(index.Name, id)
+				}
+				continue
+			}
+
+			if indexedValue == nil && c.IsWriteOnce() {
+				for _, candidate := range candidates {
+					ids, getErr := index.getPosting(tx, index.storageKey(candidate))
+					if getErr != nil {
+						return getErr
+					}
+					if containsString(ids, id) {
+						indexedValue = candidate
+						break
+					}
+				}
+				if writeOnceValues[index.Name] == nil {
+					writeOnceValues[index.Name] = map[string][]byte{}
+				}
+				writeOnceValues[index.Name][id] = indexedValue
+			}
+
+			if !containsBytes(candidates, indexedValue) {
+				report.addMissing(index.Name, id)
+				continue
+			}
 
-	if len(savedElements) <= 1 {
+			ids, getErr := index.getPosting(tx, index.storageKey(indexedValue))
+			if getErr != nil {
+				return getErr
+			}
+			if !containsString(ids, id) {
+				report.addMissing(index.Name, id)
+			}
+		}
 		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	for _, savedElement := range savedElements {
-		if savedElement.ID.ID == lastID {
+	for _, index := range c.indexes {
+		if !index.isReady() {
 			continue
 		}
 
-		var elem interface{}
-		if jsonErr := json.Unmarshal(savedElement.ContentAsBytes, &elem); jsonErr != nil {
-			return jsonErr
+		err := tx.Bucket([]byte("indexes")).Bucket([]byte(index.Name)).ForEach(func(storageKey, idsAsBytes []byte) error {
+			ids, readErr := index.readPosting(tx, storageKey, idsAsBytes)
+			if readErr != nil {
+				return readErr
+			}
+
+			for _, id := range ids {
+				if !storedIDs[id] {
+					report.addDangling(index.Name, id)
+					continue
+				}
+
+				refs, getRefsErr := c.getRefs(tx, id)
+				if getRefsErr != nil {
+					return getRefsErr
+				}
+				savedValue := refs.getIndexedValue(index.Name)
+				if value, ok := writeOnceValues[index.Name][id]; ok {
+					savedValue = value
+				}
+				if savedValue == nil || !bytes.Equal(index.storageKey(savedValue), storageKey) {
+					report.addDangling(index.Name, id)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		m := elem.(map[string]interface{})
+// exportIndex writes the index definition followed by all its keys
+func (c *Collection) exportIndex(tx *bolt.Tx, i *indexType, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(&indexDumpEntry{Index: i}); err != nil {
+		return err
+	}
 
-		ctx2, cancel2 := context.WithTimeout(ctx, c.options.TransactionTimeOut)
-		defer cancel2()
+	indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name))
+	if indexBucket == nil {
+		return ErrNotFound
+	}
 
-		tr := newTransaction(savedElement.ID.ID)
-		tr.ctx = ctx2
+	return indexBucket.ForEach(func(key, idsAsBytes []byte) error {
+		ids, err := i.readPosting(tx, key, idsAsBytes)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(&indexDumpEntry{Key: key, IDs: ids})
+	})
+}
 
-		tr.contentInterface = m
+// importIndex replaces the content of the index with the dump. The references
+// are rebuilt from the keys because refs save the value the key is made of.
+func (c *Collection) importIndex(r io.Reader) error {
+	decoder := json.NewDecoder(r)
 
-		fakeWgAction := new(sync.WaitGroup)
-		fakeWgCommitted := new(sync.WaitGroup)
-		fakeWgAction.Add(1)
-		fakeWgCommitted.Add(1)
-		go c.putIntoIndexes(ctx, errChan, fakeWgAction, fakeWgCommitted, tr)
+	header := new(indexDumpEntry)
+	if err := decoder.Decode(header); err != nil {
+		return err
+	}
+	if header.Index == nil {
+		return fmt.Errorf("the dump does not start with the index definition")
+	}
 
-		err := waitForDoneErrOrCanceled(ctx2, fakeWgCommitted, errChan)
-		if err != nil {
+	i := c.getIndex(header.Index.Name)
+	if i == nil {
+		if header.Index.Extractor {
+			return fmt.Errorf("the extractor index %q must be set before the import", header.Index.Name)
+		}
+
+		i = newIndex(header.Index.Name, header.Index.Type, header.Index.Selector...)
+		if err := i.setOptions(header.Index.getOptions()); err != nil {
 			return err
 		}
+		i.options = c.options
+		i.cipher = c.indexCipher
+		i.beginView = c.beginIndexView
 
-		lastID = tr.id
+		c.indexes = append(c.indexes, i)
+		if err := c.setIndexesIntoConfigBucket(i); err != nil {
+			return err
+		}
+	} else if i.Type != header.Index.Type ||
+		i.SelectorHash != header.Index.SelectorHash ||
+		!reflect.DeepEqual(i.getOptions(), header.Index.getOptions()) {
+		return fmt.Errorf("the dump of index %q does not have the same definition", i.Name)
 	}
 
-	goto newLoop
+	return c.updateIndex(func(tx *bolt.Tx) error {
+		indexesBucket := tx.Bucket([]byte("indexes"))
+		refsBucket := tx.Bucket([]byte("refs"))
+
+		if err := indexesBucket.DeleteBucket([]byte(i.Name)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if err := deleteIndexPostings(tx, i.Name); err != nil {
+			return err
+		}
+		if _, createErr := indexesBucket.CreateBucket([]byte(i.Name)); createErr != nil {
+			return createErr
+		}
+
+		// Remove the previous references to the index
+		toClean := []*refs{}
+		if err := refsBucket.ForEach(func(key, refsAsBytes []byte) error {
+			refs, err := c.refsFromDB(key, refsAsBytes)
+			if err != nil {
+				return err
+			}
+			if refs.getIndexedValue(i.Name) != nil {
+				toClean = append(toClean, refs)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, refs := range toClean {
+			refs.rmIndexedValue(i.Name)
+			if err := c.putRefs(tx, refs); err != nil {
+				return err
+			}
+		}
+
+		for decoder.More() {
+			entry := new(indexDumpEntry)
+			if err := decoder.Decode(entry); err != nil {
+				return err
+			}
+
+			if err := i.setPosting(tx, entry.Key, entry.IDs); err != nil {
+				return err
+			}
+
+			indexedValue := i.valueFromStorageKey(entry.Key)
+			for _, id := range entry.IDs {
+				refs, getRefsErr := c.getRefs(tx, id)
+				if getRefsErr != nil {
+					return getRefsErr
+				}
+				if refs.ObjectID == "" {
+					refs.ObjectID = id
+					refs.ObjectHashID = buildID(id)
+				}
+
+				refs.setIndexedValue(i.Name, i.SelectorHash, indexedValue)
+				if err := c.putRefs(tx, refs); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// decodeStored decodes the document as it is saved into the store.
+// The numbers are kept as json.Number to not lose precision.
+func decodeStored(contentAsBytes []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(contentAsBytes))
+	decoder.UseNumber()
+
+	object := map[string]interface{}{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	return object, nil
 }
@@ -1,6 +1,7 @@
 package gotinydb
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/fatih/structs"
 )
 
@@ -711,7 +713,7 @@ func TestRollback(t *testing.T) {
 		}
 	}
 
-	oneAsBytes, _ := c.Get("1", nil)
+	oneAsBytes, _ := c.GetRaw("1")
 	if string(oneAsBytes) != `{"ID":"1","Email":"christi-81@muppet.com","Balance":7456846233081745525,"Address":{"City":"Scribner","ZipCode":86},"Age":6,"LastLogin":"2018-01-21T20:42:49.779258288+01:00"}` {
 		t.Errorf("Value is not what is expected")
 		return
@@ -725,7 +727,7 @@ func TestRollback(t *testing.T) {
 		t.Errorf("timestamp %d is not what is expected %d", timestamp, 302)
 	}
 
-	oneAsBytes, _ = c.Get("1", nil)
+	oneAsBytes, _ = c.GetRaw("1")
 	if string(oneAsBytes) != `{"Address":{"City":"Kuznetsk","ZipCode":71},"Age":14,"Balance":777382239779228500,"Email":"carol-60@rigoletto.com","ID":"1","LastLogin":"2016-08-01T21:59:36.165049552+02:00"}` {
 		t.Errorf("Value is not what is expected")
 		return
@@ -739,7 +741,7 @@ func TestRollback(t *testing.T) {
 		t.Errorf("timestamp %d is not what is expected %d", timestamp, 2)
 	}
 
-	oneAsBytes, _ = c.Get("1", nil)
+	oneAsBytes, _ = c.GetRaw("1")
 	if string(oneAsBytes) != `{"Address":{"City":"Stan","ZipCode":84},"Age":5,"Balance":2126067743217278000,"Email":"geritol-60@puget.com","ID":"1","LastLogin":"2017-02-09T23:28:19.405858256+01:00"}` {
 		t.Errorf("Value is not what is expected")
 		return
@@ -750,9 +752,1749 @@ func TestRollback(t *testing.T) {
 		return
 	}
 
-	oneAsBytes, _ = c.Get("1", nil)
+	oneAsBytes, _ = c.GetRaw("1")
 	if string(oneAsBytes) != `{"Address":{"City":"Stan","ZipCode":84},"Age":5,"Balance":2126067743217278000,"Email":"geritol-60@puget.com","ID":"1","LastLogin":"2017-02-09T23:28:19.405858256+01:00"}` {
 		t.Errorf("Value is not what is expected")
 		return
 	}
 }
+
+func TestHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if _, err := c.History("0", 0); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	for _, dataSet := range [][]byte{dataSet1, dataSet2, dataSet3} {
+		user := unmarshalDataSet(dataSet)[0]
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := c.Delete("0"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	versions, err := c.History("0", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(versions) != 4 {
+		t.Errorf("expected 4 versions but had %d", len(versions))
+		return
+	}
+	if !versions[0].Deleted || versions[0].Content != nil {
+		t.Errorf("the newest version must be the deletion")
+		return
+	}
+	for i, dataSet := range [][]byte{dataSet3, dataSet2, dataSet1} {
+		version := versions[i+1]
+		if version.Deleted || version.Timestamp >= versions[i].Timestamp {
+			t.Errorf("the versions are not ordered from the newest")
+			return
+		}
+		user := new(User)
+		json.Unmarshal(version.Content, user)
+		if expected := unmarshalDataSet(dataSet)[0]; user.Email != expected.Email {
+			t.Errorf("expected %q but had %q", expected.Email, user.Email)
+			return
+		}
+	}
+
+	versions, _ = c.History("0", 2)
+	if len(versions) != 2 {
+		t.Errorf("expected 2 versions but had %d", len(versions))
+		return
+	}
+}
+
+func TestPutWithMeta(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	user := unmarshalDataSet(dataSet1)[0]
+	if err := c.Put(user.ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+	user = unmarshalDataSet(dataSet2)[0]
+	meta := map[string]string{"author": "admin", "reason": "email update"}
+	if err := c.PutWithMeta(user.ID, user, meta); err != nil {
+		t.Error(err)
+		return
+	}
+
+	retrievedUser := new(User)
+	if err := c.Get(user.ID, retrievedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if retrievedUser.Email != user.Email {
+		t.Errorf("expected %q but had %q", user.Email, retrievedUser.Email)
+		return
+	}
+
+	versions, err := c.History(user.ID, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(versions) != 2 {
+		t.Errorf("expected 2 versions but had %d", len(versions))
+		return
+	}
+	if !reflect.DeepEqual(versions[0].Meta, meta) {
+		t.Errorf("expected %v but had %v", meta, versions[0].Meta)
+		return
+	}
+	if versions[0].Time.IsZero() {
+		t.Errorf("the time of the write is not set")
+		return
+	}
+	if versions[1].Meta != nil {
+		t.Errorf("the first version has no metadata but had %v", versions[1].Meta)
+		return
+	}
+}
+
+func TestPurgeHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	put := func(id string, version int) {
+		if err := c.Put(id, map[string]int{"Version": version}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	put("doc", 1)
+	put("doc", 2)
+	put("deleted", 1)
+	put("deleted", 2)
+	c.Delete("deleted")
+	time.Sleep(time.Millisecond)
+	olderThan := time.Now()
+	put("doc", 3)
+	put("doc", 4)
+
+	before, _ := c.History("doc", 0)
+	// The version 3 is newer than the purge time so the history is kept
+	purged, err := c.PurgeHistory(olderThan)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if purged != 0 {
+		t.Errorf("expected no purged version but had %d", purged)
+		return
+	}
+	if after, _ := c.History("doc", 0); len(after) != 4 {
+		t.Errorf("expected 4 versions but had %d", len(after))
+		return
+	}
+
+	// The deleted documents are not purged
+	deleted, _ := c.History("deleted", 0)
+	if len(deleted) != 3 || !deleted[0].Deleted || string(deleted[1].Content) != `{"Version":2}` {
+		t.Errorf("unexpected history of the deleted document %v", deleted)
+		return
+	}
+	if err := c.Get("deleted", nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	// Only the current version is kept, with its time
+	if purged, _ := c.PurgeDocumentHistory("doc", time.Now()); purged != 3 {
+		t.Errorf("expected 3 purged versions but had %d", purged)
+		return
+	}
+	versions, _ := c.History("doc", 0)
+	if len(versions) != 1 || string(versions[0].Content) != `{"Version":4}` || !versions[0].Time.Equal(before[0].Time) {
+		t.Errorf("unexpected history %v", versions)
+		return
+	}
+	if purged, _ := c.PurgeHistory(time.Now()); purged != 0 {
+		t.Errorf("nothing should be purged but had %d", purged)
+		return
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	beforeFirst := time.Now()
+	times := []time.Time{}
+	step := func(write func() error) {
+		time.Sleep(time.Millisecond)
+		if err := write(); err != nil {
+			t.Error(err)
+		}
+		time.Sleep(time.Millisecond)
+		times = append(times, time.Now())
+	}
+	step(func() error { return c.Put("id", map[string]int{"Version": 1}) })
+	step(func() error { return c.Put("id", map[string]int{"Version": 2}) })
+	step(func() error { return c.Delete("id") })
+	step(func() error { return c.Put("id", map[string]int{"Version": 3}) })
+	step(func() error {
+		batch := c.NewBatch()
+		batch.Delete("id")
+		return batch.Write()
+	})
+
+	for i, expected := range []string{`{"Version":1}`, `{"Version":2}`, "", `{"Version":3}`, ""} {
+		content, err := c.GetAt("id", times[i])
+		if expected == "" {
+			if err != ErrNotFound {
+				t.Errorf("%d: expected %v but had %v", i, ErrNotFound, err)
+			}
+			continue
+		}
+		if err != nil || string(content) != expected {
+			t.Errorf("%d: expected %q but had %q %v", i, expected, content, err)
+		}
+	}
+
+	if _, err := c.GetAt("id", beforeFirst); err != ErrNotFound {
+		t.Errorf("expected %v before the first version but had %v", ErrNotFound, err)
+	}
+}
+
+func TestRollbackAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.HistoryDepth = 3
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("age", IntIndex, "Age"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:3]
+	for _, user := range users[:2] {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	time.Sleep(time.Millisecond)
+	to := time.Now()
+
+	// The bad import
+	updated := *users[0]
+	updated.Age = 100
+	c.Put(updated.ID, updated)
+	c.Put(users[2].ID, users[2])
+	c.Delete(users[1].ID)
+
+	if err := c.RollbackAll(to); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, user := range users[:2] {
+		saved := new(User)
+		if err := c.Get(user.ID, saved); err != nil || saved.Age != user.Age {
+			t.Errorf("the document %q is not rolled back: %v", user.ID, err)
+			return
+		}
+	}
+	if err := c.Get(users[2].ID, nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+	// The indexes are rolled back too
+	response, _ := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Age").CompareTo(uint(100))))
+	if response != nil && response.Len() != 0 {
+		t.Errorf("the index still has the updated document")
+		return
+	}
+
+	// The versions of the time are not kept anymore
+	for i := 0; i < 4; i++ {
+		c.Put(users[0].ID, users[0])
+	}
+	if err := c.RollbackAll(to); err != ErrHistoryTooShort {
+		t.Errorf("expected %v but had %v", ErrHistoryTooShort, err)
+		return
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.HistoryRetention = time.Millisecond * 50
+	options.HistoryRetentionCheckInterval = time.Millisecond * 10
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	for i := 0; i < 3; i++ {
+		if err := c.Put("id", map[string]int{"Version": i}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if versions, _ := c.History("id", 0); len(versions) != 3 {
+		t.Errorf("expected 3 versions but had %d", len(versions))
+		return
+	}
+
+	time.Sleep(time.Millisecond * 150)
+	if versions, _ := c.History("id", 0); len(versions) != 1 || string(versions[0].Content) != `{"Version":2}` {
+		t.Errorf("the previous versions are not purged: %v", versions)
+		return
+	}
+}
+
+func TestHistoryDepth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	newOptions := func() *Options {
+		options := NewDefaultOptions(testPath)
+		options.HistoryDepth = 2
+		return options
+	}
+	db, openDBErr := Open(ctx, newOptions())
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	putVersions := func(n int) error {
+		for i := 0; i < n; i++ {
+			if err := c.Put("id", map[string]int{"Version": i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := putVersions(5); err != nil {
+		t.Error(err)
+		return
+	}
+	if versions, _ := c.History("id", 0); len(versions) != 3 {
+		t.Errorf("expected 3 versions but had %d", len(versions))
+		return
+	}
+
+	if err := c.SetHistoryDepth(3); err == nil {
+		t.Errorf("the depth of the collection can't be bigger than the one of the database")
+		return
+	}
+	if err := c.SetHistoryDepth(1); err != nil {
+		t.Error(err)
+		return
+	}
+	if versions, _ := c.History("id", 0); len(versions) != 2 {
+		t.Errorf("expected 2 versions but had %d", len(versions))
+		return
+	}
+	if _, err := c.Rollback("id", 1); err == nil {
+		t.Errorf("the version is not kept by the history")
+		return
+	}
+
+	// The depth is saved
+	db.Close()
+	db, openDBErr = Open(ctx, newOptions())
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ = db.Use("testCol")
+	if depth := c.HistoryDepth(); depth != 1 {
+		t.Errorf("expected the depth 1 but had %d", depth)
+		return
+	}
+
+	// Without history the previous versions are discarded
+	c.SetHistoryDepth(0)
+	if err := putVersions(2); err != nil {
+		t.Error(err)
+		return
+	}
+	c.SetHistoryDepth(-1)
+	if depth := c.HistoryDepth(); depth != 2 {
+		t.Errorf("expected the depth of the database but had %d", depth)
+		return
+	}
+	if versions, _ := c.History("id", 0); len(versions) != 1 {
+		t.Errorf("expected 1 version but had %d", len(versions))
+		return
+	}
+
+	// The options built by hand keep the default history
+	if depth := (&Options{}).historyDepth(); depth != DefaultHistoryDepth {
+		t.Errorf("expected the depth %d but had %d", DefaultHistoryDepth, depth)
+		return
+	}
+	if depth := (&Options{HistoryDepth: -1}).historyDepth(); depth != 0 {
+		t.Errorf("a negative depth must disable the history but had %d", depth)
+		return
+	}
+}
+
+func TestVerifyAndRebuildIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	report, err := c.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() || report.Documents != len(users) {
+		t.Errorf("the report is not clean: %d documents, missing %v, dangling %v", report.Documents, report.Missing, report.Dangling)
+		return
+	}
+
+	// Break the indexes like a crash in the middle of a write would
+	emailAsBytes, _ := stringToBytes(users[0].Email)
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("indexes")).Bucket([]byte("email")).Delete(emailAsBytes); err != nil {
+			return err
+		}
+		ageAsBytes, _ := intToBytes(uint(1000))
+		return tx.Bucket([]byte("indexes")).Bucket([]byte("age")).Put(ageAsBytes, []byte(`["unknown"]`))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err = c.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(report.Missing["email"], []string{users[0].ID}) {
+		t.Errorf("expected %q to be missing but had %v", users[0].ID, report.Missing)
+		return
+	}
+	if !reflect.DeepEqual(report.Dangling["age"], []string{"unknown"}) {
+		t.Errorf("expected %q to be dangling but had %v", "unknown", report.Dangling)
+		return
+	}
+
+	for _, indexName := range []string{"email", "age"} {
+		if err := c.RebuildIndex(indexName); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	report, err = c.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the report is not clean after rebuild: missing %v, dangling %v", report.Missing, report.Dangling)
+		return
+	}
+
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if id, _ := response.One(new(User)); id != users[0].ID {
+		t.Errorf("the rebuilt index returned %q instead of %q", id, users[0].ID)
+		return
+	}
+
+	if err := c.RebuildIndex("not an index"); err != ErrNotFound {
+		t.Errorf("expected error %v but had %v", ErrNotFound, err)
+		return
+	}
+}
+
+func TestExportAndImportIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	dumps := map[string]*bytes.Buffer{}
+	for _, index := range c.indexes {
+		dumps[index.Name] = bytes.NewBuffer(nil)
+		if err := c.ExportIndex(index.Name, dumps[index.Name]); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := c.ExportIndex("not an index", bytes.NewBuffer(nil)); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	for name, dump := range dumps {
+		if err := c.DeleteIndex(name); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := c.ImportIndex(dump); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	report, err := c.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() || report.Documents != len(users) {
+		t.Errorf("the report is not clean: %d documents, missing %v, dangling %v", report.Documents, report.Missing, report.Dangling)
+		return
+	}
+
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if _, id, _ := response.First(); id != users[0].ID {
+		t.Errorf("expected ID %q but had %q", users[0].ID, id)
+		return
+	}
+
+	// A dump can't be loaded into an index with an other definition
+	dump := bytes.NewBuffer(nil)
+	if err := c.ExportIndex("age", dump); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.DeleteIndex("age"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetIndex("age", IntIndex, "Balance"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.ImportIndex(dump); err == nil {
+		t.Errorf("the import must fail if the index definitions are different")
+		return
+	}
+}
+
+func TestSeed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:20]
+	buildSeed := func(users []*User) *bytes.Buffer {
+		seed := bytes.NewBuffer(nil)
+		for _, user := range users {
+			content, _ := json.Marshal(user)
+			line, _ := json.Marshal(&seedRecord{ID: user.ID, Content: content})
+			seed.Write(append(line, '\n'))
+		}
+		return seed
+	}
+
+	if err := c.Seed(buildSeed(users[:10]), true); err != nil {
+		t.Error(err)
+		return
+	}
+	// The collection is not empty anymore so the second seed is ignored
+	if err := c.Seed(buildSeed(users[10:]), true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ids, _ := c.GetIDs("", 100)
+	if len(ids) != 10 {
+		t.Errorf("expected 10 documents but had %d", len(ids))
+		return
+	}
+
+	// The seeded numbers are indexed as signed integers
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Age").CompareTo(int(users[4].Age))))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	found := false
+	response.All(func(id string, _ []byte) error {
+		if id == users[4].ID {
+			found = true
+		}
+		return nil
+	})
+	if !found {
+		t.Errorf("the seeded document %q is not indexed", users[4].ID)
+		return
+	}
+
+	if err := c.Seed(buildSeed(users[10:]), false); err != nil {
+		t.Error(err)
+		return
+	}
+	ids, _ = c.GetIDs("", 100)
+	if len(ids) != 20 {
+		t.Errorf("expected 20 documents but had %d", len(ids))
+		return
+	}
+
+	if err := c.Seed(bytes.NewBufferString(`{"Content": {}}`), false); err != ErrEmptyID {
+		t.Errorf("expected %v but had %v", ErrEmptyID, err)
+		return
+	}
+
+	// With the Go type the numbers are indexed as unsigned integers like with Put
+	typed, _ := db.Use("typedSeed")
+	if err := setIndexes(typed); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := typed.SeedAs(buildSeed(users), true, func() interface{} { return new(User) }); err != nil {
+		t.Error(err)
+		return
+	}
+	response, queryErr = typed.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Age").CompareTo(users[4].Age)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	found = false
+	response.All(func(id string, _ []byte) error {
+		if id == users[4].ID {
+			found = true
+		}
+		return nil
+	})
+	if !found {
+		t.Errorf("the document %q seeded with its type is not found with an unsigned value", users[4].ID)
+		return
+	}
+}
+
+func TestSyncTo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	source, _ := db.Use("testCol")
+	target, _ := db.Use("target")
+	if err := setIndexes(target); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The target has a part of the documents, some are outdated and some are not in the source
+	for _, user := range users[:50] {
+		if err := target.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, user := range users[:5] {
+		if err := target.Put(user.ID, &User{ID: user.ID, Email: "outdated@tlaloc.com"}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := target.Put("unknown", &User{ID: "unknown"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := target.Put("bin", []byte{0, 1, 2}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := source.SyncTo(target); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sourceValues, _ := source.GetValues("", 1000)
+	targetValues, _ := target.GetValues("", 1000)
+	if len(sourceValues) != len(targetValues) {
+		t.Errorf("the target has %d documents but the source has %d", len(targetValues), len(sourceValues))
+		return
+	}
+	for i := range sourceValues {
+		if sourceValues[i].GetID() != targetValues[i].GetID() || !bytes.Equal(sourceValues[i].ContentAsBytes, targetValues[i].ContentAsBytes) {
+			t.Errorf("the document %q is not synchronized", sourceValues[i].GetID())
+			return
+		}
+	}
+
+	response, queryErr := target.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[2].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if _, id, _ := response.First(); id != users[2].ID {
+		t.Errorf("expected %q but had %q", users[2].ID, id)
+		return
+	}
+
+	report, err := target.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the report is not clean: missing %v, dangling %v", report.Missing, report.Dangling)
+		return
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	if version, err := c.Version("id"); err != nil || version != 0 {
+		t.Errorf("expected version 0 but had %d and error %v", version, err)
+		return
+	}
+
+	if err := c.PutIfAbsent("id", &User{ID: "id", Balance: 0}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.PutIfAbsent("id", &User{ID: "id", Balance: 1}); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+
+	version, _ := c.Version("id")
+	if version == 0 {
+		t.Errorf("the version of a saved ID can't be 0")
+		return
+	}
+	if err := c.PutIfVersion("id", &User{ID: "id", Balance: 1}, version+1); err != ErrVersionMismatch {
+		t.Errorf("expected %v but had %v", ErrVersionMismatch, err)
+		return
+	}
+
+	// Only one of the concurrent writers with the same version succeeds
+	nbWriters := 10
+	errs := make(chan error, nbWriters)
+	for i := 0; i < nbWriters; i++ {
+		go func(i int) {
+			errs <- c.PutIfVersion("id", &User{ID: "id", Balance: i}, version)
+		}(i)
+	}
+	succeeded := 0
+	for i := 0; i < nbWriters; i++ {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else if err != ErrVersionMismatch {
+			t.Error(err)
+			return
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected one write but had %d", succeeded)
+		return
+	}
+
+	newVersion, _ := c.Version("id")
+	if newVersion <= version {
+		t.Errorf("the version %d must be greater than %d", newVersion, version)
+		return
+	}
+}
+
+func TestPutRev(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	if _, _, err := c.GetRev("id", nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+	if err := c.PutRev("id", &User{ID: "id"}, 0); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.PutRev("id", &User{ID: "id"}, 0); err != ErrConflict {
+		t.Errorf("expected %v but had %v", ErrConflict, err)
+		return
+	}
+
+	// The concurrent increments are retried after a conflict so none is lost
+	nbWriters := 10
+	errs := make(chan error, nbWriters)
+	for i := 0; i < nbWriters; i++ {
+		go func() {
+			for {
+				user := new(User)
+				rev, _, err := c.GetRev("id", user)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				user.Balance++
+				err = c.PutRev("id", user, rev)
+				if err != ErrConflict {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < nbWriters; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	user := new(User)
+	rev, _, err := c.GetRev("id", user)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Balance != nbWriters {
+		t.Errorf("expected a balance of %d but had %d", nbWriters, user.Balance)
+		return
+	}
+	if version, _ := c.Version("id"); version != rev {
+		t.Errorf("expected revision %d but had %d", version, rev)
+		return
+	}
+}
+
+func TestQueryIfChanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	query := NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(10))).SetOrder(true, "Age")
+
+	response, err := c.QueryIfChanged(query, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fingerprint := response.Fingerprint()
+	if fingerprint == "" || response.Len() == 0 {
+		t.Errorf("the response can't be empty")
+		return
+	}
+
+	if _, err := c.QueryIfChanged(query, fingerprint); err != ErrNotModified {
+		t.Errorf("expected %v but had %v", ErrNotModified, err)
+		return
+	}
+
+	// Change one of the results
+	_, id, _ := response.First()
+	user := new(User)
+	c.Get(id, user)
+	user.Email = "changed@tlaloc.com"
+	if err := c.Put(id, user); err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err = c.QueryIfChanged(query, fingerprint)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Fingerprint() == fingerprint {
+		t.Errorf("the fingerprint must change with the content")
+		return
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, id := range []string{"docs", "docs/a", "docs/a/1", "docs/b", "docsOther", "other"} {
+		if err := c.Put(id, &User{ID: id, Email: id + "@tlaloc.com"}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	getIDs := func(root string) []string {
+		elems, err := c.GetSubtree(root)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		ids := []string{}
+		for _, elem := range elems {
+			ids = append(ids, elem.GetID())
+		}
+		return ids
+	}
+
+	if ids := getIDs("docs"); !reflect.DeepEqual(ids, []string{"docs", "docs/a", "docs/a/1", "docs/b"}) {
+		t.Errorf("wrong subtree %v", ids)
+		return
+	}
+
+	if err := c.MoveSubtree("docs/a", "docs/a/1/x"); err == nil {
+		t.Errorf("a subtree can't be moved into itself")
+		return
+	}
+	if err := c.MoveSubtree("docs/a", "archive"); err != nil {
+		t.Error(err)
+		return
+	}
+	if ids := getIDs("archive"); !reflect.DeepEqual(ids, []string{"archive", "archive/1"}) {
+		t.Errorf("wrong moved subtree %v", ids)
+		return
+	}
+	if ids := getIDs("docs"); !reflect.DeepEqual(ids, []string{"docs", "docs/b"}) {
+		t.Errorf("wrong subtree after the move %v", ids)
+		return
+	}
+
+	// The moved documents are indexed with their new IDs
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo("docs/a/1@tlaloc.com")))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if _, id, _ := response.First(); id != "archive/1" {
+		t.Errorf("expected %q but had %q", "archive/1", id)
+		return
+	}
+
+	if err := c.DeleteSubtree("docs"); err != nil {
+		t.Error(err)
+		return
+	}
+	if ids, _ := c.GetIDs("", 10); !reflect.DeepEqual(ids, []string{"archive", "archive/1", "docsOther", "other"}) {
+		t.Errorf("wrong IDs after the delete %v", ids)
+		return
+	}
+}
+
+func TestExistsAndCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	other, _ := db.Use("other")
+	other.Put("id", &User{ID: "id"})
+
+	if n, err := c.Count(); err != nil || n != len(users) {
+		t.Errorf("expected %d documents but had %d and error %v", len(users), n, err)
+		return
+	}
+
+	if exists, err := c.Exists(users[3].ID); err != nil || !exists {
+		t.Errorf("%q must exist: %v", users[3].ID, err)
+		return
+	}
+	if exists, err := c.Exists("unknown"); err != nil || exists {
+		t.Errorf("%q must not exist: %v", "unknown", err)
+		return
+	}
+	if _, err := c.Exists(""); err != ErrEmptyID {
+		t.Errorf("expected %v but had %v", ErrEmptyID, err)
+		return
+	}
+
+	if err := c.Delete(users[3].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if exists, _ := c.Exists(users[3].ID); exists {
+		t.Errorf("%q is deleted", users[3].ID)
+		return
+	}
+	if n, _ := c.Count(); n != len(users)-1 {
+		t.Errorf("expected %d documents but had %d", len(users)-1, n)
+		return
+	}
+}
+
+func TestSchema(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	c, _ := db.Use("testCol")
+	if err := c.SetSchema(
+		&SchemaField{Selector: []string{"Email"}, Type: StringIndex},
+		&SchemaField{Selector: []string{"Address", "ZipCode"}, Type: IntIndex},
+	); err != nil {
+		t.Error(err)
+		return
+	}
+
+	user := unmarshalDataSet(dataSet1)[0]
+	if err := c.Put(user.ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+
+	err := c.Put("missing", map[string]interface{}{"Email": "a@b.c"})
+	if schemaErr, ok := err.(*SchemaError); !ok || !schemaErr.Missing || schemaErr.Selector[1] != "ZipCode" {
+		t.Errorf("expected a missing ZipCode error but had %v", err)
+		return
+	}
+
+	err = c.Put("wrong type", map[string]interface{}{"Email": 10, "Address": map[string]interface{}{"ZipCode": 1}})
+	if schemaErr, ok := err.(*SchemaError); !ok || schemaErr.Missing || schemaErr.Type != StringIndex {
+		t.Errorf("expected a wrong type error but had %v", err)
+		return
+	}
+
+	// Binary contents are not checked
+	if err := c.Put("bin", []byte("bin")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	db.Close()
+	db, openDBErr = Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ = db.Use("testCol")
+	if len(c.Schema()) != 2 {
+		t.Errorf("the schema is not saved: %v", c.Schema())
+		return
+	}
+	if err := c.Put("missing", map[string]interface{}{}); err == nil {
+		t.Errorf("the schema is not checked after the reopening")
+		return
+	}
+
+	if err := c.SetSchema(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Put("missing", map[string]interface{}{}); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	expected := 0
+	for _, user := range users {
+		if user.Age > 10 {
+			expected++
+		}
+	}
+
+	defer func(size int) { DeleteManyBatchSize = size }(DeleteManyBatchSize)
+	DeleteManyBatchSize = 7
+
+	progressCalls := 0
+	lastProgress := 0
+	deleted, err := c.DeleteManyWithProgress(
+		NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(10))),
+		func(deleted int) {
+			progressCalls++
+			lastProgress = deleted
+		},
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if deleted != expected || lastProgress != expected {
+		t.Errorf("expected %d deleted documents but had %d and %d reported", expected, deleted, lastProgress)
+		return
+	}
+	if progressCalls < expected/DeleteManyBatchSize {
+		t.Errorf("the progress has been called only %d times", progressCalls)
+		return
+	}
+
+	if n, _ := c.Count(); n != len(users)-expected {
+		t.Errorf("expected %d documents left but had %d", len(users)-expected, n)
+		return
+	}
+
+	// Nothing to delete anymore
+	deleted, err = c.DeleteMany(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(10))))
+	if err != nil || deleted != 0 {
+		t.Errorf("expected no deletion but had %d and error %v", deleted, err)
+		return
+	}
+}
+
+func TestUpdateMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	expected := 0
+	for _, user := range users {
+		if user.Age > 15 {
+			expected++
+		}
+	}
+
+	defer func(size int) { UpdateManyBatchSize = size }(UpdateManyBatchSize)
+	UpdateManyBatchSize = 7
+
+	olderThan15 := func() *Query {
+		return NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(15))).SetLimits(1000, 1000)
+	}
+
+	skipped := ""
+	updated, err := c.UpdateMany(olderThan15(), func(id string, content []byte) (interface{}, error) {
+		if skipped == "" {
+			skipped = id
+			return nil, nil
+		}
+
+		user := new(User)
+		if err := json.Unmarshal(content, user); err != nil {
+			return nil, err
+		}
+		user.Age = 1
+		return user, nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if updated != expected-1 {
+		t.Errorf("expected %d updated documents but had %d", expected-1, updated)
+		return
+	}
+
+	// Only the skipped document is still indexed with its old age
+	response, queryErr := c.Query(olderThan15())
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if response.Len() != 1 || response.list[0].GetID() != skipped {
+		t.Errorf("expected only %q but had %d documents", skipped, response.Len())
+		return
+	}
+
+	// The errors of the update function stop the updates
+	if _, err := c.UpdateMany(olderThan15(), func(id string, content []byte) (interface{}, error) {
+		return nil, ErrNotFound
+	}); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+}
+
+func TestResponseClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	response, err := c.Query(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(10))))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() == 0 {
+		t.Errorf("the response is empty")
+		return
+	}
+
+	response.Close()
+	response.Close()
+	if response.Len() != 0 {
+		t.Errorf("the response is not empty after Close")
+		return
+	}
+	if _, err := response.One(new(User)); err != ErrTheResponseIsOver {
+		t.Errorf("expected %v but had %v", ErrTheResponseIsOver, err)
+		return
+	}
+}
+
+func TestResponseDecode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	response, err := c.Query(NewQuery().SetLimits(10, 1000).SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(10))).SetOrder(true, "Age"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := []*User{{ID: "replaced"}}
+	if err := response.Decode(&users); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(users) != response.Len() {
+		t.Errorf("expected %d users but had %d", response.Len(), len(users))
+		return
+	}
+	for i, user := range users {
+		if user.ID != response.list[i].GetID() {
+			t.Errorf("expected %q at position %d but had %q", response.list[i].GetID(), i, user.ID)
+			return
+		}
+	}
+
+	values := []User{}
+	if err := response.Decode(&values); err != nil || len(values) != len(users) || values[0].ID != users[0].ID {
+		t.Errorf("unexpected values %v: %v", values, err)
+		return
+	}
+
+	if err := response.Decode(users); err != ErrWrongType {
+		t.Errorf("expected %v but had %v", ErrWrongType, err)
+		return
+	}
+	if err := response.Decode(new(User)); err != ErrWrongType {
+		t.Errorf("expected %v but had %v", ErrWrongType, err)
+		return
+	}
+}
+
+func TestResponseOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	for _, ascendent := range []bool{true, false} {
+		// The ages have many ties
+		q := NewQuery().SetOrder(ascendent, "Age").SetLimits(50, 1000).
+			SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(5)))
+
+		response, err := c.Query(q)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		fromAll := []string{}
+		ages := []uint{}
+		response.All(func(id string, objAsBytes []byte) error {
+			user := new(User)
+			json.Unmarshal(objAsBytes, user)
+			fromAll = append(fromAll, id)
+			ages = append(ages, user.Age)
+			return nil
+		})
+
+		for i := 1; i < len(fromAll); i++ {
+			before, after := i-1, i
+			if !ascendent {
+				before, after = i, i-1
+			}
+			if ages[before] > ages[after] || (ages[before] == ages[after] && fromAll[before] > fromAll[after]) {
+				t.Errorf("ascendent %v: %q (%d) and %q (%d) are not in order", ascendent, fromAll[i-1], ages[i-1], fromAll[i], ages[i])
+				return
+			}
+		}
+
+		fromNext := []string{}
+		for _, id, _ := response.First(); id != ""; _, id, _ = response.Next() {
+			fromNext = append(fromNext, id)
+		}
+		fromPrev := []string{}
+		for _, id, _ := response.Last(); id != ""; _, id, _ = response.Prev() {
+			fromPrev = append([]string{id}, fromPrev...)
+		}
+		fromOne := []string{}
+		for {
+			id, err := response.One(new(User))
+			if err == ErrTheResponseIsOver {
+				break
+			}
+			fromOne = append(fromOne, id)
+		}
+
+		again, _ := c.Query(q)
+		fromAgain := []string{}
+		again.All(func(id string, _ []byte) error {
+			fromAgain = append(fromAgain, id)
+			return nil
+		})
+
+		for name, ids := range map[string][]string{"Next": fromNext, "Prev": fromPrev, "One": fromOne, "again": fromAgain} {
+			if !reflect.DeepEqual(ids, fromAll) {
+				t.Errorf("ascendent %v: %s has an other order than All\n%v\n%v", ascendent, name, ids, fromAll)
+				return
+			}
+		}
+	}
+}
+
+func TestQueryTrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	q := NewQuery().SetLimits(10, 1000).
+		SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(5))).
+		SetFilter(NewFilter(Less).SetSelector("Balance").CompareTo(0))
+
+	response, err := c.Query(q)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Trace() != nil {
+		t.Errorf("the response must not have a trace by default")
+		return
+	}
+
+	response, err = c.Query(q.SetTrace(true))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	trace := response.Trace()
+	if trace == nil || len(trace.Filters) != 2 {
+		t.Errorf("expected a trace of 2 filters but had %v", trace)
+		return
+	}
+
+	expected := map[string]int{}
+	for _, user := range users {
+		if user.Age > 5 {
+			expected["age"]++
+		}
+		if user.Balance < 0 {
+			expected["balance"]++
+		}
+	}
+	total := 0
+	for _, filter := range trace.Filters {
+		// The indexes return at most the internal limit of the query
+		if filter.IDs == 0 || filter.IDs > expected[filter.IndexName] {
+			t.Errorf("expected up to %d IDs for %q but had %d", expected[filter.IndexName], filter.IndexName, filter.IDs)
+			return
+		}
+		total += filter.IDs
+	}
+	if trace.IDsConsidered != total {
+		t.Errorf("expected %d IDs considered but had %d", total, trace.IDsConsidered)
+		return
+	}
+	if trace.DocumentsFetched != response.Len() || trace.Total < trace.Fetch+trace.Order {
+		t.Errorf("unexpected trace %+v", trace)
+		return
+	}
+}
+
+func TestQueryProfile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	q := NewQuery().SetLimits(10, 1000).
+		SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(5))).
+		SetFilter(NewFilter(Less).SetSelector("Balance").CompareTo(0)).
+		SetOrder(true, "Age").
+		Profile()
+
+	response, err := c.Query(q)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	profile := response.Trace()
+	if profile == nil || len(profile.Filters) != 2 {
+		t.Errorf("expected the profile of 2 filters but had %v", profile)
+		return
+	}
+	for _, filter := range profile.Filters {
+		if filter.Duration <= 0 {
+			t.Errorf("the index scan of %q is not timed", filter.IndexName)
+			return
+		}
+	}
+	if profile.Merge <= 0 || profile.Select <= 0 || profile.Order <= 0 || profile.Fetch <= 0 {
+		t.Errorf("unexpected profile %+v", profile)
+		return
+	}
+	if profile.Total < profile.Merge+profile.Select+profile.Order+profile.Fetch {
+		t.Errorf("the total is shorter than the steps %+v", profile)
+		return
+	}
+}
+
+func TestFetchBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	defaultFetchBatchSize := FetchBatchSize
+	FetchBatchSize = 7
+	defer func() { FetchBatchSize = defaultFetchBatchSize }()
+
+	// The IDs are not in the order of the keys and one is asked twice
+	ids := []string{}
+	for i := len(users) - 1; i >= 0; i -= 3 {
+		ids = append(ids, users[i].ID)
+	}
+	ids = append(ids, users[len(users)-1].ID)
+
+	contents, err := c.get(ctx, ids...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, id := range ids {
+		user := new(User)
+		json.Unmarshal(contents[i], user)
+		if user.ID != id {
+			t.Errorf("expected %q but had %q", id, user.ID)
+			return
+		}
+	}
+
+	if _, err := c.get(ctx, users[0].ID, "missing", users[1].ID); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+}
+
+func TestContextOperations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	canceled, cancelOperation := context.WithCancel(context.Background())
+	cancelOperation()
+
+	if err := c.PutContext(canceled, "new", users[0]); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+	if err := c.Get("new", nil); err != ErrNotFound {
+		t.Errorf("the canceled write is saved: %v", err)
+		return
+	}
+	if err := c.GetContext(canceled, users[0].ID, new(User)); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+	if err := c.DeleteContext(canceled, users[0].ID); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+	if _, err := c.QueryContext(canceled, NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(5)))); err == nil {
+		t.Errorf("the canceled query returned no error")
+		return
+	}
+
+	canceledWrites := map[string]func() error{
+		"PutWithMetaContext": func() error {
+			return c.PutWithMetaContext(canceled, "new", users[0], map[string]string{"author": "test"})
+		},
+		"PutIfAbsentContext":  func() error { return c.PutIfAbsentContext(canceled, "new", users[0]) },
+		"PutIfVersionContext": func() error { return c.PutIfVersionContext(canceled, "new", users[0], 0) },
+		"PutWithTTLContext":   func() error { return c.PutWithTTLContext(canceled, "new", users[0], time.Hour) },
+	}
+	for name, write := range canceledWrites {
+		if err := write(); err != context.Canceled {
+			t.Errorf("%s: expected %v but had %v", name, context.Canceled, err)
+			return
+		}
+	}
+	if exists, err := c.Exists("new"); err != nil || exists {
+		t.Errorf("the canceled writes are saved: %v", err)
+		return
+	}
+	if _, err := c.ExistsContext(canceled, users[0].ID); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+	if _, err := c.CountContext(canceled); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+
+	// The operations run as usual with a context which is not done
+	if err := c.PutContext(ctx, "new", users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+	user := new(User)
+	if err := c.GetContext(ctx, "new", user); err != nil || user.ID != users[0].ID {
+		t.Errorf("unexpected user %+v: %v", user, err)
+		return
+	}
+	if exists, err := c.ExistsContext(ctx, "new"); err != nil || !exists {
+		t.Errorf("expected the document to exist: %v", err)
+		return
+	}
+	if n, err := c.CountContext(ctx); err != nil || n != len(users)+1 {
+		t.Errorf("expected %d documents but had %d: %v", len(users)+1, n, err)
+		return
+	}
+	if err := c.DeleteContext(ctx, "new"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.PutIfAbsentContext(ctx, "new", users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.PutIfAbsentContext(ctx, "new", users[0]); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+	if err := c.DeleteContext(ctx, "new"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Get("new", nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+}
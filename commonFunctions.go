@@ -3,6 +3,7 @@ package gotinydb
 import (
 	"context"
 	"encoding/base64"
+	"reflect"
 	"sync"
 
 	"github.com/minio/highwayhash"
@@ -36,7 +37,7 @@ func getIDsAsString(input []*idType) (ret []string) {
 func newTransaction(id string) *writeTransaction {
 	tr := new(writeTransaction)
 	tr.id = id
-	tr.responseChan = make(chan error, 0)
+	tr.responseChan = make(chan error, 1)
 
 	return tr
 }
@@ -77,7 +78,39 @@ func (it IndexType) TypeName() string {
 		return "IntIndex"
 	case TimeIndex:
 		return "TimeIndex"
+	case HashIndex:
+		return "HashIndex"
 	default:
 		return ""
 	}
 }
+
+// containsBytes returns true if the list has an element equal to the given value
+func containsBytes(list [][]byte, value []byte) bool {
+	for _, elem := range list {
+		if reflect.DeepEqual(elem, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if the list has an element equal to the given value
+func containsString(list []string, value string) bool {
+	for _, elem := range list {
+		if elem == value {
+			return true
+		}
+	}
+	return false
+}
+
+// removeString returns the list without the given value and true if it was found
+func removeString(list []string, value string) ([]string, bool) {
+	for j, elem := range list {
+		if elem == value {
+			return append(list[:j], list[j+1:]...), true
+		}
+	}
+	return list, false
+}
@@ -0,0 +1,169 @@
+package gotinydb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Compact runs the value log garbage collection until there is nothing more to clean
+// or until an operation is done on the database.
+func (d *DB) Compact(discardRatio float64) error {
+	return d.compact(discardRatio, time.Now().UnixNano())
+}
+
+// compact runs the garbage collection while there is no operation after startedAt
+func (d *DB) compact(discardRatio float64, startedAt int64) error {
+	return d.runValueLogGC(discardRatio, 0, func() bool {
+		return d.lastActivityTime() > startedAt
+	})
+}
+
+// runValueLogGC runs the garbage collection until there is nothing more to clean or stop returns true.
+// If maxThroughput is set it waits after every rewritten file.
+// The start and the end are sent to the event subscriptions.
+func (d *DB) runValueLogGC(discardRatio float64, maxThroughput int64, stop func() bool) error {
+	d.events.emit(&Event{Type: EventCompactionStarted})
+	err := d.valueLogGC(discardRatio, maxThroughput, stop)
+	d.events.emit(&Event{Type: EventCompactionFinished, Err: err})
+	return err
+}
+
+func (d *DB) valueLogGC(discardRatio float64, maxThroughput int64, stop func() bool) error {
+	for !stop() {
+		if d.closing || d.valueStore == nil {
+			return nil
+		}
+
+		err := d.valueStore.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite || err == badger.ErrRejected {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if pause := compactionPause(d.valueLogFileSize(), maxThroughput); pause > 0 {
+			select {
+			case <-time.After(pause):
+			case <-d.ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// compactionPause returns the time to wait after the rewrite of a value log file
+// to not rewrite more than maxThroughput bytes by second
+func compactionPause(fileSize, maxThroughput int64) time.Duration {
+	if maxThroughput <= 0 {
+		return 0
+	}
+	return time.Duration(float64(fileSize) / float64(maxThroughput) * float64(time.Second))
+}
+
+// valueLogFileSize returns the size of the value log files, the part rewritten at once by the compaction.
+// It is limited to CompactionChunkSize if the compaction has a maximum throughput.
+func (d *DB) valueLogFileSize() int64 {
+	size := d.options.BadgerOptions.ValueLogFileSize
+	if d.compaction != nil && d.compaction.MaxThroughput > 0 && size > CompactionChunkSize {
+		return CompactionChunkSize
+	}
+	return size
+}
+
+// compactionPolicy returns the policy of the background compaction, nil if there is none.
+// The policy is built from CompactionInterval if Compaction is not set and
+// CompactionMaxThroughput is used if the policy has no MaxThroughput.
+func (o *Options) compactionPolicy() *CompactionPolicy {
+	var policy CompactionPolicy
+	switch {
+	case o.Compaction != nil:
+		policy = *o.Compaction
+	case o.CompactionInterval > 0:
+		policy = CompactionPolicy{CheckInterval: o.CompactionInterval, Online: true}
+	default:
+		return nil
+	}
+
+	if policy.MaxThroughput <= 0 {
+		policy.MaxThroughput = o.CompactionMaxThroughput
+	}
+	return &policy
+}
+
+// compactionLoop checks the policy at every interval and compacts when it allows it
+func (d *DB) compactionLoop(policy *CompactionPolicy) {
+	interval := policy.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCompactionCheckInterval
+	}
+	discardRatio := policy.DiscardRatio
+	if discardRatio <= 0 {
+		discardRatio = DefaultCompactionDiscardRatio
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if d.closing {
+				return
+			}
+			if policy.allows(now, d.lastActivityTime()) {
+				startedAt := now.UnixNano()
+				// The loop waits for the compaction to not queue an other one meanwhile
+				done := make(chan struct{})
+				if err := d.workers.submit(d.ctx, func() {
+					defer close(done)
+					d.runValueLogGC(discardRatio, policy.MaxThroughput, func() bool {
+						return !policy.Online && d.lastActivityTime() > startedAt
+					})
+				}); err != nil {
+					return
+				}
+				select {
+				case <-done:
+				case <-d.ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *DB) lastActivityTime() int64 {
+	return atomic.LoadInt64(&d.lastActivity)
+}
+
+// touch saves the time of the operation to stop the running compaction
+func (c *Collection) touch() {
+	if c.activity == nil {
+		return
+	}
+	atomic.StoreInt64(c.activity, time.Now().UnixNano())
+}
+
+// allows returns true if the compaction can run at the given time
+func (p *CompactionPolicy) allows(now time.Time, lastActivity int64) bool {
+	if !p.Online && p.IdleFor > 0 && now.UnixNano()-lastActivity < int64(p.IdleFor) {
+		return false
+	}
+
+	if p.WindowStart == p.WindowEnd {
+		return true
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sinceMidnight := now.Sub(midnight)
+	if p.WindowStart < p.WindowEnd {
+		return sinceMidnight >= p.WindowStart && sinceMidnight < p.WindowEnd
+	}
+	// The window goes over midnight
+	return sinceMidnight >= p.WindowStart || sinceMidnight < p.WindowEnd
+}
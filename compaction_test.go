@@ -0,0 +1,107 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompactionPolicy(t *testing.T) {
+	now := time.Date(2018, 6, 15, 14, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name         string
+		policy       *CompactionPolicy
+		lastActivity time.Time
+		allowed      bool
+	}{
+		{"no condition", &CompactionPolicy{}, now, true},
+		{"idle", &CompactionPolicy{IdleFor: time.Minute}, now.Add(-time.Minute * 2), true},
+		{"not idle", &CompactionPolicy{IdleFor: time.Minute}, now.Add(-time.Second), false},
+		{"in window", &CompactionPolicy{WindowStart: time.Hour * 13, WindowEnd: time.Hour * 15}, now, true},
+		{"before window", &CompactionPolicy{WindowStart: time.Hour * 15, WindowEnd: time.Hour * 16}, now, false},
+		{"window over midnight", &CompactionPolicy{WindowStart: time.Hour * 22, WindowEnd: time.Hour * 15}, now, true},
+		{"out of window over midnight", &CompactionPolicy{WindowStart: time.Hour * 22, WindowEnd: time.Hour * 6}, now, false},
+		{"in window but not idle", &CompactionPolicy{IdleFor: time.Minute, WindowStart: time.Hour * 13, WindowEnd: time.Hour * 15}, now, false},
+		{"online not idle", &CompactionPolicy{Online: true, IdleFor: time.Minute}, now.Add(-time.Second), true},
+		{"online before window", &CompactionPolicy{Online: true, WindowStart: time.Hour * 15, WindowEnd: time.Hour * 16}, now, false},
+	}
+
+	for _, test := range tests {
+		if allowed := test.policy.allows(now, test.lastActivity.UnixNano()); allowed != test.allowed {
+			t.Errorf("%s: expected %v but had %v", test.name, test.allowed, allowed)
+		}
+	}
+}
+
+func TestCompactionPause(t *testing.T) {
+	if pause := compactionPause(1<<30, 0); pause != 0 {
+		t.Errorf("expected no pause without limit but had %s", pause)
+	}
+	if pause := compactionPause(1<<30, 1<<28); pause != time.Second*4 {
+		t.Errorf("expected a pause of 4s but had %s", pause)
+	}
+
+	// The options build an online policy and limit the size of the rewritten files
+	options := NewDefaultOptions("")
+	options.CompactionInterval = time.Minute
+	options.CompactionMaxThroughput = 1 << 20
+	d := &DB{options: options, compaction: options.compactionPolicy()}
+	if d.compaction == nil || !d.compaction.Online || d.compaction.CheckInterval != time.Minute || d.compaction.MaxThroughput != 1<<20 {
+		t.Errorf("unexpected policy %+v", d.compaction)
+		return
+	}
+	if size := d.valueLogFileSize(); size != CompactionChunkSize {
+		t.Errorf("expected value log files of %d bytes but had %d", CompactionChunkSize, size)
+	}
+
+	// The policy keeps its own throughput
+	options.Compaction = &CompactionPolicy{MaxThroughput: 1 << 10}
+	if policy := options.compactionPolicy(); policy.MaxThroughput != 1<<10 || policy.Online {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+	options.Compaction, options.CompactionInterval, options.CompactionMaxThroughput = nil, 0, 0
+	if policy := options.compactionPolicy(); policy != nil {
+		t.Errorf("expected no policy but had %+v", policy)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.Compaction = &CompactionPolicy{IdleFor: time.Millisecond * 10, CheckInterval: time.Millisecond * 10}
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	before := db.lastActivityTime()
+	if err := c.Put("id", &User{ID: "id"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if db.lastActivityTime() <= before {
+		t.Errorf("the activity is not saved")
+		return
+	}
+
+	// Let the background compaction run
+	time.Sleep(time.Millisecond * 50)
+
+	if err := db.Compact(DefaultCompactionDiscardRatio); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Get("id", nil); err != nil {
+		t.Error(err)
+		return
+	}
+}
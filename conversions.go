@@ -2,11 +2,23 @@ package gotinydb
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"math"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// IndexValue returns the given value as it is saved into the indexes.
+// The supported types are the same as the filter values.
+func IndexValue(value interface{}) ([]byte, error) {
+	filterValue, err := newfilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return filterValue.Bytes(), nil
+}
+
 // stringToBytes converter from a string to bytes slice.
 // If an error is returned it's has the form of ErrWrongType
 func stringToBytes(input interface{}) ([]byte, error) {
@@ -20,6 +32,17 @@ func stringToBytes(input interface{}) ([]byte, error) {
 	return []byte(lowerCaseString), nil
 }
 
+// hashToBytes converter from string to a fixed size hash of the string as bytes slice.
+// The string is lower cased first like for the string indexes.
+func hashToBytes(input interface{}) ([]byte, error) {
+	asBytes, err := stringToBytes(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildIDInternal(string(asBytes)), nil
+}
+
 // intToBytes converter from a int or uint of any size (int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64)
 // to bytes slice. If an error is returned it's has the form of ErrWrongType
 func intToBytes(input interface{}) ([]byte, error) {
@@ -78,3 +101,45 @@ func timeToBytes(input interface{}) ([]byte, error) {
 
 	return typedInput.MarshalBinary()
 }
+
+// jsonNumberToBytes converts a number decoded from the stored JSON.
+// The JSON encoding does not keep the difference between signed and unsigned
+// integers, this is why the signed and if possible the unsigned representations are returned.
+// If an error is returned it's has the form of ErrWrongType
+func jsonNumberToBytes(input interface{}) ([][]byte, error) {
+	typedInput, ok := input.(json.Number)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	ret := [][]byte{}
+	if asInt, err := typedInput.Int64(); err == nil {
+		asBytes, _ := intToBytes(asInt)
+		ret = append(ret, asBytes)
+	}
+	if asUint, err := strconv.ParseUint(typedInput.String(), 10, 64); err == nil {
+		asBytes, _ := intToBytes(asUint)
+		ret = append(ret, asBytes)
+	}
+
+	if len(ret) == 0 {
+		return nil, ErrWrongType
+	}
+	return ret, nil
+}
+
+// jsonTimeToBytes converts a time decoded from the stored JSON.
+// If an error is returned it's has the form of ErrWrongType
+func jsonTimeToBytes(input interface{}) ([]byte, error) {
+	typedInput, ok := input.(string)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	t, parseErr := time.Parse(time.RFC3339Nano, typedInput)
+	if parseErr != nil {
+		return nil, ErrWrongType
+	}
+
+	return timeToBytes(t)
+}
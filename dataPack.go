@@ -0,0 +1,77 @@
+package gotinydb
+
+// AttachPack attaches the database at path read only, like a reference dataset
+// shipped with the application. Its files are memory mapped and never written.
+// Its collections are returned by Use as long as the database has no collection
+// with the same name, and their writes return ErrReadOnly.
+// The pack is opened with the keys of packOptions, never with the ones of the database,
+// so clear and encrypted packs can be attached to any database. Nil options open a clear pack.
+func (d *DB) AttachPack(path string, packOptions *PackOptions) (*DataPack, error) {
+	options := NewDefaultOptions(path)
+	options.ReadOnly = true
+	if packOptions != nil {
+		options.EncryptionKey = packOptions.EncryptionKey
+		options.BlindTokenKey = packOptions.BlindTokenKey
+		options.AnonymizationKey = packOptions.AnonymizationKey
+	}
+
+	packDB, err := Open(d.ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	pack := &DataPack{
+		Path:   path,
+		parent: d,
+		packDB: packDB,
+	}
+
+	d.archivesMutex.Lock()
+	d.packs = append(d.packs, pack)
+	d.archivesMutex.Unlock()
+
+	return pack, nil
+}
+
+// Collections returns the collections of the pack with their indexes
+func (p *DataPack) Collections() []CollectionInfo {
+	return p.packDB.Collections()
+}
+
+// Detach closes the pack, its collections are not returned by Use anymore
+func (p *DataPack) Detach() error {
+	p.parent.archivesMutex.Lock()
+	found := false
+	for i, pack := range p.parent.packs {
+		if pack == p {
+			p.parent.packs = append(p.parent.packs[:i], p.parent.packs[i+1:]...)
+			found = true
+			break
+		}
+	}
+	p.parent.archivesMutex.Unlock()
+	if !found {
+		return ErrArchiveNotAttached
+	}
+
+	return p.packDB.Close()
+}
+
+// packCollection returns the collection of an attached pack with the given name or nil
+func (d *DB) packCollection(name string) *Collection {
+	for _, pack := range d.attachedPacks() {
+		for _, col := range pack.packDB.getCollections() {
+			if col.name == name {
+				return col
+			}
+		}
+	}
+	return nil
+}
+
+// attachedPacks returns a copy of the list of the attached packs
+func (d *DB) attachedPacks() []*DataPack {
+	d.archivesMutex.Lock()
+	defer d.archivesMutex.Unlock()
+	return append([]*DataPack{}, d.packs...)
+}
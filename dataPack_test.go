@@ -0,0 +1,98 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestDataPack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The pack is built by an other database
+	packPath := <-getTestPathChan
+	defer os.RemoveAll(packPath)
+	packDB, openDBErr := Open(ctx, NewDefaultOptions(packPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	refs, _ := packDB.Use("refs")
+	if err := refs.SetIndex("email", StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users {
+		if err := refs.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	packDB.Close()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	// The clear pack is attached to an encrypted database
+	options := NewDefaultOptions(testPath)
+	options.EncryptionKey = make([]byte, 32)
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	pack, err := db.AttachPack(packPath, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if infos := pack.Collections(); len(infos) != 1 || infos[0].Name != "refs" {
+		t.Errorf("unexpected collections %+v", infos)
+		return
+	}
+
+	c, err := db.Use("refs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	user := new(User)
+	if err := c.Get(users[2].ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Email != users[2].Email {
+		t.Errorf("expected %v but had %v", users[2], user)
+		return
+	}
+	response, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[5].Email)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() != 1 {
+		t.Errorf("expected 1 result but had %d", response.Len())
+		return
+	}
+
+	if err := c.Put("new", users[0]); err != ErrReadOnly {
+		t.Errorf("expected %v but had %v", ErrReadOnly, err)
+		return
+	}
+	if err := c.Delete(users[0].ID); err != ErrReadOnly {
+		t.Errorf("expected %v but had %v", ErrReadOnly, err)
+		return
+	}
+
+	if err := pack.Detach(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := pack.Detach(); err != ErrArchiveNotAttached {
+		t.Errorf("expected %v but had %v", ErrArchiveNotAttached, err)
+		return
+	}
+}
@@ -0,0 +1,61 @@
+package gotinydb
+
+import (
+	"fmt"
+	"time"
+)
+
+// check returns the refused error of the read only databases,
+// otherwise it checks the free space.
+func (s *diskSpace) check() error {
+	if s != nil && s.refused != nil {
+		return s.refused
+	}
+	return s.checkFreeSpace()
+}
+
+// checkFreeSpace returns a *LowDiskSpaceError if the free space is under the minimum.
+// The free space is read again only after the check interval.
+// The deletes are refused too because badger writes them as new entries.
+func (s *diskSpace) checkFreeSpace() error {
+	if s == nil || s.options.MinFreeSpace == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	interval := s.options.DiskSpaceCheckInterval
+	if interval <= 0 {
+		interval = DefaultDiskSpaceCheckInterval
+	}
+
+	if time.Since(s.lastCheck) >= interval {
+		freeSpace, err := getFreeSpace(s.options.Path)
+		if err != nil {
+			return err
+		}
+		s.lastCheck = time.Now()
+		s.freeSpace = freeSpace
+
+		readOnly := freeSpace < s.options.MinFreeSpace
+		if readOnly != s.readOnly {
+			s.readOnly = readOnly
+			if s.options.LowDiskSpaceHook != nil {
+				go s.options.LowDiskSpaceHook(freeSpace, readOnly)
+			}
+		}
+	}
+
+	if s.readOnly {
+		return &LowDiskSpaceError{
+			FreeSpace:    s.freeSpace,
+			MinFreeSpace: s.options.MinFreeSpace,
+		}
+	}
+	return nil
+}
+
+func (e *LowDiskSpaceError) Error() string {
+	return fmt.Sprintf("the database is read only, %d bytes free and %d needed", e.FreeSpace, e.MinFreeSpace)
+}
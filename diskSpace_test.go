@@ -0,0 +1,72 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLowDiskSpace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.DiskSpaceCheckInterval = time.Millisecond
+
+	hookCalls := make(chan bool, 10)
+	options.LowDiskSpaceHook = func(_ uint64, readOnly bool) {
+		hookCalls <- readOnly
+	}
+
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.Put("id", &User{ID: "id"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// No disk has this free space
+	options.MinFreeSpace = 1 << 62
+	time.Sleep(time.Millisecond * 2)
+
+	err := c.Put("id2", &User{ID: "id2"})
+	if lowSpaceErr, ok := err.(*LowDiskSpaceError); !ok || lowSpaceErr.MinFreeSpace != options.MinFreeSpace {
+		t.Errorf("expected a low disk space error but had %v", err)
+		return
+	}
+	if _, ok := c.Delete("id").(*LowDiskSpaceError); !ok {
+		t.Errorf("the delete must be refused")
+		return
+	}
+	if readOnly := <-hookCalls; !readOnly {
+		t.Errorf("the hook must be called with the read only state")
+		return
+	}
+
+	// The reads still work
+	if err := c.Get("id", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	options.MinFreeSpace = 1
+	time.Sleep(time.Millisecond * 2)
+
+	if err := c.Put("id2", &User{ID: "id2"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if readOnly := <-hookCalls; readOnly {
+		t.Errorf("the hook must be called when the database can write again")
+		return
+	}
+}
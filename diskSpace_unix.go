@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package gotinydb
+
+import (
+	"syscall"
+)
+
+// getFreeSpace returns the number of bytes the user can write in the given directory
+func getFreeSpace(path string) (uint64, error) {
+	stat := new(syscall.Statfs_t)
+	if err := syscall.Statfs(path, stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
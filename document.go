@@ -0,0 +1,475 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var (
+	jsonMarshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonNumberType     = reflect.TypeOf(json.Number(""))
+	documentObjectType = reflect.TypeOf(documentObject{})
+
+	// documentFieldsCache holds the *documentFields of the struct types
+	documentFieldsCache sync.Map
+)
+
+// writeDocument writes the value as encoding/json marshals it, so the binary codecs
+// are read back as the JSON saved into the indexes without marshaling it again.
+// The values with their own MarshalJSON or MarshalText are written from their JSON.
+func writeDocument(w documentWriter, value interface{}) error {
+	return writeDocumentValue(w, reflect.ValueOf(value))
+}
+
+// encodeDocument writes the JSON content with the writer
+func encodeDocument(w documentWriter, content []byte) error {
+	doc, err := readDocument(content)
+	if err != nil {
+		return err
+	}
+	return writeDocument(w, doc)
+}
+
+func writeDocumentValue(w documentWriter, v reflect.Value) error {
+	if !v.IsValid() {
+		w.writeNull()
+		return nil
+	}
+
+	t := v.Type()
+	switch {
+	case t == documentObjectType:
+		object := v.Interface().(documentObject)
+		w.openMap(len(object))
+		for _, member := range object {
+			w.writeKey(member.Key)
+			if err := writeDocument(w, member.Value); err != nil {
+				return err
+			}
+		}
+		w.close()
+		return nil
+	case t == jsonNumberType:
+		return writeDocumentNumber(w, json.Number(v.String()))
+	case t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType):
+		return writeDocumentJSON(w, v)
+	case t.Kind() != reflect.Ptr && v.CanAddr() &&
+		(reflect.PtrTo(t).Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)):
+		return writeDocumentJSON(w, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return w.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return w.writeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return ErrNotEncodable
+		}
+		return w.writeFloat(f, t.Bits())
+	case reflect.String:
+		w.writeString(v.String())
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			w.writeNull()
+			return nil
+		}
+		return writeDocumentValue(w, v.Elem())
+	case reflect.Struct:
+		return writeDocumentStruct(w, v)
+	case reflect.Map:
+		return writeDocumentMap(w, v)
+	case reflect.Slice:
+		if v.IsNil() {
+			w.writeNull()
+			return nil
+		}
+		// Like encoding/json the bytes are saved in base64
+		elem := reflect.PtrTo(t.Elem())
+		if t.Elem().Kind() == reflect.Uint8 && !elem.Implements(jsonMarshalerType) && !elem.Implements(textMarshalerType) {
+			w.writeString(base64.StdEncoding.EncodeToString(v.Bytes()))
+			return nil
+		}
+		return writeDocumentArray(w, v)
+	case reflect.Array:
+		return writeDocumentArray(w, v)
+	default:
+		return ErrNotEncodable
+	}
+	return nil
+}
+
+// writeDocumentJSON writes the value from its JSON
+func writeDocumentJSON(w documentWriter, v reflect.Value) error {
+	value := v.Interface()
+	if v.CanAddr() {
+		value = v.Addr().Interface()
+	}
+
+	asBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return encodeDocument(w, asBytes)
+}
+
+func writeDocumentNumber(w documentWriter, number json.Number) error {
+	s := number.String()
+	if s == "" {
+		s = "0"
+	}
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(i, 10) == s {
+		return w.writeInt(i)
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil && strconv.FormatUint(u, 10) == s {
+		return w.writeUint(u)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && formatJSONFloat(f, 64) == s {
+		return w.writeFloat(f, 64)
+	}
+	return ErrNotEncodable
+}
+
+func writeDocumentArray(w documentWriter, v reflect.Value) error {
+	w.openArray(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := writeDocumentValue(w, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	w.close()
+	return nil
+}
+
+func writeDocumentMap(w documentWriter, v reflect.Value) error {
+	if v.IsNil() {
+		w.writeNull()
+		return nil
+	}
+
+	type member struct {
+		key   string
+		value reflect.Value
+	}
+	members := make([]member, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		var name string
+		switch {
+		case key.Kind() == reflect.String:
+			name = key.String()
+		case key.Type().Implements(textMarshalerType):
+			return writeDocumentJSON(w, v)
+		case key.Kind() >= reflect.Int && key.Kind() <= reflect.Int64:
+			name = strconv.FormatInt(key.Int(), 10)
+		case key.Kind() >= reflect.Uint && key.Kind() <= reflect.Uintptr:
+			name = strconv.FormatUint(key.Uint(), 10)
+		default:
+			return ErrNotEncodable
+		}
+		members = append(members, member{name, v.MapIndex(key)})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].key < members[j].key })
+
+	w.openMap(len(members))
+	for _, member := range members {
+		w.writeKey(member.key)
+		if err := writeDocumentValue(w, member.value); err != nil {
+			return err
+		}
+	}
+	w.close()
+	return nil
+}
+
+func writeDocumentStruct(w documentWriter, v reflect.Value) error {
+	fields := getDocumentFields(v.Type())
+	if fields.viaJSON {
+		return writeDocumentJSON(w, v)
+	}
+
+	written := make([]documentField, 0, len(fields.fields))
+	for _, field := range fields.fields {
+		if field.omitEmpty && isEmptyDocumentValue(v.Field(field.index)) {
+			continue
+		}
+		written = append(written, field)
+	}
+
+	w.openMap(len(written))
+	for _, field := range written {
+		w.writeKey(field.name)
+		if err := writeDocumentValue(w, v.Field(field.index)); err != nil {
+			return err
+		}
+	}
+	w.close()
+	return nil
+}
+
+// getDocumentFields returns the fields of the struct type as encoding/json marshals them.
+// The embedded structs, the options other than omitempty and the duplicated names are left to encoding/json.
+func getDocumentFields(t reflect.Type) *documentFields {
+	if cached, found := documentFieldsCache.Load(t); found {
+		return cached.(*documentFields)
+	}
+
+	ret := new(documentFields)
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if structField.Anonymous {
+			ret.viaJSON = true
+			break
+		}
+		if structField.PkgPath != "" {
+			continue
+		}
+
+		tag := structField.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+		if !isValidJSONTag(name) {
+			name = structField.Name
+		}
+
+		field := documentField{index: i, name: name}
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "":
+			case "omitempty":
+				field.omitEmpty = true
+			default:
+				ret.viaJSON = true
+			}
+		}
+		if names[name] {
+			ret.viaJSON = true
+		}
+		names[name] = true
+		ret.fields = append(ret.fields, field)
+	}
+
+	documentFieldsCache.Store(t, ret)
+	return ret
+}
+
+// isValidJSONTag is the check of encoding/json for the names of the fields
+func isValidJSONTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+// isEmptyDocumentValue is the omitempty check of encoding/json
+func isEmptyDocumentValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// formatJSONFloat returns the float as encoding/json marshals it
+func formatJSONFloat(f float64, bits int) string {
+	var asBytes []byte
+	if bits == 32 {
+		asBytes, _ = json.Marshal(float32(f))
+	} else {
+		asBytes, _ = json.Marshal(f)
+	}
+	return string(asBytes)
+}
+
+// isJSONInteger returns true if the float is marshaled by encoding/json as the integer of the same value
+func isJSONInteger(f float64) bool {
+	return f == math.Trunc(f) && math.Abs(f) <= 1<<53 && !(f == 0 && math.Signbit(f))
+}
+
+// readDocument reads the JSON content as a tree which keeps the order of the members.
+// It returns ErrNotEncodable if the content is not JSON or if it would not be written back the same,
+// with spaces or escaping encoding/json does not produce.
+func readDocument(content []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	doc, err := readDocumentValue(decoder)
+	if err != nil {
+		return nil, ErrNotEncodable
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrNotEncodable
+	}
+	if !bytes.Equal(appendDocumentJSON(nil, doc), content) {
+		return nil, ErrNotEncodable
+	}
+	return doc, nil
+}
+
+func readDocumentValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		object := documentObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readDocumentValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, documentMember{Key: key.(string), Value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	case '[':
+		array := documentArray{}
+		for decoder.More() {
+			value, err := readDocumentValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	}
+	return nil, ErrNotEncodable
+}
+
+// appendDocumentJSON appends the JSON of the document tree to buf
+func appendDocumentJSON(buf []byte, doc interface{}) []byte {
+	switch value := doc.(type) {
+	case nil:
+		buf = append(buf, "null"...)
+	case bool:
+		buf = strconv.AppendBool(buf, value)
+	case string:
+		asBytes, _ := json.Marshal(value)
+		buf = append(buf, asBytes...)
+	case json.Number:
+		buf = append(buf, value...)
+	case documentArray:
+		buf = append(buf, '[')
+		for i, elem := range value {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendDocumentJSON(buf, elem)
+		}
+		buf = append(buf, ']')
+	case documentObject:
+		buf = append(buf, '{')
+		for i, member := range value {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendDocumentJSON(buf, member.Key)
+			buf = append(buf, ':')
+			buf = appendDocumentJSON(buf, member.Value)
+		}
+		buf = append(buf, '}')
+	}
+	return buf
+}
+
+func (t *treeWriter) add(value interface{}) {
+	if len(t.stack) == 0 {
+		t.root = value
+		return
+	}
+
+	frame := t.stack[len(t.stack)-1]
+	if frame.isMap {
+		frame.object = append(frame.object, documentMember{Key: frame.key, Value: value})
+	} else {
+		frame.array = append(frame.array, value)
+	}
+}
+
+func (t *treeWriter) writeNull()           { t.add(nil) }
+func (t *treeWriter) writeBool(value bool) { t.add(value) }
+func (t *treeWriter) writeString(s string) { t.add(s) }
+func (t *treeWriter) writeKey(key string)  { t.stack[len(t.stack)-1].key = key }
+
+func (t *treeWriter) writeInt(value int64) error {
+	t.add(json.Number(strconv.FormatInt(value, 10)))
+	return nil
+}
+
+func (t *treeWriter) writeUint(value uint64) error {
+	t.add(json.Number(strconv.FormatUint(value, 10)))
+	return nil
+}
+
+func (t *treeWriter) writeFloat(value float64, bits int) error {
+	t.add(json.Number(formatJSONFloat(value, bits)))
+	return nil
+}
+
+func (t *treeWriter) openMap(n int) {
+	t.stack = append(t.stack, &treeFrame{isMap: true, object: make(documentObject, 0, n)})
+}
+
+func (t *treeWriter) openArray(n int) {
+	t.stack = append(t.stack, &treeFrame{array: make(documentArray, 0, n)})
+}
+
+func (t *treeWriter) close() {
+	frame := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	if frame.isMap {
+		t.add(frame.object)
+	} else {
+		t.add(frame.array)
+	}
+}
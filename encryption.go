@@ -0,0 +1,355 @@
+package gotinydb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// encryptedMagic starts every encrypted stream
+var encryptedMagic = []byte("GTDBENC1")
+
+// encryptionCheckKey saves a sealed value to check the EncryptionKey at Open
+var encryptionCheckKey = []byte("\x00encryption")
+
+// encryptedChunkSize defines the size of the clear chunks sealed one by one
+const encryptedChunkSize = 64 << 10
+
+type (
+	// encryptWriter seals everything written into it chunk by chunk with AES-GCM.
+	// The last chunk is flagged to detect truncated streams.
+	encryptWriter struct {
+		w       io.Writer
+		aead    cipher.AEAD
+		nonce   []byte
+		counter uint64
+		buf     []byte
+	}
+
+	// indexCipher hides the indexed values and the IDs of the index files.
+	// The index keys are replaced by tokens and the values are sealed.
+	// A nil indexCipher leaves them in clear.
+	indexCipher struct {
+		aead     cipher.AEAD
+		tokenKey []byte
+	}
+
+	// decryptReader opens the chunks written by encryptWriter
+	decryptReader struct {
+		r       io.Reader
+		aead    cipher.AEAD
+		nonce   []byte
+		counter uint64
+		buf     []byte
+		done    bool
+	}
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newEncryptWriter writes the header and returns a writer which needs to be closed
+// to flush the last chunk
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ew := new(encryptWriter)
+	ew.w = w
+	ew.aead = aead
+	ew.nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, ew.nonce); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(append(append([]byte{}, encryptedMagic...), ew.nonce...)); err != nil {
+		return nil, err
+	}
+
+	return ew, nil
+}
+
+// chunkNonce returns the nonce of the given chunk. The base nonce is random
+// and the chunk counter is xored into the last bytes.
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := append([]byte{}, base...)
+	counterAsBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterAsBytes, counter)
+	for i := range counterAsBytes {
+		nonce[len(nonce)-8+i] ^= counterAsBytes[i]
+	}
+	return nonce
+}
+
+func (ew *encryptWriter) Write(p []byte) (n int, err error) {
+	ew.buf = append(ew.buf, p...)
+	for len(ew.buf) > encryptedChunkSize {
+		if err := ew.writeChunk(ew.buf[:encryptedChunkSize], false); err != nil {
+			return 0, err
+		}
+		ew.buf = ew.buf[encryptedChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (ew *encryptWriter) writeChunk(chunk []byte, last bool) error {
+	additionalData := []byte{0}
+	if last {
+		additionalData[0] = 1
+	}
+
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.nonce, ew.counter), chunk, additionalData)
+	ew.counter++
+
+	lenAsBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenAsBytes, uint32(len(sealed)))
+	if _, err := ew.w.Write(lenAsBytes); err != nil {
+		return err
+	}
+	_, err := ew.w.Write(sealed)
+	return err
+}
+
+// Close writes the remaining content as the last chunk
+func (ew *encryptWriter) Close() error {
+	err := ew.writeChunk(ew.buf, true)
+	ew.buf = nil
+	return err
+}
+
+// isEncrypted checks the header and returns a reader which starts at the beginning of the stream
+func isEncrypted(r io.Reader) (bool, io.Reader, error) {
+	header := make([]byte, len(encryptedMagic))
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, nil, err
+	}
+	return bytes.Equal(header[:n], encryptedMagic), io.MultiReader(bytes.NewReader(header[:n]), r), nil
+}
+
+// newDecryptReader reads the header and returns a reader of the clear content
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encryptedMagic)+aead.NonceSize())
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(encryptedMagic)], encryptedMagic) {
+		return nil, fmt.Errorf("the stream is not encrypted")
+	}
+
+	dr := new(decryptReader)
+	dr.r = r
+	dr.aead = aead
+	dr.nonce = header[len(encryptedMagic):]
+	return dr, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (n int, err error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) readChunk() error {
+	lenAsBytes := make([]byte, 4)
+	if _, err := io.ReadFull(dr.r, lenAsBytes); err != nil {
+		if err == io.EOF {
+			// The last chunk has not been found
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	sealed := make([]byte, binary.BigEndian.Uint32(lenAsBytes))
+	if _, err := io.ReadFull(dr.r, sealed); err != nil {
+		return err
+	}
+
+	nonce := chunkNonce(dr.nonce, dr.counter)
+	dr.counter++
+
+	clear, err := dr.aead.Open(nil, nonce, sealed, []byte{0})
+	if err != nil {
+		clear, err = dr.aead.Open(nil, nonce, sealed, []byte{1})
+		if err != nil {
+			return ErrDecryption
+		}
+		dr.done = true
+
+		// Nothing can follow the last chunk
+		extra := make([]byte, 1)
+		n, err := io.ReadFull(dr.r, extra)
+		if n != 0 {
+			return ErrDecryption
+		}
+		if err != io.EOF {
+			return err
+		}
+	}
+
+	dr.buf = clear
+	return nil
+}
+
+// initEncryption builds the cipher of the values if the options have an EncryptionKey.
+// The key is checked with a value sealed at the first Open with a key.
+func (d *DB) initEncryption() error {
+	return d.valueStore.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(encryptionCheckKey)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		found := err == nil
+
+		if d.options.EncryptionKey == nil {
+			if found {
+				return ErrMissingEncryptionKey
+			}
+			return nil
+		}
+
+		aead, err := newAEAD(d.options.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		d.valueAEAD = aead
+
+		// The index files have keys of their own derived from the EncryptionKey
+		indexAEAD, err := newAEAD(hmacSHA256(d.options.EncryptionKey, "gotinydb index values"))
+		if err != nil {
+			return err
+		}
+		d.indexCipher = &indexCipher{
+			aead:     indexAEAD,
+			tokenKey: hmacSHA256(d.options.EncryptionKey, "gotinydb index keys"),
+		}
+
+		if found {
+			sealed, err := item.Value()
+			if err != nil {
+				return err
+			}
+			_, err = openValue(aead, encryptionCheckKey, sealed)
+			return err
+		}
+
+		// The values saved before can't be read with the key
+		iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		iter.Rewind()
+		notEmpty := iter.Valid()
+		iter.Close()
+		if notEmpty {
+			return fmt.Errorf("the database has been created without encryption")
+		}
+
+		sealed, err := sealValue(aead, encryptionCheckKey, encryptionCheckKey)
+		if err != nil {
+			return err
+		}
+		return txn.Set(encryptionCheckKey, sealed)
+	})
+}
+
+// sealValue encrypts the value with a random nonce saved before it.
+// The key the value is saved under is authenticated with it, so the value
+// can't be opened if it is moved to an other key.
+func sealValue(aead cipher.AEAD, key, value []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, value, key), nil
+}
+
+// openValue decrypts the value sealed by sealValue under the same key
+func openValue(aead cipher.AEAD, key, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+
+	clear, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], key)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return clear, nil
+}
+
+// sealValue encrypts the value saved under the key if the database has an EncryptionKey
+func (c *Collection) sealValue(key, value []byte) ([]byte, error) {
+	if c.valueAEAD == nil {
+		return value, nil
+	}
+	return sealValue(c.valueAEAD, key, value)
+}
+
+// openValue decrypts the value saved under the key if the database has an EncryptionKey
+func (c *Collection) openValue(key, value []byte) ([]byte, error) {
+	if c.valueAEAD == nil {
+		return value, nil
+	}
+	return openValue(c.valueAEAD, key, value)
+}
+
+// token returns the key saved in place of the indexed value,
+// the HMAC of the value with a key derived from the EncryptionKey
+func (ic *indexCipher) token(value []byte) []byte {
+	mac := hmac.New(sha256.New, ic.tokenKey)
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// seal encrypts the value saved under the key of an index file if the database
+// has an EncryptionKey
+func (ic *indexCipher) seal(key, value []byte) ([]byte, error) {
+	if ic == nil {
+		return value, nil
+	}
+	return sealValue(ic.aead, key, value)
+}
+
+// open decrypts the value saved under the key of an index file if the database
+// has an EncryptionKey
+func (ic *indexCipher) open(key, value []byte) ([]byte, error) {
+	if ic == nil {
+		return value, nil
+	}
+	return openValue(ic.aead, key, value)
+}
+
+// blindToken returns the HMAC of the lower case value as an hexadecimal string
+func blindToken(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,238 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestEncryptionStream(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	for _, size := range []int{0, 10, encryptedChunkSize, encryptedChunkSize*3 + 7} {
+		clear := make([]byte, size)
+		rand.Read(clear)
+
+		buf := new(bytes.Buffer)
+		ew, err := newEncryptWriter(buf, key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := ew.Write(clear); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := ew.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+		encrypted := buf.Bytes()
+
+		if isEnc, _, _ := isEncrypted(bytes.NewReader(encrypted)); !isEnc {
+			t.Errorf("the stream of %d bytes is not detected as encrypted", size)
+			return
+		}
+
+		dr, err := newDecryptReader(bytes.NewReader(encrypted), key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		decrypted, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(clear, decrypted) {
+			t.Errorf("the decrypted content of %d bytes is not equal to the original", size)
+			return
+		}
+
+		// Truncated streams must be rejected
+		dr, _ = newDecryptReader(bytes.NewReader(encrypted[:len(encrypted)-1]), key)
+		if _, err := ioutil.ReadAll(dr); err == nil {
+			t.Errorf("the truncated stream of %d bytes has been accepted", size)
+			return
+		}
+
+		// Data after the last chunk must be rejected
+		dr, _ = newDecryptReader(bytes.NewReader(append(append([]byte{}, encrypted...), 0)), key)
+		if _, err := ioutil.ReadAll(dr); err != ErrDecryption {
+			t.Errorf("expected %v with trailing data after the stream of %d bytes but had %v", ErrDecryption, size, err)
+			return
+		}
+
+		// Wrong key must be rejected
+		wrongKey := make([]byte, 32)
+		dr, _ = newDecryptReader(bytes.NewReader(encrypted), wrongKey)
+		if _, err := ioutil.ReadAll(dr); err != ErrDecryption {
+			t.Errorf("expected %v with the wrong key but had %v", ErrDecryption, err)
+			return
+		}
+	}
+
+	if isEnc, _, _ := isEncrypted(bytes.NewReader([]byte("PK"))); isEnc {
+		t.Errorf("clear content is detected as encrypted")
+		return
+	}
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	newOptions := func(key []byte) *Options {
+		options := NewDefaultOptions(testPath)
+		options.EncryptionKey = key
+		return options
+	}
+
+	db, openDBErr := Open(ctx, newOptions(key))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("email", StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)
+	user, updatedUser := users[0], unmarshalDataSet(dataSet2)[0]
+	if err := c.Put(user.ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.PutWithMeta(updatedUser.ID, updatedUser, map[string]string{"author": "admin"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nothing is saved in clear into the store
+	db.valueStore.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			value, _ := iter.Item().Value()
+			for _, clear := range []string{user.Email, updatedUser.Email, "admin"} {
+				if bytes.Contains(value, []byte(clear)) {
+					t.Errorf("%q is saved in clear", clear)
+				}
+			}
+		}
+		return nil
+	})
+	// Nor into the index file
+	indexFile, err := ioutil.ReadFile(testPath + "/collections/" + c.id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, clear := range []string{user.Email, updatedUser.Email} {
+		if bytes.Contains(indexFile, []byte(clear)) {
+			t.Errorf("%q is saved in clear into the index file", clear)
+		}
+	}
+
+	response, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(updatedUser.Email)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() != 1 {
+		t.Errorf("expected 1 document but had %d", response.Len())
+		return
+	}
+	if _, err := c.Query(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Email").CompareTo("a"))); err != ErrEncryptedIndexRange {
+		t.Errorf("expected %v but had %v", ErrEncryptedIndexRange, err)
+		return
+	}
+
+	retrievedUser := new(User)
+	if err := c.Get(user.ID, retrievedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if retrievedUser.Email != updatedUser.Email {
+		t.Errorf("expected %q but had %q", updatedUser.Email, retrievedUser.Email)
+		return
+	}
+	versions, err := c.History(user.ID, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(versions) != 2 || versions[0].Meta["author"] != "admin" || versions[0].Time.IsZero() {
+		t.Errorf("the history is not decrypted")
+		return
+	}
+	db.Close()
+
+	if _, err := Open(ctx, newOptions(nil)); err != ErrMissingEncryptionKey {
+		t.Errorf("expected %v but had %v", ErrMissingEncryptionKey, err)
+		return
+	}
+	wrongKey := make([]byte, 32)
+	rand.Read(wrongKey)
+	if _, err := Open(ctx, newOptions(wrongKey)); err != ErrDecryption {
+		t.Errorf("expected %v but had %v", ErrDecryption, err)
+		return
+	}
+
+	db, openDBErr = Open(ctx, newOptions(key))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ = db.Use("testCol")
+	if err := c.Get(user.ID, retrievedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if retrievedUser.Email != updatedUser.Email {
+		t.Errorf("expected %q but had %q", updatedUser.Email, retrievedUser.Email)
+		return
+	}
+}
+
+func TestEncryptionOfClearDatabase(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ := db.Use("testCol")
+	if err := c.Put("id", []byte("clear")); err != nil {
+		t.Error(err)
+		return
+	}
+	db.Close()
+
+	options := NewDefaultOptions(testPath)
+	options.EncryptionKey = make([]byte, 32)
+	if _, err := Open(ctx, options); err == nil {
+		t.Errorf("a database created without encryption can't be opened with a key")
+		return
+	}
+}
@@ -0,0 +1,114 @@
+package gotinydb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SubscribeEvents returns the subscription which receives the lifecycle events of the
+// given types, or all of them if none is given. The events are dropped if the
+// subscription is full, DefaultEventBufferSize events are kept.
+// The subscriptions are closed when the database is closed.
+func (d *DB) SubscribeEvents(types ...EventType) *EventSubscription {
+	s := &EventSubscription{
+		bus:    d.events,
+		types:  types,
+		events: make(chan *Event, DefaultEventBufferSize),
+	}
+
+	d.events.mutex.Lock()
+	defer d.events.mutex.Unlock()
+
+	if d.events.closed {
+		close(s.events)
+		return s
+	}
+	d.events.subscriptions = append(d.events.subscriptions, s)
+	return s
+}
+
+// Events returns the channel of the events. It is closed by Close and by DB.Close.
+func (s *EventSubscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Dropped returns the number of events which have been dropped because the channel was full
+func (s *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription
+func (s *EventSubscription) Close() {
+	bus := s.bus
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for i, subscription := range bus.subscriptions {
+		if subscription == s {
+			copy(bus.subscriptions[i:], bus.subscriptions[i+1:])
+			bus.subscriptions[len(bus.subscriptions)-1] = nil
+			bus.subscriptions = bus.subscriptions[:len(bus.subscriptions)-1]
+			close(s.events)
+			return
+		}
+	}
+}
+
+// wants returns true if the subscription receives the events of the given type
+func (s *EventSubscription) wants(t EventType) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, wanted := range s.types {
+		if wanted == t {
+			return true
+		}
+	}
+	return false
+}
+
+// close closes the channels of all the subscriptions, so the loops reading them end
+func (b *eventBus) close() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, s := range b.subscriptions {
+		close(s.events)
+	}
+	b.subscriptions = nil
+	b.closed = true
+}
+
+// emit sends the event to the subscriptions without waiting
+func (b *eventBus) emit(event *Event) {
+	if b == nil {
+		return
+	}
+	event.Time = time.Now()
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, s := range b.subscriptions {
+		if !s.wants(event.Type) {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// warnQuota sends EventQuotaWarning if the size is over QuotaWarningRatio of the quota
+func (b *eventBus) warnQuota(collection string, size, quota uint64) {
+	if quota == 0 || float64(size) < float64(quota)*QuotaWarningRatio {
+		return
+	}
+	b.emit(&Event{Type: EventQuotaWarning, Collection: collection, Size: size, Quota: quota})
+}
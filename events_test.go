@@ -0,0 +1,131 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	all := db.SubscribeEvents()
+	defer all.Close()
+	backups := db.SubscribeEvents(EventBackupCompleted, EventBackupFailed)
+	defer backups.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("email", StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	// The collection is already over the warning ratio of its quota
+	size, _ := c.Size()
+	quota := size + size/20
+	if err := c.SetQuota(quota); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)
+	if err := c.Put(users[0].ID, users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Compact(0.5); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.WriteBackup(ctx, bytes.NewBuffer(nil)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []*Event{
+		{Type: EventCollectionCreated, Collection: "testCol"},
+		{Type: EventIndexBuilt, Collection: "testCol", Index: "email"},
+		{Type: EventQuotaWarning, Collection: "testCol"},
+		{Type: EventCompactionStarted},
+		{Type: EventCompactionFinished},
+		{Type: EventBackupCompleted},
+	}
+	for _, wanted := range expected {
+		event := <-all.Events()
+		if event.Type != wanted.Type || event.Collection != wanted.Collection || event.Index != wanted.Index || event.Time.IsZero() {
+			t.Errorf("expected %+v but had %+v", wanted, event)
+			return
+		}
+		if wanted.Type == EventQuotaWarning && (event.Quota != quota || float64(event.Size) < float64(quota)*QuotaWarningRatio) {
+			t.Errorf("unexpected quota warning %+v", event)
+			return
+		}
+	}
+
+	if len(backups.Events()) != 1 {
+		t.Errorf("expected only the backup event but had %d events", len(backups.Events()))
+		return
+	}
+	if event := <-backups.Events(); event.Type != EventBackupCompleted || event.Version == 0 {
+		t.Errorf("unexpected backup event %+v", event)
+		return
+	}
+}
+
+func TestEventsClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	subscription := db.SubscribeEvents()
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range subscription.Events() {
+			n++
+		}
+		done <- n
+	}()
+
+	if _, err := db.Use("testCol"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The loop over the events ends when the database is closed
+	select {
+	case n := <-done:
+		if n != 1 {
+			t.Errorf("expected 1 event but had %d", n)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("the events channel is not closed by DB.Close")
+		return
+	}
+	// Closing the subscription after the database does not panic
+	subscription.Close()
+	if _, open := <-db.SubscribeEvents().Events(); open {
+		t.Errorf("the subscriptions made after Close must be closed")
+	}
+}
@@ -0,0 +1,254 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// PutWithTTL does the same as Put but the document expires after the given duration.
+// The expired documents are not returned anymore. Their index references are cleaned
+// in the background every Options.ExpirationCheckInterval and a delete is sent to the
+// change log and the watchers. If the options have a Compaction policy the cleaning runs
+// only when the policy allows it. The expiration has a precision of one second.
+// A Put without TTL on the same ID removes the expiration.
+func (c *Collection) PutWithTTL(id string, content interface{}, ttl time.Duration) error {
+	return c.PutWithTTLContext(c.ctx, id, content, ttl)
+}
+
+// PutWithTTLContext does the same as PutWithTTL with the context of PutContext
+func (c *Collection) PutWithTTLContext(ctx context.Context, id string, content interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return c.PutContext(ctx, id, content)
+	}
+	if c.IsWriteOnce() {
+		return ErrWriteOnce
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	return c.put(ctx, id, content, &putOptions{ttl: ttl})
+}
+
+// addExpiration saves the expiration time of the document to clean it when it expires
+func (c *Collection) addExpiration(tx *bolt.Tx, tr *writeTransaction) error {
+	if tr.ttl <= 0 {
+		return nil
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte("expirations"))
+	if err != nil {
+		return err
+	}
+	return c.putExpiration(bucket, uint64(time.Now().Add(tr.ttl).Unix()), tr.id)
+}
+
+// putExpiration saves the expiration with a key ordered by time.
+// If the database has an EncryptionKey the ID is replaced by its token
+// into the key and sealed into the value.
+func (c *Collection) putExpiration(bucket *bolt.Bucket, expiresAt uint64, id string) error {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, expiresAt)
+	if c.indexCipher == nil {
+		return bucket.Put(append(key, id...), nil)
+	}
+
+	key = append(key, c.indexCipher.token([]byte(id))...)
+	value, err := c.indexCipher.seal(key, []byte(id))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+// expirationID returns the ID of the expiration saved by putExpiration
+func (c *Collection) expirationID(key, value []byte) (string, error) {
+	if c.indexCipher == nil {
+		return string(key[8:]), nil
+	}
+	id, err := c.indexCipher.open(key, value)
+	return string(id), err
+}
+
+// expirationLoop cleans the expired documents of all the collections with the workers
+func (d *DB) expirationLoop() {
+	interval := d.options.ExpirationCheckInterval
+	if interval <= 0 {
+		interval = DefaultExpirationCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if d.closing {
+				return
+			}
+			// The cleaning follows the same rules as the compaction
+			policy := d.compaction
+			if policy != nil && !policy.allows(now, d.lastActivityTime()) {
+				continue
+			}
+			startedAt := now.UnixNano()
+
+			for _, c := range d.getCollections() {
+				if c == nil {
+					continue
+				}
+				c := c
+				if err := d.workers.submit(d.ctx, func() {
+					// The cleaning stops at the first operation like the compaction
+					if policy != nil && !policy.Online && d.lastActivityTime() > startedAt {
+						return
+					}
+					if err := c.cleanExpired(d.ctx, now); err != nil {
+						d.options.log(LogError, "expired documents cleaning failed", "collection", c.name, "error", err)
+					}
+				}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// cleanExpired removes the index references of the documents expired at the given time
+// and sends their deletion to the change log and the listeners.
+// The index transaction is taken first so no write of the same ID can be committed meanwhile.
+func (c *Collection) cleanExpired(ctx context.Context, now time.Time) error {
+	// The expired IDs with their last content
+	removed := map[string][]byte{}
+	err := c.updateIndex(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("expirations"))
+		if bucket == nil {
+			return nil
+		}
+
+		expired := map[string]string{}
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil && binary.BigEndian.Uint64(key[:8]) <= uint64(now.Unix()); key, value = cursor.Next() {
+			id, err := c.expirationID(key, value)
+			if err != nil {
+				return err
+			}
+			expired[string(key)] = id
+		}
+
+		for key, id := range expired {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+
+			expiresAt, err := c.getExpiresAt(id)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case expiresAt == 0:
+				// Saved again without TTL
+			case expiresAt > uint64(now.Unix()):
+				// Saved again with an other TTL
+				if err := c.putExpiration(bucket, expiresAt, id); err != nil {
+					return err
+				}
+			default:
+				if err := c.unindexDocument(ctx, tx, id); err != nil {
+					return err
+				}
+				if err := tx.Bucket([]byte("refs")).Delete(buildBytesID(id)); err != nil {
+					return err
+				}
+				removed[id] = c.getExpiredContent(id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The values have expired by themselves, the records are committed alone
+	records := []*ChangeRecord{}
+	for id := range removed {
+		records = append(records, &ChangeRecord{ID: id, Operation: ChangeDelete})
+	}
+	if len(records) != 0 {
+		if err := c.updateStoreWithChanges(ctx, func(*badger.Txn) error { return nil }, records...); err != nil {
+			return err
+		}
+	}
+
+	for id, previous := range removed {
+		if previous != nil {
+			c.notifyChange(ChangeDelete, id, previous, nil, false)
+		}
+	}
+	return nil
+}
+
+// getExpiredContent returns the content of the expired last version of the ID, nil if
+// it can't be read anymore. The subscriptions need it to know if the document leaves their result.
+func (c *Collection) getExpiredContent(id string) []byte {
+	if !c.hasListeners() {
+		return nil
+	}
+
+	var content []byte
+	c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iter.Close()
+
+		storeID := c.buildStoreID(id)
+		iter.Seek(storeID)
+		if !iter.Valid() || !bytes.Equal(iter.Item().Key(), storeID) || iter.Item().ExpiresAt() == 0 {
+			return nil
+		}
+
+		value, err := iter.Item().ValueCopy(nil)
+		if err != nil {
+			return nil
+		}
+		content, _ = c.getAndCheckContent(iter.Item().Key(), iter.Item().UserMeta(), value)
+		return nil
+	})
+	return content
+}
+
+// getExpiresAt returns the expiration of the last version of the ID,
+// 0 if it does not expire and 1 if it is expired or not saved
+func (c *Collection) getExpiresAt(id string) (expiresAt uint64, _ error) {
+	err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iter.Close()
+
+		storeID := c.buildStoreID(id)
+		iter.Seek(storeID)
+		if !iter.Valid() || string(iter.Item().Key()) != string(storeID) {
+			expiresAt = 1
+			return nil
+		}
+
+		item := iter.Item()
+		if item.IsDeletedOrExpired() {
+			expiresAt = 1
+			return nil
+		}
+		expiresAt = item.ExpiresAt()
+		return nil
+	})
+	return expiresAt, err
+}
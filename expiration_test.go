@@ -0,0 +1,99 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestPutWithTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.ExpirationCheckInterval = time.Millisecond * 100
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	events, _ := c.Watch(ctx, &WatchOptions{BufferSize: 100})
+
+	users := unmarshalDataSet(dataSet1)[:3]
+	if err := c.PutWithTTL(users[0].ID, users[0], time.Second); err != nil {
+		t.Error(err)
+		return
+	}
+	// Saved again with a longer TTL
+	c.PutWithTTL(users[1].ID, users[1], time.Second)
+	c.PutWithTTL(users[1].ID, users[1], time.Hour)
+	// Saved again without TTL
+	c.PutWithTTL(users[2].ID, users[2], time.Second)
+	c.Put(users[2].ID, users[2])
+
+	if err := c.Get(users[0].ID, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	time.Sleep(time.Millisecond * 2500)
+
+	if err := c.Get(users[0].ID, nil); err == nil {
+		t.Errorf("the document has not expired")
+		return
+	}
+	for _, user := range users[1:] {
+		if err := c.Get(user.ID, nil); err != nil {
+			t.Errorf("%q should not expire: %v", user.ID, err)
+			return
+		}
+	}
+
+	report, _ := c.VerifyIndexes()
+	if !report.OK() {
+		t.Errorf("the expired document is still indexed: %+v", report)
+		return
+	}
+	response, _ := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if response.Len() != 0 {
+		t.Errorf("the expired document is returned by the query")
+		return
+	}
+
+	c.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("refs")).Get(buildBytesID(users[0].ID)) != nil {
+			t.Errorf("the references of the expired document are still saved")
+		}
+		return nil
+	})
+
+	// The watchers receive the deletion with the expired content
+	for {
+		select {
+		case event := <-events:
+			if event.Operation != ChangeDelete {
+				continue
+			}
+			if event.ID != users[0].ID || event.Previous == nil {
+				t.Errorf("unexpected expiration event %+v", event)
+			}
+			return
+		default:
+			t.Errorf("the expiration is not sent to the watchers")
+			return
+		}
+	}
+}
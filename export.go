@@ -0,0 +1,133 @@
+package gotinydb
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+// Export writes the documents of the collection to w in the given format, in the order of their IDs.
+// If q is not nil only the documents matching its filters are exported. The store is read
+// directly so the limits and the order of the query do not apply.
+//
+// ExportJSONLines writes one {"ID": "id", "Content": {...}} object per line,
+// the format read by Seed. If columns are given the content only has their values
+// under their names.
+// ExportCSV writes a header with "_id" and the names of the columns, then one row
+// by document. The values which are not strings are written as JSON and the missing
+// values are empty. The strings which would be read back as another value by ImportCSV,
+// like "42", "true" or the empty string, are written quoted as JSON strings. The columns are the indexed selectors if none is given.
+//
+// The rules of SetAnonymization are applied to the documents.
+// The documents which are not JSON are not exported.
+func (c *Collection) Export(ctx context.Context, w io.Writer, format ExportFormat, q *Query, columns ...*Column) error {
+	if format == ExportCSV && len(columns) == 0 {
+		for _, index := range c.indexes {
+			if index.Extractor {
+				continue
+			}
+			columns = append(columns, &Column{Name: index.Name, Selector: index.Selector})
+		}
+	}
+
+	var write func(id string, object map[string]interface{}) error
+	var flush func() error
+	switch format {
+	case ExportJSONLines:
+		buffered := bufio.NewWriter(w)
+		flush = buffered.Flush
+		encoder := json.NewEncoder(buffered)
+		write = func(id string, object map[string]interface{}) error {
+			content := object
+			if len(columns) != 0 {
+				content = map[string]interface{}{}
+				for _, column := range columns {
+					if value, ok := getValueFromSelector(object, column.Selector); ok {
+						content[column.Name] = value
+					}
+				}
+			}
+			return encoder.Encode(&exportRecord{ID: id, Content: content})
+		}
+	case ExportCSV:
+		csvWriter := csv.NewWriter(w)
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+
+		header := []string{"_id"}
+		for _, column := range columns {
+			header = append(header, column.Name)
+		}
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+
+		write = func(id string, object map[string]interface{}) error {
+			row := []string{id}
+			for _, column := range columns {
+				row = append(row, exportCSVValue(object, column.Selector))
+			}
+			return csvWriter.Write(row)
+		}
+	default:
+		return ErrUnknownExportFormat
+	}
+
+	exportOne := func(id string, contentAsBytes []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		object, decodeErr := decodeStored(contentAsBytes)
+		if decodeErr != nil {
+			return nil
+		}
+
+		if err := c.anonymize(object); err != nil {
+			return err
+		}
+		return write(id, object)
+	}
+
+	if q != nil {
+		if err := c.checkQueryFilters(q); err != nil {
+			return err
+		}
+	}
+
+	iter := c.Iterate(IterOptions{})
+	defer iter.Close()
+	for iter.Next() {
+		contentAsBytes, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		if q != nil && !c.matchQuery(q, contentAsBytes) {
+			continue
+		}
+		if err := exportOne(iter.ID(), contentAsBytes); err != nil {
+			return err
+		}
+	}
+
+	return flush()
+}
+
+// exportCSVValue returns the value of the selector as it is written into a CSV cell
+func exportCSVValue(object map[string]interface{}, selector []string) string {
+	value, ok := getValueFromSelector(object, selector)
+	if !ok || value == nil {
+		return ""
+	}
+	if asString, ok := value.(string); ok {
+		// The strings read as JSON by the import are quoted to stay strings
+		if asString != "" && !json.Valid([]byte(asString)) {
+			return asString
+		}
+	}
+	asBytes, _ := json.Marshal(value)
+	return string(asBytes)
+}
@@ -0,0 +1,139 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetAnonymization(&AnonymizeRule{Selector: []string{"Address", "City"}, Transform: AnonymizeRedact}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:20]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// Not exported
+	if err := c.Put("bin", []byte{1, 2, 3}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The JSON lines can be loaded by Seed
+	jsonLines := bytes.NewBuffer(nil)
+	if err := c.Export(ctx, jsonLines, ExportJSONLines, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	other, _ := db.Use("other")
+	if err := other.Seed(bytes.NewReader(jsonLines.Bytes()), false); err != nil {
+		t.Error(err)
+		return
+	}
+	exported := new(User)
+	if err := other.Get(users[3].ID, exported); err != nil {
+		t.Error(err)
+		return
+	}
+	if exported.Email != users[3].Email || exported.Address.City != "" {
+		t.Errorf("unexpected exported user %v", exported)
+		return
+	}
+
+	// The CSV of the query with the given columns
+	csvBuffer := bytes.NewBuffer(nil)
+	q := NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[3].Email))
+	if err := c.Export(ctx, csvBuffer, ExportCSV, q,
+		&Column{Name: "email", Selector: []string{"Email"}},
+		&Column{Name: "age", Selector: []string{"Age"}},
+		&Column{Name: "city", Selector: []string{"Address", "City"}},
+	); err != nil {
+		t.Error(err)
+		return
+	}
+	records, err := csv.NewReader(csvBuffer).ReadAll()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := [][]string{
+		{"_id", "email", "age", "city"},
+		{users[3].ID, users[3].Email, fmt.Sprint(users[3].Age), ""},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("expected %v but had %v", expected, records)
+		return
+	}
+
+	// The columns limit the content of the JSON lines
+	jsonLines.Reset()
+	if err := c.Export(ctx, jsonLines, ExportJSONLines, q, &Column{Name: "email", Selector: []string{"Email"}}); err != nil {
+		t.Error(err)
+		return
+	}
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(jsonLines.Bytes(), &line); err != nil {
+		t.Error(err)
+		return
+	}
+	if fmt.Sprint(line["Content"]) != fmt.Sprintf("map[email:%s]", users[3].Email) {
+		t.Errorf("unexpected line %s", jsonLines.String())
+		return
+	}
+
+	// The limits of the query do not truncate the export
+	limited := NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(0))).SetLimits(1, 1)
+	jsonLines.Reset()
+	if err := c.Export(ctx, jsonLines, ExportJSONLines, limited); err != nil {
+		t.Error(err)
+		return
+	}
+	expectedLines := 0
+	for _, user := range users {
+		if user.Age > 0 {
+			expectedLines++
+		}
+	}
+	if n := bytes.Count(jsonLines.Bytes(), []byte("\n")); n != expectedLines {
+		t.Errorf("expected %d lines but had %d", expectedLines, n)
+		return
+	}
+
+	if err := c.Export(ctx, jsonLines, ExportFormat("xml"), nil); err != ErrUnknownExportFormat {
+		t.Errorf("expected %v but had %v", ErrUnknownExportFormat, err)
+		return
+	}
+	canceledCtx, cancelExport := context.WithCancel(ctx)
+	cancelExport()
+	if err := c.Export(canceledCtx, jsonLines, ExportJSONLines, nil); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+}
@@ -1,6 +1,9 @@
 package gotinydb
 
 import (
+	"bytes"
+	"encoding/json"
+	"strconv"
 	"time"
 )
 
@@ -76,6 +79,171 @@ func (f *Filter) SetSelector(s ...string) *Filter {
 	return f
 }
 
+// match returns true if the value is in the result of the filter.
+// toBytes converts the filter values the same way the value is.
+func (f *Filter) match(value []byte, toBytes func(*filterValue) []byte) bool {
+	if len(f.values) == 0 {
+		return false
+	}
+
+	compareTo := func(filterValue *filterValue) int {
+		return bytes.Compare(value, toBytes(filterValue))
+	}
+
+	switch f.GetType() {
+	case Equal:
+		for _, filterValue := range f.values {
+			if compareTo(filterValue) == 0 {
+				return true
+			}
+		}
+	case Greater:
+		comp := compareTo(f.values[0])
+		return comp > 0 || (f.equal && comp == 0)
+	case Less:
+		comp := compareTo(f.values[0])
+		return comp < 0 || (f.equal && comp == 0)
+	case Between:
+		if len(f.values) < 2 {
+			return false
+		}
+		low, high := compareTo(f.values[0]), compareTo(f.values[1])
+		if f.equal {
+			return low >= 0 && high <= 0
+		}
+		return low > 0 && high < 0
+	}
+	return false
+}
+
+// matchStored returns true if the document as it is decoded from the store is in the
+// result of the filter, without any index. The filters on an array match if one of
+// its elements does, so an Equal filter selects the arrays containing one of the values.
+func (f *Filter) matchStored(object map[string]interface{}) bool {
+	if len(f.values) == 0 {
+		return false
+	}
+
+	field, ok := getValueFromSelector(object, f.selector)
+	if !ok {
+		return false
+	}
+	candidates, isArray := field.([]interface{})
+	if !isArray {
+		candidates = []interface{}{field}
+	}
+
+	for _, candidate := range candidates {
+		asBytes, ok := f.values[0].storedToBytes(candidate)
+		if ok && f.match(asBytes, (*filterValue).Bytes) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON returns the filter as JSON, so the change filters holding it can be sent
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	wire := &filterJSON{Selector: f.selector, Operator: f.operator, Equal: f.equal}
+	for _, value := range f.values {
+		valueAsBytes, err := json.Marshal(value.Value)
+		if err != nil {
+			return nil, err
+		}
+		wireValue := &filterValueJSON{Type: value.Type, Value: valueAsBytes}
+		switch value.Value.(type) {
+		case uint, uint8, uint16, uint32, uint64:
+			wireValue.Unsigned = true
+		}
+		wire.Values = append(wire.Values, wireValue)
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON reads the filter returned by MarshalJSON
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	wire := new(filterJSON)
+	if err := json.Unmarshal(data, wire); err != nil {
+		return err
+	}
+
+	*f = Filter{operator: wire.Operator, equal: wire.Equal}
+	f.SetSelector(wire.Selector...)
+	for _, wireValue := range wire.Values {
+		value, err := wireValue.filterValue()
+		if err != nil {
+			return err
+		}
+		f.values = append(f.values, value)
+	}
+	return nil
+}
+
+// filterValue returns the value with the Go type it has been built with
+func (w *filterValueJSON) filterValue() (*filterValue, error) {
+	var value interface{}
+	var err error
+	switch w.Type {
+	case StringIndex:
+		var asString string
+		err = json.Unmarshal(w.Value, &asString)
+		value = asString
+	case IntIndex:
+		if w.Unsigned {
+			var asUint uint64
+			err = json.Unmarshal(w.Value, &asUint)
+			value = asUint
+		} else {
+			var asInt int64
+			err = json.Unmarshal(w.Value, &asInt)
+			value = asInt
+		}
+	case TimeIndex:
+		var asTime time.Time
+		err = json.Unmarshal(w.Value, &asTime)
+		value = asTime
+	default:
+		return nil, ErrWrongType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newfilterValue(value)
+}
+
+// storedToBytes converts a value decoded from the store like the filter value.
+// The numbers are read as signed or unsigned integers like the filter value is.
+func (f *filterValue) storedToBytes(value interface{}) ([]byte, bool) {
+	var asBytes []byte
+	var err error
+	switch f.Type {
+	case StringIndex:
+		asBytes, err = stringToBytes(value)
+	case IntIndex:
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		switch f.Value.(type) {
+		case uint, uint8, uint16, uint32, uint64:
+			var asUint uint64
+			if asUint, err = strconv.ParseUint(number.String(), 10, 64); err == nil {
+				asBytes, err = intToBytes(asUint)
+			}
+		default:
+			var asInt int64
+			if asInt, err = number.Int64(); err == nil {
+				asBytes, err = intToBytes(asInt)
+			}
+		}
+	case TimeIndex:
+		asBytes, err = jsonTimeToBytes(value)
+	default:
+		return nil, false
+	}
+	return asBytes, err == nil
+}
+
 // Bytes returns the value as a slice of bytes
 func (f *filterValue) Bytes() []byte {
 	var bytes []byte
@@ -1,6 +1,7 @@
 package gotinydb
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 	"time"
@@ -56,3 +57,58 @@ func TestAction_ValueToCompareAsBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterJSON(t *testing.T) {
+	date := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	filter := &ChangeFilter{Filters: []*Filter{
+		NewFilter(Greater).SetSelector("U").CompareTo(uint(10)).EqualWanted(),
+		NewFilter(Between).SetSelector("Sub", "I").CompareTo(-5).CompareTo(5),
+		NewFilter(Less).SetSelector("T").CompareTo(date),
+		NewFilter(Equal).SetSelector("Tags").CompareTo("A"),
+	}}
+
+	asBytes, err := json.Marshal(filter)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	read := new(ChangeFilter)
+	if err := json.Unmarshal(asBytes, read); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, f := range filter.Filters {
+		got := read.Filters[i]
+		if got.operator != f.operator || got.equal != f.equal || got.selectorHash != f.selectorHash || len(got.values) != len(f.values) {
+			t.Errorf("filter %d: expected %+v but had %+v", i, f, got)
+			return
+		}
+		for j, value := range f.values {
+			if !reflect.DeepEqual(got.values[j].Bytes(), value.Bytes()) {
+				t.Errorf("filter %d value %d: expected %v but had %v", i, j, value.Value, got.values[j].Value)
+				return
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		content string
+		match   bool
+	}{
+		{`{"U":10,"Sub":{"I":0},"T":"2018-01-01T00:00:00Z","Tags":["a"]}`, true},
+		{`{"U":9,"Sub":{"I":0},"T":"2018-01-01T00:00:00Z","Tags":["a"]}`, false},
+		{`{"U":10,"Sub":{"I":-5},"T":"2018-01-01T00:00:00Z","Tags":["a"]}`, false},
+		{`{"U":10,"Sub":{"I":0},"T":"2018-06-01T00:00:00Z","Tags":["a"]}`, false},
+		{`{"U":10,"Sub":{"I":0},"T":"2018-01-01T00:00:00Z","Tags":["c","a"]}`, true},
+		{`{"U":10,"Sub":{"I":0},"T":"2018-01-01T00:00:00Z","Tags":["c","b"]}`, false},
+		{`{"U":10,"Sub":{"I":0},"T":"2018-01-01T00:00:00Z"}`, false},
+	} {
+		if read.matchContent([]byte(test.content)) != test.match {
+			t.Errorf("%s: expected the match to be %v", test.content, test.match)
+		}
+	}
+	if read.matchContent(nil) {
+		t.Errorf("the deletes must not match the filters")
+	}
+}
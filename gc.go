@@ -0,0 +1,361 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// GC finds the references and the index entries of the documents which are not stored
+// anymore, the deleted or expired values still in the store and the free pages of the index files.
+// In dry run mode nothing is changed and the report gives what would be cleaned.
+// Otherwise the index entries are removed, the versions older than the HistoryRetention
+// of the options are purged, the index files are rewritten without their free pages and the
+// value log garbage collection is run to reclaim the space of the deleted values.
+// The writes are queued while an index file is rewritten, it waits for the open snapshots.
+// It should run when the collections are not queried.
+func (d *DB) GC(ctx context.Context, dryRun bool) (*GCReport, error) {
+	report := &GCReport{DryRun: dryRun}
+	sizeBefore := dirSize(d.options.Path)
+
+	for _, c := range d.getCollections() {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		var err error
+		if dryRun {
+			err = c.viewIndex(func(tx *bolt.Tx) error {
+				return c.gc(tx, report)
+			})
+		} else {
+			// The index transaction is taken first so no write can be committed during the scan
+			err = c.updateIndex(func(tx *bolt.Tx) error {
+				return c.gc(tx, report)
+			})
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if err := c.countTombstones(report); err != nil {
+			return report, err
+		}
+
+		c.dbLock.share()
+		stats, pageSize := c.db.Stats(), c.db.Info().PageSize
+		c.dbLock.unshare()
+		report.ReclaimableBytes += int64((stats.FreePageN + stats.PendingPageN) * pageSize)
+
+		if dryRun {
+			continue
+		}
+
+		if d.options.HistoryRetention > 0 {
+			purged, err := c.PurgeHistory(time.Now().Add(-d.options.HistoryRetention))
+			if err != nil {
+				return report, err
+			}
+			report.PurgedVersions += purged
+		}
+
+		if err := c.compactIndexFile(); err != nil {
+			return report, err
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	err := d.compact(DefaultCompactionDiscardRatio, time.Now().UnixNano())
+	report.ReclaimedBytes = sizeBefore - dirSize(d.options.Path)
+	return report, err
+}
+
+// compactIndexFile copies the index file of the collection into a new one without
+// the free pages and replaces it. The writes are queued and the index reads wait
+// during the copy. The old file is kept if the new one can't be opened.
+func (c *Collection) compactIndexFile() error {
+	maintenance, err := c.StartMaintenance(&MaintenanceOptions{Queue: true})
+	if err == ErrMaintenanceOngoing {
+		// The file is compacted by the next GC
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer maintenance.End()
+
+	// The writes hold writeMutex before they share the index file
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.dbLock.lock()
+	defer c.dbLock.unlock()
+
+	path := c.db.Path()
+	compactPath := path + ".compact"
+	compactDB, err := bolt.Open(compactPath, FilePermission, c.options.BoltOptions)
+	if err != nil {
+		return err
+	}
+
+	err = c.db.View(func(tx *bolt.Tx) error {
+		return compactDB.Update(func(compactTx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+				compactBucket, err := compactTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(bucket, compactBucket)
+			})
+		})
+	})
+	if closeErr := compactDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(compactPath)
+		return err
+	}
+
+	if err := c.db.Close(); err != nil {
+		os.Remove(compactPath)
+		return c.reopenIndexFile(path, err)
+	}
+
+	oldPath := path + ".old"
+	if err := os.Rename(path, oldPath); err != nil {
+		os.Remove(compactPath)
+		return c.reopenIndexFile(path, err)
+	}
+	if err = os.Rename(compactPath, path); err == nil {
+		var db *bolt.DB
+		if db, err = bolt.Open(path, FilePermission, c.options.BoltOptions); err == nil {
+			c.db = db
+			return os.Remove(oldPath)
+		}
+	}
+
+	// The old file is put back
+	os.Remove(compactPath)
+	if renameErr := os.Rename(oldPath, path); renameErr != nil {
+		c.options.log(LogError, "index file not restored after a failed compaction", "collection", c.name, "path", oldPath, "error", renameErr)
+		return renameErr
+	}
+	return c.reopenIndexFile(path, err)
+}
+
+// reopenIndexFile opens the index file again after a failed compaction and returns cause
+func (c *Collection) reopenIndexFile(path string, cause error) error {
+	db, err := bolt.Open(path, FilePermission, c.options.BoltOptions)
+	if err != nil {
+		c.options.log(LogError, "index file not reopened after a failed compaction", "collection", c.name, "error", err)
+		return err
+	}
+	c.db = db
+	return cause
+}
+
+// share waits for the end of the replacement of the index file and shares it
+func (l *indexFileLock) share() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.init()
+	for l.replacing {
+		l.cond.Wait()
+	}
+	l.shares++
+}
+
+// unshare ends a share of the index file
+func (l *indexFileLock) unshare() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.init()
+	l.shares--
+	if l.shares == 0 {
+		l.cond.Broadcast()
+	}
+}
+
+// lock waits for the end of all the shares of the index file to replace it
+func (l *indexFileLock) lock() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.init()
+	for l.replacing || l.shares > 0 {
+		l.cond.Wait()
+	}
+	l.replacing = true
+}
+
+// unlock ends the replacement of the index file
+func (l *indexFileLock) unlock() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.replacing = false
+	l.cond.Broadcast()
+}
+
+func (l *indexFileLock) init() {
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mutex)
+	}
+}
+
+// copyBucket copies the keys and the nested buckets of src into dst
+func copyBucket(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(key, value []byte) error {
+		if value != nil {
+			return dst.Put(key, value)
+		}
+
+		nested, err := dst.CreateBucket(key)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(key), nested)
+	})
+}
+
+// dirSize returns the size of the files of the directory
+func dirSize(path string) (size int64) {
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// gc cleans the references and the index entries of the IDs which are not stored.
+// Nothing is changed if the transaction is read only.
+func (c *Collection) gc(tx *bolt.Tx, report *GCReport) error {
+	storedIDs := map[string]bool{}
+	iter := c.Iterate(IterOptions{})
+	for iter.Next() {
+		storedIDs[iter.ID()] = true
+	}
+	iter.Close()
+
+	refsBucket := tx.Bucket([]byte("refs"))
+	staleRefs := [][]byte{}
+	err := refsBucket.ForEach(func(key, refsAsBytes []byte) error {
+		refs, _ := c.refsFromDB(key, refsAsBytes)
+		if refs != nil && storedIDs[refs.ObjectID] {
+			return nil
+		}
+
+		report.StaleRefs++
+		report.ReclaimableBytes += int64(len(key) + len(refsAsBytes))
+		staleRefs = append(staleRefs, append([]byte{}, key...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, index := range c.indexes {
+		indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte(index.Name))
+		if indexBucket == nil {
+			continue
+		}
+
+		updates := map[string][]string{}
+		err := indexBucket.ForEach(func(key, idsAsBytes []byte) error {
+			ids, readErr := index.readPosting(tx, key, idsAsBytes)
+			if readErr != nil {
+				return readErr
+			}
+
+			kept := []string{}
+			for _, id := range ids {
+				if storedIDs[id] {
+					kept = append(kept, id)
+				}
+			}
+			if len(kept) == len(ids) && len(ids) != 0 {
+				return nil
+			}
+			report.StaleIndexIDs += len(ids) - len(kept)
+
+			if len(kept) == 0 {
+				report.ReclaimableBytes += int64(len(key) + len(idsAsBytes))
+				updates[string(key)] = nil
+				return nil
+			}
+
+			allAsBytes, _ := json.Marshal(ids)
+			keptAsBytes, _ := json.Marshal(kept)
+			report.ReclaimableBytes += int64(len(allAsBytes) - len(keptAsBytes))
+			updates[string(key)] = kept
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !tx.Writable() {
+			continue
+		}
+		for key, kept := range updates {
+			if kept == nil {
+				err = index.deletePosting(tx, []byte(key))
+			} else {
+				err = index.setPosting(tx, []byte(key), kept)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !tx.Writable() {
+		return nil
+	}
+	for _, key := range staleRefs {
+		if err := refsBucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countTombstones adds the stored keys of the collection which are deleted or expired to the report
+func (c *Collection) countTombstones(report *GCReport) error {
+	return c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iter.Close()
+
+		prefix := []byte(c.id[:4] + "_")
+		var lastKey []byte
+		deleted := false
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+
+			// The first version of every key is the last one
+			if string(item.Key()) != string(lastKey) {
+				lastKey = item.KeyCopy(lastKey)
+				deleted = item.IsDeletedOrExpired()
+				if deleted {
+					report.Tombstones++
+				}
+			}
+
+			if deleted {
+				report.ReclaimableBytes += item.EstimatedSize()
+			}
+		}
+		return nil
+	})
+}
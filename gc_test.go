@@ -0,0 +1,231 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestGC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, user := range users[:3] {
+		if err := c.Delete(user.ID); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Simulates the references of a document lost before the indexes were updated
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		ghost := newRefs()
+		ghost.ObjectID = "ghost"
+		ghost.ObjectHashID = buildID("ghost")
+		if err := tx.Bucket([]byte("refs")).Put(ghost.IDasBytes(), ghost.asBytes()); err != nil {
+			return err
+		}
+
+		emailIndex := c.getIndex("email")
+		indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte("email"))
+		key := emailIndex.storageKey([]byte(users[5].Email))
+		ids := []string{}
+		json.Unmarshal(indexBucket.Get(key), &ids)
+		idsAsBytes, _ := json.Marshal(append(ids, "ghost"))
+		return indexBucket.Put(key, idsAsBytes)
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, gcErr := db.GC(ctx, true)
+	if gcErr != nil {
+		t.Error(gcErr)
+		return
+	}
+	// The deletes keep the references of the deleted documents
+	if report.StaleRefs != 4 || report.StaleIndexIDs != 1 || report.Tombstones != 3 || report.ReclaimableBytes == 0 {
+		t.Errorf("unexpected dry run report %+v", report)
+		return
+	}
+
+	// The dry run does not change anything
+	report, _ = db.GC(ctx, true)
+	if report.StaleRefs != 4 || report.StaleIndexIDs != 1 {
+		t.Errorf("the dry run has cleaned: %+v", report)
+		return
+	}
+
+	if _, err := db.GC(ctx, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, _ = db.GC(ctx, true)
+	if report.StaleRefs != 0 || report.StaleIndexIDs != 0 {
+		t.Errorf("the references are not cleaned: %+v", report)
+		return
+	}
+
+	verifyReport, _ := c.VerifyIndexes()
+	if !verifyReport.OK() {
+		t.Errorf("the indexes are not consistent: %+v", verifyReport)
+		return
+	}
+
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[5].Email)))
+	if queryErr != nil || response.Len() != 1 {
+		t.Errorf("the document is not found anymore: %v", queryErr)
+		return
+	}
+}
+
+func TestGCIndexFileAndHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.HistoryRetention = time.Hour
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)
+	for _, dataSet := range [][]byte{dataSet1, dataSet2, dataSet3} {
+		user := unmarshalDataSet(dataSet)[0]
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The versions are older than the retention
+	db.options.HistoryRetention = time.Nanosecond
+	report, err := db.GC(ctx, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.PurgedVersions != 2 {
+		t.Errorf("expected 2 purged versions but had %d", report.PurgedVersions)
+		return
+	}
+	for _, suffix := range []string{".compact", ".old"} {
+		if _, err := os.Stat(c.db.Path() + suffix); !os.IsNotExist(err) {
+			t.Errorf("the %s index file is not removed", suffix)
+			return
+		}
+	}
+
+	// The indexes are still usable after the rewrite of their file
+	if err := c.Put(users[1].ID, users[1]); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, user := range []*User{unmarshalDataSet(dataSet3)[0], users[1]} {
+		response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(user.Email)))
+		if queryErr != nil || response.Len() != 1 {
+			t.Errorf("%q is not found after the GC: %v", user.Email, queryErr)
+			return
+		}
+	}
+}
+
+func TestCompactIndexFileWithQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)
+	for _, user := range users[:10] {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The queries wait for the replacement of the index file
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			response, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[3].Email)))
+			if err == nil && response.Len() != 1 {
+				err = fmt.Errorf("expected 1 document but had %d", response.Len())
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := c.compactIndexFile(); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(done)
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
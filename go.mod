@@ -1,16 +1,27 @@
 module github.com/alexandrestein/gotinydb
 
+go 1.27.1
+
 require (
-	github.com/AndreasBriese/bbloom v0.0.0-20170702084017-28f7e881ca57
 	github.com/boltdb/bolt v1.3.1
 	github.com/dgraph-io/badger v1.5.3
-	github.com/dgryski/go-farm v0.0.0-20180109070241-2de33835d102
 	github.com/fatih/structs v1.0.0
-	github.com/golang/protobuf v1.1.0
-	github.com/google/btree v0.0.0-20180124185431-e89373fe6b4a
+	github.com/google/btree v1.0.0
 	github.com/minio/highwayhash v0.0.0-20180501080913-85fc8a2dacad
-	github.com/petar/GoLLRB v0.0.0-20130427215148-53be0d36a84c
-	github.com/pkg/errors v0.8.0
-	golang.org/x/net v0.0.0-20180629035331-4cb1c02c05b0
-	golang.org/x/sys v0.0.0-20180627142611-7138fd3d9dc8
+	github.com/prometheus/client_golang v0.9.0
+	golang.org/x/text v0.3.2
+)
+
+require (
+	github.com/AndreasBriese/bbloom v0.0.0-20170702084017-28f7e881ca57 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/dgryski/go-farm v0.0.0-20180109070241-2de33835d102 // indirect
+	github.com/golang/protobuf v1.3.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
+	github.com/prometheus/common v0.0.0-20181020173914-7e9e6cabbd39 // indirect
+	github.com/prometheus/procfs v0.0.0-20181204211112-1dc9a6cbc91a // indirect
+	golang.org/x/net v0.0.0-20200222125558-5a598a2470a0 // indirect
+	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae // indirect
 )
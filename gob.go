@@ -0,0 +1,63 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+func init() {
+	// The names are saved with the values so they must never change
+	gob.RegisterName("gotinydb.object", documentObject{})
+	gob.RegisterName("gotinydb.array", documentArray{})
+	gob.RegisterName("gotinydb.number", json.Number(""))
+}
+
+// NewGobCodec returns a codec saving the documents with encoding/gob.
+// Gob needs the Go types to decode, so the documents are saved as a tree
+// of their JSON values which keeps the numbers and the order of the fields.
+// Every value holds the description of the types of the tree, so this codec
+// mostly fits the documents big enough to amortize it.
+func NewGobCodec() Codec {
+	return &gobCodec{}
+}
+
+func (g *gobCodec) ID() byte {
+	return GobCodecID
+}
+
+func (g *gobCodec) Name() string {
+	return "gob"
+}
+
+func (g *gobCodec) EncodeValue(value interface{}) ([]byte, error) {
+	w := new(treeWriter)
+	if err := writeDocument(w, value); err != nil {
+		return nil, err
+	}
+	return g.encodeTree(w.root)
+}
+
+func (g *gobCodec) Encode(content []byte) ([]byte, error) {
+	doc, err := readDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	return g.encodeTree(doc)
+}
+
+func (g *gobCodec) encodeTree(doc interface{}) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buffer).Encode(&gobDocument{Value: doc}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (g *gobCodec) Decode(encoded []byte) ([]byte, error) {
+	doc := new(gobDocument)
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(doc); err != nil {
+		return nil, err
+	}
+	return appendDocumentJSON(nil, doc.Value), nil
+}
@@ -0,0 +1,138 @@
+package gotinydb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// hashShardSeparator separates the name of the collection and the number of the shard
+const hashShardSeparator = "#"
+
+// UseHashSharded builds or gets a collection split into the given number of shards
+// by the hash of the IDs. Every shard is a collection with its own index file, named
+// after the collection and its number, like "users#0". The collection with the given
+// name saves the number of shards and the index definitions set to the shards.
+// The number can't be changed once the collection is built, ErrShardCount is returned.
+// The shards are not returned by DB.Use, which refuses the names holding "#".
+func (d *DB) UseHashSharded(name string, shards int) (*HashShardedCollection, error) {
+	if shards <= 0 {
+		return nil, fmt.Errorf("the number of shards must be positive but is %d", shards)
+	}
+
+	base, err := d.Use(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = base.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("config"))
+		saved := bucket.Get([]byte("hashShards"))
+		if saved == nil {
+			return bucket.Put([]byte("hashShards"), []byte(strconv.Itoa(shards)))
+		}
+		if string(saved) != strconv.Itoa(shards) {
+			return ErrShardCount
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &HashShardedCollection{
+		db:     d,
+		name:   name,
+		shards: make([]*Collection, shards),
+	}
+	for i := range s.shards {
+		shard, err := d.use(name+hashShardSeparator+strconv.Itoa(i), "")
+		if err != nil {
+			return nil, err
+		}
+		// The new shards get the indexes of the base collection
+		for _, index := range base.indexes {
+			if shard.getIndex(index.Name) != nil {
+				continue
+			}
+			if err := shard.SetIndexWithOptions(index.Name, index.Type, index.getOptions(), index.Selector...); err != nil {
+				return nil, err
+			}
+		}
+		s.shards[i] = shard
+	}
+	return s, nil
+}
+
+// SetIndex sets the index in all the shards
+func (s *HashShardedCollection) SetIndex(name string, t IndexType, selector ...string) error {
+	return s.SetIndexWithOptions(name, t, nil, selector...)
+}
+
+// SetIndexWithOptions does the same as SetIndex with the options of the index
+func (s *HashShardedCollection) SetIndexWithOptions(name string, t IndexType, options *IndexOptions, selector ...string) error {
+	base, err := s.db.Use(s.name)
+	if err != nil {
+		return err
+	}
+	if err := base.SetIndexWithOptions(name, t, options, selector...); err != nil {
+		return err
+	}
+	for _, shard := range s.shards {
+		if err := shard.SetIndexWithOptions(name, t, options, selector...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put saves the content into the shard of the ID
+func (s *HashShardedCollection) Put(id string, content interface{}) error {
+	return s.Shard(id).Put(id, content)
+}
+
+// Get decodes the content of the ID from its shard into dest
+func (s *HashShardedCollection) Get(id string, dest interface{}) error {
+	return s.Shard(id).Get(id, dest)
+}
+
+// GetRaw returns the content of the ID from its shard
+func (s *HashShardedCollection) GetRaw(id string) ([]byte, error) {
+	return s.Shard(id).GetRaw(id)
+}
+
+// Delete removes the ID from its shard
+func (s *HashShardedCollection) Delete(id string) error {
+	return s.Shard(id).Delete(id)
+}
+
+// Query runs the query in all the shards and returns the results in the order of the query
+func (s *HashShardedCollection) Query(q *Query) (*Response, error) {
+	responses := make([]*Response, len(s.shards))
+	for i, shard := range s.shards {
+		response, err := shard.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = response
+	}
+	return mergeResponses(q, responses...), nil
+}
+
+// Shard returns the collection which saves the ID
+func (s *HashShardedCollection) Shard(id string) *Collection {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return s.shards[hash.Sum32()%uint32(len(s.shards))]
+}
+
+// Shards returns the names of the shards from the first to the last
+func (s *HashShardedCollection) Shards() []string {
+	ret := make([]string, len(s.shards))
+	for i, shard := range s.shards {
+		ret[i] = shard.name
+	}
+	return ret
+}
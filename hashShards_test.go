@@ -0,0 +1,103 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestHashShardedCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	s, err := db.UseHashSharded("users", 4)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := s.SetIndex("age", IntIndex, "Age"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:40]
+	for _, user := range users {
+		if err := s.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, c := range s.shards {
+		if count, _ := c.Count(); count == 0 || count == len(users) {
+			t.Errorf("the documents are not split, %s has %d", c.name, count)
+			return
+		}
+	}
+	// The shards are only written through the sharded collection
+	if _, err := db.Use(s.Shards()[0]); err != ErrCollectionName {
+		t.Errorf("expected %v but had %v", ErrCollectionName, err)
+		return
+	}
+
+	user := new(User)
+	if err := s.Get(users[3].ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Email != users[3].Email {
+		t.Errorf("expected %v but had %v", users[3], user)
+		return
+	}
+
+	// The responses of the shards are merged in the order of the query
+	q := NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(0)).EqualWanted()).SetOrder(true, "Age")
+	response, queryErr := s.Query(q)
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if response.Len() != len(users) {
+		t.Errorf("expected %d results but had %d", len(users), response.Len())
+		return
+	}
+	previousAge := uint(0)
+	for _, id, _ := response.First(); id != ""; _, id, _ = response.Next() {
+		user := new(User)
+		if err := s.Get(id, user); err != nil {
+			t.Error(err)
+			return
+		}
+		if user.Age < previousAge {
+			t.Errorf("the results are not ordered by age")
+			return
+		}
+		previousAge = user.Age
+	}
+
+	if err := s.Delete(users[3].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := s.Get(users[3].ID, nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	if _, err := db.UseHashSharded("users", 4); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.UseHashSharded("users", 8); err != ErrShardCount {
+		t.Errorf("expected %v but had %v", ErrShardCount, err)
+		return
+	}
+}
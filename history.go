@@ -0,0 +1,558 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// SetHistoryDepth defines the number of previous versions of the documents kept for
+// Rollback and History. Zero disables the versioning of the collection, the previous
+// versions are then discarded by the writes. The depth can't be bigger than the
+// HistoryDepth of the database options and a negative depth uses it again.
+// The setting is saved with the collection.
+func (c *Collection) SetHistoryDepth(depth int) error {
+	if depth > c.options.historyDepth() {
+		return fmt.Errorf("the history depth can't be bigger than the depth of the database %d", c.options.historyDepth())
+	}
+	if depth < 0 {
+		depth = -1
+	}
+
+	if err := c.updateIndex(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("config")).Put([]byte("historyDepth"), []byte(strconv.Itoa(depth)))
+	}); err != nil {
+		return err
+	}
+
+	c.historyDepthMutex.Lock()
+	c.historyDepth = depth
+	c.historyDepthMutex.Unlock()
+	return nil
+}
+
+// HistoryDepth returns the number of previous versions of the documents kept by the collection
+func (c *Collection) HistoryDepth() int {
+	c.historyDepthMutex.RLock()
+	defer c.historyDepthMutex.RUnlock()
+
+	if c.historyDepth < 0 || c.historyDepth > c.options.historyDepth() {
+		return c.options.historyDepth()
+	}
+	return c.historyDepth
+}
+
+// historyDepth returns the HistoryDepth of the options, DefaultHistoryDepth if it is not set
+func (o *Options) historyDepth() int {
+	if o.HistoryDepth == 0 {
+		return DefaultHistoryDepth
+	}
+	if o.HistoryDepth < 0 {
+		return 0
+	}
+	return o.HistoryDepth
+}
+
+func (c *Collection) loadHistoryDepth() {
+	depth := -1
+	c.viewIndex(func(tx *bolt.Tx) error {
+		if saved, err := strconv.Atoi(string(tx.Bucket([]byte("config")).Get([]byte("historyDepth")))); err == nil {
+			depth = saved
+		}
+		return nil
+	})
+
+	c.historyDepthMutex.Lock()
+	c.historyDepth = depth
+	c.historyDepthMutex.Unlock()
+}
+
+// PurgeHistory removes the previous versions of the documents written before olderThan
+// and returns the number of removed versions. See PurgeDocumentHistory.
+func (c *Collection) PurgeHistory(olderThan time.Time) (purged int, _ error) {
+	ids, err := c.versionedIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		n, err := c.PurgeDocumentHistory(id, olderThan)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// PurgeDocumentHistory removes the previous versions of the document once they are all
+// written before olderThan and returns the number of removed versions. The versions
+// without time, saved before the times were saved, are older than any time.
+// The documents with a TTL and the deleted documents are not purged.
+//
+// The store keeps one write of a key by transaction, so the versions can only be
+// removed by writing the current version again with the discard of the earlier ones.
+// The history is then not cut while a previous version is newer than olderThan.
+// The content and the time of the current version don't change but its Version does.
+// Nothing is purged if the document is updated meanwhile. The space is reclaimed by
+// the next compaction of the store, see DB.GC.
+func (c *Collection) PurgeDocumentHistory(id string, olderThan time.Time) (int, error) {
+	if c.IsWriteOnce() {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return 0, err
+	}
+	c.touch()
+
+	storeID := c.buildStoreID(id)
+	versions, err := c.getStoredVersions(storeID)
+	if err != nil || len(versions) <= 1 {
+		return 0, err
+	}
+
+	// Only the current version must be kept, a deleted document keeps its last content too
+	if kept := keptVersions(versions, olderThan); len(kept) != 1 {
+		return 0, nil
+	}
+
+	// The conditional writes don't see an other version between their check and their write
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	current := versions[0]
+	err = c.store.Update(func(txn *badger.Txn) error {
+		if !c.isCurrentVersion(txn, storeID, current) {
+			return ErrVersionMismatch
+		}
+		// The saved value is written as it is, with its header and its time
+		return txn.SetWithDiscard(storeID, current.value, current.meta)
+	})
+	if err == ErrVersionMismatch || err == badger.ErrConflict {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	// The deletions before the current version are not needed by GetAt anymore
+	if err := c.removeDeletions(id, current.time); err != nil {
+		return 0, err
+	}
+	return len(versions) - 1, nil
+}
+
+// historyRetentionLoop purges the versions older than the HistoryRetention of the
+// options with the workers
+func (d *DB) historyRetentionLoop() {
+	interval := d.options.HistoryRetentionCheckInterval
+	if interval <= 0 {
+		interval = DefaultHistoryRetentionCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if d.closing {
+				return
+			}
+			olderThan := now.Add(-d.options.HistoryRetention)
+			for _, c := range d.getCollections() {
+				if c == nil {
+					continue
+				}
+				c := c
+				if err := d.workers.submit(d.ctx, func() {
+					if _, err := c.PurgeHistory(olderThan); err != nil {
+						d.options.log(LogError, "history purge failed", "collection", c.name, "error", err)
+					}
+				}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// GetAt returns the content of the document as it was at the given time.
+// The versions kept by the history are read and nothing is changed.
+// ErrNotFound is returned if the document was not saved or deleted at this time
+// or if its version is not kept anymore.
+func (c *Collection) GetAt(id string, at time.Time) ([]byte, error) {
+	c.touch()
+
+	storeID := c.buildStoreID(id)
+	versions, err := c.getStoredVersions(storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := c.contentAt(id, versions, at)
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return nil, ErrNotFound
+	}
+	return content, nil
+}
+
+// recordDeletion saves the time of the deletion of the ID for GetAt
+func recordDeletion(tx *bolt.Tx, id string) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("deletions"))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(deletionKey(id, time.Now()), nil)
+}
+
+// deletionKey orders the deletions of the ID by time
+func deletionKey(id string, t time.Time) []byte {
+	key := buildBytesID(id)
+	timeAsBytes := make([]byte, 8)
+	// The zero time is before all the deletions
+	if t.After(time.Unix(0, 0)) {
+		binary.BigEndian.PutUint64(timeAsBytes, uint64(t.UnixNano()))
+	}
+	return append(key, timeAsBytes...)
+}
+
+// deletedBetween returns true if the ID was deleted after from and up to to
+func (c *Collection) deletedBetween(id string, from, to time.Time) (deleted bool) {
+	c.viewIndex(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("deletions"))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		key, _ := cursor.Seek(deletionKey(id, from.Add(1)))
+		deleted = key != nil && bytes.Compare(key, deletionKey(id, to)) <= 0
+		return nil
+	})
+	return
+}
+
+// removeDeletions removes the deletions of the ID saved before the given time
+func (c *Collection) removeDeletions(id string, before time.Time) error {
+	return c.updateIndex(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("deletions"))
+		if bucket == nil {
+			return nil
+		}
+
+		prefix := buildBytesID(id)
+		end := deletionKey(id, before)
+		toDelete := [][]byte{}
+		cursor := bucket.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix) && bytes.Compare(key, end) < 0; key, _ = cursor.Next() {
+			toDelete = append(toDelete, key)
+		}
+		for _, key := range toDelete {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RollbackAll reverts all the documents of the collection and their indexes to their
+// state at the given time with the versions kept by the history. The documents saved
+// after are deleted and the deleted ones are saved again. The writes are new versions
+// like the ones of Rollback and they are committed in one batch, so nothing is changed
+// if one of them fails. A rollback of too many documents fails with the transaction
+// limit of the store.
+// If the history does not have the state of some documents at this time nothing is
+// changed and ErrHistoryTooShort is returned.
+func (c *Collection) RollbackAll(to time.Time) error {
+	if c.IsWriteOnce() {
+		return ErrWriteOnce
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	ids, err := c.versionedIDs()
+	if err != nil {
+		return err
+	}
+
+	// All the changes are known before any write
+	batch := c.NewBatch()
+	for _, id := range ids {
+		versions, err := c.getStoredVersions(c.buildStoreID(id))
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		versions = c.versionsInDepth(versions)
+		if !c.historyCovers(versions, to) {
+			return ErrHistoryTooShort
+		}
+
+		var current []byte
+		if !versions[0].deleted {
+			if current, err = c.getAndCheckContent(c.buildStoreID(id), versions[0].meta, versions[0].value); err != nil {
+				return err
+			}
+		}
+		var content []byte
+		bin := false
+		if version := c.versionAt(id, versions, to); version != nil {
+			if content, err = c.getAndCheckContent(c.buildStoreID(id), version.meta, version.value); err != nil {
+				return err
+			}
+			bin = isBinaryMeta(version.meta)
+		}
+
+		switch {
+		case bytes.Equal(content, current):
+			continue
+		case content == nil:
+			err = batch.Delete(id)
+		case bin:
+			err = batch.Put(id, content)
+		default:
+			err = batch.Put(id, json.RawMessage(content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+	return batch.Write()
+}
+
+// contentAt returns the content of the ID at the given time from its versions,
+// nil if it was not saved or deleted
+func (c *Collection) contentAt(id string, versions []*storedVersion, at time.Time) ([]byte, error) {
+	version := c.versionAt(id, versions, at)
+	if version == nil {
+		return nil, nil
+	}
+	return c.getAndCheckContent(c.buildStoreID(id), version.meta, version.value)
+}
+
+// versionAt returns the version of the ID at the given time, nil if it was not saved or deleted
+func (c *Collection) versionAt(id string, versions []*storedVersion, at time.Time) *storedVersion {
+	for _, version := range versions {
+		if version.deleted || version.time.After(at) {
+			continue
+		}
+
+		if c.deletedBetween(id, version.time, at) {
+			return nil
+		}
+		return version
+	}
+	return nil
+}
+
+// historyCovers returns true if the versions give the state of the document at the given time.
+// It is not known if the oldest version is after it and the earlier versions have been removed.
+func (c *Collection) historyCovers(versions []*storedVersion, at time.Time) bool {
+	oldest := versions[len(versions)-1]
+	if !oldest.time.After(at) {
+		return true
+	}
+	return !oldest.discard && len(versions) <= c.HistoryDepth()
+}
+
+// versionsInDepth returns the versions within the history depth of the collection.
+// Badger still returns the older versions until they are removed by a compaction.
+func (c *Collection) versionsInDepth(versions []*storedVersion) []*storedVersion {
+	if maxVersions := c.HistoryDepth() + 1; len(versions) > maxVersions {
+		return versions[:maxVersions]
+	}
+	return versions
+}
+
+// getStoredVersions returns the versions of the key from the newest
+func (c *Collection) getStoredVersions(storeID []byte) (versions []*storedVersion, _ error) {
+	err := c.store.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iterator.Close()
+
+		for iterator.Seek(storeID); iterator.Valid(); iterator.Next() {
+			item := iterator.Item()
+			if !bytes.Equal(storeID, item.Key()) {
+				return nil
+			}
+			// The documents with a TTL are not purged
+			if item.ExpiresAt() != 0 {
+				versions = nil
+				return nil
+			}
+
+			version := &storedVersion{
+				meta:    item.UserMeta(),
+				deleted: item.IsDeletedOrExpired(),
+				discard: item.DiscardEarlierVersions(),
+			}
+			if !version.deleted {
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				version.value = value
+				clear, err := c.openValue(item.Key(), value)
+				if err != nil {
+					return err
+				}
+				version.time, _, _ = valueHeader(version.meta, clear)
+			}
+			versions = append(versions, version)
+
+			if item.DiscardEarlierVersions() {
+				return nil
+			}
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// isCurrentVersion returns true if the last version of the key is the given one
+func (c *Collection) isCurrentVersion(txn *badger.Txn, storeID []byte, version *storedVersion) bool {
+	iterator := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+	defer iterator.Close()
+
+	iterator.Seek(storeID)
+	if !iterator.Valid() || !bytes.Equal(storeID, iterator.Item().Key()) {
+		return false
+	}
+
+	item := iterator.Item()
+	if item.IsDeletedOrExpired() || version.deleted {
+		return item.IsDeletedOrExpired() == version.deleted
+	}
+	value, err := item.Value()
+	if err != nil {
+		return false
+	}
+	return item.UserMeta() == version.meta && bytes.Equal(value, version.value)
+}
+
+// keptVersions returns the versions kept by a purge: the current one, the ones written
+// after olderThan and the last content. The oldest kept version is not a deletion.
+func keptVersions(versions []*storedVersion, olderThan time.Time) []*storedVersion {
+	kept := []*storedVersion{versions[0]}
+	hasContent := !versions[0].deleted
+	for _, version := range versions[1:] {
+		if !version.deleted && hasContent && version.time.Before(olderThan) {
+			break
+		}
+		kept = append(kept, version)
+		hasContent = hasContent || !version.deleted
+	}
+
+	for len(kept) > 1 && kept[len(kept)-1].deleted {
+		kept = kept[:len(kept)-1]
+	}
+	// Only deletions can't be purged
+	if kept[len(kept)-1].deleted {
+		return versions
+	}
+	return kept
+}
+
+// versionedIDs returns the IDs of the collection with at least one version, deleted or not
+func (c *Collection) versionedIDs() (ids []string, _ error) {
+	err := c.store.View(func(txn *badger.Txn) error {
+		iterator := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iterator.Close()
+
+		prefix := []byte(c.id[:4] + "_")
+		var lastKey []byte
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+			key := iterator.Item().Key()
+			if bytes.Equal(key, lastKey) {
+				continue
+			}
+			lastKey = iterator.Item().KeyCopy(lastKey)
+			ids = append(ids, string(key[len(prefix):]))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// addValueHeader returns the value saved with the time of the write and its metadata
+// and the meta of the value
+func addValueHeader(t time.Time, metadata map[string]string, value []byte, codecID byte) ([]byte, byte) {
+	var metadataAsBytes []byte
+	if len(metadata) != 0 {
+		metadataAsBytes, _ = json.Marshal(metadata)
+	}
+
+	header := make([]byte, 8, 8+binary.MaxVarintLen64+len(metadataAsBytes)+len(value))
+	binary.BigEndian.PutUint64(header, uint64(t.UnixNano()))
+	header = append(header, uvarint(uint64(len(metadataAsBytes)))...)
+	header = append(header, metadataAsBytes...)
+	return append(header, value...), codecID | timedValueFlag
+}
+
+// splitValueHeader returns the ID of the codec and the value without its header
+func splitValueHeader(meta byte, value []byte) (codecID byte, _ []byte) {
+	codecID = codecOfMeta(meta)
+	if meta&timedValueFlag == 0 {
+		return codecID, value
+	}
+
+	_, _, size := valueHeader(meta, value)
+	return codecID, value[size:]
+}
+
+// codecOfMeta returns the ID of the codec saved in the meta of a value
+func codecOfMeta(meta byte) byte {
+	return meta &^ (timedValueFlag | binaryValueFlag)
+}
+
+// isBinaryMeta returns true if the value of the meta has been saved from bytes
+func isBinaryMeta(meta byte) bool {
+	return meta&binaryValueFlag != 0
+}
+
+// valueHeader returns the time of the write and the metadata saved with the value
+// and the size of its header. The time is zero if the value has no header.
+func valueHeader(meta byte, value []byte) (t time.Time, metadata map[string]string, size int) {
+	if meta&timedValueFlag == 0 || len(value) < 8 {
+		return time.Time{}, nil, 0
+	}
+
+	t = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+	metadataLength, n := binary.Uvarint(value[8:])
+	if n <= 0 || uint64(len(value)-8-n) < metadataLength {
+		return t, nil, 8
+	}
+	size = 8 + n + int(metadataLength)
+	if metadataLength != 0 {
+		json.Unmarshal(value[8+n:size], &metadata)
+	}
+	return t, metadata, size
+}
@@ -0,0 +1,52 @@
+package gotinydb
+
+// SetHooks defines the functions called around the writes of the collection,
+// including the writes of the batches and of the maintenance.
+// The hooks are not saved and nil removes them.
+func (c *Collection) SetHooks(hooks *Hooks) {
+	if hooks == nil {
+		hooks = new(Hooks)
+	}
+
+	c.hooksMutex.Lock()
+	c.hooks = hooks
+	c.hooksMutex.Unlock()
+}
+
+// getHooks never returns nil
+func (c *Collection) getHooks() *Hooks {
+	c.hooksMutex.RLock()
+	defer c.hooksMutex.RUnlock()
+
+	if c.hooks == nil {
+		return new(Hooks)
+	}
+	return c.hooks
+}
+
+// beforePut returns the content to save
+func (h *Hooks) beforePut(id string, content interface{}) (interface{}, error) {
+	if h.BeforePut == nil {
+		return content, nil
+	}
+	return h.BeforePut(id, content)
+}
+
+func (h *Hooks) afterPut(id string, contentAsBytes []byte) {
+	if h.AfterPut != nil {
+		h.AfterPut(id, contentAsBytes)
+	}
+}
+
+func (h *Hooks) beforeDelete(id string) error {
+	if h.BeforeDelete == nil {
+		return nil
+	}
+	return h.BeforeDelete(id)
+}
+
+func (h *Hooks) afterDelete(id string) {
+	if h.AfterDelete != nil {
+		h.AfterDelete(id)
+	}
+}
@@ -0,0 +1,101 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	afterPut := []string{}
+	afterDelete := []string{}
+	c.SetHooks(&Hooks{
+		BeforePut: func(id string, content interface{}) (interface{}, error) {
+			doc, ok := content.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q is not a document", id)
+			}
+			doc["UpdatedAt"] = "now"
+			return doc, nil
+		},
+		AfterPut: func(id string, contentAsBytes []byte) {
+			afterPut = append(afterPut, id+" "+string(contentAsBytes))
+		},
+		BeforeDelete: func(id string) error {
+			if id == "locked" {
+				return fmt.Errorf("%q can't be deleted", id)
+			}
+			return nil
+		},
+		AfterDelete: func(id string) {
+			afterDelete = append(afterDelete, id)
+		},
+	})
+
+	if err := c.Put("locked", map[string]interface{}{"V": 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Put("bin", []byte("bin")); err == nil {
+		t.Errorf("the hook should reject the binary content")
+		return
+	}
+	batch := c.NewBatch()
+	if err := batch.Put("doc", map[string]interface{}{"V": 2}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := batch.Delete("locked"); err == nil {
+		t.Errorf("the hook should reject the delete")
+		return
+	}
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Delete("locked"); err == nil {
+		t.Errorf("the hook should reject the delete")
+		return
+	}
+	if err := c.Delete("doc"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedPut := []string{`locked {"UpdatedAt":"now","V":1}`, `doc {"UpdatedAt":"now","V":2}`}
+	if fmt.Sprint(afterPut) != fmt.Sprint(expectedPut) {
+		t.Errorf("expected %v but had %v", expectedPut, afterPut)
+		return
+	}
+	if fmt.Sprint(afterDelete) != "[doc]" {
+		t.Errorf("expected [doc] but had %v", afterDelete)
+		return
+	}
+
+	content, _ := c.GetRaw("locked")
+	if string(content) != `{"UpdatedAt":"now","V":1}` {
+		t.Errorf("the content is not modified by the hook: %s", string(content))
+		return
+	}
+
+	c.SetHooks(nil)
+	if err := c.Put("bin", []byte("bin")); err != nil {
+		t.Error(err)
+		return
+	}
+}
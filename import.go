@@ -0,0 +1,252 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Import saves the documents read from r in the given format, the one written by Export.
+//
+// ImportJSONLines reads one {"ID": "id", "Content": {...}} object per line, or the
+// documents themselves if the IDSelector of the options is set.
+// ImportCSV reads a header and one row by document. The columns of the options give
+// the selectors of the values by column name, the other columns are saved as top-level fields.
+// The "_id" column is the ID of the document if the IDSelector is not set.
+// The values which are valid JSON, like the numbers or the quoted strings, are saved decoded
+// and the empty ones are not saved, so the CSV of Export is read back with the same values.
+//
+// The documents are saved and indexed by batches of ImportOptions.BatchSize.
+// If the import fails, the documents of the previous batches are saved and the report
+// tells how many there are.
+func (c *Collection) Import(ctx context.Context, r io.Reader, format ImportFormat, opts ImportOptions) (*ImportReport, error) {
+	var next func() (id string, content interface{}, err error)
+	switch format {
+	case ImportJSONLines:
+		next = c.importJSONLines(r, opts.IDSelector)
+	case ImportCSV:
+		var err error
+		next, err = c.importCSV(r, opts)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnknownImportFormat
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = ImportBatchSize
+	}
+
+	report := new(ImportReport)
+	pending := []*importedDocument{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		id, content, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return report, err
+		}
+		if id == "" {
+			return report, ErrEmptyID
+		}
+
+		report.Read++
+		pending = append(pending, &importedDocument{id: id, content: content})
+		if len(pending) >= batchSize {
+			if err := c.importBatch(pending, opts.Conflict, report); err != nil {
+				return report, err
+			}
+			pending = pending[:0]
+		}
+	}
+
+	return report, c.importBatch(pending, opts.Conflict, report)
+}
+
+// importBatch saves the documents, in smaller batches if they do not fit into one transaction
+func (c *Collection) importBatch(documents []*importedDocument, conflict ImportConflict, report *ImportReport) error {
+	if len(documents) == 0 {
+		return nil
+	}
+
+	toWrite, err := c.importConflicts(documents, conflict)
+	if err != nil {
+		return err
+	}
+	report.Skipped += len(documents) - len(toWrite)
+
+	batch := c.NewBatch()
+	for _, document := range toWrite {
+		if err := batch.Put(document.id, document.content); err != nil {
+			return err
+		}
+	}
+
+	err = batch.Write()
+	if err == badger.ErrTxnTooBig && len(toWrite) > 1 {
+		half := len(toWrite) / 2
+		if err := c.importBatch(toWrite[:half], ImportOverwrite, report); err != nil {
+			return err
+		}
+		return c.importBatch(toWrite[half:], ImportOverwrite, report)
+	} else if err != nil {
+		return err
+	}
+
+	report.Written += len(toWrite)
+	return nil
+}
+
+// importConflicts returns the documents to write with the given policy
+func (c *Collection) importConflicts(documents []*importedDocument, conflict ImportConflict) (toWrite []*importedDocument, _ error) {
+	if conflict == ImportOverwrite {
+		return documents, nil
+	}
+
+	seen := map[string]bool{}
+	err := c.store.View(func(txn *badger.Txn) error {
+		for _, document := range documents {
+			err := checkAbsent(txn, c.buildStoreID(document.id))
+			if err == nil && seen[document.id] {
+				err = ErrIDExists
+			}
+			seen[document.id] = true
+
+			if err == ErrIDExists && conflict == ImportSkip {
+				continue
+			} else if err != nil {
+				return err
+			}
+			toWrite = append(toWrite, document)
+		}
+		return nil
+	})
+	return toWrite, err
+}
+
+// importJSONLines returns the function reading the documents of the JSON lines one by one
+func (c *Collection) importJSONLines(r io.Reader, idSelector []string) func() (string, interface{}, error) {
+	decoder := json.NewDecoder(r)
+	return func() (string, interface{}, error) {
+		if !decoder.More() {
+			return "", nil, io.EOF
+		}
+
+		if len(idSelector) == 0 {
+			record := new(seedRecord)
+			if err := decoder.Decode(record); err != nil {
+				return "", nil, err
+			}
+			return record.ID, record.Content, nil
+		}
+
+		content := json.RawMessage{}
+		if err := decoder.Decode(&content); err != nil {
+			return "", nil, err
+		}
+		object, err := decodeStored(content)
+		if err != nil {
+			return "", nil, err
+		}
+		return importID(object, idSelector), content, nil
+	}
+}
+
+// importCSV reads the header and returns the function reading the rows one by one
+func (c *Collection) importCSV(r io.Reader, opts ImportOptions) (func() (string, interface{}, error), error) {
+	csvReader := csv.NewReader(r)
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	selectors := make([][]string, len(header))
+	for i, name := range header {
+		selectors[i] = []string{name}
+		for _, column := range opts.Columns {
+			if column.Name == name {
+				selectors[i] = column.Selector
+				break
+			}
+		}
+	}
+
+	return func() (string, interface{}, error) {
+		row, err := csvReader.Read()
+		if err != nil {
+			return "", nil, err
+		}
+
+		id := ""
+		object := map[string]interface{}{}
+		for i, cell := range row {
+			if header[i] == "_id" && len(opts.IDSelector) == 0 {
+				id = cell
+				continue
+			}
+			if cell == "" {
+				continue
+			}
+			setValueOfSelector(object, selectors[i], importCSVValue(cell))
+		}
+
+		if len(opts.IDSelector) != 0 {
+			id = importID(object, opts.IDSelector)
+		}
+		return id, object, nil
+	}, nil
+}
+
+// importCSVValue returns the value of the cell decoded if it is JSON
+func importCSVValue(cell string) interface{} {
+	if !json.Valid([]byte(cell)) {
+		return cell
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(cell)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return cell
+	}
+	return value
+}
+
+// importID returns the ID of the document at the given selector
+func importID(object map[string]interface{}, selector []string) string {
+	value, ok := getValueFromSelector(object, selector)
+	if !ok || value == nil {
+		return ""
+	}
+	if asString, ok := value.(string); ok {
+		return asString
+	}
+	return fmt.Sprint(value)
+}
+
+// setValueOfSelector sets the value into the object, the missing objects of the path are added
+func setValueOfSelector(object map[string]interface{}, selector []string, value interface{}) {
+	if len(selector) == 0 {
+		return
+	}
+	for _, field := range selector[:len(selector)-1] {
+		child, ok := object[field].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			object[field] = child
+		}
+		object = child
+	}
+	object[selector[len(selector)-1]] = value
+}
@@ -0,0 +1,152 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	users := unmarshalDataSet(dataSet1)[:20]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The export is imported into an other collection with its indexes
+	exported := bytes.NewBuffer(nil)
+	if err := c.Export(ctx, exported, ExportJSONLines, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	other, _ := db.Use("other")
+	if err := setIndexes(other); err != nil {
+		t.Error(err)
+		return
+	}
+	report, err := other.Import(ctx, exported, ImportJSONLines, ImportOptions{BatchSize: 7})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.Read != len(users) || report.Written != len(users) {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+	response, err := other.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[5].Email)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if id, _ := response.One(new(User)); id != users[5].ID {
+		t.Errorf("expected %q but had %q", users[5].ID, id)
+		return
+	}
+
+	// The CSV with the ID in a column and the selectors of the columns
+	csvContent := strings.Join([]string{
+		"mail,age,city",
+		users[0].Email + ",99,Paris",
+		"new@mail.com,30,",
+	}, "\n")
+	options := ImportOptions{
+		IDSelector: []string{"Email"},
+		Columns: []*Column{
+			{Name: "mail", Selector: []string{"Email"}},
+			{Name: "age", Selector: []string{"Age"}},
+			{Name: "city", Selector: []string{"Address", "City"}},
+		},
+		Conflict: ImportSkip,
+	}
+	report, err = c.Import(ctx, strings.NewReader(csvContent), ImportCSV, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.Read != 2 || report.Written != 2 || report.Skipped != 0 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+	imported := new(User)
+	if err := c.Get(users[0].Email, imported); err != nil {
+		t.Error(err)
+		return
+	}
+	if imported.Age != 99 || imported.Address == nil || imported.Address.City != "Paris" {
+		t.Errorf("unexpected imported user %+v", imported)
+		return
+	}
+
+	// The saved documents are kept
+	report, err = c.Import(ctx, strings.NewReader(csvContent), ImportCSV, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.Written != 0 || report.Skipped != 2 {
+		t.Errorf("unexpected report %+v", report)
+		return
+	}
+	options.Conflict = ImportFail
+	if _, err := c.Import(ctx, strings.NewReader(csvContent), ImportCSV, options); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+
+	// The CSV export is imported with the same values
+	typed, _ := db.Use("typed")
+	values := map[string]interface{}{"number": "42", "bool": "true", "null": "null", "empty": "", "quoted": `"a"`, "text": "a,b", "int": 42}
+	if err := typed.Put("values", values); err != nil {
+		t.Error(err)
+		return
+	}
+	columns := []*Column{}
+	for name := range values {
+		columns = append(columns, &Column{Name: name, Selector: []string{name}})
+	}
+	csvExport := bytes.NewBuffer(nil)
+	if err := typed.Export(ctx, csvExport, ExportCSV, nil, columns...); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := typed.Delete("values"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := typed.Import(ctx, csvExport, ImportCSV, ImportOptions{}); err != nil {
+		t.Error(err)
+		return
+	}
+	reimported := map[string]interface{}{}
+	if err := typed.Get("values", &reimported); err != nil {
+		t.Error(err)
+		return
+	}
+	if fmt.Sprint(reimported) != fmt.Sprint(map[string]interface{}{"number": "42", "bool": "true", "null": "null", "empty": "", "quoted": `"a"`, "text": "a,b", "int": 42.0}) {
+		t.Errorf("unexpected imported values %v", reimported)
+		return
+	}
+
+	if _, err := c.Import(ctx, strings.NewReader(""), ImportFormat("xml"), ImportOptions{}); err != ErrUnknownImportFormat {
+		t.Errorf("expected %v but had %v", ErrUnknownImportFormat, err)
+		return
+	}
+}
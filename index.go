@@ -3,8 +3,13 @@ package gotinydb
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/fatih/structs"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 // newIndex build a new Index pointer
@@ -18,6 +23,223 @@ func newIndex(name string, t IndexType, selector ...string) *indexType {
 	return ret
 }
 
+// setOptions checks and saves the given options into the index
+func (i *indexType) setOptions(options *IndexOptions) error {
+	if options == nil {
+		return nil
+	}
+
+	if options.Collation != "" {
+		if i.Type != StringIndex {
+			return fmt.Errorf("collation is only supported by %s", StringIndex.TypeName())
+		}
+		if _, err := language.Parse(options.Collation); err != nil {
+			return err
+		}
+		i.Collation = options.Collation
+	}
+
+	if options.BlindToken {
+		if i.Type != StringIndex {
+			return fmt.Errorf("blind token is only supported by %s", StringIndex.TypeName())
+		}
+		if i.Collation != "" {
+			return fmt.Errorf("blind token can't be used with collation")
+		}
+		i.BlindToken = true
+	}
+
+	i.Descending = options.Descending
+
+	if options.MaxPostingSize < 0 {
+		return fmt.Errorf("the maximum posting size can't be negative")
+	}
+	i.MaxPostingSize = options.MaxPostingSize
+	i.Overflow = options.Overflow
+
+	return nil
+}
+
+// storageKey returns the key of the given value inside the index bucket.
+// For the descending indexes a terminator is added and the bits are inverted.
+// The terminator makes a value sorted after the longer values it prefixes.
+// The keys can't be empty, so for the ascending indexes a zero byte is added to the
+// values made only of zero bytes, the empty string included. The order is kept.
+// If the database has an EncryptionKey the key is a token of the value and the order is lost.
+func (i *indexType) storageKey(value []byte) []byte {
+	if i.cipher != nil {
+		return i.cipher.token(value)
+	}
+
+	if !i.Descending {
+		if onlyZeroBytes(value) {
+			return append(append([]byte{}, value...), 0)
+		}
+		return value
+	}
+
+	ret := make([]byte, len(value)+1)
+	for j, b := range value {
+		ret[j] = ^b
+	}
+	ret[len(value)] = 0xff
+	return ret
+}
+
+// valueFromStorageKey is the reverse function of storageKey.
+// It can't be used if the database has an EncryptionKey.
+func (i *indexType) valueFromStorageKey(storageKey []byte) []byte {
+	if !i.Descending {
+		if len(storageKey) != 0 && onlyZeroBytes(storageKey) {
+			return storageKey[:len(storageKey)-1]
+		}
+		return storageKey
+	}
+	if len(storageKey) == 0 {
+		return nil
+	}
+
+	ret := make([]byte, len(storageKey)-1)
+	for j := range ret {
+		ret[j] = ^storageKey[j]
+	}
+	return ret
+}
+
+// onlyZeroBytes returns true if the value is empty or has only zero bytes
+func onlyZeroBytes(value []byte) bool {
+	for _, b := range value {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFilter returns an error if the filter can't be done by the index
+func (i *indexType) checkFilter(filter *Filter) error {
+	if !i.isReady() {
+		return fmt.Errorf("the extractor of the index %q is not set", i.Name)
+	}
+
+	if i.Type == HashIndex && filter.GetType() != Equal {
+		return ErrHashIndexRange
+	}
+	if i.cipher != nil && filter.GetType() != Equal {
+		return ErrEncryptedIndexRange
+	}
+
+	if !i.BlindToken {
+		return nil
+	}
+
+	if filter.GetType() != Equal {
+		return ErrBlindTokenRange
+	}
+	if i.options.BlindTokenKey == nil {
+		return ErrMissingBlindTokenKey
+	}
+	return nil
+}
+
+// matchFilter returns true if the indexed value is in the result of the filter.
+// It does the same as the query but for one value.
+func (i *indexType) matchFilter(filter *Filter, indexedValue []byte) bool {
+	return filter.match(indexedValue, i.valueToBytes)
+}
+
+// getOptions returns the options the index has been set with
+func (i *indexType) getOptions() *IndexOptions {
+	return &IndexOptions{
+		Collation:  i.Collation,
+		BlindToken: i.BlindToken,
+		Descending: i.Descending,
+
+		MaxPostingSize: i.MaxPostingSize,
+		Overflow:       i.Overflow,
+	}
+}
+
+// collate returns the sort key of the given string for the index language.
+// The collator is built at the first call.
+func (i *indexType) collate(input string) []byte {
+	i.collatorMutex.Lock()
+	defer i.collatorMutex.Unlock()
+
+	if i.collator == nil {
+		i.collator = collate.New(language.Make(i.Collation), collate.IgnoreCase)
+	}
+
+	return i.collator.KeyFromString(new(collate.Buffer), input)
+}
+
+// valueToBytes converts the filter value the same way the indexed values are
+func (i *indexType) valueToBytes(value *filterValue) []byte {
+	if i.BlindToken {
+		asString, ok := value.Value.(string)
+		if !ok || i.options.BlindTokenKey == nil {
+			return nil
+		}
+		asBytes, _ := stringToBytes(blindToken(i.options.BlindTokenKey, asString))
+		return asBytes
+	}
+
+	if i.Type == StringIndex && i.Collation != "" {
+		asString, ok := value.Value.(string)
+		if !ok {
+			return nil
+		}
+		return i.collate(asString)
+	}
+	if i.Type == HashIndex {
+		asBytes, _ := hashToBytes(value.Value)
+		return asBytes
+	}
+	return value.Bytes()
+}
+
+// applyToDocument calls the extractor of the index if any or apply otherwise
+func (i *indexType) applyToDocument(object interface{}, contentAsBytes []byte) (contentToIndex []byte, ok bool) {
+	if i.Extractor {
+		return i.applyExtractor(contentAsBytes)
+	}
+
+	// The raw JSON is indexed like the stored documents, the numbers are signed integers
+	if _, ok := object.(json.RawMessage); ok {
+		stored, _ := decodeStored(contentAsBytes)
+		candidates, ok := i.applyToStored(stored, contentAsBytes)
+		if !ok {
+			return nil, false
+		}
+		return pickCandidate(candidates, nil), true
+	}
+
+	return i.apply(object)
+}
+
+// applyExtractor calls the user function. The strings are saved in lower case like
+// the other string indexes.
+func (i *indexType) applyExtractor(contentAsBytes []byte) (contentToIndex []byte, ok bool) {
+	if i.extractor == nil {
+		return nil, false
+	}
+
+	contentToIndex, ok = i.extractor(contentAsBytes)
+	if !ok {
+		return nil, false
+	}
+
+	if i.Type == StringIndex || i.Type == HashIndex {
+		return i.testType(string(contentToIndex))
+	}
+	return contentToIndex, true
+}
+
+// isReady returns false if the index is an extractor index which has not been set since the opening
+func (i *indexType) isReady() bool {
+	return !i.Extractor || i.extractor != nil
+}
+
 // apply take the full object to add in the collection and check if is must be
 // indexed or not. If the object needs to be indexed the value to index is returned as a byte slice.
 func (i *indexType) apply(object interface{}) (contentToIndex []byte, ok bool) {
@@ -50,22 +272,126 @@ func (i *indexType) apply(object interface{}) (contentToIndex []byte, ok bool) {
 }
 
 func (i *indexType) applyToStruct(object *structs.Struct) (contentToIndex []byte, ok bool) {
-	var field *structs.Field
-	for i, fieldName := range i.Selector {
-		if i == 0 {
-			field, ok = object.FieldOk(fieldName)
-		} else {
-			field, ok = field.FieldOk(fieldName)
+	field, ok := object.FieldOk(i.Selector[0])
+	if !ok {
+		return nil, false
+	}
+	// The rest of the selector goes through the maps and the nil pointers without panicking
+	value, ok := getValueFromSelector(field.Value(), i.Selector[1:])
+	if !ok {
+		return nil, false
+	}
+	return i.testType(value)
+}
+
+// getValueFromSelector returns the value of the given selector inside maps with string keys and structs
+func getValueFromSelector(value interface{}, selector []string) (interface{}, bool) {
+	for _, fieldName := range selector {
+		reflectValue := reflect.ValueOf(value)
+		for reflectValue.Kind() == reflect.Ptr || reflectValue.Kind() == reflect.Interface {
+			if reflectValue.IsNil() {
+				return nil, false
+			}
+			reflectValue = reflectValue.Elem()
 		}
-		if !ok {
+
+		switch reflectValue.Kind() {
+		case reflect.Map:
+			if reflectValue.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			elem := reflectValue.MapIndex(reflect.ValueOf(fieldName).Convert(reflectValue.Type().Key()))
+			if !elem.IsValid() {
+				return nil, false
+			}
+			value = elem.Interface()
+		case reflect.Struct:
+			field, ok := structs.New(reflectValue.Interface()).FieldOk(fieldName)
+			if !ok {
+				return nil, false
+			}
+			value = field.Value()
+		default:
 			return nil, false
 		}
 	}
-	return i.testType(field.Value())
+	return value, true
 }
 
 func (i *indexType) applyToMap(object map[string]interface{}) (contentToIndex []byte, ok bool) {
-	var field interface{}
+	field, ok := getValueFromSelector(object, i.Selector)
+	if !ok {
+		return nil, false
+	}
+	return i.testType(field)
+}
+
+// applyToStored does the same as apply but with the document as it is decoded from the store.
+// The Go types of numbers and dates are lost by the JSON encoding, this is why
+// all the values the field could have been indexed with are returned.
+// The order is always the same, the signed representation of the numbers is the first.
+func (i *indexType) applyToStored(object map[string]interface{}, contentAsBytes []byte) (candidates [][]byte, ok bool) {
+	if i.Extractor {
+		asBytes, ok := i.applyExtractor(contentAsBytes)
+		if !ok {
+			return nil, false
+		}
+		return [][]byte{asBytes}, true
+	}
+
+	field, ok := i.getFieldFromMap(object)
+	if !ok {
+		return nil, false
+	}
+
+	switch i.Type {
+	case IntIndex:
+		asBytes, err := jsonNumberToBytes(field)
+		if err != nil {
+			return nil, false
+		}
+		return asBytes, true
+	case TimeIndex:
+		asBytes, err := jsonTimeToBytes(field)
+		if err != nil {
+			return nil, false
+		}
+		return [][]byte{asBytes}, true
+	}
+
+	asBytes, ok := i.testType(field)
+	if !ok {
+		return nil, false
+	}
+	return [][]byte{asBytes}, true
+}
+
+// pickCandidate returns the value to index among the candidates of applyToStored.
+// The previous indexed value is kept if it is one of them, so the documents saved
+// with unsigned integers are not moved. Otherwise the first candidate is used.
+func pickCandidate(candidates [][]byte, previous []byte) []byte {
+	if previous != nil && containsBytes(candidates, previous) {
+		return previous
+	}
+	return candidates[0]
+}
+
+// filterCandidate returns the candidate of applyToStored encoded like the filter values.
+// The integers are compared as signed or unsigned like the filter value is, never both,
+// otherwise the range filters would select the values out of their bounds.
+func (i *indexType) filterCandidate(filter *Filter, object map[string]interface{}, candidates [][]byte) ([]byte, bool) {
+	if i.Extractor || i.Type != IntIndex || len(filter.values) == 0 {
+		return candidates[0], true
+	}
+
+	field, ok := i.getFieldFromMap(object)
+	if !ok {
+		return nil, false
+	}
+	return filter.values[0].storedToBytes(field)
+}
+
+func (i *indexType) getFieldFromMap(object map[string]interface{}) (field interface{}, ok bool) {
 	for i, fieldName := range i.Selector {
 		if i == 0 {
 			field, ok = object[fieldName]
@@ -75,15 +401,12 @@ func (i *indexType) applyToMap(object map[string]interface{}) (contentToIndex []
 				return nil, false
 			}
 			field, ok = fieldMap[fieldName]
-			if !ok {
-				return nil, false
-			}
 		}
 		if !ok {
 			return nil, false
 		}
 	}
-	return i.testType(field)
+	return field, true
 }
 
 // doesFilterApplyToIndex only check if the filter belongs to the index
@@ -98,6 +421,10 @@ func (i *indexType) doesFilterApplyToIndex(filter *Filter) (ok bool) {
 		if value.Type == i.Type {
 			return true
 		}
+		// The hash indexes are queried with strings
+		if i.Type == HashIndex && value.Type == StringIndex {
+			return true
+		}
 	}
 
 	return false
@@ -108,10 +435,19 @@ func (i *indexType) testType(value interface{}) (contentToIndex []byte, ok bool)
 	switch i.Type {
 	case StringIndex:
 		conversionFunc = stringToBytes
+		if i.Collation != "" {
+			asString, ok := value.(string)
+			if !ok {
+				return nil, false
+			}
+			return i.collate(asString), true
+		}
 	case IntIndex:
 		conversionFunc = intToBytes
 	case TimeIndex:
 		conversionFunc = timeToBytes
+	case HashIndex:
+		conversionFunc = hashToBytes
 	default:
 		return nil, false
 	}
@@ -123,7 +459,7 @@ func (i *indexType) testType(value interface{}) (contentToIndex []byte, ok bool)
 }
 
 // query do the given filter and ad it to the tree
-func (i *indexType) query(ctx context.Context, filter *Filter, finishedChan chan *idsType) {
+func (i *indexType) query(ctx context.Context, view *SnapshotCollection, filter *Filter, finishedChan chan *idsType) {
 	done := false
 	defer func() {
 		// Make sure to reply as done
@@ -133,17 +469,21 @@ func (i *indexType) query(ctx context.Context, filter *Filter, finishedChan chan
 		}
 	}()
 
+	start := time.Now()
 	ids, _ := newIDs(ctx, filter.selectorHash, nil, nil)
 
 	switch filter.GetType() {
 	// If equal just this leave will be send
 	case Equal:
-		i.queryEqual(ctx, ids, filter)
+		i.queryEqual(ctx, view, ids, filter)
 	case Greater, Less:
-		i.queryGreaterLess(ctx, ids, filter)
+		i.queryGreaterLess(ctx, view, ids, filter)
 	case Between:
-		i.queryBetween(ctx, ids, filter)
+		i.queryBetween(ctx, view, ids, filter)
 	}
+	ids.indexName = i.Name
+	ids.filter = filter
+	ids.duration = time.Since(start)
 
 	// Force to check first if a cancel signal has been send
 	// If not already canceled it wait for done or cancel
@@ -200,8 +540,55 @@ func (r *refs) setIndexedValue(indexName string, indexHash uint64, indexedVal []
 	r.Refs = append(r.Refs, ref)
 }
 
+// rmIndexedValue removes from the list of references the one of the given index
+func (r *refs) rmIndexedValue(indexName string) {
+	for i, ref := range r.Refs {
+		if ref.IndexName == indexName {
+			copy(r.Refs[i:], r.Refs[i+1:])
+			r.Refs[len(r.Refs)-1] = nil
+			r.Refs = r.Refs[:len(r.Refs)-1]
+			return
+		}
+	}
+}
+
+// getIndexedValue returns the indexed value for the given index or nil if not indexed
+func (r *refs) getIndexedValue(indexName string) []byte {
+	for _, ref := range r.Refs {
+		if ref.IndexName == indexName {
+			return ref.IndexedValue
+		}
+	}
+	return nil
+}
+
 // asBytes marshals the given Refs pointer into a slice of bytes fo saving
 func (r *refs) asBytes() []byte {
 	ret, _ := json.Marshal(r)
 	return ret
 }
+
+// newIndexReport builds a new empty IndexReport pointer
+func newIndexReport() *IndexReport {
+	report := new(IndexReport)
+	report.Missing = map[string][]string{}
+	report.Dangling = map[string][]string{}
+	return report
+}
+
+func (r *IndexReport) addMissing(indexName, id string) {
+	if !containsString(r.Missing[indexName], id) {
+		r.Missing[indexName] = append(r.Missing[indexName], id)
+	}
+}
+
+func (r *IndexReport) addDangling(indexName, id string) {
+	if !containsString(r.Dangling[indexName], id) {
+		r.Dangling[indexName] = append(r.Dangling[indexName], id)
+	}
+}
+
+// OK returns true if no inconsistency has been found
+func (r *IndexReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Dangling) == 0
+}
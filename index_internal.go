@@ -3,19 +3,35 @@ package gotinydb
 import (
 	"bytes"
 	"context"
-	"log"
-	"reflect"
+
+	"github.com/boltdb/bolt"
 )
 
-func (i *indexType) getIDsForOneValue(ctx context.Context, indexedValue []byte) (ids *idsType, err error) {
-	tx, getTxErr := i.getTx(false)
+// readTx returns the index transaction of the snapshot or a new one if view is nil.
+// The returned function releases the transaction.
+func (i *indexType) readTx(view *SnapshotCollection) (*bolt.Tx, func(), error) {
+	if view != nil {
+		// The transaction can't be used by many goroutines at the same time
+		view.txMutex.Lock()
+		return view.tx, view.txMutex.Unlock, nil
+	}
+
+	return i.beginView()
+}
+
+func (i *indexType) getIDsForOneValue(ctx context.Context, view *SnapshotCollection, indexedValue []byte) (ids *idsType, err error) {
+	tx, release, getTxErr := i.readTx(view)
 	if getTxErr != nil {
 		return nil, getTxErr
 	}
-	defer tx.Rollback()
+	defer release()
 
 	bucket := tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name))
-	asBytes := bucket.Get(indexedValue)
+	storageKey := i.storageKey(indexedValue)
+	asBytes, getErr := i.postingAsBytes(tx, storageKey, bucket.Get(storageKey))
+	if getErr != nil {
+		return nil, getErr
+	}
 
 	ids, err = newIDs(ctx, i.SelectorHash, indexedValue, asBytes)
 	if err != nil {
@@ -24,59 +40,67 @@ func (i *indexType) getIDsForOneValue(ctx context.Context, indexedValue []byte)
 	return ids, nil
 }
 
-func (i *indexType) getIDsForRangeOfValues(ctx context.Context, indexedValue, limit []byte, keepEqual, increasing bool) (allIDs *idsType, err error) {
-	tx, getTxErr := i.getTx(false)
+func (i *indexType) getIDsForRangeOfValues(ctx context.Context, view *SnapshotCollection, indexedValue, limit []byte, keepEqual, increasing bool) (allIDs *idsType, err error) {
+	tx, release, getTxErr := i.readTx(view)
 	if getTxErr != nil {
 		return nil, getTxErr
 	}
-	defer tx.Rollback()
+	defer release()
 
 	bucket := tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name))
 	// Initiate the cursor (iterator)
 	iter := bucket.Cursor()
-	// Go to the requested position and get the values of it
-	firstIndexedValueAsByte, firstIDsAsByte := iter.Seek(indexedValue)
-	firstIDsValue, unmarshalIDsErr := newIDs(ctx, i.SelectorHash, indexedValue, firstIDsAsByte)
-	if unmarshalIDsErr != nil {
-		return nil, unmarshalIDsErr
-	}
 
-	allIDs, _ = newIDs(ctx, i.SelectorHash, indexedValue, nil)
-
-	// if the asked value is found
-	if reflect.DeepEqual(firstIndexedValueAsByte, indexedValue) && keepEqual {
-		allIDs.AddIDs(firstIDsValue)
-	}
+	// The keys of the descending indexes are saved in the reverse order
+	forward := increasing != i.Descending
 
 	var nextFunc func() (key []byte, value []byte)
-	if increasing {
+	if forward {
 		nextFunc = iter.Next
 	} else {
 		nextFunc = iter.Prev
 	}
 
-	for {
-		indexedValue, idsAsByte := nextFunc()
-		if len(indexedValue) <= 0 && len(idsAsByte) <= 0 {
-			break
-		}
-		ids, unmarshalIDsErr := newIDs(ctx, i.SelectorHash, indexedValue, idsAsByte)
-		if unmarshalIDsErr != nil {
-			return nil, unmarshalIDsErr
+	allIDs, _ = newIDs(ctx, i.SelectorHash, indexedValue, nil)
+
+	// Go to the requested position.
+	// If the asked value is not present the cursor is after it and when going backward
+	// the first value to check is the previous one.
+	start := i.storageKey(indexedValue)
+	storageKey, idsAsByte := iter.Seek(start)
+	if !forward && !bytes.Equal(storageKey, start) {
+		storageKey, idsAsByte = iter.Prev()
+	}
+
+	for ; storageKey != nil; storageKey, idsAsByte = nextFunc() {
+		value := i.valueFromStorageKey(storageKey)
+
+		if !keepEqual && bytes.Equal(value, indexedValue) {
+			continue
 		}
 
 		if limit != nil {
 			if keepEqual {
-				if bytes.Compare(limit, indexedValue) < 0 {
+				if bytes.Compare(limit, value) < 0 {
 					break
 				}
 			} else {
-				if bytes.Compare(limit, indexedValue) <= 0 {
+				if bytes.Compare(limit, value) <= 0 {
 					break
 				}
 			}
 		}
 
+		postingAsBytes, getErr := i.postingAsBytes(tx, storageKey, idsAsByte)
+		if getErr != nil {
+			return nil, getErr
+		}
+
+		ids, unmarshalIDsErr := newIDs(ctx, i.SelectorHash, value, postingAsBytes)
+		if unmarshalIDsErr != nil {
+			return nil, unmarshalIDsErr
+		}
+
 		allIDs.AddIDs(ids)
 
 		// Clean if to big
@@ -88,16 +112,16 @@ func (i *indexType) getIDsForRangeOfValues(ctx context.Context, indexedValue, li
 	return allIDs, nil
 }
 
-func (i *indexType) queryEqual(ctx context.Context, ids *idsType, filter *Filter) {
+func (i *indexType) queryEqual(ctx context.Context, view *SnapshotCollection, ids *idsType, filter *Filter) {
 	for _, value := range filter.values {
-		tmpIDs, getErr := i.getIDsForOneValue(ctx, value.Bytes())
+		tmpIDs, getErr := i.getIDsForOneValue(ctx, view, i.valueToBytes(value))
 		if getErr != nil {
-			log.Printf("Index.runQuery Equal: %s\n", getErr.Error())
+			i.options.log(LogError, "index query failed", "index", i.Name, "filter", filter.GetType(), "error", getErr)
 			return
 		}
 
 		for _, tmpID := range tmpIDs.IDs {
-			tmpID.values[i.SelectorHash] = value.Bytes()
+			tmpID.values[i.SelectorHash] = i.valueToBytes(value)
 
 		}
 
@@ -105,29 +129,29 @@ func (i *indexType) queryEqual(ctx context.Context, ids *idsType, filter *Filter
 	}
 }
 
-func (i *indexType) queryGreaterLess(ctx context.Context, ids *idsType, filter *Filter) {
+func (i *indexType) queryGreaterLess(ctx context.Context, view *SnapshotCollection, ids *idsType, filter *Filter) {
 	greater := true
 	if filter.GetType() == Less {
 		greater = false
 	}
 
-	tmpIDs, getIdsErr := i.getIDsForRangeOfValues(ctx, filter.values[0].Bytes(), nil, filter.equal, greater)
+	tmpIDs, getIdsErr := i.getIDsForRangeOfValues(ctx, view, i.valueToBytes(filter.values[0]), nil, filter.equal, greater)
 	if getIdsErr != nil {
-		log.Printf("Index.runQuery Greater, Less: %s\n", getIdsErr.Error())
+		i.options.log(LogError, "index query failed", "index", i.Name, "filter", filter.GetType(), "error", getIdsErr)
 		return
 	}
 
 	ids.AddIDs(tmpIDs)
 }
 
-func (i *indexType) queryBetween(ctx context.Context, ids *idsType, filter *Filter) {
+func (i *indexType) queryBetween(ctx context.Context, view *SnapshotCollection, ids *idsType, filter *Filter) {
 	// Needs two values to make between
 	if len(filter.values) < 2 {
 		return
 	}
-	tmpIDs, getIdsErr := i.getIDsForRangeOfValues(ctx, filter.values[0].Bytes(), filter.values[1].Bytes(), filter.equal, true)
+	tmpIDs, getIdsErr := i.getIDsForRangeOfValues(ctx, view, i.valueToBytes(filter.values[0]), i.valueToBytes(filter.values[1]), filter.equal, true)
 	if getIdsErr != nil {
-		log.Printf("Index.runQuery Between: %s\n", getIdsErr.Error())
+		i.options.log(LogError, "index query failed", "index", i.Name, "filter", filter.GetType(), "error", getIdsErr)
 		return
 	}
 
@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/boltdb/bolt"
 )
 
 func TestConcurrentCollections(t *testing.T) {
@@ -122,7 +126,7 @@ func checkObjectsForConcurrent(c *Collection, dataSet []byte, done chan error) {
 	for _, user := range users[:1] {
 		// for _, user := range users {
 		retrievedUser := new(User)
-		if _, err := c.Get(user.ID, retrievedUser); err != nil {
+		if err := c.Get(user.ID, retrievedUser); err != nil {
 			done <- err
 			return
 		}
@@ -143,20 +147,528 @@ func unmarshalDataSet(dataSet []byte) []*User {
 }
 
 func updateUser(c *Collection, v1, v2, v3 *User, done chan error) error {
-	if err := c.Put(v1.ID, v1); err != nil {
-		done <- err
-		return err
-	}
-	if err := c.Put(v1.ID, v2); err != nil {
-		done <- err
-		return err
-	}
-	if err := c.Put(v1.ID, v3); err != nil {
-		done <- err
-		return err
+	// Every update is saved over the revision read just before
+	for _, user := range []*User{v1, v2, v3} {
+		rev, _, err := c.GetRev(v1.ID, nil)
+		if err != nil && err != ErrNotFound {
+			done <- err
+			return err
+		}
+		if err := c.PutRev(v1.ID, user, rev); err != nil {
+			done <- err
+			return err
+		}
 	}
 
 	done <- nil
 	return nil
 }
 
+func TestCollatedIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, userErr := db.Use("testCol")
+	if userErr != nil {
+		t.Error(userErr)
+		return
+	}
+
+	if err := c.SetIndexWithOptions("age", IntIndex, &IndexOptions{Collation: "fr"}, "Age"); err == nil {
+		t.Errorf("collation must be refused for int indexes")
+		return
+	}
+	if err := c.SetIndexWithOptions("name", StringIndex, &IndexOptions{Collation: "fr"}, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, email := range []string{"zorro", "ésa", "esb", "eta", "Émile"} {
+		if err := c.Put(fmt.Sprint(i), &User{ID: fmt.Sprint(i), Email: email}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	response, queryErr := c.Query(
+		NewQuery().SetOrder(true, "Email").SetFilter(
+			NewFilter(Less).SetSelector("Email").CompareTo("f"),
+		),
+	)
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+
+	emails := []string{}
+	response.All(func(id string, objAsBytes []byte) error {
+		user := new(User)
+		json.Unmarshal(objAsBytes, user)
+		emails = append(emails, user.Email)
+		return nil
+	})
+	if expected := []string{"Émile", "ésa", "esb", "eta"}; !reflect.DeepEqual(emails, expected) {
+		t.Errorf("the collated order is %v but expected %v", emails, expected)
+		return
+	}
+
+	response, queryErr = c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo("ESB")))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if id, _ := response.One(new(User)); id != "2" {
+		t.Errorf("the case insensitive equal returned %q instead of %q", id, "2")
+		return
+	}
+}
+
+func TestBlindTokenIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	if _, err := db.BlindToken("jonas-90@tlaloc.com"); err != ErrMissingBlindTokenKey {
+		t.Errorf("expected %v but had %v", ErrMissingBlindTokenKey, err)
+		return
+	}
+	options.BlindTokenKey = []byte("blind token key")
+
+	c, userErr := db.Use("testCol")
+	if userErr != nil {
+		t.Error(userErr)
+		return
+	}
+
+	if err := c.SetIndexWithOptions("email", StringIndex, &IndexOptions{BlindToken: true}, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users {
+		// Only the token is saved
+		token, err := db.BlindToken(user.Email)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := c.Put(user.ID, &User{ID: user.ID, Email: token}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// The clear value is used to query
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[3].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if id, _ := response.One(new(User)); id != users[3].ID {
+		t.Errorf("the blind token query returned %q instead of %q", id, users[3].ID)
+		return
+	}
+
+	if _, err := c.Query(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Email").CompareTo("a"))); err != ErrBlindTokenRange {
+		t.Errorf("expected %v but had %v", ErrBlindTokenRange, err)
+		return
+	}
+
+	options.BlindTokenKey = nil
+	if _, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[3].Email))); err != ErrMissingBlindTokenKey {
+		t.Errorf("expected %v but had %v", ErrMissingBlindTokenKey, err)
+		return
+	}
+}
+
+func TestHashIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, userErr := db.Use("testCol")
+	if userErr != nil {
+		t.Error(userErr)
+		return
+	}
+
+	if err := c.SetIndex("email", HashIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[3].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if id, _ := response.One(new(User)); id != users[3].ID {
+		t.Errorf("the hash query returned %q instead of %q", id, users[3].ID)
+		return
+	}
+
+	if _, err := c.Query(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Email").CompareTo("a"))); err != ErrHashIndexRange {
+		t.Errorf("expected %v but had %v", ErrHashIndexRange, err)
+		return
+	}
+
+	// Only the hashes are saved
+	c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("indexes")).Bucket([]byte("email")).ForEach(func(key, _ []byte) error {
+			if len(key) != len(buildIDInternal("")) {
+				t.Errorf("the key %x is not a hash", key)
+			}
+			return nil
+		})
+	})
+}
+
+func TestDescendingIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	options.TransactionTimeOut = time.Second * 100
+	options.QueryTimeOut = time.Second * 100
+	options.InternalQueryLimit = 1000
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	ascCol, _ := db.Use("ascending")
+	descCol, _ := db.Use("descending")
+	for _, c := range []*Collection{ascCol, descCol} {
+		indexOptions := &IndexOptions{Descending: c == descCol}
+		if err := c.SetIndexWithOptions("email", StringIndex, indexOptions, "Email"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := c.SetIndexWithOptions("age", IntIndex, indexOptions, "Age"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, user := range unmarshalDataSet(dataSet1) {
+			if err := c.Put(user.ID, user); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		// Prefixes must be ordered after the longer values
+		for _, email := range []string{"0a", "0ab"} {
+			if err := c.Put(email, &User{ID: email, Email: email}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	queries := []*Query{
+		NewQuery().SetOrder(false, "Email").SetFilter(NewFilter(Less).SetSelector("Email").CompareTo("0b")),
+		NewQuery().SetOrder(true, "Email").SetFilter(NewFilter(Greater).SetSelector("Email").CompareTo("fa")),
+		NewQuery().SetOrder(false, "Email").SetFilter(NewFilter(Greater).SetSelector("Email").EqualWanted().CompareTo("s")),
+		NewQuery().SetOrder(true, "Email").SetFilter(NewFilter(Between).SetSelector("Email").CompareTo("c").CompareTo("d")),
+		NewQuery().SetOrder(false, "Age").SetFilter(NewFilter(Less).SetSelector("Age").EqualWanted().CompareTo(uint(10))),
+		NewQuery().SetOrder(true, "Age").SetFilter(NewFilter(Equal).SetSelector("Age").CompareTo(uint(7))),
+	}
+
+	for i, q := range queries {
+		q.SetLimits(1000, 1000)
+
+		ids := [2][]string{}
+		for j, c := range []*Collection{ascCol, descCol} {
+			response, err := c.Query(q)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response.All(func(id string, _ []byte) error {
+				ids[j] = append(ids[j], id)
+				return nil
+			})
+		}
+
+		if len(ids[0]) == 0 || !reflect.DeepEqual(ids[0], ids[1]) {
+			t.Errorf("query %d returned %v for the ascending index and %v for the descending one", i, ids[0], ids[1])
+			return
+		}
+		if i == 0 && !reflect.DeepEqual(ids[1], []string{"0ab", "0a"}) {
+			t.Errorf("the prefixes are not ordered: %v", ids[1])
+			return
+		}
+	}
+
+	report, err := descCol.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the descending index is not valid: missing %v, dangling %v", report.Missing, report.Dangling)
+		return
+	}
+}
+
+func TestExtractorIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	db.options.InternalQueryLimit = 1000
+	c, _ := db.Use("testCol")
+
+	// Index the domain of the email and the year of the last login
+	domainExtractor := func(doc []byte) ([]byte, bool) {
+		user := new(User)
+		if err := json.Unmarshal(doc, user); err != nil || !strings.Contains(user.Email, "@") {
+			return nil, false
+		}
+		return []byte(strings.Split(user.Email, "@")[1]), true
+	}
+	yearExtractor := func(doc []byte) ([]byte, bool) {
+		user := new(User)
+		if err := json.Unmarshal(doc, user); err != nil {
+			return nil, false
+		}
+		year, err := IndexValue(user.LastLogin.Year())
+		return year, err == nil
+	}
+
+	if err := c.SetExtractorIndex("domain", StringIndex, domainExtractor); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetExtractorIndex("year", IntIndex, yearExtractor); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check that the values written after the index is set are indexed too
+	if err := c.Put("new", &User{ID: "new", Email: "new@TLALOC.com", LastLogin: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Error(err)
+		return
+	}
+	users = append(users, &User{ID: "new", Email: "new@tlaloc.com", LastLogin: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+	checkQuery := func(q *Query, expected func(user *User) bool) bool {
+		response, err := c.Query(q.SetLimits(1000, 1000))
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+
+		expectedIDs := []string{}
+		for _, user := range users {
+			if expected(user) {
+				expectedIDs = append(expectedIDs, user.ID)
+			}
+		}
+		ids := []string{}
+		response.All(func(id string, _ []byte) error {
+			ids = append(ids, id)
+			return nil
+		})
+		sort.Strings(ids)
+		sort.Strings(expectedIDs)
+		if len(ids) == 0 || !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("the query returned %v but expected %v", ids, expectedIDs)
+			return false
+		}
+		return true
+	}
+
+	if !checkQuery(
+		NewQuery().SetFilter(NewFilter(Equal).SetSelector("domain").CompareTo("tlaloc.com")),
+		func(user *User) bool { return strings.HasSuffix(user.Email, "@tlaloc.com") },
+	) {
+		return
+	}
+	if !checkQuery(
+		NewQuery().SetFilter(NewFilter(Less).SetSelector("year").CompareTo(2018)),
+		func(user *User) bool { return user.LastLogin.Year() < 2018 },
+	) {
+		return
+	}
+
+	// The functions are lost when the database is closed
+	testPath := db.options.Path
+	db.Close()
+	options := NewDefaultOptions(testPath)
+	options.InternalQueryLimit = 1000
+	db, _ = Open(ctx, options)
+	defer db.Close()
+	c, _ = db.Use("testCol")
+
+	if _, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("domain").CompareTo("tlaloc.com"))); err == nil {
+		t.Errorf("the query must fail if the extractor is not set")
+		return
+	}
+	if err := c.SetExtractorIndex("domain", StringIndex, domainExtractor); err != nil {
+		t.Error(err)
+		return
+	}
+	if !checkQuery(
+		NewQuery().SetFilter(NewFilter(Equal).SetSelector("domain").CompareTo("tlaloc.com")),
+		func(user *User) bool { return strings.HasSuffix(user.Email, "@tlaloc.com") },
+	) {
+		return
+	}
+}
+
+func TestMapFieldIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	type product struct {
+		Attributes map[string]string
+		Stocks     map[string]int
+		Addresses  map[string]*Address
+	}
+
+	if err := c.SetIndex("color", StringIndex, "Attributes", "color"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetIndex("stock", IntIndex, "Stocks", "paris"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetIndex("city", StringIndex, "Addresses", "home", "City"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	products := map[string]*product{
+		"red": {
+			Attributes: map[string]string{"color": "red"},
+			Stocks:     map[string]int{"paris": 10},
+			Addresses:  map[string]*Address{"home": {City: "Paris"}},
+		},
+		"blue": {
+			Attributes: map[string]string{"color": "blue"},
+			Stocks:     map[string]int{"paris": 2},
+			Addresses:  map[string]*Address{"home": {City: "Lyon"}},
+		},
+		"none": {},
+	}
+	for id, p := range products {
+		if err := c.Put(id, p); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	queries := []struct {
+		filter   *Filter
+		expected string
+	}{
+		{NewFilter(Equal).SetSelector("Attributes", "color").CompareTo("blue"), "blue"},
+		{NewFilter(Greater).SetSelector("Stocks", "paris").CompareTo(5), "red"},
+		{NewFilter(Equal).SetSelector("Addresses", "home", "City").CompareTo("lyon"), "blue"},
+	}
+	for _, query := range queries {
+		response, err := c.Query(NewQuery().SetFilter(query.filter))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if response.Len() != 1 {
+			t.Errorf("expected one response but had %d", response.Len())
+			return
+		}
+		if _, id, _ := response.First(); id != query.expected {
+			t.Errorf("expected %q but had %q", query.expected, id)
+			return
+		}
+	}
+
+	// The indexes built from the saved documents are the same
+	report, err := c.VerifyIndexes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the report is not clean: missing %v, dangling %v", report.Missing, report.Dangling)
+		return
+	}
+}
+
+func TestPickCandidate(t *testing.T) {
+	i := newIndex("balance", IntIndex, "Balance")
+	candidates, ok := i.applyToStored(map[string]interface{}{"Balance": json.Number("12")}, nil)
+	if !ok || len(candidates) != 2 {
+		t.Errorf("expected the signed and the unsigned values but had %v", candidates)
+		return
+	}
+
+	signed, _ := intToBytes(12)
+	unsigned, _ := intToBytes(uint(12))
+	if got := pickCandidate(candidates, nil); !reflect.DeepEqual(got, signed) {
+		t.Errorf("expected the signed value %v but had %v", signed, got)
+	}
+	if got := pickCandidate(candidates, unsigned); !reflect.DeepEqual(got, unsigned) {
+		t.Errorf("expected the previous value %v but had %v", unsigned, got)
+	}
+	other, _ := intToBytes(13)
+	if got := pickCandidate(candidates, other); !reflect.DeepEqual(got, signed) {
+		t.Errorf("expected the signed value %v but had %v", signed, got)
+	}
+}
@@ -0,0 +1,139 @@
+package gotinydb
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Iterate returns an iterator over the IDs and the values of the collection in the ID order.
+// All the elements are read from the same transaction, so the writes done after the call
+// are not seen. The iterator must be closed.
+func (c *Collection) Iterate(options IterOptions) *Iterator {
+	storePrefix := []byte(c.id[:4] + "_")
+	keyPrefix := append(append([]byte{}, storePrefix...), options.Prefix...)
+
+	txn, endTxn := c.store.begin(false)
+	iterOptions := badger.DefaultIteratorOptions
+	// The values are read only if asked
+	iterOptions.PrefetchValues = false
+	iterOptions.Reverse = options.Reverse
+	iter := txn.NewIterator(iterOptions)
+
+	var seekKey []byte
+	if !options.Reverse {
+		seekKey = keyPrefix
+		if options.StartAfter != "" && options.StartAfter > options.Prefix {
+			seekKey = append(append([]byte{}, storePrefix...), options.StartAfter...)
+		}
+		iter.Seek(seekKey)
+	} else {
+		// The reverse iteration starts before the first key following all the keys of the prefix
+		seekKey = prefixEnd(keyPrefix)
+		startKey := append(append([]byte{}, storePrefix...), options.StartAfter...)
+		if options.StartAfter != "" && bytes.Compare(startKey, seekKey) < 0 {
+			seekKey = startKey
+			iter.Seek(seekKey)
+		} else {
+			iter.Seek(seekKey)
+			if iter.Valid() && bytes.Equal(iter.Item().Key(), seekKey) {
+				iter.Next()
+			}
+		}
+	}
+
+	return &Iterator{
+		c:       c,
+		txn:     txn,
+		endTxn:  endTxn,
+		iter:    iter,
+		options: options,
+		prefix:  keyPrefix,
+	}
+}
+
+// Next moves to the next element and returns false when there is no more element
+func (i *Iterator) Next() bool {
+	if i.closed {
+		return false
+	}
+	if i.options.Limit > 0 && i.count >= i.options.Limit {
+		return false
+	}
+
+	if i.started {
+		i.iter.Next()
+	}
+	i.started = true
+
+	for ; i.iter.ValidForPrefix(i.prefix); i.iter.Next() {
+		item := i.iter.Item()
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+
+		id := string(item.Key()[5:])
+		if id == i.options.StartAfter {
+			continue
+		}
+
+		i.item = item
+		i.id = id
+		i.count++
+		return true
+	}
+
+	i.item = nil
+	i.id = ""
+	return false
+}
+
+// ID returns the ID of the current element
+func (i *Iterator) ID() string {
+	return i.id
+}
+
+// Binary returns true if the current element has been saved from bytes and is not a document
+func (i *Iterator) Binary() bool {
+	return i.item != nil && isBinaryMeta(i.item.UserMeta())
+}
+
+// Value reads the content of the current element.
+// The content is checked and ErrDataCorrupted is returned if it does not match its signature.
+func (i *Iterator) Value() ([]byte, error) {
+	if i.item == nil {
+		return nil, ErrNotFound
+	}
+
+	contentAndSignature, err := i.item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.c.getAndCheckContent(i.item.Key(), i.item.UserMeta(), contentAndSignature)
+}
+
+// Close releases the transaction of the iterator
+func (i *Iterator) Close() {
+	if i.closed {
+		return
+	}
+	i.closed = true
+	i.item = nil
+
+	i.iter.Close()
+	i.endTxn()
+}
+
+// prefixEnd returns the first key after all the keys starting with the prefix.
+// The prefixes of the store keys end with '_', so it is never empty.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
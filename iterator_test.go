@@ -0,0 +1,122 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestIterate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	other, _ := db.Use("otherCol")
+	for _, id := range []string{"a1", "a2", "a3", "b1", "b2", "c1"} {
+		if err := c.Put(id, map[string]string{"ID": id}); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := other.Put(id+"other", map[string]string{"ID": id}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	listIDs := func(options IterOptions) []string {
+		iter := c.Iterate(options)
+		defer iter.Close()
+
+		ids := []string{}
+		for iter.Next() {
+			ids = append(ids, iter.ID())
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name     string
+		options  IterOptions
+		expected []string
+	}{
+		{"all", IterOptions{}, []string{"a1", "a2", "a3", "b1", "b2", "c1"}},
+		{"prefix", IterOptions{Prefix: "b"}, []string{"b1", "b2"}},
+		{"start after", IterOptions{StartAfter: "a2", Limit: 3}, []string{"a3", "b1", "b2"}},
+		{"start after with prefix", IterOptions{Prefix: "a", StartAfter: "a1"}, []string{"a2", "a3"}},
+		{"start after out of prefix", IterOptions{Prefix: "a", StartAfter: "b1"}, []string{}},
+		{"reverse", IterOptions{Reverse: true, Limit: 2}, []string{"c1", "b2"}},
+		{"reverse prefix", IterOptions{Prefix: "a", Reverse: true}, []string{"a3", "a2", "a1"}},
+		{"reverse start after", IterOptions{Reverse: true, StartAfter: "b1"}, []string{"a3", "a2", "a1"}},
+		{"reverse start after with prefix", IterOptions{Prefix: "b", Reverse: true, StartAfter: "b2"}, []string{"b1"}},
+	}
+
+	for _, test := range tests {
+		if ids := listIDs(test.options); !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s: expected %v but had %v", test.name, test.expected, ids)
+		}
+	}
+
+	// The IDs can have any byte, the reverse iteration must not miss the ones with 0xFF
+	binary, _ := db.Use("binaryIDs")
+	for _, id := range []string{"x\xff1", "x\xff2", "y"} {
+		if err := binary.Put(id, map[string]string{"ID": id}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, test := range []struct {
+		options  IterOptions
+		expected []string
+	}{
+		{IterOptions{Reverse: true}, []string{"y", "x\xff2", "x\xff1"}},
+		{IterOptions{Prefix: "x", Reverse: true}, []string{"x\xff2", "x\xff1"}},
+		{IterOptions{Prefix: "x", Reverse: true, StartAfter: "x\xff2"}, []string{"x\xff1"}},
+	} {
+		iter := binary.Iterate(test.options)
+		ids := []string{}
+		for iter.Next() {
+			ids = append(ids, iter.ID())
+		}
+		iter.Close()
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("expected %q but had %q", test.expected, ids)
+		}
+	}
+
+	iter := c.Iterate(IterOptions{Prefix: "c"})
+	defer iter.Close()
+
+	// The writes done after the call are not seen
+	if err := c.Put("c2", map[string]string{"ID": "c2"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	count := 0
+	for iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(value) != `{"ID":"c1"}` {
+			t.Errorf("unexpected value %q", string(value))
+			return
+		}
+		count++
+	}
+	if count != 1 {
+		t.Errorf("expected 1 element but had %d", count)
+		return
+	}
+}
@@ -0,0 +1,48 @@
+package gotinydb
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// log sends the message to the logger of the options or to the standard library one
+func (o *Options) log(level LogLevel, message string, keysAndValues ...interface{}) {
+	var logger Logger = stdLogger{}
+	if o != nil && o.Logger != nil {
+		logger = o.Logger
+	}
+	logger.Log(level, message, keysAndValues...)
+}
+
+func (stdLogger) Log(level LogLevel, message string, keysAndValues ...interface{}) {
+	if level < LogInfo {
+		return
+	}
+
+	buf := bytes.NewBufferString("gotinydb " + level.String() + ": " + message)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(buf, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(buf, " %v", keysAndValues[i])
+		}
+	}
+	log.Print(buf.String())
+}
+
+func (nopLogger) Log(LogLevel, string, ...interface{}) {}
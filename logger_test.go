@@ -0,0 +1,98 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLog struct {
+	level         LogLevel
+	message       string
+	keysAndValues []interface{}
+}
+
+type testLogger struct {
+	mutex sync.Mutex
+	logs  []*testLog
+}
+
+func (l *testLogger) Log(level LogLevel, message string, keysAndValues ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.logs = append(l.logs, &testLog{level, message, keysAndValues})
+}
+
+func (l *testLogger) get() []*testLog {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]*testLog{}, l.logs...)
+}
+
+func TestLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The address is not served anymore
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	logger := new(testLogger)
+	options := NewDefaultOptions(testPath)
+	options.Logger = logger
+	options.ReplicationTLS = newTestReplicationTLS(t)
+	db, openDBErr := OpenReplica(ctx, options, addr)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	for i := 0; len(logger.get()) == 0; i++ {
+		if i > 100 {
+			t.Errorf("the failed connection is not logged")
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if l := logger.get()[0]; l.level != LogWarn || l.message != "replication interrupted" || l.keysAndValues[1] != addr {
+		t.Errorf("unexpected log %+v", l)
+		return
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	options := NewDefaultOptions("")
+	options.log(LogDebug, "hidden")
+	options.log(LogError, "query failed", "index", "email", "error", errors.New("timeout"))
+	if !strings.HasSuffix(buf.String(), "gotinydb error: query failed index=email error=timeout\n") || strings.Contains(buf.String(), "hidden") {
+		t.Errorf("unexpected logs %q", buf.String())
+		return
+	}
+
+	buf.Reset()
+	options.Logger = NopLogger
+	options.log(LogError, "silenced")
+	if buf.Len() != 0 {
+		t.Errorf("the logs are not discarded %q", buf.String())
+		return
+	}
+}
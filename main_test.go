@@ -1,9 +1,11 @@
 package gotinydb
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
@@ -99,6 +101,278 @@ func TestOpenAndClose(t *testing.T) {
 	}
 }
 
+func TestRenameCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	if err := db.RenameCollection("testCol", ""); err == nil {
+		t.Errorf("the collection can't be renamed with an empty name")
+		return
+	}
+	if err := db.RenameCollection("notExists", "newCol"); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+	if _, err := db.Use("otherCol"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.RenameCollection("testCol", "otherCol"); err != ErrCollectionExists {
+		t.Errorf("expected %v but had %v", ErrCollectionExists, err)
+		return
+	}
+
+	if err := db.RenameCollection("testCol", "newCol"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRenamed := func() bool {
+		c, err := db.Use("newCol")
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+
+		response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo("jonas-90@tlaloc.com")))
+		if queryErr != nil {
+			t.Error(queryErr)
+			return false
+		}
+		if id, _ := response.One(new(User)); id != "0" {
+			t.Errorf("%q is not the right ID. Expected %q", id, "0")
+			return false
+		}
+		return true
+	}
+	if !checkRenamed() {
+		return
+	}
+
+	// A new collection with the old name is empty
+	c, err := db.Use("testCol")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Put("new", []byte("new")); err != nil {
+		t.Error(err)
+		return
+	}
+	if ids, _ := c.GetIDs("", 1000); len(ids) != 1 {
+		t.Errorf("the new collection must have only one value but had %d", len(ids))
+		return
+	}
+
+	testPath := db.options.Path
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	db, err = Open(ctx, NewDefaultOptions(testPath))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRenamed()
+}
+
+func TestCopyCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	if err := db.CopyCollection("notExists", "copy", nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+	if err := db.CopyCollection("testCol", "testCol", nil); err != ErrCollectionExists {
+		t.Errorf("expected %v but had %v", ErrCollectionExists, err)
+		return
+	}
+
+	// A binary content which looks like JSON must stay binary
+	src, _ := db.Use("testCol")
+	if err := src.Put("binary", []byte(`{"Age":1}`)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := db.CopyCollection("testCol", "copy", nil); err != nil {
+		t.Error(err)
+		return
+	}
+	c, _ := db.Use("copy")
+	if ids, _ := c.GetIDs("", 1000); len(ids) != len(users)+1 {
+		t.Errorf("expected %d documents but had %d", len(users)+1, len(ids))
+		return
+	}
+	iter := c.Iterate(IterOptions{Prefix: "binary"})
+	if !iter.Next() || !iter.Binary() {
+		t.Errorf("the binary content is not copied as binary")
+	}
+	iter.Close()
+
+	// The indexes are copied
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	user := new(User)
+	if id, _ := response.One(user); id != users[0].ID || !reflect.DeepEqual(user, users[0]) {
+		t.Errorf("%q is not the right ID. Expected %q", id, users[0].ID)
+		return
+	}
+
+	// Only the documents matching the filters are copied and the limits are not used
+	filter := NewFilter(Less).SetSelector("Age").CompareTo(uint(5))
+	if err := db.CopyCollection("testCol", "young", NewQuery().SetFilter(filter).SetLimits(1, 1)); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := 0
+	for _, user := range users {
+		if user.Age < 5 {
+			expected++
+		}
+	}
+	c, _ = db.Use("young")
+	if ids, _ := c.GetIDs("", 1000); len(ids) != expected {
+		t.Errorf("expected %d documents but had %d", expected, len(ids))
+		return
+	}
+
+	// The signed integers are compared as signed values only
+	lowBalance, highBalance := -5000000000000000000, 2000000000000000000
+	between := NewQuery().SetFilter(NewFilter(Greater).SetSelector("Balance").CompareTo(lowBalance)).
+		SetFilter(NewFilter(Less).SetSelector("Balance").CompareTo(highBalance))
+	if err := db.CopyCollection("testCol", "balance", between); err != nil {
+		t.Error(err)
+		return
+	}
+	expected = 0
+	for _, user := range users {
+		if user.Balance > lowBalance && user.Balance < highBalance {
+			expected++
+		}
+	}
+	c, _ = db.Use("balance")
+	if ids, _ := c.GetIDs("", 1000); len(ids) != expected || expected == 0 || expected == len(users) {
+		t.Errorf("expected %d documents but had %d", expected, len(ids))
+		return
+	}
+}
+
+func TestCollections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("other")
+	if err := c.SetIndexWithOptions("email", StringIndex, &IndexOptions{Descending: true}, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	check := func() bool {
+		infos := db.Collections()
+		if len(infos) != 2 {
+			t.Errorf("expected 2 collections but had %d", len(infos))
+			return false
+		}
+		for _, info := range infos {
+			switch info.Name {
+			case "testCol":
+				if len(info.Indexes) != 6 || info.Indexes[2].Name != "city" ||
+					!reflect.DeepEqual(info.Indexes[2].Selector, []string{"Address", "City"}) || info.Indexes[2].Type != StringIndex {
+					t.Errorf("unexpected indexes %+v", info.Indexes)
+					return false
+				}
+			case "other":
+				if len(info.Indexes) != 1 || !info.Indexes[0].Options.Descending {
+					t.Errorf("unexpected indexes %+v", info.Indexes)
+					return false
+				}
+			default:
+				t.Errorf("unexpected collection %q", info.Name)
+				return false
+			}
+		}
+		return true
+	}
+	if !check() {
+		return
+	}
+
+	testPath := db.options.Path
+	db.Close()
+	var err error
+	db, err = Open(ctx, NewDefaultOptions(testPath))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check()
+}
+
+func TestOpenWithWarmUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer os.RemoveAll(db.options.Path)
+
+	testPath := db.options.Path
+	if err := db.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	options := NewDefaultOptions(testPath)
+	options.WarmUpIndexes = true
+	db, err := Open(ctx, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if _, id, _ := response.First(); id != users[0].ID {
+		t.Errorf("expected %q but had %q", users[0].ID, id)
+		return
+	}
+}
+
 func TestCreateCollection(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -173,7 +447,7 @@ func testPutGetAndDeleteCollection(t *testing.T, userID string, user interface{}
 		retrievedTestUser := struct {
 			Login, Pass string
 		}{}
-		if _, getErr := c.Get(userID, &retrievedTestUser); getErr != nil {
+		if getErr := c.Get(userID, &retrievedTestUser); getErr != nil {
 			t.Error(getErr)
 			return
 		}
@@ -182,7 +456,7 @@ func testPutGetAndDeleteCollection(t *testing.T, userID string, user interface{}
 			return
 		}
 	} else {
-		retrieveContent, getErr := c.Get(userID, nil)
+		retrieveContent, getErr := c.GetRaw(userID)
 		if getErr != nil {
 			t.Error(getErr)
 			return
@@ -197,7 +471,7 @@ func testPutGetAndDeleteCollection(t *testing.T, userID string, user interface{}
 		t.Error(delErr)
 		return
 	}
-	if _, getErr := c.Get(userID, nil); getErr != ErrNotFound {
+	if getErr := c.Get(userID, nil); getErr != ErrNotFound {
 		t.Errorf("No error but the ID has been deleted")
 		return
 	}
@@ -226,7 +500,7 @@ func testPutGetAndDeleteCollectionFillupTestAndClose(ctx context.Context, testPa
 		retrievedTestUser := struct {
 			Login, Pass string
 		}{}
-		if _, getErr := c.Get(userID, &retrievedTestUser); getErr != nil {
+		if getErr := c.Get(userID, &retrievedTestUser); getErr != nil {
 			t.Error(getErr)
 			return false
 		}
@@ -235,7 +509,7 @@ func testPutGetAndDeleteCollectionFillupTestAndClose(ctx context.Context, testPa
 			return false
 		}
 	} else {
-		retrieveContent, getErr := c.Get(userID, nil)
+		retrieveContent, getErr := c.GetRaw(userID)
 		if getErr != nil {
 			t.Error(getErr)
 			return false
@@ -315,3 +589,301 @@ func TestBackup(t *testing.T) {
 		return
 	}
 }
+
+func TestEncryptedBackup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	db.options.BackupKey = key
+
+	path := fmt.Sprintf("%s/encryptedBackupTest.zip", os.TempDir())
+	defer os.RemoveAll(path)
+	if err := db.Backup(path, 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	restoredDBPath := <-getTestPathChan
+	defer os.RemoveAll(restoredDBPath)
+	db2Conf := NewDefaultOptions(restoredDBPath)
+	db2Conf.TransactionTimeOut = time.Second * 100
+	db2, openErr := Open(ctx, db2Conf)
+	if openErr != nil {
+		t.Error(openErr)
+		return
+	}
+	defer db2.Close()
+
+	if err := db2.Load(path); err != ErrMissingKey {
+		t.Errorf("expected %v but had %v", ErrMissingKey, err)
+		return
+	}
+
+	db2.options.BackupKey = key
+	if err := db2.Load(path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	collection, getColErr := db2.Use("testCol")
+	if getColErr != nil {
+		t.Error(getColErr)
+		return
+	}
+
+	user := new(User)
+	if err := collection.Get("9", user); err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Email != "witt-77@clayton.com" {
+		t.Errorf("restored user has email %q instead of %q", user.Email, "witt-77@clayton.com")
+		return
+	}
+}
+
+func TestWriteBackup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	if err := c.Delete(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The writes continue during the backup
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i, user := range users[1:] {
+			user.Balance = i
+			c.Put(user.ID, user)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/writeBackupTest.zip", os.TempDir())
+	defer os.RemoveAll(path)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = db.WriteBackup(ctx, file)
+	file.Close()
+	<-done
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	canceledCtx, cancelBackup := context.WithCancel(ctx)
+	cancelBackup()
+	if err := db.WriteBackup(canceledCtx, ioutil.Discard); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+
+	restoredDBPath := <-getTestPathChan
+	defer os.RemoveAll(restoredDBPath)
+	db2, openErr := Open(ctx, NewDefaultOptions(restoredDBPath))
+	if openErr != nil {
+		t.Error(openErr)
+		return
+	}
+	defer db2.Close()
+
+	if err := db2.Load(path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	collection, _ := db2.Use("testCol")
+	response, queryErr := collection.Query(NewQuery().SetFilter(NewFilter(Equal).CompareTo(users[9].Email).SetSelector("Email")))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if id, _ := response.One(new(User)); id != users[9].ID {
+		t.Errorf("expected ID %q but had %q", users[9].ID, id)
+		return
+	}
+	// The removed documents do not come back with their previous versions
+	if err := collection.Get(users[0].ID, nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+}
+
+func TestReadBackup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	backup := bytes.NewBuffer(nil)
+	if err := db.WriteBackup(ctx, backup); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A corrupted archive is not loaded
+	corrupted := append([]byte{}, backup.Bytes()...)
+	corrupted[len(corrupted)/3] ^= 0xff
+
+	corruptedPath := <-getTestPathChan
+	defer os.RemoveAll(corruptedPath)
+	db2, openErr := Open(ctx, NewDefaultOptions(corruptedPath))
+	if openErr != nil {
+		t.Error(openErr)
+		return
+	}
+	defer db2.Close()
+	if err := db2.ReadBackup(ctx, bytes.NewReader(corrupted)); err == nil {
+		t.Errorf("the corrupted archive must not be loaded")
+		return
+	}
+	if len(db2.collections) != 0 {
+		t.Errorf("the corrupted archive has been partially loaded")
+		return
+	}
+
+	restoredDBPath := <-getTestPathChan
+	defer os.RemoveAll(restoredDBPath)
+	options := NewDefaultOptions(restoredDBPath)
+	options.Restore = backup
+	var loaded, total int64
+	options.LoadProgress = func(l, t int64) {
+		loaded, total = l, t
+	}
+	db3, openErr := Open(ctx, options)
+	if openErr != nil {
+		t.Error(openErr)
+		return
+	}
+	defer db3.Close()
+
+	if total == 0 || loaded != total {
+		t.Errorf("the progress ended at %d on %d", loaded, total)
+		return
+	}
+
+	collection, _ := db3.Use("testCol")
+	user := new(User)
+	if err := collection.Get(users[9].ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Email != users[9].Email {
+		t.Errorf("restored user has email %q instead of %q", user.Email, users[9].Email)
+		return
+	}
+}
+
+func TestBackupSince(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	full := bytes.NewBuffer(nil)
+	version, err := db.BackupSince(ctx, full, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	c, _ := db.Use("testCol")
+	updated := *users[9]
+	updated.Email = "updated@clayton.com"
+	if err := c.Put(updated.ID, &updated); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Delete(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+
+	incremental := bytes.NewBuffer(nil)
+	nextVersion, err := db.BackupSince(ctx, incremental, version)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if nextVersion <= version {
+		t.Errorf("the version %d must be after %d", nextVersion, version)
+		return
+	}
+	if incremental.Len() >= full.Len() {
+		t.Errorf("the incremental backup is not smaller than the full one: %d and %d", incremental.Len(), full.Len())
+		return
+	}
+
+	restoredDBPath := <-getTestPathChan
+	defer os.RemoveAll(restoredDBPath)
+	db2, openErr := Open(ctx, NewDefaultOptions(restoredDBPath))
+	if openErr != nil {
+		t.Error(openErr)
+		return
+	}
+	defer db2.Close()
+
+	for _, archive := range []*bytes.Buffer{full, incremental} {
+		if err := db2.ReadBackup(ctx, archive); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	collection, _ := db2.Use("testCol")
+	response, queryErr := collection.Query(NewQuery().SetFilter(NewFilter(Equal).CompareTo(updated.Email).SetSelector("Email")))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if id, _ := response.One(new(User)); id != updated.ID {
+		t.Errorf("expected ID %q but had %q", updated.ID, id)
+		return
+	}
+
+	// The removal of the increment is loaded too
+	if err := collection.Get(users[0].ID, nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+	response, queryErr = collection.Query(NewQuery().SetFilter(NewFilter(Equal).CompareTo(users[0].Email).SetSelector("Email")))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if response.Len() != 0 {
+		t.Errorf("the removed document is still indexed")
+		return
+	}
+}
@@ -0,0 +1,91 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+)
+
+// StartMaintenance sets the collection in maintenance mode until Maintenance.End is called.
+// The writes done with the collection are queued or rejected with a *MaintenanceError
+// depending on the options, the writes done with the returned Maintenance are not blocked.
+// The options can be nil, in this case the writes are rejected.
+func (c *Collection) StartMaintenance(options *MaintenanceOptions) (*Maintenance, error) {
+	if options == nil {
+		options = new(MaintenanceOptions)
+	}
+
+	c.maintenanceMutex.Lock()
+	defer c.maintenanceMutex.Unlock()
+
+	if c.maintenance != nil {
+		return nil, ErrMaintenanceOngoing
+	}
+
+	c.maintenance = &Maintenance{
+		c:       c,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	return c.maintenance, nil
+}
+
+// Put saves the content without waiting for the end of the maintenance
+func (m *Maintenance) Put(id string, content interface{}) error {
+	ctx, cancel := context.WithTimeout(m.c.ctx, m.c.options.TransactionTimeOut)
+	defer cancel()
+
+	return m.c.put(ctx, id, content, nil)
+}
+
+// Delete removes the content without waiting for the end of the maintenance
+func (m *Maintenance) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.c.options.TransactionTimeOut)
+	defer cancel()
+
+	return m.c.delete(ctx, id)
+}
+
+// End stops the maintenance mode and releases the queued writes
+func (m *Maintenance) End() {
+	m.c.maintenanceMutex.Lock()
+	defer m.c.maintenanceMutex.Unlock()
+
+	if m.c.maintenance != m {
+		return
+	}
+
+	m.c.maintenance = nil
+	close(m.done)
+}
+
+// waitForMaintenance returns immediately if the collection is not in maintenance.
+// Otherwise it returns a *MaintenanceError or waits for the end of the maintenance.
+func (c *Collection) waitForMaintenance(ctx context.Context) error {
+	c.maintenanceMutex.RLock()
+	maintenance := c.maintenance
+	c.maintenanceMutex.RUnlock()
+
+	if maintenance == nil {
+		return nil
+	}
+
+	maintenanceErr := &MaintenanceError{
+		Collection: c.name,
+		RetryAfter: maintenance.options.RetryAfter,
+	}
+
+	if !maintenance.options.Queue {
+		return maintenanceErr
+	}
+
+	select {
+	case <-maintenance.done:
+		return nil
+	case <-ctx.Done():
+		return maintenanceErr
+	}
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("collection %q is in maintenance, retry after %s", e.Collection, e.RetryAfter)
+}
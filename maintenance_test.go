@@ -0,0 +1,91 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	maintenance, err := c.StartMaintenance(&MaintenanceOptions{RetryAfter: time.Minute})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := c.StartMaintenance(nil); err != ErrMaintenanceOngoing {
+		t.Errorf("expected %v but had %v", ErrMaintenanceOngoing, err)
+		return
+	}
+
+	// The writes are rejected with the retry information
+	err = c.Put("id", &User{ID: "id"})
+	if maintenanceErr, ok := err.(*MaintenanceError); !ok || maintenanceErr.RetryAfter != time.Minute {
+		t.Errorf("expected a maintenance error but had %v", err)
+		return
+	}
+	if _, ok := c.Delete("id").(*MaintenanceError); !ok {
+		t.Errorf("the delete must be rejected during the maintenance")
+		return
+	}
+
+	// The maintenance job can still write
+	if err := maintenance.Put("id", &User{ID: "id"}); err != nil {
+		t.Error(err)
+		return
+	}
+	maintenance.End()
+
+	if err := c.Put("id", &User{ID: "id", Email: "after"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// In queue mode the writes wait for the end of the maintenance
+	maintenance, err = c.StartMaintenance(&MaintenanceOptions{Queue: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Put("id", &User{ID: "id", Email: "queued"})
+	}()
+
+	select {
+	case err := <-done:
+		t.Errorf("the write must wait for the end of the maintenance but returned %v", err)
+		return
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	maintenance.Put("id", &User{ID: "id", Email: "migrated"})
+	maintenance.End()
+
+	if err := <-done; err != nil {
+		t.Error(err)
+		return
+	}
+
+	user := new(User)
+	c.Get("id", user)
+	if user.Email != "queued" {
+		t.Errorf("the queued write must be done after the maintenance but the email is %q", user.Email)
+		return
+	}
+}
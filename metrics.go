@@ -0,0 +1,66 @@
+package gotinydb
+
+import (
+	"time"
+)
+
+// Those define the operations counted by the metrics
+const (
+	metricPut    = "put"
+	metricGet    = "get"
+	metricDelete = "delete"
+)
+
+// newMetrics opens the metrics of the options for the database
+func newMetrics(d *DB, m Metrics) (*metrics, error) {
+	if err := m.Open(d.options.Path); err != nil {
+		return nil, err
+	}
+	return &metrics{m: m}, nil
+}
+
+// unregister closes the metrics when the database is closed
+func (m *metrics) unregister() {
+	if m == nil {
+		return
+	}
+	m.m.Close()
+}
+
+// count adds n operations of the collection
+func (m *metrics) count(collection, operation string, n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.m.CountOperations(collection, operation, n)
+}
+
+func (m *metrics) observeQuery(collection string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.m.ObserveQuery(collection, time.Since(start))
+}
+
+func (m *metrics) observeIndexScan(collection, index string, length int) {
+	if m == nil {
+		return
+	}
+	m.m.ObserveIndexScan(collection, index, length)
+}
+
+// countRetries returns fn counting its calls after the first one as retries
+func (m *metrics) countRetries(fn func() error) func() error {
+	if m == nil {
+		return fn
+	}
+
+	first := true
+	return func() error {
+		if !first {
+			m.m.CountRetry()
+		}
+		first = false
+		return fn()
+	}
+}
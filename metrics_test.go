@@ -0,0 +1,139 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetrics counts the measures it receives
+type testMetrics struct {
+	mutex      sync.Mutex
+	opened     bool
+	operations map[string]int
+	queries    int
+	indexScans int
+}
+
+func (m *testMetrics) Open(path string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.opened {
+		return fmt.Errorf("the metrics are already used")
+	}
+	m.opened = true
+	m.operations = map[string]int{}
+	return nil
+}
+
+func (m *testMetrics) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.opened = false
+}
+
+func (m *testMetrics) CountOperations(collection, operation string, n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.operations[collection+" "+operation] += n
+}
+
+func (m *testMetrics) ObserveQuery(collection string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queries++
+}
+
+func (m *testMetrics) ObserveIndexScan(collection, index string, length int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.indexScans++
+}
+
+func (m *testMetrics) CountRetry() {}
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	recorder := new(testMetrics)
+	options := NewDefaultOptions(testPath)
+	options.Metrics = recorder
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("email", StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users[:5] {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	batch := c.NewBatch()
+	for _, user := range users[5:] {
+		batch.Put(user.ID, user)
+	}
+	batch.Delete(users[0].ID)
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Delete(users[1].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Get(users[2].ID, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[3].Email))); err != nil {
+		t.Error(err)
+		return
+	}
+
+	recorder.mutex.Lock()
+	for operation, expected := range map[string]int{metricPut: 10, metricDelete: 2, metricGet: 1} {
+		if value := recorder.operations["testCol "+operation]; value != expected {
+			t.Errorf("expected %v %s but had %v", expected, operation, value)
+		}
+	}
+	if recorder.queries != 1 || recorder.indexScans != 1 {
+		t.Errorf("expected 1 query and 1 index scan but had %d and %d", recorder.queries, recorder.indexScans)
+	}
+	recorder.mutex.Unlock()
+
+	// The metrics are closed by Close and opened again by Open
+	db.Close()
+	options.Path = testPath
+	db, openDBErr = Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	other := NewDefaultOptions(testPath + "_second")
+	defer os.RemoveAll(testPath + "_second")
+	other.Metrics = recorder
+	if _, err := Open(ctx, other); err == nil {
+		t.Errorf("the error of the metrics must fail the opening")
+		return
+	}
+}
@@ -0,0 +1,276 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// NewMsgpackCodec returns a codec saving the documents in the MessagePack format.
+// The documents are encoded from their Go values and read back as the JSON of
+// encoding/json, so the indexes and the queries are not changed.
+// The contents which are not JSON or which would not be read back exactly are saved as they are.
+func NewMsgpackCodec() Codec {
+	return &msgpackCodec{}
+}
+
+func (m *msgpackCodec) ID() byte {
+	return MsgpackCodecID
+}
+
+func (m *msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (m *msgpackCodec) EncodeValue(value interface{}) ([]byte, error) {
+	w := &msgpackWriter{buffer: bytes.NewBuffer(nil)}
+	if err := writeDocument(w, value); err != nil {
+		return nil, err
+	}
+	return w.buffer.Bytes(), nil
+}
+
+func (m *msgpackCodec) Encode(content []byte) ([]byte, error) {
+	w := &msgpackWriter{buffer: bytes.NewBuffer(nil)}
+	if err := encodeDocument(w, content); err != nil {
+		return nil, err
+	}
+	return w.buffer.Bytes(), nil
+}
+
+func (m *msgpackCodec) Decode(encoded []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := decodeMsgpackValue(buffer, bytes.NewReader(encoded)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (m *msgpackWriter) writeNull() {
+	m.buffer.WriteByte(0xc0)
+}
+
+func (m *msgpackWriter) writeBool(value bool) {
+	if value {
+		m.buffer.WriteByte(0xc3)
+	} else {
+		m.buffer.WriteByte(0xc2)
+	}
+}
+
+func (m *msgpackWriter) writeInt(i int64) error {
+	w := m.buffer
+	switch {
+	case i >= -32 && i <= math.MaxInt8:
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		w.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		w.WriteByte(0xd1)
+		binary.Write(w, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		w.WriteByte(0xd2)
+		binary.Write(w, binary.BigEndian, int32(i))
+	default:
+		w.WriteByte(0xd3)
+		binary.Write(w, binary.BigEndian, i)
+	}
+	return nil
+}
+
+func (m *msgpackWriter) writeUint(u uint64) error {
+	if u <= math.MaxInt64 {
+		return m.writeInt(int64(u))
+	}
+	m.buffer.WriteByte(0xcf)
+	return binary.Write(m.buffer, binary.BigEndian, u)
+}
+
+// writeFloat writes the floats marshaled as integers by encoding/json as integers, they are shorter
+func (m *msgpackWriter) writeFloat(f float64, bits int) error {
+	if bits == 32 {
+		m.buffer.WriteByte(0xca)
+		return binary.Write(m.buffer, binary.BigEndian, float32(f))
+	}
+	if isJSONInteger(f) {
+		return m.writeInt(int64(f))
+	}
+	m.buffer.WriteByte(0xcb)
+	return binary.Write(m.buffer, binary.BigEndian, f)
+}
+
+func (m *msgpackWriter) writeString(s string) {
+	w := m.buffer
+	switch {
+	case len(s) < 32:
+		w.WriteByte(0xa0 | byte(len(s)))
+	case len(s) <= math.MaxUint8:
+		w.Write([]byte{0xd9, byte(len(s))})
+	case len(s) <= math.MaxUint16:
+		w.WriteByte(0xda)
+		binary.Write(w, binary.BigEndian, uint16(len(s)))
+	default:
+		w.WriteByte(0xdb)
+		binary.Write(w, binary.BigEndian, uint32(len(s)))
+	}
+	w.WriteString(s)
+}
+
+func (m *msgpackWriter) writeKey(key string) {
+	m.writeString(key)
+}
+
+func (m *msgpackWriter) openMap(n int) {
+	m.writeHeader(0x80, 0xde, n)
+}
+
+func (m *msgpackWriter) openArray(n int) {
+	m.writeHeader(0x90, 0xdc, n)
+}
+
+func (m *msgpackWriter) close() {}
+
+// writeHeader writes the header of a map or an array,
+// the 32 bits size type follows the 16 bits one
+func (m *msgpackWriter) writeHeader(fixType, type16 byte, n int) {
+	w := m.buffer
+	switch {
+	case n < 16:
+		w.WriteByte(fixType | byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(type16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(type16 + 1)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+// decodeMsgpackValue reads the next value of r and writes it as JSON
+func decodeMsgpackValue(w *bytes.Buffer, r *bytes.Reader) error {
+	t, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case t <= 0x7f:
+		w.WriteString(strconv.Itoa(int(t)))
+		return nil
+	case t >= 0xe0:
+		w.WriteString(strconv.Itoa(int(int8(t))))
+		return nil
+	case t&0xe0 == 0xa0:
+		return decodeMsgpackString(w, r, int(t&0x1f))
+	case t&0xf0 == 0x80:
+		return decodeMsgpackContainer(w, r, true, int(t&0x0f))
+	case t&0xf0 == 0x90:
+		return decodeMsgpackContainer(w, r, false, int(t&0x0f))
+	}
+
+	switch t {
+	case 0xc0:
+		w.WriteString("null")
+	case 0xc2:
+		w.WriteString("false")
+	case 0xc3:
+		w.WriteString("true")
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readBigEndianUint(r, 1<<(t-0xcc))
+		if err != nil {
+			return err
+		}
+		w.WriteString(strconv.FormatUint(n, 10))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (t - 0xd0)
+		n, err := readBigEndianUint(r, size)
+		if err != nil {
+			return err
+		}
+		// Extends the sign of the shorter integers
+		shift := uint(64 - 8*size)
+		w.WriteString(strconv.FormatInt(int64(n<<shift)>>shift, 10))
+	case 0xca, 0xcb:
+		size := 4
+		if t == 0xcb {
+			size = 8
+		}
+		n, err := readBigEndianUint(r, size)
+		if err != nil {
+			return err
+		}
+		// The floats are written with their precision like encoding/json does
+		if size == 4 {
+			w.WriteString(formatJSONFloat(float64(math.Float32frombits(uint32(n))), 32))
+		} else {
+			w.WriteString(formatJSONFloat(math.Float64frombits(n), 64))
+		}
+	case 0xd9, 0xda, 0xdb:
+		n, err := readBigEndianUint(r, 1<<(t-0xd9))
+		if err != nil {
+			return err
+		}
+		return decodeMsgpackString(w, r, int(n))
+	case 0xdc, 0xdd, 0xde, 0xdf:
+		n, err := readBigEndianUint(r, 2<<((t-0xdc)%2))
+		if err != nil {
+			return err
+		}
+		return decodeMsgpackContainer(w, r, t >= 0xde, int(n))
+	default:
+		return fmt.Errorf("unsupported MessagePack type 0x%x", t)
+	}
+	return nil
+}
+
+func decodeMsgpackString(w *bytes.Buffer, r *bytes.Reader, n int) error {
+	if n > r.Len() {
+		return io.ErrUnexpectedEOF
+	}
+	s := make([]byte, n)
+	r.Read(s)
+
+	asBytes, err := json.Marshal(string(s))
+	if err != nil {
+		return err
+	}
+	w.Write(asBytes)
+	return nil
+}
+
+func decodeMsgpackContainer(w *bytes.Buffer, r *bytes.Reader, isMap bool, n int) error {
+	open, end := byte('['), byte(']')
+	if isMap {
+		open, end = '{', '}'
+	}
+
+	w.WriteByte(open)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if isMap {
+			if err := decodeMsgpackValue(w, r); err != nil {
+				return err
+			}
+			w.WriteByte(':')
+		}
+		if err := decodeMsgpackValue(w, r); err != nil {
+			return err
+		}
+	}
+	w.WriteByte(end)
+	return nil
+}
+
+func readBigEndianUint(r *bytes.Reader, size int) (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
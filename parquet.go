@@ -0,0 +1,424 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+)
+
+// ExportParquet writes the documents of the collection as a Parquet file.
+// The first column "_id" holds the IDs and the other ones the values of the given
+// selectors, or of the selectors of the indexes if no column is given.
+// The type of a column is the type of the index with the same selector:
+// IntIndex values are saved as INT64, TimeIndex values as INT64 TIMESTAMP_MILLIS
+// and the other ones as UTF8 strings. The missing values are null. ErrWrongType is
+// returned if a value of an IntIndex column is a number with a fractional part.
+// The rules of SetAnonymization are applied to the documents and the columns
+// of hashed values are strings. The documents which are not JSON are not exported.
+// The file is not compressed and has one row group every ParquetRowGroupSize documents.
+func (c *Collection) ExportParquet(w io.Writer, columns ...*Column) error {
+	if len(columns) == 0 {
+		for _, index := range c.indexes {
+			if index.Extractor {
+				continue
+			}
+			columns = append(columns, &Column{Name: index.Name, Selector: index.Selector})
+		}
+	}
+
+	pw := &parquetWriter{w: w, columns: []*parquetColumnWriter{{name: "_id", required: true}}}
+	for _, column := range columns {
+		columnWriter := &parquetColumnWriter{name: column.Name, selector: column.Selector}
+		selectorHash := buildSelectorHash(column.Selector)
+		for _, index := range c.indexes {
+			if !index.Extractor && index.SelectorHash == selectorHash {
+				columnWriter.kind = index.Type
+				break
+			}
+		}
+		if c.anonymizationOf(column.Selector) == AnonymizeHash {
+			columnWriter.kind = StringIndex
+		}
+		pw.columns = append(pw.columns, columnWriter)
+	}
+
+	if err := pw.write([]byte("PAR1")); err != nil {
+		return err
+	}
+
+	iter := c.Iterate(IterOptions{})
+	defer iter.Close()
+	for iter.Next() {
+		contentAsBytes, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		object, decodeErr := decodeStored(contentAsBytes)
+		if decodeErr != nil {
+			continue
+		}
+
+		if err := c.anonymize(object); err != nil {
+			return err
+		}
+		if err := pw.addRow(iter.ID(), object); err != nil {
+			return err
+		}
+		if pw.rows >= ParquetRowGroupSize {
+			if err := pw.flushRowGroup(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+	return pw.writeFooter()
+}
+
+// Those are the values of the Parquet format used by the export
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+type (
+	// parquetWriter writes the row groups and keeps their metadata for the footer
+	parquetWriter struct {
+		w       io.Writer
+		offset  int64
+		columns []*parquetColumnWriter
+
+		rows      int
+		totalRows int64
+		rowGroups [][]byte
+	}
+
+	// parquetColumnWriter keeps the values of the column for the current row group
+	parquetColumnWriter struct {
+		name     string
+		selector []string
+		kind     IndexType
+		required bool
+
+		values  bytes.Buffer
+		defined []bool
+	}
+
+	// thriftWriter encodes the Parquet metadata with the Thrift compact protocol
+	thriftWriter struct {
+		buf       bytes.Buffer
+		lastField []int16
+	}
+)
+
+func (pw *parquetWriter) write(p []byte) error {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) addRow(id string, object map[string]interface{}) error {
+	pw.rows++
+	pw.columns[0].addString(id)
+
+	for _, column := range pw.columns[1:] {
+		value, ok := getValueFromSelector(object, column.selector)
+		if !ok || value == nil {
+			column.defined = append(column.defined, false)
+			continue
+		}
+
+		switch column.kind {
+		case IntIndex:
+			number, _ := value.(json.Number)
+			asInt, err := number.Int64()
+			if err != nil {
+				asFloat, floatErr := number.Float64()
+				if floatErr != nil {
+					column.defined = append(column.defined, false)
+					continue
+				}
+				// The floats would be truncated
+				if asFloat != math.Trunc(asFloat) || asFloat < math.MinInt64 || asFloat >= math.MaxInt64 {
+					return ErrWrongType
+				}
+				asInt = int64(asFloat)
+			}
+			column.addInt64(asInt)
+		case TimeIndex:
+			asString, _ := value.(string)
+			t, err := time.Parse(time.RFC3339Nano, asString)
+			if err != nil {
+				column.defined = append(column.defined, false)
+				continue
+			}
+			column.addInt64(t.UnixNano() / int64(time.Millisecond))
+		default:
+			if asString, ok := value.(string); ok {
+				column.addString(asString)
+			} else {
+				asBytes, _ := json.Marshal(value)
+				column.addString(string(asBytes))
+			}
+		}
+	}
+	return nil
+}
+
+func (column *parquetColumnWriter) addString(value string) {
+	binary.Write(&column.values, binary.LittleEndian, uint32(len(value)))
+	column.values.WriteString(value)
+	column.defined = append(column.defined, true)
+}
+
+func (column *parquetColumnWriter) addInt64(value int64) {
+	binary.Write(&column.values, binary.LittleEndian, value)
+	column.defined = append(column.defined, true)
+}
+
+func (column *parquetColumnWriter) physicalType() int32 {
+	if column.kind == IntIndex || column.kind == TimeIndex {
+		return parquetInt64
+	}
+	return parquetByteArray
+}
+
+// page returns the data of the page: the definition levels of the optional
+// columns followed by the plain values
+func (column *parquetColumnWriter) page() []byte {
+	page := new(bytes.Buffer)
+	if !column.required {
+		// One bit-packed run of the RLE hybrid encoding with a bit width of 1
+		groups := (len(column.defined) + 7) / 8
+		levels := new(bytes.Buffer)
+		levels.Write(uvarint(uint64(groups<<1 | 1)))
+		packed := make([]byte, groups)
+		for i, defined := range column.defined {
+			if defined {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		levels.Write(packed)
+
+		binary.Write(page, binary.LittleEndian, uint32(levels.Len()))
+		page.Write(levels.Bytes())
+	}
+	page.Write(column.values.Bytes())
+	return page.Bytes()
+}
+
+// flushRowGroup writes one page per column and saves the metadata of the row group
+func (pw *parquetWriter) flushRowGroup() error {
+	if pw.rows == 0 {
+		return nil
+	}
+
+	rowGroup := new(thriftWriter)
+	rowGroup.listBegin(1, 12, len(pw.columns))
+	var totalSize int64
+	for _, column := range pw.columns {
+		page := column.page()
+
+		header := new(thriftWriter)
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structBegin(5)
+		header.i32(1, int32(len(column.defined)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.structEnd()
+		header.stop()
+
+		pageOffset := pw.offset
+		if err := pw.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(page); err != nil {
+			return err
+		}
+		chunkSize := int64(header.buf.Len() + len(page))
+		totalSize += chunkSize
+
+		rowGroup.listStructBegin()
+		rowGroup.i64(2, pageOffset)
+		rowGroup.structBegin(3)
+		rowGroup.i32(1, column.physicalType())
+		rowGroup.listBegin(2, 5, 2)
+		rowGroup.listI32(parquetPlain)
+		rowGroup.listI32(parquetRLE)
+		rowGroup.listBegin(3, 8, 1)
+		rowGroup.listBinary([]byte(column.name))
+		rowGroup.i32(4, 0) // UNCOMPRESSED
+		rowGroup.i64(5, int64(len(column.defined)))
+		rowGroup.i64(6, chunkSize)
+		rowGroup.i64(7, chunkSize)
+		rowGroup.i64(9, pageOffset)
+		rowGroup.structEnd()
+		rowGroup.structEnd()
+
+		column.values.Reset()
+		column.defined = nil
+	}
+	rowGroup.i64(2, totalSize)
+	rowGroup.i64(3, int64(pw.rows))
+
+	pw.rowGroups = append(pw.rowGroups, rowGroup.buf.Bytes())
+	pw.totalRows += int64(pw.rows)
+	pw.rows = 0
+	return nil
+}
+
+// writeFooter writes the file metadata, its length and the magic number
+func (pw *parquetWriter) writeFooter() error {
+	meta := new(thriftWriter)
+	meta.i32(1, 1)
+
+	meta.listBegin(2, 12, len(pw.columns)+1)
+	meta.listStructBegin()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(pw.columns)))
+	meta.structEnd()
+	for _, column := range pw.columns {
+		meta.listStructBegin()
+		meta.i32(1, column.physicalType())
+		if column.required {
+			meta.i32(3, parquetRequired)
+		} else {
+			meta.i32(3, parquetOptional)
+		}
+		meta.binary(4, []byte(column.name))
+		switch column.kind {
+		case IntIndex:
+		case TimeIndex:
+			meta.i32(6, parquetTimestampMillis)
+		default:
+			meta.i32(6, parquetUTF8)
+		}
+		meta.structEnd()
+	}
+
+	meta.i64(3, pw.totalRows)
+
+	meta.listBegin(4, 12, len(pw.rowGroups))
+	for _, rowGroup := range pw.rowGroups {
+		// The row groups are encoded as structs of the list without their stop byte
+		meta.buf.Write(rowGroup)
+		meta.buf.WriteByte(0)
+	}
+	meta.binary(6, []byte("gotinydb"))
+	meta.stop()
+
+	if err := pw.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(meta.buf.Len()))
+	if err := pw.write(length); err != nil {
+		return err
+	}
+	return pw.write([]byte("PAR1"))
+}
+
+func (t *thriftWriter) last() int16 {
+	if len(t.lastField) == 0 {
+		return 0
+	}
+	return t.lastField[len(t.lastField)-1]
+}
+
+func (t *thriftWriter) setLast(id int16) {
+	if len(t.lastField) == 0 {
+		t.lastField = []int16{id}
+		return
+	}
+	t.lastField[len(t.lastField)-1] = id
+}
+
+func (t *thriftWriter) fieldHeader(id int16, thriftType byte) {
+	if delta := id - t.last(); delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | thriftType)
+	} else {
+		t.buf.WriteByte(thriftType)
+		t.buf.Write(uvarint(zigzag(int64(id))))
+	}
+	t.setLast(id)
+}
+
+func (t *thriftWriter) i32(id int16, value int32) {
+	t.fieldHeader(id, 5)
+	t.buf.Write(uvarint(zigzag(int64(value))))
+}
+
+func (t *thriftWriter) i64(id int16, value int64) {
+	t.fieldHeader(id, 6)
+	t.buf.Write(uvarint(zigzag(value)))
+}
+
+func (t *thriftWriter) binary(id int16, value []byte) {
+	t.fieldHeader(id, 8)
+	t.buf.Write(uvarint(uint64(len(value))))
+	t.buf.Write(value)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.fieldHeader(id, 12)
+	t.lastField = append(t.lastField, 0)
+}
+
+// listStructBegin starts a struct element of a list
+func (t *thriftWriter) listStructBegin() {
+	t.lastField = append(t.lastField, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.stop()
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.fieldHeader(id, 9)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.buf.Write(uvarint(uint64(size)))
+}
+
+func (t *thriftWriter) listI32(value int32) {
+	t.buf.Write(uvarint(zigzag(int64(value))))
+}
+
+func (t *thriftWriter) listBinary(value []byte) {
+	t.buf.Write(uvarint(uint64(len(value))))
+	t.buf.Write(value)
+}
+
+func zigzag(n int64) uint64 {
+	return uint64(n<<1) ^ uint64(n>>63)
+}
+
+func uvarint(n uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, n)]
+}
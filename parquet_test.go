@@ -0,0 +1,283 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExportParquet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:20]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// Without the field of the column
+	if err := c.Put("noAge", map[string]string{"Email": "no@age.com"}); err != nil {
+		t.Error(err)
+		return
+	}
+	// Not exported
+	if err := c.Put("bin", []byte{1, 2, 3}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	defaultRowGroupSize := ParquetRowGroupSize
+	ParquetRowGroupSize = 8
+	defer func() { ParquetRowGroupSize = defaultRowGroupSize }()
+
+	buf := new(bytes.Buffer)
+	if err := c.ExportParquet(buf, &Column{Name: "age", Selector: []string{"Age"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	file := buf.Bytes()
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Errorf("the file does not have the magic number")
+		return
+	}
+	metaLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := newThriftReader(file[len(file)-8-metaLength : len(file)-8]).readStruct()
+
+	if meta[3].(int64) != 21 {
+		t.Errorf("expected 21 rows but had %d", meta[3])
+		return
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != 3 || string(schema[2].(map[int16]interface{})[4].([]byte)) != "age" {
+		t.Errorf("unexpected schema %v", schema)
+		return
+	}
+
+	// Reads the columns of all the row groups
+	ids := []string{}
+	ages := []interface{}{}
+	for _, rowGroup := range meta[4].([]interface{}) {
+		columns := rowGroup.(map[int16]interface{})[1].([]interface{})
+
+		idMeta := columns[0].(map[int16]interface{})[3].(map[int16]interface{})
+		rows := int(idMeta[5].(int64))
+		page := readParquetPage(file, idMeta[9].(int64))
+		for i := 0; i < rows; i++ {
+			length := int(binary.LittleEndian.Uint32(page))
+			ids = append(ids, string(page[4:4+length]))
+			page = page[4+length:]
+		}
+
+		ageMeta := columns[1].(map[int16]interface{})[3].(map[int16]interface{})
+		page = readParquetPage(file, ageMeta[9].(int64))
+		levelsLength := int(binary.LittleEndian.Uint32(page))
+		levels := page[4 : 4+levelsLength]
+		// Skips the header of the bit-packed run
+		_, n := binary.Uvarint(levels)
+		levels = levels[n:]
+		values := page[4+levelsLength:]
+		for i := 0; i < rows; i++ {
+			if levels[i/8]&(1<<uint(i%8)) == 0 {
+				ages = append(ages, nil)
+				continue
+			}
+			ages = append(ages, int64(binary.LittleEndian.Uint64(values)))
+			values = values[8:]
+		}
+	}
+
+	if len(ids) != 21 || len(meta[4].([]interface{})) != 3 {
+		t.Errorf("expected 21 rows in 3 row groups but had %d", len(ids))
+		return
+	}
+	for i, id := range ids {
+		if id == "noAge" {
+			if ages[i] != nil {
+				t.Errorf("the missing value must be null but had %v", ages[i])
+			}
+			continue
+		}
+
+		found := false
+		for _, user := range users {
+			if user.ID == id {
+				found = true
+				if ages[i] != int64(user.Age) {
+					t.Errorf("expected the age %d for %q but had %v", user.Age, id, ages[i])
+				}
+			}
+		}
+		if !found {
+			t.Errorf("unexpected ID %q", id)
+		}
+	}
+}
+
+// TestExportParquetGolden compares the export with testdata/export.parquet,
+// which has been read with an other Parquet implementation
+func TestExportParquetGolden(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:20]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := c.Put("noAge", map[string]string{"Email": "no@age.com"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	defaultRowGroupSize := ParquetRowGroupSize
+	ParquetRowGroupSize = 8
+	defer func() { ParquetRowGroupSize = defaultRowGroupSize }()
+
+	buf := new(bytes.Buffer)
+	if err := c.ExportParquet(buf, &Column{Name: "age", Selector: []string{"Age"}}, &Column{Name: "email", Selector: []string{"Email"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	golden, err := ioutil.ReadFile("testdata/export.parquet")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Errorf("the export is not the same as testdata/export.parquet")
+		return
+	}
+
+	// The floats of the IntIndex columns are not truncated
+	if err := c.Put("float", map[string]interface{}{"Age": 1.5}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.ExportParquet(new(bytes.Buffer), &Column{Name: "age", Selector: []string{"Age"}}); err != ErrWrongType {
+		t.Errorf("expected %v but had %v", ErrWrongType, err)
+	}
+}
+
+// readParquetPage returns the data of the page at the given offset
+func readParquetPage(file []byte, offset int64) []byte {
+	reader := newThriftReader(file[offset:])
+	header := reader.readStruct()
+	start := int(offset) + reader.pos
+	return file[start : start+int(header[3].(int32))]
+}
+
+// thriftReader decodes the Thrift compact protocol into maps of the field IDs
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func newThriftReader(buf []byte) *thriftReader {
+	return &thriftReader{buf: buf}
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	n, size := binary.Uvarint(r.buf[r.pos:])
+	r.pos += size
+	return n
+}
+
+func (r *thriftReader) varint() int64 {
+	n := r.uvarint()
+	return int64(n>>1) ^ -int64(n&1)
+}
+
+func (r *thriftReader) readValue(thriftType byte) interface{} {
+	switch thriftType {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 5:
+		return int32(r.varint())
+	case 6:
+		return r.varint()
+	case 8:
+		length := int(r.uvarint())
+		value := r.buf[r.pos : r.pos+length]
+		r.pos += length
+		return value
+	case 9:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.readValue(header & 0x0f)
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	ret := map[int16]interface{}{}
+	last := int16(0)
+	for {
+		header := r.byte()
+		if header == 0 {
+			return ret
+		}
+
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		ret[id] = r.readValue(header & 0x0f)
+	}
+}
@@ -0,0 +1,84 @@
+package gotinydb
+
+import (
+	"context"
+)
+
+// workerPool runs the background work of the database with a fixed number of goroutines
+type workerPool struct {
+	jobs chan func()
+}
+
+// newWorkerPool starts the workers, they stop when the context is done
+func newWorkerPool(ctx context.Context, options *WorkerPoolOptions) *workerPool {
+	size, queueSize := DefaultWorkerPoolSize, DefaultWorkerQueueSize
+	if options != nil {
+		if options.Size > 0 {
+			size = options.Size
+		}
+		if options.QueueSize > 0 {
+			queueSize = options.QueueSize
+		}
+	}
+
+	p := &workerPool{jobs: make(chan func(), queueSize)}
+	for i := 0; i < size; i++ {
+		go p.work(ctx)
+	}
+	return p
+}
+
+func (p *workerPool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.jobs:
+			job()
+		}
+	}
+}
+
+// submit queues the job. It waits while the queue is full and returns the error
+// of the context if it is done first.
+func (p *workerPool) submit(ctx context.Context, job func()) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// trySubmit queues the job if the queue has a free place and returns false otherwise
+func (p *workerPool) trySubmit(job func()) bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// run runs the job with the workers and returns its error. It returns the error of the
+// context if it is done before the job ends. The job runs in the calling goroutine
+// without pool, and must not wait for an other job of the pool.
+func (p *workerPool) run(ctx context.Context, job func() error) error {
+	if p == nil {
+		return job()
+	}
+
+	done := make(chan error, 1)
+	if err := p.submit(ctx, func() { done <- job() }); err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
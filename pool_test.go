@@ -0,0 +1,79 @@
+package gotinydb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := newWorkerPool(ctx, &WorkerPoolOptions{Size: 2, QueueSize: 1})
+
+	var running, maxRunning int32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		if err := p.submit(ctx, func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 5)
+			atomic.AddInt32(&running, -1)
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	wg.Wait()
+
+	if maxRunning != 2 {
+		t.Errorf("expected 2 jobs at the same time but had %d", maxRunning)
+		return
+	}
+
+	// The submit waits while the queue is full
+	block := make(chan struct{})
+	defer close(block)
+	for i := 0; i < 3; i++ {
+		p.submit(ctx, func() { <-block })
+	}
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Millisecond*20)
+	defer timeoutCancel()
+	if err := p.submit(timeoutCtx, func() {}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v but had %v", context.DeadlineExceeded, err)
+		return
+	}
+	if p.trySubmit(func() {}) {
+		t.Errorf("the job can't be queued when the queue is full")
+		return
+	}
+}
+
+func TestWorkerPoolRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := newWorkerPool(ctx, &WorkerPoolOptions{Size: 1, QueueSize: 1})
+	if err := p.run(ctx, func() error { return ErrNotFound }); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	// Without pool the job runs in the calling goroutine
+	var nilPool *workerPool
+	ran := false
+	if err := nilPool.run(ctx, func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("the job did not run: %v", err)
+		return
+	}
+}
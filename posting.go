@@ -0,0 +1,280 @@
+package gotinydb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+// The posting list of an indexed value is the list of the IDs which have this value.
+// It is saved into the index bucket as a JSON list. If the index has a MaxPostingSize
+// and the overflow strategy is OverflowSegment, the list saved into the index bucket
+// is the head of the posting list. When the head is full it is moved as a segment
+// into the "postings" bucket: postings/<index name>/<0 + storage key>/<sequence>.
+// A write only updates the head or one segment and not the full list.
+// If the database has an EncryptionKey the lists are sealed with the key they are saved under.
+
+// segmentsBucket returns the bucket of the segments of the given value or nil if it has none.
+// The bucket is created if create is true.
+func (i *indexType) segmentsBucket(tx *bolt.Tx, storageKey []byte, create bool) (*bolt.Bucket, error) {
+	// The bucket names can't be empty like the storage key of an empty string
+	name := append([]byte{0}, storageKey...)
+
+	if !create {
+		postings := tx.Bucket([]byte("postings"))
+		if postings == nil {
+			return nil, nil
+		}
+		indexPostings := postings.Bucket([]byte(i.Name))
+		if indexPostings == nil {
+			return nil, nil
+		}
+		return indexPostings.Bucket(name), nil
+	}
+
+	postings, err := tx.CreateBucketIfNotExists([]byte("postings"))
+	if err != nil {
+		return nil, err
+	}
+	indexPostings, err := postings.CreateBucketIfNotExists([]byte(i.Name))
+	if err != nil {
+		return nil, err
+	}
+	return indexPostings.CreateBucketIfNotExists(name)
+}
+
+// decodeIDs returns the list of IDs saved under the key
+func (i *indexType) decodeIDs(key, asBytes []byte) ([]string, error) {
+	ids := []string{}
+	if len(asBytes) == 0 {
+		return ids, nil
+	}
+
+	asBytes, err := i.cipher.open(key, asBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(asBytes, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// encodeIDs returns the list of IDs as it is saved under the key
+func (i *indexType) encodeIDs(key []byte, ids []string) ([]byte, error) {
+	asBytes, _ := json.Marshal(ids)
+	return i.cipher.seal(key, asBytes)
+}
+
+// segmentKey returns the key the segment is sealed with, the storage key of the value
+// and the sequence of the segment
+func segmentKey(storageKey, sequence []byte) []byte {
+	return append(append([]byte{}, storageKey...), sequence...)
+}
+
+// readPosting returns all the IDs of the posting list with the given head
+func (i *indexType) readPosting(tx *bolt.Tx, storageKey, headAsBytes []byte) ([]string, error) {
+	ids, err := i.decodeIDs(storageKey, headAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, _ := i.segmentsBucket(tx, storageKey, false)
+	if segments == nil {
+		return ids, nil
+	}
+
+	err = segments.ForEach(func(sequence, segmentAsBytes []byte) error {
+		segment, err := i.decodeIDs(segmentKey(storageKey, sequence), segmentAsBytes)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, segment...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// getPosting returns all the IDs of the posting list of the given value
+func (i *indexType) getPosting(tx *bolt.Tx, storageKey []byte) ([]string, error) {
+	head := tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name)).Get(storageKey)
+	return i.readPosting(tx, storageKey, head)
+}
+
+// postingAsBytes returns the full posting list as a JSON list.
+// The head is returned as is if the value has no segments and is not sealed.
+func (i *indexType) postingAsBytes(tx *bolt.Tx, storageKey, headAsBytes []byte) ([]byte, error) {
+	if segments, _ := i.segmentsBucket(tx, storageKey, false); segments == nil && i.cipher == nil {
+		return headAsBytes, nil
+	}
+
+	ids, err := i.readPosting(tx, storageKey, headAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ids)
+}
+
+// addToPosting adds the ID to the posting list of the value
+func (i *indexType) addToPosting(tx *bolt.Tx, storageKey []byte, id string) error {
+	indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name))
+
+	head, err := i.decodeIDs(storageKey, indexBucket.Get(storageKey))
+	if err != nil {
+		return err
+	}
+
+	if i.MaxPostingSize > 0 && len(head) >= i.MaxPostingSize {
+		if i.Overflow == OverflowReject {
+			return ErrPostingListFull
+		}
+
+		if err := i.addSegment(tx, storageKey, head); err != nil {
+			return err
+		}
+		head = []string{}
+	}
+
+	headAsBytes, err := i.encodeIDs(storageKey, append(head, id))
+	if err != nil {
+		return err
+	}
+	return indexBucket.Put(storageKey, headAsBytes)
+}
+
+// addSegment saves the given IDs as a new segment of the value
+func (i *indexType) addSegment(tx *bolt.Tx, storageKey []byte, ids []string) error {
+	segments, err := i.segmentsBucket(tx, storageKey, true)
+	if err != nil {
+		return err
+	}
+
+	sequence, err := segments.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+
+	segmentAsBytes, err := i.encodeIDs(segmentKey(storageKey, key), ids)
+	if err != nil {
+		return err
+	}
+	return segments.Put(key, segmentAsBytes)
+}
+
+// rmFromPosting removes the ID from the posting list of the value.
+// Only the head or the segment holding the ID is saved again.
+func (i *indexType) rmFromPosting(tx *bolt.Tx, storageKey []byte, id string) error {
+	indexBucket := tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name))
+
+	head, err := i.decodeIDs(storageKey, indexBucket.Get(storageKey))
+	if err != nil {
+		return err
+	}
+
+	if kept, found := removeString(head, id); found {
+		headAsBytes, err := i.encodeIDs(storageKey, kept)
+		if err != nil {
+			return err
+		}
+		return indexBucket.Put(storageKey, headAsBytes)
+	}
+
+	segments, _ := i.segmentsBucket(tx, storageKey, false)
+	if segments == nil {
+		return nil
+	}
+
+	cursor := segments.Cursor()
+	for key, segmentAsBytes := cursor.First(); key != nil; key, segmentAsBytes = cursor.Next() {
+		segment, err := i.decodeIDs(segmentKey(storageKey, key), segmentAsBytes)
+		if err != nil {
+			return err
+		}
+
+		kept, found := removeString(segment, id)
+		if !found {
+			continue
+		}
+		if len(kept) == 0 {
+			return cursor.Delete()
+		}
+		keptAsBytes, err := i.encodeIDs(segmentKey(storageKey, key), kept)
+		if err != nil {
+			return err
+		}
+		return segments.Put(append([]byte{}, key...), keptAsBytes)
+	}
+	return nil
+}
+
+// setPosting replaces the posting list of the value by the given IDs.
+// The IDs are split into segments if needed.
+func (i *indexType) setPosting(tx *bolt.Tx, storageKey []byte, ids []string) error {
+	if err := i.deleteSegments(tx, storageKey); err != nil {
+		return err
+	}
+
+	if i.MaxPostingSize > 0 && len(ids) > i.MaxPostingSize {
+		if i.Overflow == OverflowReject {
+			return ErrPostingListFull
+		}
+
+		for len(ids) > i.MaxPostingSize {
+			if err := i.addSegment(tx, storageKey, ids[:i.MaxPostingSize]); err != nil {
+				return err
+			}
+			ids = ids[i.MaxPostingSize:]
+		}
+	}
+
+	headAsBytes, err := i.encodeIDs(storageKey, ids)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name)).Put(storageKey, headAsBytes)
+}
+
+// deletePosting removes the value from the index
+func (i *indexType) deletePosting(tx *bolt.Tx, storageKey []byte) error {
+	if err := i.deleteSegments(tx, storageKey); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("indexes")).Bucket([]byte(i.Name)).Delete(storageKey)
+}
+
+func (i *indexType) deleteSegments(tx *bolt.Tx, storageKey []byte) error {
+	postings := tx.Bucket([]byte("postings"))
+	if postings == nil {
+		return nil
+	}
+	indexPostings := postings.Bucket([]byte(i.Name))
+	if indexPostings == nil {
+		return nil
+	}
+
+	err := indexPostings.DeleteBucket(append([]byte{0}, storageKey...))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+// deleteIndexPostings removes the segments of all the values of the index
+func deleteIndexPostings(tx *bolt.Tx, indexName string) error {
+	postings := tx.Bucket([]byte("postings"))
+	if postings == nil {
+		return nil
+	}
+
+	err := postings.DeleteBucket([]byte(indexName))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestPostingSegments(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndexWithOptions("age", IntIndex, &IndexOptions{MaxPostingSize: 3}, "Age"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, user := range users[:50] {
+		if err := c.Delete(user.ID); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	age := users[60].Age
+	expected := []string{}
+	for _, user := range users[50:] {
+		if user.Age == age {
+			expected = append(expected, user.ID)
+		}
+	}
+	if len(expected) <= 3 {
+		t.Errorf("the test needs more than 3 users with the same age")
+		return
+	}
+
+	// The values with more IDs than the limit have segments
+	err := c.db.View(func(tx *bolt.Tx) error {
+		index := c.getIndex("age")
+		key := index.storageKey(index.valueToBytes(&filterValue{Value: age, Type: IntIndex}))
+		if segments, _ := index.segmentsBucket(tx, key, false); segments == nil {
+			t.Errorf("the value has no segment")
+		}
+		if segmented, _ := index.readPosting(tx, key, nil); len(segmented) == 0 {
+			t.Errorf("the segments are empty")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Age").CompareTo(age)).SetLimits(1000, 1000))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	ids := []string{}
+	response.All(func(id string, _ []byte) error {
+		ids = append(ids, id)
+		return nil
+	})
+	sort.Strings(ids)
+	sort.Strings(expected)
+	if len(ids) != len(expected) {
+		t.Errorf("expected %v but had %v", expected, ids)
+		return
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Errorf("expected %v but had %v", expected, ids)
+			return
+		}
+	}
+
+	report, verifyErr := c.VerifyIndexes()
+	if verifyErr != nil {
+		t.Error(verifyErr)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the index is not valid: %+v", report)
+		return
+	}
+}
+
+func TestPostingReject(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndexWithOptions("city", StringIndex, &IndexOptions{MaxPostingSize: 2, Overflow: OverflowReject}, "Address", "City"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	user := unmarshalDataSet(dataSet1)[0]
+	for _, id := range []string{"a", "b"} {
+		if err := c.Put(id, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := c.Put("c", user); err != ErrPostingListFull {
+		t.Errorf("expected %v but had %v", ErrPostingListFull, err)
+		return
+	}
+	if err := c.Get("c", nil); err != ErrNotFound {
+		t.Errorf("the rejected document must not be saved but had %v", err)
+		return
+	}
+
+	// The IDs can be added again after a delete
+	if err := c.Delete("a"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Put("c", user); err != nil {
+		t.Error(err)
+		return
+	}
+}
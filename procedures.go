@@ -0,0 +1,69 @@
+package gotinydb
+
+// RegisterProcedure saves the given function under the given name.
+// The procedures are not saved, they need to be registered after every Open.
+func (d *DB) RegisterProcedure(name string, procedure Procedure) error {
+	if procedure == nil {
+		return ErrInvalidArgument
+	}
+
+	d.proceduresMutex.Lock()
+	defer d.proceduresMutex.Unlock()
+
+	if d.procedures == nil {
+		d.procedures = map[string]Procedure{}
+	}
+	if _, ok := d.procedures[name]; ok {
+		return ErrProcedureExists
+	}
+
+	d.procedures[name] = procedure
+	return nil
+}
+
+// UnregisterProcedure removes the procedure of the given name
+func (d *DB) UnregisterProcedure(name string) error {
+	d.proceduresMutex.Lock()
+	defer d.proceduresMutex.Unlock()
+
+	if _, ok := d.procedures[name]; !ok {
+		return ErrNotFound
+	}
+
+	newProcedures := map[string]Procedure{}
+	for procedureName, procedure := range d.procedures {
+		if procedureName != name {
+			newProcedures[procedureName] = procedure
+		}
+	}
+	d.procedures = newProcedures
+	return nil
+}
+
+// Call runs the procedure of the given name with the given arguments inside
+// a transaction like RunInTransaction. The writes of the procedure are committed
+// only if it returns no error, and the procedure runs again if the commit conflicts.
+// The procedures run one after the other, so two calls never see the other one half done.
+func (d *DB) Call(name string, args []byte) ([]byte, error) {
+	d.proceduresMutex.RLock()
+	procedure, ok := d.procedures[name]
+	d.proceduresMutex.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	d.callMutex.Lock()
+	defer d.callMutex.Unlock()
+
+	var result []byte
+	err := d.RunInTransaction(d.ctx, func(tx *Tx) error {
+		var procedureErr error
+		result, procedureErr = procedure(tx, args)
+		return procedureErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
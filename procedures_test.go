@@ -0,0 +1,124 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestProcedures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	type transferArgs struct {
+		From, To string
+		Amount   int
+	}
+
+	// Moves balance from one user to the other
+	transfer := func(tx *Tx, args []byte) ([]byte, error) {
+		params := new(transferArgs)
+		if err := json.Unmarshal(args, params); err != nil {
+			return nil, err
+		}
+
+		c := tx.Collection("testCol")
+		from, to := new(User), new(User)
+		if err := c.Get(params.From, from); err != nil {
+			return nil, err
+		}
+		if err := c.Get(params.To, to); err != nil {
+			return nil, err
+		}
+		if from.Balance < params.Amount {
+			return nil, fmt.Errorf("not enough balance")
+		}
+
+		from.Balance -= params.Amount
+		if err := c.Put(from.ID, from); err != nil {
+			return nil, err
+		}
+		if params.To == params.From {
+			return nil, fmt.Errorf("same user")
+		}
+		to.Balance += params.Amount
+		if err := c.Put(to.ID, to); err != nil {
+			return nil, err
+		}
+		return json.Marshal(from.Balance)
+	}
+
+	if err := db.RegisterProcedure("transfer", transfer); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.RegisterProcedure("nil", nil); err != ErrInvalidArgument {
+		t.Errorf("expected %v but had %v", ErrInvalidArgument, err)
+		return
+	}
+	if err := db.RegisterProcedure("transfer", transfer); err != ErrProcedureExists {
+		t.Errorf("expected %v but had %v", ErrProcedureExists, err)
+		return
+	}
+	if _, err := db.Call("unknown", nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	from, to := users[0], users[1]
+	args, _ := json.Marshal(&transferArgs{From: from.ID, To: to.ID, Amount: 1})
+
+	// The calls run one after the other so no transfer is lost
+	nbCalls := 10
+	wg := new(sync.WaitGroup)
+	for i := 0; i < nbCalls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.Call("transfer", args); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, _ := db.Use("testCol")
+	savedFrom, savedTo := new(User), new(User)
+	c.Get(from.ID, savedFrom)
+	c.Get(to.ID, savedTo)
+	if savedFrom.Balance != from.Balance-nbCalls || savedTo.Balance != to.Balance+nbCalls {
+		t.Errorf("the balances are %d and %d but expected %d and %d", savedFrom.Balance, savedTo.Balance, from.Balance-nbCalls, to.Balance+nbCalls)
+		return
+	}
+
+	// The writes of a failing procedure are dropped
+	sameArgs, _ := json.Marshal(&transferArgs{From: from.ID, To: from.ID, Amount: 1})
+	if _, err := db.Call("transfer", sameArgs); err == nil {
+		t.Errorf("the transfer to the same user must fail")
+		return
+	}
+	c.Get(from.ID, savedFrom)
+	if savedFrom.Balance != from.Balance-nbCalls {
+		t.Errorf("the balance of the failed transfer is saved, it is %d but expected %d", savedFrom.Balance, from.Balance-nbCalls)
+		return
+	}
+
+	if err := db.UnregisterProcedure("transfer"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := db.Call("transfer", args); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+}
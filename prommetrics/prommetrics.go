@@ -0,0 +1,131 @@
+// Package prommetrics exports the metrics of gotinydb databases to Prometheus.
+// It is a package of its own so the databases without metrics do not depend on Prometheus.
+package prommetrics
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alexandrestein/gotinydb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// Metrics holds the Prometheus collectors of one database. It is given to
+	// the Metrics of the gotinydb options.
+	Metrics struct {
+		registerer prometheus.Registerer
+
+		operations         *prometheus.CounterVec
+		queryDuration      *prometheus.HistogramVec
+		indexScanLength    *prometheus.HistogramVec
+		transactionRetries prometheus.Counter
+		storageSize        prometheus.GaugeFunc
+
+		// path is the directory of the database measured by storageSize
+		path      string
+		pathMutex sync.Mutex
+	}
+)
+
+// New returns the collectors of the operations, the query durations, the index scan lengths,
+// the retries and the size of a database. They are registered into registerer when the
+// database is opened and unregistered when it is closed. The databases of one registerer
+// need different constant labels, given with prometheus.WrapRegistererWith.
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		registerer: registerer,
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: gotinydb.MetricsNamespace,
+			Name:      "operations_total",
+			Help:      "Number of documents saved, read and deleted.",
+		}, []string{"collection", "operation"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: gotinydb.MetricsNamespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the queries.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"collection"}),
+		indexScanLength: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: gotinydb.MetricsNamespace,
+			Name:      "index_scan_length",
+			Help:      "Number of IDs read from an index by a filter of a query.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+		}, []string{"collection", "index"}),
+		transactionRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: gotinydb.MetricsNamespace,
+			Name:      "transaction_retries_total",
+			Help:      "Number of writes done again after a transient error.",
+		}),
+	}
+	m.storageSize = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: gotinydb.MetricsNamespace,
+		Name:      "storage_size_bytes",
+		Help:      "Size of the files of the database.",
+	}, m.size)
+	return m
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.operations, m.queryDuration, m.indexScanLength, m.transactionRetries, m.storageSize}
+}
+
+// Open registers the collectors, the ones registered before an error are unregistered
+func (m *Metrics) Open(path string) error {
+	m.pathMutex.Lock()
+	m.path = path
+	m.pathMutex.Unlock()
+
+	for _, collector := range m.collectors() {
+		if err := m.registerer.Register(collector); err != nil {
+			m.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close unregisters the collectors
+func (m *Metrics) Close() {
+	for _, collector := range m.collectors() {
+		m.registerer.Unregister(collector)
+	}
+}
+
+// CountOperations adds n operations of the collection
+func (m *Metrics) CountOperations(collection, operation string, n int) {
+	m.operations.WithLabelValues(collection, operation).Add(float64(n))
+}
+
+// ObserveQuery adds the duration of a query of the collection
+func (m *Metrics) ObserveQuery(collection string, duration time.Duration) {
+	m.queryDuration.WithLabelValues(collection).Observe(duration.Seconds())
+}
+
+// ObserveIndexScan adds the number of IDs read from the index
+func (m *Metrics) ObserveIndexScan(collection, index string, length int) {
+	m.indexScanLength.WithLabelValues(collection, index).Observe(float64(length))
+}
+
+// CountRetry counts a write done again
+func (m *Metrics) CountRetry() {
+	m.transactionRetries.Inc()
+}
+
+// size returns the size of the files of the database
+func (m *Metrics) size() float64 {
+	m.pathMutex.Lock()
+	path := m.path
+	m.pathMutex.Unlock()
+
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return float64(size)
+}
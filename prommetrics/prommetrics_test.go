@@ -0,0 +1,111 @@
+package prommetrics
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/alexandrestein/gotinydb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath, err := ioutil.TempDir("", "gotinydb-prommetrics-")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(testPath)
+
+	registry := prometheus.NewRegistry()
+	metrics := New(registry)
+	options := gotinydb.NewDefaultOptions(testPath)
+	options.Metrics = metrics
+	db, err := gotinydb.Open(ctx, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("email", gotinydb.StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := c.Put(id, map[string]string{"Email": id + "@example.com"}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := c.Delete("a"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Get("b", nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := c.Query(gotinydb.NewQuery().SetFilter(gotinydb.NewFilter(gotinydb.Equal).SetSelector("Email").CompareTo("c@example.com"))); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for operation, expected := range map[string]float64{"put": 3, "delete": 1, "get": 1} {
+		if value := testutil.ToFloat64(metrics.operations.WithLabelValues("testCol", operation)); value != expected {
+			t.Errorf("expected %v %s but had %v", expected, operation, value)
+			return
+		}
+	}
+	if n := collectAndCount(metrics.queryDuration); n != 1 {
+		t.Errorf("expected 1 query duration but had %d", n)
+		return
+	}
+	if n := collectAndCount(metrics.indexScanLength); n != 1 {
+		t.Errorf("expected 1 index scan length but had %d", n)
+		return
+	}
+	if size := testutil.ToFloat64(metrics.storageSize); size <= 0 {
+		t.Errorf("expected the size of the database but had %v", size)
+		return
+	}
+
+	// The collectors are unregistered by Close and registered again by Open
+	db.Close()
+	db, err = gotinydb.Open(ctx, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	other := gotinydb.NewDefaultOptions(testPath + "_second")
+	defer os.RemoveAll(testPath + "_second")
+	other.Metrics = New(registry)
+	if _, err := gotinydb.Open(ctx, other); err == nil {
+		t.Errorf("the collectors of two databases can't have the same names")
+		return
+	}
+}
+
+// collectAndCount returns the number of metrics of the collector
+func collectAndCount(collector prometheus.Collector) (n int) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+	for range metrics {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,274 @@
+package gotinydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The fields of google.protobuf.Value, Struct and ListValue
+const (
+	protobufNullField   = 1
+	protobufNumberField = 2
+	protobufStringField = 3
+	protobufBoolField   = 4
+	protobufStructField = 5
+	protobufListField   = 6
+)
+
+// NewProtobufCodec returns a codec saving the documents as google.protobuf.Value messages,
+// so any Protocol Buffers library reads them without a schema. The numbers are doubles,
+// the documents with integers which do not fit in a double are saved as they are.
+func NewProtobufCodec() Codec {
+	return &protobufCodec{}
+}
+
+func (p *protobufCodec) ID() byte {
+	return ProtobufCodecID
+}
+
+func (p *protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (p *protobufCodec) EncodeValue(value interface{}) ([]byte, error) {
+	w := new(protobufWriter)
+	if err := writeDocument(w, value); err != nil {
+		return nil, err
+	}
+	return w.output, nil
+}
+
+func (p *protobufCodec) Encode(content []byte) ([]byte, error) {
+	w := new(protobufWriter)
+	if err := encodeDocument(w, content); err != nil {
+		return nil, err
+	}
+	return w.output, nil
+}
+
+func (p *protobufCodec) Decode(encoded []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := decodeProtobufValue(buffer, encoded); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// add gives the Value message to the map or the array being written
+func (p *protobufWriter) add(value []byte) {
+	if len(p.stack) == 0 {
+		p.output = value
+		return
+	}
+
+	frame := p.stack[len(p.stack)-1]
+	if frame.isMap {
+		// The members of Struct are map entries with the key as field 1 and the value as field 2
+		entry := appendProtobufBytes(nil, 1, []byte(frame.key))
+		entry = appendProtobufBytes(entry, 2, value)
+		frame.content = appendProtobufBytes(frame.content, 1, entry)
+	} else {
+		frame.content = appendProtobufBytes(frame.content, 1, value)
+	}
+}
+
+func (p *protobufWriter) writeNull() {
+	p.add([]byte{protobufNullField << 3, 0})
+}
+
+func (p *protobufWriter) writeBool(value bool) {
+	if value {
+		p.add([]byte{protobufBoolField << 3, 1})
+	} else {
+		p.add([]byte{protobufBoolField << 3, 0})
+	}
+}
+
+func (p *protobufWriter) writeInt(i int64) error {
+	if i > 1<<53 || i < -1<<53 {
+		return ErrNotEncodable
+	}
+	return p.writeFloat(float64(i), 64)
+}
+
+func (p *protobufWriter) writeUint(u uint64) error {
+	if u > 1<<53 {
+		return ErrNotEncodable
+	}
+	return p.writeFloat(float64(u), 64)
+}
+
+// writeFloat saves the float32 values which are not marshaled the same as float64 as they are
+func (p *protobufWriter) writeFloat(f float64, bits int) error {
+	if bits == 32 && formatJSONFloat(f, 32) != formatJSONFloat(f, 64) {
+		return ErrNotEncodable
+	}
+
+	value := make([]byte, 9)
+	value[0] = protobufNumberField<<3 | 1
+	binary.LittleEndian.PutUint64(value[1:], math.Float64bits(f))
+	p.add(value)
+	return nil
+}
+
+func (p *protobufWriter) writeString(s string) {
+	p.add(appendProtobufBytes(nil, protobufStringField, []byte(s)))
+}
+
+func (p *protobufWriter) writeKey(key string) {
+	p.stack[len(p.stack)-1].key = key
+}
+
+func (p *protobufWriter) openMap(n int) {
+	p.stack = append(p.stack, &protobufFrame{isMap: true})
+}
+
+func (p *protobufWriter) openArray(n int) {
+	p.stack = append(p.stack, &protobufFrame{})
+}
+
+func (p *protobufWriter) close() {
+	frame := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	if frame.isMap {
+		p.add(appendProtobufBytes(nil, protobufStructField, frame.content))
+	} else {
+		p.add(appendProtobufBytes(nil, protobufListField, frame.content))
+	}
+}
+
+// appendProtobufBytes appends the length delimited field to buf
+func appendProtobufBytes(buf []byte, field int, value []byte) []byte {
+	buf = appendProtobufVarint(buf, uint64(field<<3|2))
+	buf = appendProtobufVarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func appendProtobufVarint(buf []byte, n uint64) []byte {
+	for n >= 0x80 {
+		buf = append(buf, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
+
+// readProtobufField returns the number of the next field of the message and its content.
+// The content of the varint fields is their value as a varint.
+func readProtobufField(message []byte) (field int, content, rest []byte, _ error) {
+	key, n := binary.Uvarint(message)
+	if n <= 0 {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	message = message[n:]
+
+	switch key & 7 {
+	case 0:
+		_, n = binary.Uvarint(message)
+		if n <= 0 {
+			return 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		return int(key >> 3), message[:n], message[n:], nil
+	case 1:
+		if len(message) < 8 {
+			return 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		return int(key >> 3), message[:8], message[8:], nil
+	case 2:
+		length, n := binary.Uvarint(message)
+		if n <= 0 || length > uint64(len(message)-n) {
+			return 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		message = message[n:]
+		return int(key >> 3), message[:length], message[length:], nil
+	}
+	return 0, nil, nil, fmt.Errorf("unsupported Protocol Buffers wire type %d", key&7)
+}
+
+// decodeProtobufValue writes the Value message as JSON
+func decodeProtobufValue(w *bytes.Buffer, message []byte) error {
+	field, content, rest, err := readProtobufField(message)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("the Protocol Buffers value has more than one kind")
+	}
+
+	switch field {
+	case protobufNullField:
+		w.WriteString("null")
+	case protobufNumberField:
+		if len(content) != 8 {
+			return io.ErrUnexpectedEOF
+		}
+		w.WriteString(formatJSONFloat(math.Float64frombits(binary.LittleEndian.Uint64(content)), 64))
+	case protobufStringField:
+		asBytes, err := json.Marshal(string(content))
+		if err != nil {
+			return err
+		}
+		w.Write(asBytes)
+	case protobufBoolField:
+		if len(content) == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if content[0] == 0 {
+			w.WriteString("false")
+		} else {
+			w.WriteString("true")
+		}
+	case protobufStructField:
+		return decodeProtobufContainer(w, content, true)
+	case protobufListField:
+		return decodeProtobufContainer(w, content, false)
+	default:
+		return fmt.Errorf("unsupported Protocol Buffers value field %d", field)
+	}
+	return nil
+}
+
+func decodeProtobufContainer(w *bytes.Buffer, message []byte, isMap bool) error {
+	open, end := byte('['), byte(']')
+	if isMap {
+		open, end = '{', '}'
+	}
+
+	w.WriteByte(open)
+	for i := 0; len(message) > 0; i++ {
+		_, value, rest, err := readProtobufField(message)
+		if err != nil {
+			return err
+		}
+		message = rest
+
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if isMap {
+			_, key, entryRest, err := readProtobufField(value)
+			if err != nil {
+				return err
+			}
+			asBytes, err := json.Marshal(string(key))
+			if err != nil {
+				return err
+			}
+			w.Write(asBytes)
+			w.WriteByte(':')
+
+			_, value, _, err = readProtobufField(entryRest)
+			if err != nil {
+				return err
+			}
+		}
+		if err := decodeProtobufValue(w, value); err != nil {
+			return err
+		}
+	}
+	w.WriteByte(end)
+	return nil
+}
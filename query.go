@@ -3,7 +3,11 @@ package gotinydb
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"reflect"
 	"sort"
 	"time"
 
@@ -22,6 +26,9 @@ type (
 		limit         int
 		internalLimit int
 		timeout       time.Duration
+
+		// trace defines if the responses of the query have a trace
+		trace bool
 	}
 
 	// idType is a type to order IDs during query to be compatible with the tree query
@@ -43,6 +50,11 @@ type (
 	// passed to deferent functions
 	idsType struct {
 		IDs []*idType
+
+		// Those are set by the index queries for the trace
+		indexName string
+		filter    *Filter
+		duration  time.Duration
 	}
 
 	idsTypeMultiSorter struct {
@@ -53,11 +65,50 @@ type (
 	// FilterOperator defines the type of filter to perform
 	FilterOperator string
 
-	// Response holds the results of a query
+	// Response holds the results of a query.
+	// The results are ordered by the value of the order selector and the documents with
+	// the same value by ID. Without order selector they are ordered by ID. The order is
+	// descending unless the query is set ascendent with SetOrder.
+	// All the accessors return the results in this order, Last and Prev in the reverse order.
 	Response struct {
 		list           []*ResponseElem
 		actualPosition int
-		query          *Query
+		// onePosition is the cursor of One, it does not depend on First, Next, Last and Prev
+		onePosition int
+		query       *Query
+		fingerprint string
+		trace       *QueryTrace
+	}
+
+	// QueryTrace holds the timings of the execution of a query.
+	// It is returned by Response.Trace if the query is set with SetTrace.
+	QueryTrace struct {
+		// Filters has one element for each filter run by an index
+		Filters []*FilterTrace
+		// IDsConsidered is the number of IDs returned by the indexes
+		IDsConsidered int
+		// DocumentsFetched is the number of documents read from the store
+		DocumentsFetched int
+		// Merge is the time spent to merge the IDs returned by the indexes
+		Merge time.Duration
+		// Select is the time spent to select the IDs found by all the filters
+		// and Order the time spent to order them. Order used to hold the time
+		// of the selection too, add Select to it to get the same value.
+		Select, Order time.Duration
+		// Fetch is the time spent to read the documents
+		Fetch time.Duration
+		// Total is the time of the whole query
+		Total time.Duration
+	}
+
+	// FilterTrace holds the execution of a filter by an index
+	FilterTrace struct {
+		IndexName string
+		Operator  FilterOperator
+		Selector  []string
+		// IDs is the number of IDs returned by the index for the filter
+		IDs      int
+		Duration time.Duration
 	}
 
 	// ResponseElem defines the response as a pointer
@@ -82,7 +133,7 @@ func (iMs *idsTypeMultiSorter) Swap(i, j int) {
 }
 func (iMs *idsTypeMultiSorter) Less(i, j int) bool {
 	if iMs.invert {
-		return !iMs.less(i, j)
+		return iMs.less(j, i)
 	}
 
 	return iMs.less(i, j)
@@ -141,7 +192,8 @@ func (q *Query) SetTimeout(timeout time.Duration) *Query {
 	return q
 }
 
-// SetOrder defines the order of the response
+// SetOrder defines the order of the response.
+// The documents with the same value are ordered by ID in the same direction.
 func (q *Query) SetOrder(ascendent bool, selector ...string) *Query {
 	q.orderSelector = selector
 	q.order = buildSelectorHash(selector)
@@ -149,6 +201,21 @@ func (q *Query) SetOrder(ascendent bool, selector ...string) *Query {
 	return q
 }
 
+// SetTrace defines if the responses of the query have a trace of the execution.
+// The trace is given by Response.Trace.
+func (q *Query) SetTrace(trace bool) *Query {
+	q.trace = trace
+	return q
+}
+
+// Profile makes the responses of the query carry the time spent by every step,
+// the index scans of the filters, the merge and the selection of the IDs, their order
+// and the load of the contents. The timings are given by Response.Trace.
+// It is the same as SetTrace(true).
+func (q *Query) Profile() *Query {
+	return q.SetTrace(true)
+}
+
 // SetFilter defines the action to perform to get IDs
 func (q *Query) SetFilter(f *Filter) *Query {
 	if q.filters == nil {
@@ -340,6 +407,29 @@ func (i *idsType) Strings() []string {
 	return ret
 }
 
+// mergeResponses returns the elements of the responses in the order of the query,
+// limited to the limit of the query
+func mergeResponses(q *Query, responses ...*Response) *Response {
+	ids := []*idType{}
+	elems := map[*idType]*ResponseElem{}
+	for _, response := range responses {
+		for _, elem := range response.list {
+			ids = append(ids, elem.ID)
+			elems[elem.ID] = elem
+		}
+	}
+
+	sorter := &idsTypeMultiSorter{IDs: ids, invert: !q.ascendent}
+	sorter.Sort(q.limit)
+
+	ret := newResponse(len(sorter.IDs))
+	ret.query = q
+	for i, id := range sorter.IDs {
+		ret.list[i] = elems[id]
+	}
+	return ret
+}
+
 // newResponse build a new Response pointer with the given limit
 func newResponse(limit int) *Response {
 	r := new(Response)
@@ -347,11 +437,29 @@ func newResponse(limit int) *Response {
 	return r
 }
 
+// Trace returns the timings of the execution of the query or nil if the query
+// has not been set with SetTrace
+func (r *Response) Trace() *QueryTrace {
+	return r.trace
+}
+
 // Len returns the length of the given response
 func (r *Response) Len() int {
 	return len(r.list)
 }
 
+// Close releases the contents of the response so they can be freed even if the
+// response is still referenced. The response is empty after it.
+// The contents are read when the query runs, so no transaction is held by the response.
+func (r *Response) Close() {
+	if r == nil {
+		return
+	}
+	r.list = nil
+	r.actualPosition = 0
+	r.onePosition = 0
+}
+
 // First used with Next
 func (r *Response) First() (i int, id string, objAsByte []byte) {
 	if len(r.list) <= 0 {
@@ -417,18 +525,66 @@ func (r *Response) All(fn func(id string, objAsBytes []byte) error) (n int, err
 // One retrieve one element at the time and put it into the destination pointer.
 // Use it to get the objects one after the other.
 func (r *Response) One(destination interface{}) (id string, err error) {
-	if r.actualPosition >= len(r.list) {
-		r.actualPosition = 0
+	if r.onePosition >= len(r.list) {
+		r.onePosition = 0
 		return "", ErrTheResponseIsOver
 	}
 
-	id = r.list[r.actualPosition].ID.String()
-	err = json.Unmarshal(r.list[r.actualPosition].ContentAsBytes, destination)
-	r.actualPosition++
+	id = r.list[r.onePosition].ID.String()
+	err = json.Unmarshal(r.list[r.onePosition].ContentAsBytes, destination)
+	r.onePosition++
 
 	return id, err
 }
 
+// Decode unmarshals all the contents of the response in its order into dest,
+// which must be a pointer to a slice like *[]*User. The slice is replaced.
+// ErrWrongType is returned if dest is not a pointer to a slice.
+func (r *Response) Decode(dest interface{}) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Slice {
+		return ErrWrongType
+	}
+	if r == nil {
+		return ErrNotFound
+	}
+
+	slice := destValue.Elem()
+	ret := reflect.MakeSlice(slice.Type(), len(r.list), len(r.list))
+	for i, elem := range r.list {
+		if err := json.Unmarshal(elem.ContentAsBytes, ret.Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	slice.Set(ret)
+	return nil
+}
+
+// Fingerprint returns a hash of the IDs and the contents of the response in their order.
+// It is the same as long as the query returns the same results.
+func (r *Response) Fingerprint() string {
+	if r == nil {
+		return ""
+	}
+	if r.fingerprint != "" {
+		return r.fingerprint
+	}
+
+	hasher := sha256.New()
+	size := make([]byte, 8)
+	for _, elem := range r.list {
+		// The lengths avoid the ambiguity between the IDs and the contents
+		for _, part := range [][]byte{[]byte(elem.GetID()), elem.ContentAsBytes} {
+			binary.BigEndian.PutUint64(size, uint64(len(part)))
+			hasher.Write(size)
+			hasher.Write(part)
+		}
+	}
+
+	r.fingerprint = hex.EncodeToString(hasher.Sum(nil))
+	return r.fingerprint
+}
+
 // GetID return the ID as string of the given element
 func (r *ResponseElem) GetID() string {
 	return r.ID.ID
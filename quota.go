@@ -0,0 +1,178 @@
+package gotinydb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// SetQuota defines the maximum size in bytes of the collection, its values with
+// their history, its streamed contents and its index file. The puts fail with ErrQuotaExceeded once
+// the size is over it, the deletes are still possible. Zero removes the quota.
+// The setting is saved with the collection.
+func (c *Collection) SetQuota(maxBytes uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, maxBytes)
+	if err := c.updateIndex(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("config")).Put([]byte("quota"), value)
+	}); err != nil {
+		return err
+	}
+
+	c.quotaMutex.Lock()
+	c.quota = maxBytes
+	c.quotaMutex.Unlock()
+	if maxBytes == 0 {
+		return nil
+	}
+
+	_, err := c.refreshSize()
+	return err
+}
+
+// Quota returns the maximum size of the collection, 0 if there is no quota
+func (c *Collection) Quota() uint64 {
+	c.quotaMutex.Lock()
+	defer c.quotaMutex.Unlock()
+	return c.quota
+}
+
+func (c *Collection) loadQuota() {
+	quota := uint64(0)
+	c.viewIndex(func(tx *bolt.Tx) error {
+		if saved := tx.Bucket([]byte("config")).Get([]byte("quota")); len(saved) == 8 {
+			quota = binary.BigEndian.Uint64(saved)
+		}
+		return nil
+	})
+
+	c.quotaMutex.Lock()
+	c.quota = quota
+	c.quotaMutex.Unlock()
+	if quota == 0 {
+		return
+	}
+
+	if _, err := c.refreshSize(); err != nil {
+		c.options.log(LogError, "collection size not read", "collection", c.name, "error", err)
+	}
+}
+
+// Size returns an estimation of the space used by the collection,
+// its values with their history, its streamed contents and its index file
+func (c *Collection) Size() (size uint64, _ error) {
+	err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+		defer iter.Close()
+
+		for _, prefix := range [][]byte{[]byte(c.id[:4] + "_"), []byte(c.id[:4] + "~"), []byte(c.id[:4] + "#"), []byte(c.id[:4] + "$")} {
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				size += uint64(iter.Item().EstimatedSize())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size + uint64(dirSize(c.indexFilePath())), nil
+}
+
+// checkQuota returns ErrQuotaExceeded if the database or the collection is bigger
+// than its quota and the QuotaExceededHook does not allow the write.
+// The size of the collection is counted by the writes and read again in the
+// background after the DiskSpaceCheckInterval.
+func (c *Collection) checkQuota() error {
+	if err := c.diskSpace.checkSize(); err != nil {
+		return err
+	}
+
+	c.quotaMutex.Lock()
+	quota, size := c.quota, c.size
+	refresh := quota != 0 && !c.sizeRefreshing && time.Since(c.sizeCheck) >= c.diskSpace.checkInterval()
+	if refresh {
+		c.sizeRefreshing = true
+	}
+	c.quotaMutex.Unlock()
+
+	if refresh {
+		if err := c.workers.submit(c.ctx, func() {
+			if _, err := c.refreshSize(); err != nil {
+				c.options.log(LogError, "collection size not read", "collection", c.name, "error", err)
+			}
+		}); err != nil {
+			c.quotaMutex.Lock()
+			c.sizeRefreshing = false
+			c.quotaMutex.Unlock()
+		}
+	}
+
+	if quota == 0 || size <= quota {
+		return nil
+	}
+	return quotaExceeded(c.options, c.name, size, quota)
+}
+
+// refreshSize reads the size of the collection again for the quota.
+// The writes committed during the scan may be counted twice until the next one.
+func (c *Collection) refreshSize() (uint64, error) {
+	size, err := c.Size()
+
+	c.quotaMutex.Lock()
+	defer c.quotaMutex.Unlock()
+	c.sizeRefreshing = false
+	if err != nil {
+		return 0, err
+	}
+	c.size = size
+	c.sizeCheck = time.Now()
+	c.events.warnQuota(c.name, c.size, c.quota)
+	return size, nil
+}
+
+// addSize counts the bytes of the committed writes into the size of the collection
+func (c *Collection) addSize(n int) {
+	c.quotaMutex.Lock()
+	c.size += uint64(n)
+	c.quotaMutex.Unlock()
+}
+
+// checkSize returns ErrQuotaExceeded if the database is bigger than MaxSizeBytes
+// and the QuotaExceededHook does not allow the write
+func (s *diskSpace) checkSize() error {
+	if s == nil || s.options.MaxSizeBytes == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Since(s.lastSizeCheck) >= s.checkInterval() {
+		s.size = uint64(dirSize(s.options.Path))
+		s.lastSizeCheck = time.Now()
+		s.events.warnQuota("", s.size, s.options.MaxSizeBytes)
+	}
+
+	if s.size <= s.options.MaxSizeBytes {
+		return nil
+	}
+	return quotaExceeded(s.options, "", s.size, s.options.MaxSizeBytes)
+}
+
+func (s *diskSpace) checkInterval() time.Duration {
+	if s == nil || s.options.DiskSpaceCheckInterval <= 0 {
+		return DefaultDiskSpaceCheckInterval
+	}
+	return s.options.DiskSpaceCheckInterval
+}
+
+// quotaExceeded calls the hook of the options if any or returns ErrQuotaExceeded
+func quotaExceeded(options *Options, collection string, size, quota uint64) error {
+	if options.QuotaExceededHook != nil {
+		return options.QuotaExceededHook(collection, size, quota)
+	}
+	return ErrQuotaExceeded
+}
@@ -0,0 +1,146 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	newOptions := func() *Options {
+		options := NewDefaultOptions(testPath)
+		options.DiskSpaceCheckInterval = time.Millisecond
+		return options
+	}
+
+	db, openDBErr := Open(ctx, newOptions())
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	c, _ := db.Use("testCol")
+	other, _ := db.Use("other")
+
+	size, err := c.Size()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetQuota(size + 10000); err != nil {
+		t.Error(err)
+		return
+	}
+
+	content := make([]byte, 1000)
+	var putErr error
+	for i := 0; i < 20 && putErr == nil; i++ {
+		putErr = c.Put(fmt.Sprint(i), content)
+		time.Sleep(time.Millisecond * 2)
+	}
+	if putErr != ErrQuotaExceeded {
+		t.Errorf("expected %v but had %v", ErrQuotaExceeded, putErr)
+		return
+	}
+
+	// The other collections and the deletes are not limited
+	if err := other.Put("id", content); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Delete("0"); err != nil {
+		t.Error(err)
+		return
+	}
+	db.Close()
+
+	db, openDBErr = Open(ctx, newOptions())
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ = db.Use("testCol")
+	if c.Quota() != size+10000 {
+		t.Errorf("expected a quota of %d but had %d", size+10000, c.Quota())
+		return
+	}
+	if err := c.SetQuota(0); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Put("id", content); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The hook can allow the writes over the size of the database
+	db.options.MaxSizeBytes = 1
+	hookCalls := 0
+	db.options.QuotaExceededHook = func(collection string, size, quota uint64) error {
+		hookCalls++
+		if collection != "" || quota != 1 {
+			t.Errorf("unexpected hook call for %q with quota %d", collection, quota)
+		}
+		return nil
+	}
+	if err := c.Put("id", content); err != nil || hookCalls != 1 {
+		t.Errorf("the hook must allow the write: %v", err)
+		return
+	}
+
+	db.options.QuotaExceededHook = nil
+	if err := c.Put("id", content); err != ErrQuotaExceeded {
+		t.Errorf("expected %v but had %v", ErrQuotaExceeded, err)
+		return
+	}
+	db.Close()
+}
+
+func TestQuotaCountsWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	options := NewDefaultOptions(testPath)
+	// The size is not read again during the test
+	options.DiskSpaceCheckInterval = time.Hour
+	db, openDBErr := Open(ctx, options)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	size, err := c.Size()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetQuota(size + 10000); err != nil {
+		t.Error(err)
+		return
+	}
+
+	content := make([]byte, 1000)
+	puts := 0
+	for ; puts < 20; puts++ {
+		if err := c.Put(fmt.Sprint(puts), content); err == ErrQuotaExceeded {
+			break
+		} else if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if puts == 0 || puts == 20 {
+		t.Errorf("the writes are not counted into the size of the collection, %d puts done", puts)
+	}
+}
@@ -0,0 +1,551 @@
+package gotinydb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// replicationVersionKey saves on the replica the last version of the primary it has applied
+var replicationVersionKey = []byte("\x00replication")
+
+// replicationFilterKey saves on the replica the filter of the last copy
+var replicationFilterKey = []byte("\x00replicationFilter")
+
+// replicationPrimaryKey saves on the replica the identity of the primary it follows
+var replicationPrimaryKey = []byte("\x00replicationPrimary")
+
+// replicationEpochKey saves on the primary the epoch of its change log
+var replicationEpochKey = []byte("\x00replicationEpoch")
+
+// ServeReplication sends the writes of the database to the replicas opened with OpenReplica
+// which connect to the listener. It needs the change log of the options.
+// The connections are served over TLS with the ReplicationTLS of the options, which is required.
+// The new replicas, the ones which are behind the change log and the ones following an other
+// primary or an other epoch of this one receive a copy of all the collections first,
+// then the writes are sent in their order.
+// It returns when the listener fails, or nil when the database is closed,
+// once the connections of the replicas are closed.
+func (d *DB) ServeReplication(listener net.Listener) error {
+	if d.options.ChangeLogSize <= 0 {
+		return ErrChangeLogDisabled
+	}
+	if d.options.ReplicationTLS == nil {
+		return ErrReplicationTLSMissing
+	}
+	listener = tls.NewListener(listener, d.options.ReplicationTLS)
+
+	d.replicationWaitGroup.Add(1)
+	defer d.replicationWaitGroup.Done()
+
+	ctx, cancel := context.WithCancel(d.replicationCtx)
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.serveReplica(ctx, conn)
+		}()
+	}
+}
+
+// OpenReplica opens the database of the options as a read only copy of the primary
+// serving the replication at primaryAddr with ServeReplication.
+// The writes of the primary are applied in their order. After a disconnection the replica
+// connects again every ReplicationRetryInterval and continues from the last version it has.
+// The primary is dialed over TLS with the ReplicationTLS of the options, which is required.
+// The documents saved with a TTL on the primary expire on the replica at the same time.
+// The writes done on the replica return ErrReplica, but the indexes can be set.
+func OpenReplica(ctx context.Context, options *Options, primaryAddr string) (*DB, error) {
+	if options.ReplicationTLS == nil {
+		return nil, ErrReplicationTLSMissing
+	}
+
+	d, err := Open(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	d.diskSpace.refused = ErrReplica
+
+	d.replicationWaitGroup.Add(1)
+	go d.replicationLoop(primaryAddr)
+	return d, nil
+}
+
+// serveReplica sends the copy and the writes to one replica until the connection fails
+func (d *DB) serveReplica(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	hello := new(replicationHello)
+	conn.SetReadDeadline(time.Now().Add(3 * ReplicationHeartbeatInterval))
+	if err := json.NewDecoder(conn).Decode(hello); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	writer := bufio.NewWriter(conn)
+	encoder := json.NewEncoder(writer)
+
+	version := hello.Version
+	following := hello.Primary
+	lastSent := time.Now()
+	for {
+		lastVersion := d.changeLog.lastVersion()
+		// The epoch changes if a backup is loaded meanwhile
+		identity, err := d.replicationIdentity()
+		if err != nil {
+			return
+		}
+
+		// The replica has nothing or its versions come from an other change log
+		if version == 0 || version > lastVersion || following == nil || *following != *identity {
+			if version, err = d.sendReplicationCopy(ctx, encoder, hello.Filter, identity); err != nil {
+				return
+			}
+			following = identity
+		}
+
+		records, err := d.Changes(version)
+		if err == ErrChangeLogTruncated {
+			version = 0
+			continue
+		} else if err != nil {
+			return
+		}
+
+		for i, record := range records {
+			version = record.Version
+			if !hello.Filter.matchID(record.Collection, record.ID) {
+				// The version is sent even if the last records are not
+				if i == len(records)-1 {
+					if err := encoder.Encode(&replicationMessage{Version: version}); err != nil {
+						return
+					}
+				}
+				continue
+			}
+
+			if err := d.completeReplicationRecord(record); err != nil {
+				return
+			}
+			// The document is removed from the replica if it is not selected anymore
+			if record.Operation != ChangeDelete && !hello.Filter.matchContent(record.Content) {
+				record.Operation, record.Content, record.Bin = ChangeDelete, nil, false
+			}
+			if err := encoder.Encode(&replicationMessage{Record: record, Version: record.Version}); err != nil {
+				return
+			}
+		}
+
+		if writer.Buffered() == 0 && time.Since(lastSent) >= ReplicationHeartbeatInterval {
+			if err := encoder.Encode(&replicationMessage{Version: version}); err != nil {
+				return
+			}
+		}
+		if writer.Buffered() != 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+			lastSent = time.Now()
+		}
+
+		if len(records) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ReplicationPollInterval):
+			}
+		}
+	}
+}
+
+// sendReplicationCopy sends the indexes and the documents of all the collections
+// selected by the filter and returns the version of the change log the copy starts from.
+// The writes done during the copy are sent again after it.
+func (d *DB) sendReplicationCopy(ctx context.Context, encoder *json.Encoder, filter *ChangeFilter, identity *replicationIdentity) (uint64, error) {
+	version := d.changeLog.lastVersion()
+
+	collections := []CollectionInfo{}
+	for _, info := range d.Collections() {
+		if filter.matchCollection(info.Name) {
+			collections = append(collections, info)
+		}
+	}
+	if err := encoder.Encode(&replicationMessage{Sync: true, Collections: collections, Primary: identity}); err != nil {
+		return 0, err
+	}
+
+	for _, c := range d.getCollections() {
+		if err := d.sendReplicationCollection(ctx, encoder, c, filter); err != nil {
+			return 0, err
+		}
+	}
+
+	return version, encoder.Encode(&replicationMessage{Version: version})
+}
+
+func (d *DB) sendReplicationCollection(ctx context.Context, encoder *json.Encoder, c *Collection, filter *ChangeFilter) error {
+	if !filter.matchCollection(c.name) {
+		return nil
+	}
+
+	iterOptions := IterOptions{}
+	if filter != nil {
+		iterOptions.Prefix = filter.Prefix
+	}
+	iter := c.Iterate(iterOptions)
+	defer iter.Close()
+
+	for iter.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		content, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		if !filter.matchContent(content) {
+			continue
+		}
+
+		record := &ChangeRecord{Collection: c.name, ID: iter.ID(), Operation: ChangePut, Content: content, Bin: iter.Binary(), ExpiresAt: iter.item.ExpiresAt()}
+		if err := encoder.Encode(&replicationMessage{Record: record}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completeReplicationRecord sets the current content of the document if the change log
+// does not save the contents. The put becomes a delete if the document has been removed since.
+func (d *DB) completeReplicationRecord(record *ChangeRecord) error {
+	if record.Operation == ChangeDelete || record.Content != nil {
+		return nil
+	}
+
+	var c *Collection
+	for _, col := range d.getCollections() {
+		if col.name == record.Collection {
+			c = col
+			break
+		}
+	}
+	if c == nil {
+		record.Operation = ChangeDelete
+		return nil
+	}
+
+	// The flags and the expiration are read with the content
+	err := c.store.View(func(txn *badger.Txn) error {
+		contents, err := c.getFromTxn(d.ctx, txn, record.ID)
+		if err != nil {
+			return err
+		}
+		item, err := txn.Get(c.buildStoreID(record.ID))
+		if err != nil {
+			return err
+		}
+		record.Content, record.Bin, record.ExpiresAt = contents[0], isBinaryMeta(item.UserMeta()), item.ExpiresAt()
+		return nil
+	})
+	if err == ErrNotFound {
+		record.Operation = ChangeDelete
+		return nil
+	}
+	return err
+}
+
+// replicationIdentity returns the identity of the change log served to the replicas
+func (d *DB) replicationIdentity() (*replicationIdentity, error) {
+	id, err := d.PeerID()
+	if err != nil {
+		return nil, err
+	}
+	epoch, err := d.getStoredVersion(replicationEpochKey)
+	if err != nil {
+		return nil, err
+	}
+	return &replicationIdentity{ID: id, Epoch: epoch}, nil
+}
+
+// newReplicationEpoch moves the epoch after the one before a backup is loaded
+// and the one of the backup, the replicas are then copied again
+func (d *DB) newReplicationEpoch(previous uint64) error {
+	loaded, err := d.getStoredVersion(replicationEpochKey)
+	if err != nil {
+		return err
+	}
+	if previous > loaded {
+		loaded = previous
+	}
+	return d.setStoredVersion(replicationEpochKey, loaded+1)
+}
+
+// replicationLoop keeps the replica connected to the primary until the database is closed
+func (d *DB) replicationLoop(primaryAddr string) {
+	defer d.replicationWaitGroup.Done()
+	ctx := d.replicationCtx
+
+	dialer := &tls.Dialer{Config: d.options.ReplicationTLS}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", primaryAddr)
+		if err == nil {
+			err = d.replicate(ctx, conn)
+		}
+		if err != nil && ctx.Err() == nil {
+			d.options.log(LogWarn, "replication interrupted", "primary", primaryAddr, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ReplicationRetryInterval):
+		}
+	}
+}
+
+// replicate applies the messages of the primary until the connection fails
+func (d *DB) replicate(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	version, err := d.getStoredVersion(replicationVersionKey)
+	if err != nil {
+		return err
+	}
+	// The replica is copied again if it has not the documents of the filter
+	if changed, err := d.replicationFilterChanged(); err != nil {
+		return err
+	} else if changed {
+		version = 0
+	}
+	primary := new(replicationIdentity)
+	if found, err := d.getStoredJSON(replicationPrimaryKey, primary); err != nil {
+		return err
+	} else if !found {
+		primary = nil
+	}
+	if err := json.NewEncoder(conn).Encode(&replicationHello{Version: version, Filter: d.options.ReplicationFilter, Primary: primary}); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for ctx.Err() == nil {
+		// The primary sends a heartbeat when there is no write
+		conn.SetReadDeadline(time.Now().Add(3 * ReplicationHeartbeatInterval))
+		message := new(replicationMessage)
+		if err := decoder.Decode(message); err != nil {
+			return err
+		}
+
+		if message.Sync {
+			if err := d.startReplicationCopy(message.Collections, message.Primary); err != nil {
+				return err
+			}
+			version = 0
+		}
+		if message.Record != nil {
+			if err := d.applyReplicationRecord(message.Record); err != nil {
+				return err
+			}
+		}
+		if message.Version != 0 && message.Version != version {
+			if err := d.setStoredVersion(replicationVersionKey, message.Version); err != nil {
+				return err
+			}
+			version = message.Version
+		}
+	}
+	return nil
+}
+
+// startReplicationCopy removes the documents of the replica and sets the indexes of the primary.
+// The version is reset to get a new copy if the connection fails before the end of this one.
+// The identity of the primary is saved with it, the versions which follow are the ones of its change log.
+func (d *DB) startReplicationCopy(collections []CollectionInfo, primary *replicationIdentity) error {
+	if err := d.setStoredVersion(replicationVersionKey, 0); err != nil {
+		return err
+	}
+	if err := d.setStoredJSON(replicationPrimaryKey, primary); err != nil {
+		return err
+	}
+
+	for _, c := range d.getCollections() {
+		if err := c.removeReplicatedDocuments(); err != nil {
+			return err
+		}
+	}
+
+	for _, info := range collections {
+		c, err := d.use(info.Name, "")
+		if err != nil {
+			return err
+		}
+
+		existing := map[string]bool{}
+		for _, index := range c.indexes {
+			existing[index.Name] = true
+		}
+		for _, index := range info.Indexes {
+			// The extractors can't be sent and need to be set on the replica
+			if index.Extractor || existing[index.Name] {
+				continue
+			}
+			if err := c.SetIndexWithOptions(index.Name, index.Type, index.Options, index.Selector...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeReplicatedDocuments deletes all the documents of the collection by batches of DeleteManyBatchSize
+func (c *Collection) removeReplicatedDocuments() error {
+	for {
+		batch := c.NewBatch()
+		batch.replicated = true
+
+		iter := c.Iterate(IterOptions{Limit: DeleteManyBatchSize})
+		for iter.Next() {
+			batch.Delete(iter.ID())
+		}
+		iter.Close()
+
+		if batch.Len() == 0 {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+}
+
+// applyReplicationRecord saves the write of the primary into the replica.
+// The contents with a TTL are saved with what is left of it, so they expire like on the primary.
+func (d *DB) applyReplicationRecord(record *ChangeRecord) error {
+	c, err := d.use(record.Collection, "")
+	if err != nil {
+		return err
+	}
+
+	var content interface{} = json.RawMessage(record.Content)
+	if record.Bin {
+		content = record.Content
+	}
+	if record.Operation != ChangeDelete && record.ExpiresAt != 0 {
+		ttl := time.Until(time.Unix(int64(record.ExpiresAt), 0))
+		if ttl > 0 {
+			ctx, cancel := context.WithTimeout(d.ctx, d.options.TransactionTimeOut)
+			defer cancel()
+			return c.put(ctx, record.ID, content, &putOptions{ttl: ttl, replicated: true})
+		}
+		// Already expired on the primary
+		record.Operation = ChangeDelete
+	}
+
+	batch := c.NewBatch()
+	batch.replicated = true
+	if record.Operation == ChangeDelete {
+		err = batch.Delete(record.ID)
+	} else {
+		err = batch.Put(record.ID, content)
+	}
+	if err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// replicationFilterChanged saves the filter of the options and returns if it is not the saved one
+func (d *DB) replicationFilterChanged() (changed bool, _ error) {
+	filterAsBytes, err := json.Marshal(d.options.ReplicationFilter)
+	if err != nil {
+		return false, err
+	}
+
+	return changed, d.valueStore.Update(func(txn *badger.Txn) error {
+		saved := []byte("null")
+		item, err := txn.Get(replicationFilterKey)
+		if err == nil {
+			if saved, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		changed = !bytes.Equal(saved, filterAsBytes)
+		if !changed {
+			return nil
+		}
+		return txn.Set(replicationFilterKey, filterAsBytes)
+	})
+}
+
+// getStoredJSON decodes the value saved under the key into dest and returns false if there is none
+func (d *DB) getStoredJSON(key []byte, dest interface{}) (found bool, _ error) {
+	return found, d.valueStore.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
+		found = true
+		return json.Unmarshal(value, dest)
+	})
+}
+
+// setStoredJSON saves the value as JSON under the key
+func (d *DB) setStoredJSON(key []byte, value interface{}) error {
+	valueAsBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return d.valueStore.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, valueAsBytes)
+	})
+}
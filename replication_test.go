@@ -0,0 +1,298 @@
+package gotinydb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestReplicationTLS returns a configuration with a self signed certificate
+// used by the primary and the replicas to authenticate each other
+func newTestReplicationTLS(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gotinydb test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer func(retryInterval time.Duration) {
+		ReplicationRetryInterval = retryInterval
+	}(ReplicationRetryInterval)
+	ReplicationRetryInterval = time.Millisecond * 50
+
+	primaryPath := <-getTestPathChan
+	defer os.RemoveAll(primaryPath)
+	primaryOptions := NewDefaultOptions(primaryPath)
+	primaryOptions.ChangeLogSize = 100
+	primary, openDBErr := Open(ctx, primaryOptions)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer primary.Close()
+
+	// The documents are only sent over TLS
+	if err := primary.ServeReplication(nil); err != ErrReplicationTLSMissing {
+		t.Errorf("expected %v but got %v", ErrReplicationTLSMissing, err)
+		return
+	}
+	if _, err := OpenReplica(ctx, NewDefaultOptions(primaryPath), "127.0.0.1:1"); err != ErrReplicationTLSMissing {
+		t.Errorf("expected %v but got %v", ErrReplicationTLSMissing, err)
+		return
+	}
+	tlsConfig := newTestReplicationTLS(t)
+	primaryOptions.ReplicationTLS = tlsConfig
+
+	c, _ := primary.Use("testCol")
+	if err := c.SetIndex("email", StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users[:5] {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	served := make(chan error, 1)
+	go func() { served <- primary.ServeReplication(listener) }()
+
+	replicaPath := <-getTestPathChan
+	defer os.RemoveAll(replicaPath)
+	replicaOptions := NewDefaultOptions(replicaPath)
+	replicaOptions.ReplicationTLS = tlsConfig
+	replica, openDBErr := OpenReplica(ctx, replicaOptions, listener.Addr().String())
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer replica.Close()
+
+	// waitFor returns false if the replica does not have the user after some time
+	waitFor := func(id string, deleted bool) bool {
+		for start := time.Now(); time.Since(start) < time.Second*5; time.Sleep(time.Millisecond * 10) {
+			rc, err := replica.Use("testCol")
+			if err != nil {
+				continue
+			}
+			err = rc.Get(id, nil)
+			if deleted == (err == ErrNotFound) {
+				return true
+			}
+		}
+		t.Errorf("the replica is not up to date with %q", id)
+		return false
+	}
+
+	// The documents saved before are copied
+	if !waitFor(users[4].ID, false) {
+		return
+	}
+	rc, _ := replica.Use("testCol")
+	if len(rc.Indexes()) != 1 || rc.Indexes()[0].Name != "email" {
+		t.Errorf("the index is not replicated %+v", rc.Indexes())
+		return
+	}
+	if err := rc.Put(users[5].ID, users[5]); err != ErrReplica {
+		t.Errorf("expected %v but got %v", ErrReplica, err)
+		return
+	}
+
+	if err := c.Put(users[5].ID, users[5]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Delete(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if !waitFor(users[5].ID, false) || !waitFor(users[0].ID, true) {
+		return
+	}
+
+	// The replica continues after a disconnection
+	listener.Close()
+	if err := <-served; err == nil {
+		t.Errorf("the closed listener must return an error")
+		return
+	}
+	if err := c.Put(users[6].ID, users[6]); err != nil {
+		t.Error(err)
+		return
+	}
+	if listener, err = net.Listen("tcp", listener.Addr().String()); err != nil {
+		t.Error(err)
+		return
+	}
+	go func() { served <- primary.ServeReplication(listener) }()
+	defer func() {
+		listener.Close()
+		<-served
+	}()
+
+	if !waitFor(users[6].ID, false) {
+		return
+	}
+	response, err := rc.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[6].Email)))
+	if err != nil || response.Len() != 1 {
+		t.Errorf("the index of the replica is not updated: %v", err)
+		return
+	}
+
+	primaryVersion := primary.changeLog.version
+	if version, err := replica.getStoredVersion(replicationVersionKey); err != nil || version != primaryVersion {
+		t.Errorf("expected version %d but got %d and %v", primaryVersion, version, err)
+		return
+	}
+
+	// The documents expire on the replica like on the primary
+	if err := c.PutWithTTL(users[7].ID, users[7], time.Second*2); err != nil {
+		t.Error(err)
+		return
+	}
+	if !waitFor(users[7].ID, false) || !waitFor(users[7].ID, true) {
+		return
+	}
+
+	// The replica is copied again when the epoch of the primary changes
+	if err := primary.newReplicationEpoch(0); err != nil {
+		t.Error(err)
+		return
+	}
+	identity, _ := primary.replicationIdentity()
+	for start := time.Now(); time.Since(start) < time.Second*5; time.Sleep(time.Millisecond * 10) {
+		following := new(replicationIdentity)
+		if _, err := replica.getStoredJSON(replicationPrimaryKey, following); err == nil && *following == *identity {
+			return
+		}
+	}
+	t.Errorf("the replica does not follow the epoch %d of the primary", identity.Epoch)
+}
+
+func TestReplicationFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primaryPath := <-getTestPathChan
+	defer os.RemoveAll(primaryPath)
+	primaryOptions := NewDefaultOptions(primaryPath)
+	primaryOptions.ChangeLogSize = 100
+	primaryOptions.ReplicationTLS = newTestReplicationTLS(t)
+	primary, openDBErr := Open(ctx, primaryOptions)
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer primary.Close()
+
+	c, _ := primary.Use("testCol")
+	other, _ := primary.Use("other")
+	c.Put("1", map[string]string{"Tenant": "a"})
+	c.Put("2", map[string]string{"Tenant": "b"})
+	other.Put("1", map[string]string{"Tenant": "a"})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go primary.ServeReplication(listener)
+
+	replicaPath := <-getTestPathChan
+	defer os.RemoveAll(replicaPath)
+	replicaOptions := NewDefaultOptions(replicaPath)
+	replicaOptions.ReplicationTLS = primaryOptions.ReplicationTLS
+	replicaOptions.ReplicationFilter = &ChangeFilter{Collections: []string{"testCol"}, Selector: []string{"Tenant"}, Values: []interface{}{"a"}}
+	replica, openDBErr := OpenReplica(ctx, replicaOptions, listener.Addr().String())
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer replica.Close()
+
+	// waitFor returns false if the replica does not have the expected IDs after some time
+	waitFor := func(ids ...string) bool {
+		got := []string{}
+		for start := time.Now(); time.Since(start) < time.Second*5; time.Sleep(time.Millisecond * 10) {
+			rc, err := replica.Use("testCol")
+			if err != nil {
+				continue
+			}
+			got = []string{}
+			iter := rc.Iterate(IterOptions{})
+			for iter.Next() {
+				got = append(got, iter.ID())
+			}
+			iter.Close()
+			if fmt.Sprint(got) == fmt.Sprint(ids) {
+				return true
+			}
+		}
+		t.Errorf("expected %v but the replica has %v", ids, got)
+		return false
+	}
+
+	if !waitFor("1") {
+		return
+	}
+	for _, info := range replica.Collections() {
+		if info.Name == "other" {
+			t.Errorf("the collection is not selected")
+			return
+		}
+	}
+
+	// The document is removed when it leaves the filter
+	c.Put("1", map[string]string{"Tenant": "b"})
+	c.Put("3", map[string]string{"Tenant": "a"})
+	waitFor("3")
+}
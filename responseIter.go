@@ -0,0 +1,47 @@
+//go:build go1.23
+// +build go1.23
+
+package gotinydb
+
+import (
+	"encoding/json"
+	"iter"
+)
+
+// Iter returns an iterator over the IDs and the contents of the response in its order.
+// It does not change the position of First, Next, Prev and One.
+//
+//	for id, content := range response.Iter() {
+//		...
+//	}
+func (r *Response) Iter() iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		if r == nil {
+			return
+		}
+		for _, elem := range r.list {
+			if !yield(elem.ID.String(), elem.ContentAsBytes) {
+				return
+			}
+		}
+	}
+}
+
+// IterAs returns an iterator over the contents of the response decoded as values of type T.
+// An element which can't be decoded is returned with the error of json.Unmarshal,
+// the loop can go on to the next one or stop.
+//
+//	for user, err := range IterAs[*User](response) {
+//		...
+//	}
+func IterAs[T any](r *Response) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for _, content := range r.Iter() {
+			var elem T
+			err := json.Unmarshal(content, &elem)
+			if !yield(elem, err) {
+				return
+			}
+		}
+	}
+}
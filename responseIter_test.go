@@ -0,0 +1,77 @@
+//go:build go1.23
+// +build go1.23
+
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestResponseIter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _ := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+
+	response, err := c.Query(NewQuery().SetLimits(10, 1000).SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(5))).SetOrder(true, "Age"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ids := []string{}
+	for id, content := range response.Iter() {
+		if len(content) == 0 {
+			t.Errorf("the content of %q is empty", id)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != response.Len() {
+		t.Errorf("expected %d elements but had %d", response.Len(), len(ids))
+		return
+	}
+	// The iteration does not move the cursor
+	if _, id, _ := response.First(); id != ids[0] {
+		t.Errorf("expected %q first but had %q", ids[0], id)
+		return
+	}
+
+	i := 0
+	lastAge := uint(0)
+	for user, err := range IterAs[*User](response) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.ID != ids[i] || user.Age < lastAge {
+			t.Errorf("unexpected user %+v at position %d", user, i)
+			return
+		}
+		lastAge = user.Age
+		i++
+		// The loop can stop early
+		if i == 3 {
+			break
+		}
+	}
+	if i != 3 {
+		t.Errorf("expected 3 iterations but had %d", i)
+		return
+	}
+
+	var nilResponse *Response
+	for range nilResponse.Iter() {
+		t.Errorf("a nil response has no element")
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// do runs the function until it succeeds, returns a non transient error or
+// the attempts are exhausted. A nil policy runs the function once.
+func (p *RetryPolicy) do(ctx context.Context, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryMaxAttempts
+	}
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryInitialBackoff
+	}
+
+	errs := []error{}
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if !p.isTransient(err) {
+			if len(errs) == 0 {
+				return err
+			}
+			return &RetryError{Errors: append(errs, err)}
+		}
+
+		errs = append(errs, err)
+		if len(errs) >= maxAttempts {
+			return &RetryError{Errors: errs}
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return &RetryError{Errors: append(errs, ErrTimeOut)}
+		}
+
+		backoff = backoff * 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// updateStore runs fn in a store transaction and commits it. Only this transaction
+// is run again after a transient error, the rest of the write is done once.
+func (c *Collection) updateStore(ctx context.Context, fn func(txn *badger.Txn) error) error {
+	return c.options.Retry.do(ctx, c.metrics.countRetries(func() error {
+		return c.store.Update(fn)
+	}))
+}
+
+func (p *RetryPolicy) isTransient(err error) bool {
+	if p.IsTransient != nil {
+		return p.IsTransient(err)
+	}
+	return isTransientError(err)
+}
+
+// isTransientError returns true for the storage errors which can succeed if the operation is done again
+func isTransientError(err error) bool {
+	// The deadline of the caller is not a storage error even if it is a timeout
+	if err == context.DeadlineExceeded {
+		return false
+	}
+
+	switch typedErr := err.(type) {
+	case *os.PathError:
+		return isTransientError(typedErr.Err)
+	case *os.SyscallError:
+		return isTransientError(typedErr.Err)
+	case syscall.Errno:
+		// The memory map growth can fail temporary when the memory is short
+		return typedErr == syscall.EAGAIN || typedErr == syscall.EINTR || typedErr == syscall.ENOMEM
+	case interface{ Temporary() bool }:
+		return typedErr.Temporary()
+	case interface{ Timeout() bool }:
+		return typedErr.Timeout()
+	}
+
+	return err == badger.ErrConflict || err == badger.ErrRetry || err == bolt.ErrTimeout
+}
+
+func (e *RetryError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("failed after %d attempts: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Last returns the last error
+func (e *RetryError) Last() error {
+	return e.Errors[len(e.Errors)-1]
+}
@@ -0,0 +1,101 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	// Succeeds after a transient error
+	attempts := 0
+	if err := policy.do(ctx, func() error {
+		attempts++
+		if attempts == 1 {
+			return badger.ErrConflict
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts but had %d", attempts)
+		return
+	}
+
+	// The other errors are not retried
+	attempts = 0
+	if err := policy.do(ctx, func() error {
+		attempts++
+		return ErrNotFound
+	}); err != ErrNotFound || attempts != 1 {
+		t.Errorf("expected %v after 1 attempt but had %v after %d", ErrNotFound, err, attempts)
+		return
+	}
+
+	// The errors are aggregated after the last attempt
+	attempts = 0
+	err := policy.do(ctx, func() error {
+		attempts++
+		return &os.PathError{Op: "mmap", Path: "db", Err: syscall.ENOMEM}
+	})
+	retryErr, ok := err.(*RetryError)
+	if !ok || len(retryErr.Errors) != 3 || attempts != 3 {
+		t.Errorf("expected a retry error with 3 errors but had %v after %d attempts", err, attempts)
+		return
+	}
+	if _, ok := retryErr.Last().(*os.PathError); !ok {
+		t.Errorf("expected the last error to be the path error but had %v", retryErr.Last())
+		return
+	}
+
+	// The custom check replaces the default one
+	policy.IsTransient = func(err error) bool { return err == ErrNotFound }
+	attempts = 0
+	policy.do(ctx, func() error {
+		attempts++
+		return ErrNotFound
+	})
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts but had %d", attempts)
+		return
+	}
+
+	// A nil policy runs once
+	attempts = 0
+	var nilPolicy *RetryPolicy
+	if err := nilPolicy.do(ctx, func() error {
+		attempts++
+		return badger.ErrConflict
+	}); err != badger.ErrConflict || attempts != 1 {
+		t.Errorf("expected one attempt but had %d", attempts)
+		return
+	}
+
+	// The deadline of the caller is a timeout but not a storage error
+	if isTransientError(context.DeadlineExceeded) {
+		t.Errorf("the deadline of the context is detected as transient")
+		return
+	}
+
+	// The retries stop with the context
+	cancel()
+	policy.InitialBackoff = time.Second
+	err = policy.do(ctx, func() error {
+		return ErrNotFound
+	})
+	if retryErr, ok := err.(*RetryError); !ok || retryErr.Last() != ErrTimeOut {
+		t.Errorf("expected the retries to stop on the canceled context but had %v", err)
+		return
+	}
+}
@@ -0,0 +1,85 @@
+package gotinydb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetSchema defines the fields the documents must have. It replaces the previous schema
+// and an empty list removes it. The saved documents are not checked, only the next writes are.
+// The binary contents are not checked.
+func (c *Collection) SetSchema(fields ...*SchemaField) error {
+	for _, field := range fields {
+		if field == nil || len(field.Selector) == 0 {
+			return fmt.Errorf("the schema fields need a selector")
+		}
+		if field.Type.TypeName() == "" {
+			return ErrWrongType
+		}
+	}
+
+	if err := c.setSchemaIntoConfigBucket(fields); err != nil {
+		return err
+	}
+	c.schema = fields
+	return nil
+}
+
+// Schema returns the fields the documents must have
+func (c *Collection) Schema() []*SchemaField {
+	return c.schema
+}
+
+// checkSchema returns a *SchemaError for the first field of the schema not found
+// in the JSON content or with the wrong type
+func (c *Collection) checkSchema(id string, contentAsBytes []byte) error {
+	if len(c.schema) == 0 {
+		return nil
+	}
+
+	object, decodeErr := decodeStored(contentAsBytes)
+	for _, field := range c.schema {
+		schemaErr := &SchemaError{
+			ID:       id,
+			Selector: field.Selector,
+			Type:     field.Type,
+		}
+
+		if decodeErr != nil {
+			schemaErr.Missing = true
+			return schemaErr
+		}
+
+		value, found := getValueFromSelector(object, field.Selector)
+		if !found || value == nil {
+			schemaErr.Missing = true
+			return schemaErr
+		}
+
+		if !field.hasType(value) {
+			return schemaErr
+		}
+	}
+	return nil
+}
+
+// hasType checks the value decoded from JSON like the indexes do
+func (f *SchemaField) hasType(value interface{}) bool {
+	var err error
+	switch f.Type {
+	case StringIndex, HashIndex:
+		_, err = stringToBytes(value)
+	case IntIndex:
+		_, err = jsonNumberToBytes(value)
+	case TimeIndex:
+		_, err = jsonTimeToBytes(value)
+	}
+	return err == nil
+}
+
+func (e *SchemaError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("document %q: the field %q is required", e.ID, strings.Join(e.Selector, "."))
+	}
+	return fmt.Sprintf("document %q: the field %q must be of type %s", e.ID, strings.Join(e.Selector, "."), e.Type.TypeName())
+}
@@ -0,0 +1,282 @@
+package gotinydb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// UseSharded builds or gets a collection split by time period. Every shard is a
+// collection named after the collection and its period, like "logs@2018-05-31"
+// or "logs@2018-05". The collection with the given name saves the settings and
+// the index definitions set to the new shards.
+// The expired shards are deleted at the call and when a new shard is created.
+// Nil options define daily shards without retention.
+func (d *DB) UseSharded(name string, options *ShardOptions) (*ShardedCollection, error) {
+	if options == nil {
+		options = &ShardOptions{Period: ShardDaily}
+	}
+	if options.Period != ShardDaily && options.Period != ShardMonthly {
+		return nil, fmt.Errorf("unknown shard period %q", options.Period)
+	}
+
+	base, err := d.Use(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = base.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("config"))
+		saved := bucket.Get([]byte("shardPeriod"))
+		if saved == nil {
+			return bucket.Put([]byte("shardPeriod"), []byte(options.Period))
+		}
+		if ShardPeriod(saved) != options.Period {
+			return fmt.Errorf("the collection %q is split by %s periods", name, string(saved))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShardedCollection{
+		db:      d,
+		name:    name,
+		options: options,
+		base:    base,
+		now:     time.Now,
+	}
+
+	if err := s.dropExpired(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetIndex sets the index in all the shards and in the next ones
+func (s *ShardedCollection) SetIndex(name string, t IndexType, selector ...string) error {
+	return s.SetIndexWithOptions(name, t, nil, selector...)
+}
+
+// SetIndexWithOptions does the same as SetIndex with the options of the index
+func (s *ShardedCollection) SetIndexWithOptions(name string, t IndexType, options *IndexOptions, selector ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.base.SetIndexWithOptions(name, t, options, selector...); err != nil {
+		return err
+	}
+	shards, err := s.shards(time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if err := shard.SetIndexWithOptions(name, t, options, selector...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put saves the content into the shard of the given time.
+// ErrShardExpired is returned if the shard is older than the retention.
+func (s *ShardedCollection) Put(t time.Time, id string, content interface{}) error {
+	shard, err := s.getShard(t)
+	if err != nil {
+		return err
+	}
+	return shard.Put(id, content)
+}
+
+// Get decodes the content of the ID from the most recent shard which has it into dest
+func (s *ShardedCollection) Get(id string, dest interface{}) error {
+	contentAsBytes, err := s.GetRaw(id)
+	if err != nil {
+		return err
+	}
+	return unmarshalContent(contentAsBytes, dest)
+}
+
+// GetRaw returns the content of the ID from the most recent shard which has it
+func (s *ShardedCollection) GetRaw(id string) ([]byte, error) {
+	shards := s.Shards()
+	for i := len(shards) - 1; i >= 0; i-- {
+		shard, err := s.db.Use(shards[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if exists, err := shard.Exists(id); err != nil {
+			return nil, err
+		} else if exists {
+			return shard.GetRaw(id)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete removes the ID from all the shards
+func (s *ShardedCollection) Delete(id string) error {
+	shards, err := s.shards(time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if err := shard.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query runs the query in the shards of the periods between from and to and returns
+// the results in the order of the query. The zero times mean no limit.
+func (s *ShardedCollection) Query(q *Query, from, to time.Time) (*Response, error) {
+	shards, err := s.shards(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := []*Response{}
+	for _, shard := range shards {
+		response, err := shard.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return mergeResponses(q, responses...), nil
+}
+
+// Shards returns the names of the shards from the oldest to the most recent
+func (s *ShardedCollection) Shards() []string {
+	ret := []string{}
+	for _, col := range s.db.getCollections() {
+		if _, ok := s.periodOf(col.name); ok {
+			ret = append(ret, col.name)
+		}
+	}
+	// The period formats are sorted like the times
+	sort.Strings(ret)
+	return ret
+}
+
+// shards returns the shards of the periods between from and to
+func (s *ShardedCollection) shards(from, to time.Time) ([]*Collection, error) {
+	if !from.IsZero() {
+		from = s.periodStart(from)
+	}
+
+	ret := []*Collection{}
+	for _, name := range s.Shards() {
+		start, _ := s.periodOf(name)
+		if (!from.IsZero() && start.Before(from)) || (!to.IsZero() && start.After(to)) {
+			continue
+		}
+
+		shard, err := s.db.Use(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, shard)
+	}
+	return ret, nil
+}
+
+// getShard returns the shard of the given time and creates it if needed
+func (s *ShardedCollection) getShard(t time.Time) (*Collection, error) {
+	if s.isExpired(s.periodStart(t)) {
+		return nil, ErrShardExpired
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	name := s.shardName(t)
+	for _, col := range s.db.getCollections() {
+		if col.name == name {
+			return col, nil
+		}
+	}
+
+	shard, err := s.db.Use(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range s.base.indexes {
+		if err := shard.SetIndexWithOptions(index.Name, index.Type, index.getOptions(), index.Selector...); err != nil {
+			return nil, err
+		}
+	}
+
+	return shard, s.dropExpiredLocked()
+}
+
+func (s *ShardedCollection) dropExpired() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropExpiredLocked()
+}
+
+// dropExpiredLocked deletes the shards older than the retention, the mutex must be locked
+func (s *ShardedCollection) dropExpiredLocked() error {
+	for _, name := range s.Shards() {
+		start, _ := s.periodOf(name)
+		if !s.isExpired(start) {
+			continue
+		}
+		if err := s.db.DeleteCollection(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isExpired returns true if the period starting at the given time is older than the retention
+func (s *ShardedCollection) isExpired(start time.Time) bool {
+	if s.options.Retention <= 0 {
+		return false
+	}
+
+	oldest := s.periodStart(s.now())
+	if s.options.Period == ShardMonthly {
+		oldest = oldest.AddDate(0, 1-s.options.Retention, 0)
+	} else {
+		oldest = oldest.AddDate(0, 0, 1-s.options.Retention)
+	}
+	return start.Before(oldest)
+}
+
+func (s *ShardedCollection) layout() string {
+	if s.options.Period == ShardMonthly {
+		return "2006-01"
+	}
+	return "2006-01-02"
+}
+
+// periodStart returns the beginning of the period of the given time in UTC
+func (s *ShardedCollection) periodStart(t time.Time) time.Time {
+	start, _ := time.Parse(s.layout(), t.UTC().Format(s.layout()))
+	return start
+}
+
+func (s *ShardedCollection) shardName(t time.Time) string {
+	return s.name + "@" + t.UTC().Format(s.layout())
+}
+
+// periodOf returns the beginning of the period of the shard and false if the name is not one of its shards
+func (s *ShardedCollection) periodOf(shardName string) (time.Time, bool) {
+	if !strings.HasPrefix(shardName, s.name+"@") {
+		return time.Time{}, false
+	}
+
+	start, err := time.Parse(s.layout(), strings.TrimPrefix(shardName, s.name+"@"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
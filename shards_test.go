@@ -0,0 +1,113 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestShardedCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	s, err := db.UseSharded("logs", &ShardOptions{Period: ShardDaily, Retention: 3})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := s.SetIndex("age", IntIndex, "Age"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	now := time.Now()
+	day := time.Hour * 24
+	users := unmarshalDataSet(dataSet1)[:30]
+	for i, user := range users {
+		if err := s.Put(now.Add(-day*time.Duration(i%3)), user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if len(s.Shards()) != 3 {
+		t.Errorf("expected 3 shards but had %v", s.Shards())
+		return
+	}
+	if err := s.Put(now.Add(-day*3), "old", users[0]); err != ErrShardExpired {
+		t.Errorf("expected %v but had %v", ErrShardExpired, err)
+		return
+	}
+
+	// The responses of the shards are merged in the order of the query
+	q := NewQuery().SetFilter(NewFilter(Greater).SetSelector("Age").CompareTo(uint(0)).EqualWanted()).SetOrder(true, "Age")
+	response, queryErr := s.Query(q, time.Time{}, time.Time{})
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if response.Len() != len(users) {
+		t.Errorf("expected %d results but had %d", len(users), response.Len())
+		return
+	}
+	previousAge := uint(0)
+	for _, id, _ := response.First(); id != ""; _, id, _ = response.Next() {
+		user := new(User)
+		if err := s.Get(id, user); err != nil {
+			t.Error(err)
+			return
+		}
+		if user.Age < previousAge {
+			t.Errorf("the results are not ordered by age")
+			return
+		}
+		previousAge = user.Age
+	}
+
+	// Only the shards of the period are queried
+	response, _ = s.Query(q, now.Add(-day), now)
+	if expected := len(users) * 2 / 3; response.Len() != expected {
+		t.Errorf("expected %d results but had %d", expected, response.Len())
+		return
+	}
+
+	if err := s.Delete(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := s.Get(users[0].ID, nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	// Two days later the two oldest shards are expired
+	s.now = func() time.Time { return now.Add(day * 2) }
+	if err := s.Put(s.now(), users[0].ID, users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+	if shards := s.Shards(); len(shards) != 2 || shards[0] != s.shardName(now) {
+		t.Errorf("the expired shards are not deleted: %v", shards)
+		return
+	}
+	// The new shards have the indexes
+	response, _ = s.Query(q, s.now(), time.Time{})
+	if response.Len() != 1 {
+		t.Errorf("expected 1 result but had %d", response.Len())
+		return
+	}
+
+	if _, err := db.UseSharded("logs", &ShardOptions{Period: ShardMonthly}); err == nil {
+		t.Errorf("the period of the collection can't be changed")
+		return
+	}
+}
@@ -0,0 +1,295 @@
+package gotinydb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// Snapshot returns a read-only view of all the collections at the time of the call.
+// The writes done after are not seen by the snapshot.
+// The writes of the collections wait while the snapshot is taken, so the values and the
+// indexes are the same as after the last write. The background work, like the cleaning
+// of the expired documents or the building of an index, is not stopped and can be seen
+// by the indexes and not by the values.
+// The index files are copied into temporary files, so the snapshot does not hold the
+// index files and the writes go on while it is used. The copies take the disk space
+// of the index files and are removed by Close, which must be called as soon as the
+// snapshot is not needed anymore.
+func (d *DB) Snapshot() (*Snapshot, error) {
+	s := &Snapshot{
+		db:          d,
+		collections: map[string]*SnapshotCollection{},
+	}
+
+	// The collections are locked in the order of the transactions
+	collections := d.getCollections()
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].id < collections[j].id
+	})
+	for _, c := range collections {
+		c.writeMutex.Lock()
+	}
+	defer func() {
+		for _, c := range collections {
+			c.writeMutex.Unlock()
+		}
+	}()
+
+	for _, c := range collections {
+		tx, endTx, err := c.beginIndexCopyView()
+		if err != nil {
+			s.release()
+			return nil, err
+		}
+		s.collections[c.name] = &SnapshotCollection{
+			snapshot: s,
+			c:        c,
+			tx:       tx,
+			endTx:    endTx,
+		}
+	}
+	s.txn, s.endTxn = d.valueStore.begin(false)
+
+	return s, nil
+}
+
+// beginIndexCopyView copies the index file into a temporary file and starts a read
+// transaction on the copy. The returned function ends it and removes the copy.
+func (c *Collection) beginIndexCopyView() (*bolt.Tx, func(), error) {
+	tmpFile, tmpErr := ioutil.TempFile("", "gotinydb-snapshot-")
+	if tmpErr != nil {
+		return nil, nil, tmpErr
+	}
+	path := tmpFile.Name()
+
+	err := c.viewIndex(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(tmpFile)
+		return err
+	})
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, err
+	}
+
+	options := new(bolt.Options)
+	if c.options.BoltOptions != nil {
+		*options = *c.options.BoltOptions
+	}
+	options.ReadOnly = true
+	db, err := bolt.Open(path, FilePermission, options)
+	if err != nil {
+		os.Remove(path)
+		return nil, nil, err
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		db.Close()
+		os.Remove(path)
+		return nil, nil, err
+	}
+	return tx, func() {
+		tx.Rollback()
+		if err := db.Close(); err != nil {
+			c.options.log(LogError, "the index copy of the snapshot can't be closed", "collection", c.name, "error", err)
+		}
+		os.Remove(path)
+	}, nil
+}
+
+// Collection returns the collection of the given name as it was when the snapshot was taken.
+// ErrNotFound is returned if the collection did not exist.
+func (s *Snapshot) Collection(name string) (*SnapshotCollection, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrSnapshotClosed
+	}
+
+	sc, ok := s.collections[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return sc, nil
+}
+
+// Close releases the snapshot. It can't be used after.
+func (s *Snapshot) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+	s.closed = true
+	s.release()
+	return nil
+}
+
+func (s *Snapshot) release() {
+	if s.txn != nil {
+		s.endTxn()
+	}
+	for _, sc := range s.collections {
+		// Waits for the index reads of a query which timed out
+		sc.txMutex.Lock()
+		sc.endTx()
+		sc.txMutex.Unlock()
+	}
+}
+
+// use runs the function if the snapshot is not closed. The store transaction
+// can't be used by many goroutines at the same time so the calls are done one after the other.
+func (s *Snapshot) use(fn func() error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+	return fn()
+}
+
+// Get does the same as Collection.Get with the content saved when the snapshot was taken
+func (sc *SnapshotCollection) Get(id string, dest interface{}) error {
+	contentAsBytes, err := sc.GetRaw(id)
+	if err != nil {
+		return err
+	}
+	return unmarshalContent(contentAsBytes, dest)
+}
+
+// GetRaw does the same as Collection.GetRaw with the content saved when the snapshot was taken
+func (sc *SnapshotCollection) GetRaw(id string) (contentAsBytes []byte, _ error) {
+	if id == "" {
+		return nil, ErrEmptyID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sc.c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := sc.snapshot.use(func() error {
+		response, err := sc.c.getFromTxn(ctx, sc.snapshot.txn, id)
+		if err != nil {
+			return err
+		}
+		contentAsBytes = response[0]
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(contentAsBytes) == 0 {
+		return nil, fmt.Errorf("content of %q is empty or not present", id)
+	}
+	return contentAsBytes, nil
+}
+
+// Query does the same as Collection.Query with the indexes and the contents
+// saved when the snapshot was taken
+func (sc *SnapshotCollection) Query(q *Query) (response *Response, _ error) {
+	err := sc.snapshot.use(func() error {
+		var err error
+		response, err = sc.c.query(context.Background(), q, sc)
+		return err
+	})
+	return response, err
+}
+
+// GetIDs does the same as Collection.GetIDs with the IDs saved when the snapshot was taken
+func (sc *SnapshotCollection) GetIDs(startID string, limit int) (ids []string, _ error) {
+	err := sc.snapshot.use(func() error {
+		records, err := sc.c.getStoredIDsAndValuesFromTxn(sc.snapshot.txn, startID, limit, true)
+		if err != nil {
+			return err
+		}
+
+		ids = make([]string, len(records))
+		for i, record := range records {
+			ids[i] = record.ID.ID
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// ExportAll writes the documents of all the collections as they were when the snapshot
+// was taken, so the exported collections are consistent with each other.
+// Every line is a JSON object {"Collection": "name", "ID": "id", "Content": {...}},
+// the collections are written one after the other in the order of their names.
+// The rules of SetAnonymization are applied and the documents which are not JSON are not exported.
+func (s *Snapshot) ExportAll(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	if err := s.use(func() error {
+		names := make([]string, 0, len(s.collections))
+		for name := range s.collections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := s.collections[name].export(encoder); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+// export writes the documents of the collection with the store transaction of the snapshot
+func (sc *SnapshotCollection) export(encoder *json.Encoder) error {
+	iter := sc.snapshot.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	prefix := []byte(sc.c.id[:4] + "_")
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		item := iter.Item()
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		contentAsBytes, err := sc.c.getAndCheckContent(item.Key(), item.UserMeta(), value)
+		if err != nil {
+			return err
+		}
+		object, decodeErr := decodeStored(contentAsBytes)
+		if decodeErr != nil {
+			continue
+		}
+
+		if err := sc.c.anonymize(object); err != nil {
+			return err
+		}
+		if err := encoder.Encode(&snapshotExportRecord{
+			Collection: sc.c.name,
+			ID:         string(item.Key()[len(prefix):]),
+			Content:    object,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
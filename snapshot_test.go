@@ -0,0 +1,182 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, users := fillUpDB(ctx, t, dataSet1)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	defer os.RemoveAll(db.options.Path)
+
+	c, _ := db.Use("testCol")
+	user := users[0]
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The writes after the snapshot are not seen
+	updatedUser := unmarshalDataSet(dataSet2)[0]
+	if err := c.Put(updatedUser.ID, updatedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Delete(users[1].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.Put("new", users[2]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sc, err := snapshot.Collection("testCol")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := snapshot.Collection("unknown"); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	retrievedUser := new(User)
+	if err := sc.Get(user.ID, retrievedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if retrievedUser.Email != user.Email {
+		t.Errorf("expected %q but had %q", user.Email, retrievedUser.Email)
+		return
+	}
+	if err := sc.Get(users[1].ID, nil); err != nil {
+		t.Errorf("the deleted user is not in the snapshot: %v", err)
+		return
+	}
+
+	ids, err := sc.GetIDs("", len(users)+1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ids) != len(users) {
+		t.Errorf("expected %d IDs but had %d", len(users), len(ids))
+		return
+	}
+
+	query := NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(user.Email))
+	response, err := sc.Query(query)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if response.Len() != 1 {
+		t.Errorf("expected 1 response but had %d", response.Len())
+		return
+	}
+	if response, _ := c.Query(query); response.Len() != 0 {
+		t.Errorf("the collection returns the old email")
+		return
+	}
+
+	if err := snapshot.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := sc.Get(user.ID, nil); err != ErrSnapshotClosed {
+		t.Errorf("expected %v but had %v", ErrSnapshotClosed, err)
+		return
+	}
+}
+
+func TestSnapshotExportAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	accounts, _ := db.Use("accounts")
+	transfers, _ := db.Use("transfers")
+	if err := accounts.Put("a", map[string]int{"Balance": 10}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := accounts.Put("b", map[string]int{"Balance": 0}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := transfers.Put("bin", []byte{1, 2, 3}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer snapshot.Close()
+
+	// The transfer is done after the snapshot in the two collections
+	if err := accounts.Put("a", map[string]int{"Balance": 5}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := accounts.Put("b", map[string]int{"Balance": 5}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := transfers.Put("1", map[string]interface{}{"From": "a", "To": "b", "Amount": 5}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	exported := bytes.NewBuffer(nil)
+	if err := snapshot.ExportAll(exported); err != nil {
+		t.Error(err)
+		return
+	}
+
+	lines := []string{}
+	decoder := json.NewDecoder(exported)
+	for decoder.More() {
+		record := new(snapshotExportRecord)
+		if err := decoder.Decode(record); err != nil {
+			t.Error(err)
+			return
+		}
+		lines = append(lines, fmt.Sprintf("%s/%s %v", record.Collection, record.ID, record.Content))
+	}
+	expected := []string{"accounts/a map[Balance:10]", "accounts/b map[Balance:0]"}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Errorf("expected %v but had %v", expected, lines)
+		return
+	}
+
+	snapshot.Close()
+	if err := snapshot.ExportAll(exported); err != ErrSnapshotClosed {
+		t.Errorf("expected %v but had %v", ErrSnapshotClosed, err)
+		return
+	}
+}
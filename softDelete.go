@@ -0,0 +1,193 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dgraph-io/badger"
+)
+
+// SoftDelete removes the document from the collection but keeps it in the trash of the collection.
+// The document is not returned by Get and the queries anymore, GetDeleted reads it
+// and Restore saves it back. Delete removes it from the trash.
+func (c *Collection) SoftDelete(id string) error {
+	if id == "" {
+		return ErrEmptyID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.TransactionTimeOut)
+	defer cancel()
+
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	c.touch()
+	if err := c.diskSpace.check(); err != nil {
+		return err
+	}
+
+	hooks := c.getHooks()
+	if err := hooks.beforeDelete(id); err != nil {
+		return err
+	}
+
+	previous := c.getPrevious(id)
+	writeOnceContent := c.writeOnceContent(ctx, id)
+
+	// Ordered with the conditional writes of the queue
+	c.writeMutex.Lock()
+	// The value is moved to the trash key in one transaction
+	err := c.updateStoreWithChanges(ctx, func(txn *badger.Txn) error {
+		item, err := txn.Get(c.buildStoreID(id))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if item.IsDeletedOrExpired() {
+			return ErrNotFound
+		}
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		// The sealed values are bound to their key
+		if value, err = c.openValue(item.Key(), value); err != nil {
+			return err
+		}
+		if value, err = c.sealValue(c.buildTrashID(id), value); err != nil {
+			return err
+		}
+		if err := txn.SetWithMeta(c.buildTrashID(id), value, item.UserMeta()); err != nil {
+			return err
+		}
+		return txn.Delete(c.buildStoreID(id))
+	}, &ChangeRecord{ID: id, Operation: ChangeDelete})
+	if err == nil {
+		err = c.deleteItemFromIndexes(ctx, id, writeOnceContent)
+	}
+	c.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	c.metrics.count(c.name, metricDelete, 1)
+
+	if previous != nil {
+		c.notifyChange(ChangeDelete, id, previous, nil, false)
+	}
+	hooks.afterDelete(id)
+	return nil
+}
+
+// Restore saves back the document removed by SoftDelete.
+// ErrIDExists is returned if a document has been saved with the same ID since.
+func (c *Collection) Restore(id string) error {
+	contentAsBytes, err := c.GetDeleted(id, nil)
+	if err != nil {
+		return err
+	}
+
+	var content interface{} = contentAsBytes
+	if json.Valid(contentAsBytes) {
+		content = json.RawMessage(contentAsBytes)
+	}
+	if err := c.PutIfAbsent(id, content); err != nil {
+		return err
+	}
+
+	return c.store.Update(func(txn *badger.Txn) error {
+		return txn.Delete(c.buildTrashID(id))
+	})
+}
+
+// GetDeleted does the same as Get for the documents removed by SoftDelete
+func (c *Collection) GetDeleted(id string, pointer interface{}) (contentAsBytes []byte, _ error) {
+	if id == "" {
+		return nil, ErrEmptyID
+	}
+	c.touch()
+
+	err := c.store.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(c.buildTrashID(id))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if item.IsDeletedOrExpired() {
+			return ErrNotFound
+		}
+
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
+		contentAsBytes, err = c.getAndCheckContent(item.Key(), item.UserMeta(), value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if pointer != nil {
+		if err := json.Unmarshal(contentAsBytes, pointer); err != nil {
+			return nil, err
+		}
+	}
+	return contentAsBytes, nil
+}
+
+// ListDeleted returns the IDs of the trash of the collection in order
+func (c *Collection) ListDeleted() ([]string, error) {
+	ids := []string{}
+	err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		defer iter.Close()
+
+		prefix := c.buildTrashID("")
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			if !iter.Item().IsDeletedOrExpired() {
+				ids = append(ids, string(iter.Item().Key()[len(prefix):]))
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// EmptyTrash removes definitively all the documents removed by SoftDelete
+func (c *Collection) EmptyTrash() error {
+	ids, err := c.ListDeleted()
+	if err != nil {
+		return err
+	}
+
+	// The trash can be too big for one transaction
+	for len(ids) != 0 {
+		batch := ids
+		if len(batch) > EmptyTrashBatchSize {
+			batch = batch[:EmptyTrashBatchSize]
+		}
+		ids = ids[len(batch):]
+
+		if err := c.store.Update(func(txn *badger.Txn) error {
+			for _, id := range batch {
+				if err := txn.Delete(c.buildTrashID(id)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildTrashID returns the store key of the soft deleted document.
+// It is out of the prefix of the collection documents so the reads don't see it.
+func (c *Collection) buildTrashID(id string) []byte {
+	return []byte(c.id[:4] + "~" + id)
+}
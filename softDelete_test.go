@@ -0,0 +1,125 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestSoftDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:3]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	emailQuery := NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[0].Email))
+
+	if err := c.SoftDelete(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SoftDelete("unknown"); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	if err := c.Get(users[0].ID, nil); err == nil {
+		t.Errorf("the soft deleted document is returned by Get")
+		return
+	}
+	if response, _ := c.Query(emailQuery); response.Len() != 0 {
+		t.Errorf("the soft deleted document is returned by the query")
+		return
+	}
+	if n, _ := c.Count(); n != 2 {
+		t.Errorf("expected 2 documents but had %d", n)
+		return
+	}
+
+	deleted := new(User)
+	if _, err := c.GetDeleted(users[0].ID, deleted); err != nil {
+		t.Error(err)
+		return
+	}
+	if deleted.Email != users[0].Email {
+		t.Errorf("expected %q but had %q", users[0].Email, deleted.Email)
+		return
+	}
+	if ids, _ := c.ListDeleted(); len(ids) != 1 || ids[0] != users[0].ID {
+		t.Errorf("unexpected trash %v", ids)
+		return
+	}
+
+	if err := c.Restore(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if response, _ := c.Query(emailQuery); response.Len() != 1 {
+		t.Errorf("the restored document is not indexed")
+		return
+	}
+	if ids, _ := c.ListDeleted(); len(ids) != 0 {
+		t.Errorf("the trash is not empty %v", ids)
+		return
+	}
+	if err := c.Restore(users[0].ID); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	// The document can't be restored over a new one
+	c.SoftDelete(users[1].ID)
+	c.Put(users[1].ID, users[2])
+	if err := c.Restore(users[1].ID); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+
+	// Delete removes it from the trash
+	if err := c.Delete(users[1].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := c.GetDeleted(users[1].ID, nil); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+
+	// The trash is emptied in many transactions
+	defer func(batchSize int) {
+		EmptyTrashBatchSize = batchSize
+	}(EmptyTrashBatchSize)
+	EmptyTrashBatchSize = 2
+
+	for _, user := range users[2:7] {
+		c.SoftDelete(user.ID)
+	}
+	if err := c.EmptyTrash(); err != nil {
+		t.Error(err)
+		return
+	}
+	if ids, _ := c.ListDeleted(); len(ids) != 0 {
+		t.Errorf("the trash is not empty %v", ids)
+		return
+	}
+}
@@ -0,0 +1,123 @@
+package gotinydb
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Stats returns the state of the database and of its collections, like for an admin endpoint.
+// The documents and the indexes are counted once by StatsInterval of the options, the calls
+// done in between return the same counts. The first call takes longer with the size of the database.
+// The values and the indexes are read through the page cache of the system,
+// the database has no cache of its own.
+func (d *DB) Stats() (*Stats, error) {
+	stats := &Stats{
+		Time:              time.Now(),
+		OpenTransactions:  int(atomic.LoadInt64(&d.openTransactions)),
+		StoreTransactions: int(atomic.LoadInt64(&d.valueStore.openTransactions)),
+		FilesSize:         uint64(dirSize(d.options.Path)),
+		Backup:            d.BackupStatus(),
+	}
+	stats.LSMSize, stats.ValueLogSize = d.valueStore.Size()
+
+	for _, c := range d.getCollections() {
+		if c == nil {
+			continue
+		}
+
+		collectionStats, err := c.stats()
+		if err != nil {
+			return nil, err
+		}
+		stats.PendingWrites += collectionStats.PendingWrites
+		stats.IndexTransactions += collectionStats.IndexTransactions
+		stats.Collections = append(stats.Collections, collectionStats)
+	}
+	return stats, nil
+}
+
+func (o *Options) statsInterval() time.Duration {
+	if o.StatsInterval <= 0 {
+		return DefaultStatsInterval
+	}
+	return o.StatsInterval
+}
+
+func (c *Collection) stats() (*CollectionStats, error) {
+	counted, err := c.countedStats()
+	if err != nil {
+		return nil, err
+	}
+
+	// The counts are copied to not change the cached ones
+	stats := *counted
+	stats.Indexes = make([]*IndexStats, len(counted.Indexes))
+	for i, indexStats := range counted.Indexes {
+		copied := *indexStats
+		stats.Indexes[i] = &copied
+	}
+
+	stats.PendingWrites = len(c.writeTransactionChan) + len(c.asyncWrites)
+	if info, err := os.Stat(c.indexFilePath()); err == nil {
+		stats.IndexFileSize = info.Size()
+	}
+	c.dbLock.share()
+	stats.IndexTransactions = c.db.Stats().OpenTxN
+	c.dbLock.unshare()
+	return &stats, nil
+}
+
+// countedStats returns the documents, the size and the indexes of the collection.
+// They are counted again only once the StatsInterval is over, the calls done
+// during the count wait for it.
+func (c *Collection) countedStats() (*CollectionStats, error) {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+
+	if c.counted != nil && time.Since(c.counted.CountedAt) < c.options.statsInterval() {
+		return c.counted, nil
+	}
+
+	stats := &CollectionStats{
+		Name:      c.name,
+		CountedAt: time.Now(),
+	}
+
+	var err error
+	if stats.Documents, err = c.Count(); err != nil {
+		return nil, err
+	}
+	if stats.Size, err = c.Size(); err != nil {
+		return nil, err
+	}
+
+	err = c.viewIndex(func(tx *bolt.Tx) error {
+		indexesBucket := tx.Bucket([]byte("indexes"))
+		for _, index := range c.indexes {
+			indexStats := &IndexStats{Name: index.Name}
+			stats.Indexes = append(stats.Indexes, indexStats)
+
+			indexBucket := indexesBucket.Bucket([]byte(index.Name))
+			if indexBucket == nil {
+				continue
+			}
+			indexStats.Values = indexBucket.Stats().KeyN
+			if err := indexBucket.ForEach(func(key, value []byte) error {
+				indexStats.Size += int64(len(key) + len(value))
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.counted = stats
+	return stats, nil
+}
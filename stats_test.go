@@ -0,0 +1,100 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("email", StringIndex, "Email"); err != nil {
+		t.Error(err)
+		return
+	}
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tx, _ := db.Begin(ctx)
+	stats, err := db.Stats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stats.OpenTransactions != 1 || stats.FilesSize == 0 || len(stats.Collections) != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+		return
+	}
+	collectionStats := stats.Collections[0]
+	if collectionStats.Name != "testCol" || collectionStats.Documents != len(users) || collectionStats.Size == 0 || collectionStats.IndexFileSize == 0 {
+		t.Errorf("unexpected collection stats %+v", collectionStats)
+		return
+	}
+	if len(collectionStats.Indexes) != 1 || collectionStats.Indexes[0].Name != "email" || collectionStats.Indexes[0].Values != len(users) || collectionStats.Indexes[0].Size == 0 {
+		t.Errorf("unexpected index stats %+v", collectionStats.Indexes)
+		return
+	}
+
+	tx.Rollback()
+	if stats, _ := db.Stats(); stats.OpenTransactions != 0 {
+		t.Errorf("expected no open transaction but had %d", stats.OpenTransactions)
+		return
+	}
+
+	// The iterator and the snapshot keep a store transaction, the snapshot reads a copy of the indexes
+	iter := c.Iterate(IterOptions{})
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, endIndexTx, err := c.beginIndexView()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stats, _ := db.Stats(); stats.StoreTransactions != 2 || stats.IndexTransactions != 1 {
+		t.Errorf("expected 2 store and 1 index transactions but had %d and %d", stats.StoreTransactions, stats.IndexTransactions)
+		return
+	}
+	iter.Close()
+	snapshot.Close()
+	endIndexTx()
+	if stats, _ := db.Stats(); stats.StoreTransactions != 0 || stats.IndexTransactions != 0 {
+		t.Errorf("expected no store and index transactions but had %d and %d", stats.StoreTransactions, stats.IndexTransactions)
+		return
+	}
+
+	// The counts are kept until the StatsInterval is over
+	if err := c.Put("new", users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+	if stats, _ := db.Stats(); stats.Collections[0].Documents != len(users) {
+		t.Errorf("expected the cached count %d but had %d", len(users), stats.Collections[0].Documents)
+		return
+	}
+	db.options.StatsInterval = time.Nanosecond
+	if stats, _ := db.Stats(); stats.Collections[0].Documents != len(users)+1 {
+		t.Errorf("expected %d documents but had %d", len(users)+1, stats.Collections[0].Documents)
+		return
+	}
+}
@@ -0,0 +1,342 @@
+package gotinydb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/dgraph-io/badger"
+)
+
+// PutReader saves the content read from r by chunks of StreamChunkSize, the content
+// is never loaded at once. The previous streamed content of the ID is replaced once
+// all the chunks are saved, the readers see the old or the new content but never a part of it.
+// The streamed contents are saved apart from the documents, they are read with
+// GetReader and removed by Delete. They are not indexed and have no history.
+func (c *Collection) PutReader(id string, r io.Reader) error {
+	if id == "" {
+		return ErrEmptyID
+	}
+
+	c.touch()
+	if err := c.diskSpace.check(); err != nil {
+		return err
+	}
+	if err := c.checkQuota(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.options.TransactionTimeOut)
+	defer cancel()
+	if err := c.waitForMaintenance(ctx); err != nil {
+		return err
+	}
+
+	manifest := &streamManifest{Token: make([]byte, 16)}
+	if _, err := io.ReadFull(rand.Reader, manifest.Token); err != nil {
+		return err
+	}
+
+	// The record of the pending content lets the next opening remove the chunks
+	// if the manifest is never saved
+	pendingID := c.buildPendingStreamID(manifest.Token)
+	if err := c.store.Update(func(txn *badger.Txn) error {
+		return txn.Set(pendingID, nil)
+	}); err != nil {
+		return err
+	}
+
+	if err := c.putChunks(manifest, r); err != nil {
+		c.abortStream(manifest)
+		return err
+	}
+
+	var previous *streamManifest
+	if err := c.store.Update(func(txn *badger.Txn) error {
+		var err error
+		previous, err = c.getStreamManifest(txn, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		manifestAsBytes, _ := json.Marshal(manifest)
+		manifestAsBytes, err = c.sealValue(c.buildStreamID(id), manifestAsBytes)
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(pendingID); err != nil {
+			return err
+		}
+		return txn.Set(c.buildStreamID(id), manifestAsBytes)
+	}); err != nil {
+		c.abortStream(manifest)
+		return err
+	}
+	c.addSize(int(manifest.Size))
+
+	if previous != nil {
+		return c.deleteChunks(previous)
+	}
+	return nil
+}
+
+// GetReader returns a reader of the content saved by PutReader. The chunks are read
+// one by one from the version saved when it is called. The reader must be closed.
+func (c *Collection) GetReader(id string) (io.ReadCloser, error) {
+	if id == "" {
+		return nil, ErrEmptyID
+	}
+	c.touch()
+
+	txn, endTxn := c.store.begin(false)
+	manifest, err := c.getStreamManifest(txn, id)
+	if err != nil {
+		endTxn()
+		return nil, err
+	}
+
+	return &streamReader{
+		c:        c,
+		txn:      txn,
+		endTxn:   endTxn,
+		manifest: manifest,
+	}, nil
+}
+
+// putChunks saves the chunks of the content, in many transactions if it is too big for one
+func (c *Collection) putChunks(manifest *streamManifest, r io.Reader) error {
+	txn, endTxn := c.store.begin(true)
+	defer func() {
+		endTxn()
+	}()
+
+	buf := make([]byte, StreamChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			key := c.buildChunkID(manifest.Token, manifest.Chunks)
+			chunk, err := c.sealValue(key, append([]byte{}, buf[:n]...))
+			if err != nil {
+				return err
+			}
+
+			if err := txn.Set(key, chunk); err == badger.ErrTxnTooBig {
+				if err := txn.Commit(nil); err != nil {
+					return err
+				}
+				endTxn()
+				txn, endTxn = c.store.begin(true)
+				if err := txn.Set(key, chunk); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+
+			manifest.Chunks++
+			manifest.Size += int64(n)
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return txn.Commit(nil)
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// deleteChunks removes the chunks of the streamed content
+func (c *Collection) deleteChunks(manifest *streamManifest) error {
+	batchSize := uint32(StreamDeleteBatchSize)
+	for start := uint32(0); start < manifest.Chunks; start += batchSize {
+		if err := c.store.Update(func(txn *badger.Txn) error {
+			for i := start; i < manifest.Chunks && i < start+batchSize; i++ {
+				if err := txn.Delete(c.buildChunkID(manifest.Token, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abortStream removes the chunks of a content which has not been saved and its pending record.
+// The record is kept if the chunks can't be removed, they are removed at the next opening.
+func (c *Collection) abortStream(manifest *streamManifest) error {
+	if err := c.deleteChunks(manifest); err != nil {
+		return err
+	}
+	return c.store.Update(func(txn *badger.Txn) error {
+		return txn.Delete(c.buildPendingStreamID(manifest.Token))
+	})
+}
+
+// sweepPendingStreams removes the chunks of the contents left without manifest
+// by an interrupted PutReader
+func (c *Collection) sweepPendingStreams() error {
+	prefix := c.buildPendingStreamID(nil)
+	tokens := [][]byte{}
+	if err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+		defer iter.Close()
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			tokens = append(tokens, iter.Item().KeyCopy(nil)[len(prefix):])
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if err := c.deletePrefix(c.buildChunksPrefix(token)); err != nil {
+			return err
+		}
+		if err := c.store.Update(func(txn *badger.Txn) error {
+			return txn.Delete(c.buildPendingStreamID(token))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStream removes the streamed content of the ID if any
+func (c *Collection) deleteStream(id string) error {
+	var manifest *streamManifest
+	if err := c.store.Update(func(txn *badger.Txn) error {
+		var err error
+		manifest, err = c.getStreamManifest(txn, id)
+		if err != nil {
+			return err
+		}
+		return txn.Delete(c.buildStreamID(id))
+	}); err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return c.deleteChunks(manifest)
+}
+
+// deleteStreams removes all the streamed contents of the collection
+func (c *Collection) deleteStreams() error {
+	for _, prefix := range [][]byte{[]byte(c.id[:4] + "#"), []byte(c.id[:4] + "$"), c.buildPendingStreamID(nil)} {
+		if err := c.deletePrefix(prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletePrefix removes the keys of the store with the prefix by batches of StreamDeleteBatchSize
+func (c *Collection) deletePrefix(prefix []byte) error {
+	for {
+		keys := [][]byte{}
+		c.store.View(func(txn *badger.Txn) error {
+			iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+			defer iter.Close()
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix) && len(keys) < StreamDeleteBatchSize; iter.Next() {
+				keys = append(keys, iter.Item().KeyCopy(nil))
+			}
+			return nil
+		})
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if err := c.store.Update(func(txn *badger.Txn) error {
+			for _, key := range keys {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Collection) getStreamManifest(txn *badger.Txn, id string) (*streamManifest, error) {
+	item, err := txn.Get(c.buildStreamID(id))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	value, err = c.openValue(item.Key(), value)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := new(streamManifest)
+	if err := json.Unmarshal(value, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (c *Collection) buildStreamID(id string) []byte {
+	return []byte(c.id[:4] + "#" + id)
+}
+
+// buildPendingStreamID returns the key of the record of a content whose manifest is not saved yet
+func (c *Collection) buildPendingStreamID(token []byte) []byte {
+	return append([]byte(c.id[:4]+"%"), token...)
+}
+
+// buildChunksPrefix returns the prefix of the keys of the chunks with the token
+func (c *Collection) buildChunksPrefix(token []byte) []byte {
+	return append([]byte(c.id[:4]+"$"), token...)
+}
+
+// buildChunkID returns the key of the chunk, the chunks of a content have the same token
+func (c *Collection) buildChunkID(token []byte, n uint32) []byte {
+	key := c.buildChunksPrefix(token)
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, n)
+	return append(key, index...)
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next >= r.manifest.Chunks {
+			return 0, io.EOF
+		}
+
+		item, err := r.txn.Get(r.c.buildChunkID(r.manifest.Token, r.next))
+		if err != nil {
+			return 0, err
+		}
+		chunk, err := item.ValueCopy(nil)
+		if err != nil {
+			return 0, err
+		}
+		r.buf, err = r.c.openValue(item.Key(), chunk)
+		if err != nil {
+			return 0, err
+		}
+		r.next++
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close releases the store transaction of the reader
+func (r *streamReader) Close() error {
+	r.endTxn()
+	return nil
+}
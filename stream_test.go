@@ -0,0 +1,177 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	defer func(size int) { StreamChunkSize = size }(StreamChunkSize)
+	StreamChunkSize = 100
+
+	c, _ := db.Use("testCol")
+
+	content := make([]byte, 1050)
+	rand.Read(content)
+	if err := c.PutReader("id", bytes.NewReader(content)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	reader, err := c.GetReader("id")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The reader keeps the version of the call
+	newContent := []byte("new content")
+	if err := c.PutReader("id", bytes.NewReader(newContent)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, expected := range [][]byte{content, newContent} {
+		retrieved, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(retrieved, expected) {
+			t.Errorf("expected %d bytes but had %d", len(expected), len(retrieved))
+			return
+		}
+
+		reader, err = c.GetReader("id")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	reader.Close()
+
+	// A failed read does not change the saved content
+	failingReader := io.MultiReader(bytes.NewReader(content), &errorReader{fmt.Errorf("read error")})
+	if err := c.PutReader("id", failingReader); err == nil {
+		t.Errorf("the error of the reader must be returned")
+		return
+	}
+	reader, _ = c.GetReader("id")
+	if retrieved, _ := ioutil.ReadAll(reader); !bytes.Equal(retrieved, newContent) {
+		t.Errorf("the content is changed by a failed write")
+		return
+	}
+	reader.Close()
+
+	if err := c.Delete("id"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := c.GetReader("id"); err != ErrNotFound {
+		t.Errorf("expected %v but had %v", ErrNotFound, err)
+		return
+	}
+}
+
+func TestStreamPendingChunks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	c, _ := db.Use("testCol")
+	countKeys := func(c *Collection, prefix []byte) (n int) {
+		c.store.View(func(txn *badger.Txn) error {
+			iter := txn.NewIterator(badger.IteratorOptions{PrefetchValues: false})
+			defer iter.Close()
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+
+	// The chunks of a PutReader interrupted before the manifest is saved
+	token := []byte("pending content")
+	if err := c.store.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(c.buildPendingStreamID(token), nil); err != nil {
+			return err
+		}
+		for i := uint32(0); i < 3; i++ {
+			if err := txn.Set(c.buildChunkID(token, i), []byte("chunk")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.PutReader("id", bytes.NewReader([]byte("content"))); err != nil {
+		t.Error(err)
+		return
+	}
+	db.Close()
+
+	db, openDBErr = Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ = db.Use("testCol")
+	if n := countKeys(c, c.buildChunksPrefix(token)); n != 0 {
+		t.Errorf("%d chunks without manifest are left", n)
+		return
+	}
+	if n := countKeys(c, c.buildPendingStreamID(nil)); n != 0 {
+		t.Errorf("%d pending records are left", n)
+		return
+	}
+	reader, err := c.GetReader("id")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	if retrieved, _ := ioutil.ReadAll(reader); string(retrieved) != "content" {
+		t.Errorf("the saved content is removed by the sweep")
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
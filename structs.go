@@ -1,36 +1,284 @@
 package gotinydb
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/json"
+	"hash"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/dgraph-io/badger"
+	"golang.org/x/text/collate"
 )
 
 type (
+	// badgerStore is the value store. It counts its open transactions for the stats.
+	badgerStore struct {
+		*badger.DB
+		openTransactions int64
+	}
+
 	// DB is the main element of the package and provide all access to sub commands
 	DB struct {
 		options *Options
+		// compaction is the policy of the background compaction built from the options, nil if none
+		compaction *CompactionPolicy
 
-		valueStore  *badger.DB
-		collections []*Collection
+		valueStore *badgerStore
+		// collectionsMutex protects the list of the collections, not the collections.
+		// The loops read it with getCollections.
+		collections      []*Collection
+		collectionsMutex sync.RWMutex
 
 		ctx     context.Context
 		closing bool
+
+		// lastActivity is the time of the last operation as Unix nanoseconds
+		lastActivity int64
+		// openTransactions is the number of transactions begun and not done
+		openTransactions int64
+		// txIntents counts the records of the transactions to build unique keys
+		txIntents uint64
+
+		diskSpace *diskSpace
+		// valueAEAD encrypts the values if the options have an EncryptionKey
+		valueAEAD cipher.AEAD
+		// indexCipher encrypts the index files if the options have an EncryptionKey
+		indexCipher *indexCipher
+		// workers runs the background work
+		workers *workerPool
+
+		procedures      map[string]Procedure
+		proceduresMutex sync.RWMutex
+		// callMutex makes the procedures run one after the other
+		callMutex sync.Mutex
+
+		backupStatus      BackupStatus
+		backupStatusMutex sync.Mutex
+
+		// changeLog is shared with the collections to record their writes
+		changeLog *changeLog
+
+		// replicationCtx is done when the database is closed, replicationStop cancels it.
+		// Close waits for the replication goroutines counted by replicationWaitGroup.
+		replicationCtx       context.Context
+		replicationStop      context.CancelFunc
+		replicationWaitGroup sync.WaitGroup
+
+		// syncMutex makes the calls of Sync run one after the other
+		syncMutex sync.Mutex
+
+		// metrics is set if the options have Metrics
+		metrics *metrics
+		// events is shared with the collections to send the lifecycle events
+		events *eventBus
+
+		// archives and packs are attached with AttachArchive and AttachPack, they are detached by Close.
+		// archivesMutex protects both lists.
+		archives      []*AttachedArchive
+		packs         []*DataPack
+		archivesMutex sync.Mutex
 	}
 
+	// CompactionPolicy defines when the background compaction can run.
+	// All the conditions need to be fulfilled and the compaction stops at the first operation,
+	// or runs with the operations if Online is set.
+	CompactionPolicy struct {
+		// IdleFor is the duration without any operation before the compaction starts.
+		// Zero means that the activity is not checked before starting.
+		IdleFor time.Duration
+		// WindowStart and WindowEnd are the times of the day in local time, as durations since midnight.
+		// The window can go over midnight. If they are equal the compaction can run all the day.
+		WindowStart, WindowEnd time.Duration
+		// CheckInterval is the time between two checks of the conditions, DefaultCompactionCheckInterval if zero
+		CheckInterval time.Duration
+		// DiscardRatio is given to the value log garbage collection, DefaultCompactionDiscardRatio if zero
+		DiscardRatio float64
+		// Online makes the compaction run at every CheckInterval in the window, even during
+		// the operations. IdleFor is not checked and the compaction does not stop at the first operation.
+		Online bool
+		// MaxThroughput if set is the maximum number of bytes of the value log rewritten by second.
+		// The value log files are limited to CompactionChunkSize and the compaction waits after
+		// every rewritten file to keep the latency of the operations low.
+		MaxThroughput int64
+	}
+
+	// Procedure defines a function registered into the database and called by name.
+	// It receives the transaction it runs in and the caller arguments and returns its result.
+	Procedure func(tx *Tx, args []byte) ([]byte, error)
+
 	// Options defines the deferent configuration elements of the database
 	Options struct {
 		Path                             string
 		TransactionTimeOut, QueryTimeOut time.Duration
 		InternalQueryLimit               int
 
+		// BackupKey if set encrypts the backups with AES-GCM.
+		// It must be 16, 24 or 32 bytes long.
+		BackupKey []byte
+		// Restore if set is the backup loaded by Open into a database without collection.
+		// It is not read when the database already has collections.
+		Restore io.Reader
+		// LoadProgress if set is called while a backup is loaded with the number of bytes
+		// of the archive loaded and the total to load
+		LoadProgress func(loaded, total int64)
+		// BlindTokenKey is the HMAC key used to build the tokens of the blind token indexes
+		BlindTokenKey []byte
+		// AnonymizationKey is the HMAC key of the AnonymizeHash transformation.
+		// Without a secret key the hashes of the small values like the emails could be
+		// found by trying all of them.
+		AnonymizationKey []byte
+		// EncryptionKey if set encrypts the saved values with AES-GCM, with their history
+		// and their metadata. It must be 16, 24 or 32 bytes long and can't be added to a
+		// database created without it. The indexed values are replaced by HMAC tokens and
+		// the references of the index files are encrypted, so the indexes only support
+		// Equal filters and can't be exported. The IDs of the documents are still saved
+		// in clear into the keys of the store.
+		EncryptionKey []byte
+
+		// Logger if set receives the logs of the database, otherwise the ones from
+		// LogInfo are written by the log package of the standard library.
+		// NopLogger silences them.
+		Logger Logger
+
+		// Metrics if set receives the operations, the query durations, the index scan lengths
+		// and the retries of the database. The prommetrics package exports them to Prometheus.
+		Metrics Metrics
+
+		// ReadOnly opens the files of the database without writing into them, they are
+		// memory mapped and shared by the processes. The path must hold a database built before,
+		// the writes return ErrReadOnly and the background cleanings are not run.
+		ReadOnly bool
+
+		// WarmUpIndexes makes Open read all the indexes once to load them into the page cache.
+		// The first queries after a cold start are faster but Open takes longer.
+		WarmUpIndexes bool
+
+		// Compaction if set runs the value log garbage collection in the background
+		// when the policy allows it
+		Compaction *CompactionPolicy
+		// CompactionInterval if set and Compaction is nil runs the compaction in the background
+		// at every interval, even during the operations
+		CompactionInterval time.Duration
+		// CompactionMaxThroughput if set is the maximum number of bytes rewritten by second by
+		// the background compaction, if the policy has no MaxThroughput. The value log files are
+		// then limited to CompactionChunkSize, the compaction pauses after every one of them.
+		CompactionMaxThroughput int64
+
+		// MinFreeSpace is the number of free bytes under which the database goes read only.
+		// Zero disables the check.
+		MinFreeSpace uint64
+		// DiskSpaceCheckInterval is the time the free space is cached, DefaultDiskSpaceCheckInterval if zero
+		DiskSpaceCheckInterval time.Duration
+		// ExpirationCheckInterval is the time between two cleanings of the expired
+		// documents, DefaultExpirationCheckInterval if zero
+		ExpirationCheckInterval time.Duration
+		// StatsInterval is the time DB.Stats keeps the counts of the documents and
+		// of the indexes, DefaultStatsInterval if zero
+		StatsInterval time.Duration
+
+		// LowDiskSpaceHook if set is called when the database goes read only and when it can write again
+		LowDiskSpaceHook func(freeSpace uint64, readOnly bool)
+
+		// MaxSizeBytes if set is the maximum size of the database files. The puts fail
+		// with ErrQuotaExceeded once it is over, the size is read every DiskSpaceCheckInterval.
+		// The collections can have their own quota with SetQuota.
+		MaxSizeBytes uint64
+		// QuotaExceededHook if set is called in place of returning ErrQuotaExceeded
+		// when a put is done over a quota. The collection is empty for MaxSizeBytes.
+		// The put is done if it returns nil.
+		QuotaExceededHook func(collection string, size, quota uint64) error
+
+		// HistoryDepth is the number of previous versions of the documents kept for
+		// Rollback and History. Zero uses DefaultHistoryDepth and a negative depth
+		// disables the versioning. It replaces the NumVersionsToKeep of the
+		// BadgerOptions and the collections can keep less with SetHistoryDepth.
+		HistoryDepth int
+		// HistoryRetention if set is the time the previous versions are kept.
+		// The older ones are purged in the background every HistoryRetentionCheckInterval,
+		// with the rules of PurgeDocumentHistory.
+		HistoryRetention time.Duration
+		// HistoryRetentionCheckInterval is the time between two purges of the history,
+		// DefaultHistoryRetentionCheckInterval if zero
+		HistoryRetentionCheckInterval time.Duration
+
+		// Retry defines how the writes are retried after a transient storage error.
+		// Nil disables the retries.
+		Retry *RetryPolicy
+		// TxRetry defines how RunInTransaction runs the transactions again after a conflict,
+		// DefaultTxRetryPolicy if nil
+		TxRetry *RetryPolicy
+
+		// AsyncQueueSize is the number of writes of PutAsync waiting to be saved
+		// by every collection, DefaultAsyncQueueSize if zero
+		AsyncQueueSize int
+
+		// BackupSchedule if set makes the database save backups while it is open
+		BackupSchedule *BackupSchedule
+
+		// ChangeLogSize if set is the number of the last writes kept in the change log
+		// read with DB.Changes. Zero disables the log.
+		ChangeLogSize int
+		// ChangeLogContents makes the change log save the written contents with the records
+		ChangeLogContents bool
+		// ChangeLogRetention if set removes the records of the change log older than it,
+		// even if the log has less than ChangeLogSize records
+		ChangeLogRetention time.Duration
+		// ReplicationFilter if set is sent by OpenReplica to the primary to receive only
+		// the documents it selects. The replica is copied again if it changes.
+		ReplicationFilter *ChangeFilter
+		// ReplicationTLS is needed by ServeReplication and OpenReplica, the documents
+		// are only sent over TLS. The primary authenticates the replicas if its ClientAuth
+		// asks for their certificates, and the replicas dial the primary with it.
+		ReplicationTLS *tls.Config
+
+		// WorkerPool defines the goroutines running the expiration cleanings, the history
+		// purges, the background compaction, the index builds and the deliveries of the
+		// Watch events. The default values are used if nil.
+		WorkerPool *WorkerPoolOptions
+
 		BadgerOptions *badger.Options
 		BoltOptions   *bolt.Options
 	}
 
+	// WorkerPoolOptions defines the pool of goroutines of the background work
+	WorkerPoolOptions struct {
+		// Size is the number of goroutines, DefaultWorkerPoolSize if zero
+		Size int
+		// QueueSize is the number of jobs waiting for a goroutine, DefaultWorkerQueueSize if zero.
+		// The background loops and the index builds wait when the queue is full, the
+		// events are then delivered by the writes.
+		QueueSize int
+	}
+
+	// RetryPolicy defines how the writes are retried after a transient storage error
+	// like a transaction conflict or a temporary failure of the memory map growth.
+	// The delay between two attempts doubles every time.
+	RetryPolicy struct {
+		// MaxAttempts is the number of tries including the first one, DefaultRetryMaxAttempts if zero
+		MaxAttempts int
+		// InitialBackoff is the delay before the second attempt, DefaultRetryInitialBackoff if zero
+		InitialBackoff time.Duration
+		// MaxBackoff if set is the maximum delay between two attempts
+		MaxBackoff time.Duration
+		// IsTransient if set replaces the default detection of the transient errors
+		IsTransient func(err error) bool
+	}
+
+	// RetryError is returned when a write failed after some retries.
+	// It holds the error of every attempt.
+	RetryError struct {
+		Errors []error
+	}
+
 	// Collection defines the storage object
 	Collection struct {
 		name, id string
@@ -39,11 +287,899 @@ type (
 		options *Options
 
 		db    *bolt.DB
-		store *badger.DB
+		store *badgerStore
+		// dbLock is shared by the index transactions and taken by the compaction
+		// of the index file which replaces db
+		dbLock indexFileLock
 
 		writeTransactionChan chan *writeTransaction
+		// writeMutex is held by the write queue from the check of a condition to the end
+		// of the write, and by the deletes and the batches while they write.
+		// This way nothing is written between the check and the conditional write.
+		writeMutex sync.Mutex
+		// asyncWrites receives the writes of PutAsync
+		asyncWrites chan *asyncWrite
+		// asyncClosed is set once the queue is drained at the end of the context,
+		// asyncMutex makes the drain wait for the writes being queued
+		asyncClosed bool
+		asyncMutex  sync.RWMutex
+
+		maintenance      *Maintenance
+		maintenanceMutex sync.RWMutex
+
+		// activity points to the last operation time of the database
+		activity *int64
+		// diskSpace is shared with the database to check the free space before the writes
+		diskSpace *diskSpace
+		// valueAEAD is shared with the database to encrypt the values
+		valueAEAD cipher.AEAD
+		// indexCipher is shared with the database to encrypt the index file
+		indexCipher *indexCipher
+		// changeLog is shared with the database to record the writes
+		changeLog *changeLog
+		// metrics is shared with the database, nil without the Metrics of the options
+		metrics *metrics
+		// events is shared with the database to send the lifecycle events
+		events *eventBus
+		// workers is shared with the database to run the index builds and the change deliveries
+		workers *workerPool
+
+		// subscriptionsMutex protects the watchers too
+		subscriptions      []*Subscription
+		watchers           []*watcher
+		subscriptionsMutex sync.RWMutex
+
+		schema []*SchemaField
+
+		anonymization      []*AnonymizeRule
+		anonymizationMutex sync.RWMutex
+
+		hooks      *Hooks
+		hooksMutex sync.RWMutex
+
+		codec      Codec
+		codecMutex sync.RWMutex
+
+		writeOnce      bool
+		writeOnceMutex sync.RWMutex
+
+		// historyDepth is the depth set with SetHistoryDepth, -1 if not set
+		historyDepth      int
+		historyDepthMutex sync.RWMutex
+
+		// quota is the maximum size set with SetQuota, size is the last size read
+		// with the writes committed since
+		quota          uint64
+		size           uint64
+		sizeCheck      time.Time
+		sizeRefreshing bool
+		quotaMutex     sync.Mutex
+
+		// counted is the last count of the documents and of the indexes done by Stats
+		counted    *CollectionStats
+		statsMutex sync.Mutex
+
+		ctx context.Context
+	}
+
+	// ShardedCollection is a collection split into one collection per period of time.
+	// It is built with DB.UseSharded.
+	ShardedCollection struct {
+		db      *DB
+		name    string
+		options *ShardOptions
+		// base saves the index definitions given to the new shards
+		base *Collection
+
+		// mutex protects the creation and the removal of the shards
+		mutex sync.Mutex
+		// now returns the current time to find the expired shards
+		now func() time.Time
+	}
+
+	// HashShardedCollection is a collection split into a fixed number of collections,
+	// each with its own index file, by the hash of the IDs. It is built with DB.UseHashSharded.
+	HashShardedCollection struct {
+		db   *DB
+		name string
+		// shards are the collections from the first to the last
+		shards []*Collection
+	}
+
+	// ShardOptions defines how a ShardedCollection is split
+	ShardOptions struct {
+		// Period is the time period of the documents of one shard
+		Period ShardPeriod
+		// Retention if set is the number of periods kept up to the current one.
+		// The older shards are deleted.
+		Retention int
+	}
+
+	// ShardPeriod defines the time period of a shard
+	ShardPeriod string
+
+	// LogLevel defines the importance of a log
+	LogLevel int
+
+	// Logger receives the logs of the database. The keys and their values alternate
+	// in keysAndValues, like "collection", "users", "error", err.
+	Logger interface {
+		Log(level LogLevel, message string, keysAndValues ...interface{})
+	}
+
+	// Metrics receives the measures of a database given with Options.Metrics.
+	// Its methods are called by many goroutines at once.
+	Metrics interface {
+		// Open is called when the database of the given path is opened,
+		// an error fails the opening. Close is called when it is closed.
+		Open(path string) error
+		Close()
+		// CountOperations adds n documents of the collection saved, read or deleted,
+		// the operation is "put", "get" or "delete"
+		CountOperations(collection, operation string, n int)
+		// ObserveQuery receives the duration of a query
+		ObserveQuery(collection string, duration time.Duration)
+		// ObserveIndexScan receives the number of IDs read from an index by a filter of a query
+		ObserveIndexScan(collection, index string, length int)
+		// CountRetry is called when a write is done again after a transient error
+		CountRetry()
+	}
+
+	// stdLogger writes the logs from LogInfo with the log package of the standard library
+	stdLogger struct{}
+	// nopLogger discards the logs
+	nopLogger struct{}
+
+	// Column defines a column of the exports and of the CSV imports
+	Column struct {
+		// Name is the name of the column
+		Name string
+		// Selector is the path of the field in the documents
+		Selector []string
+	}
+	// ParquetColumn is the former name of Column
+	ParquetColumn = Column
+
+	// CollectionInfo describes a collection of the database
+	CollectionInfo struct {
+		Name    string
+		Indexes []*IndexInfo
+	}
+
+	// IndexInfo describes an index of a collection
+	IndexInfo struct {
+		Name     string
+		Type     IndexType
+		Selector []string
+		Options  *IndexOptions
+		// Extractor is true if the values are given by a function, Selector is then empty
+		Extractor bool
+	}
+
+	// AnonymizeRule defines the transformation of a field of the exported documents
+	AnonymizeRule struct {
+		Selector  []string
+		Transform Anonymization
+	}
+
+	// Anonymization defines a transformation of the exported values
+	Anonymization string
+
+	// ExportFormat defines the format of the documents written by Collection.Export
+	ExportFormat string
+
+	// snapshotExportRecord is one line of Snapshot.ExportAll
+	snapshotExportRecord struct {
+		Collection string
+		ID         string
+		Content    map[string]interface{}
+	}
+
+	// ImportFormat defines the format of the documents read by Collection.Import
+	ImportFormat string
+
+	// ImportConflict defines what Collection.Import does with the IDs which are already saved
+	ImportConflict int
+
+	// ImportOptions defines how Collection.Import reads and saves the documents
+	ImportOptions struct {
+		// IDSelector if set is the path of the ID in the documents
+		IDSelector []string
+		// Columns gives the selectors of the CSV columns by name
+		Columns []*Column
+		// Conflict is ImportOverwrite by default
+		Conflict ImportConflict
+		// BatchSize is the number of documents saved in one transaction, ImportBatchSize if zero
+		BatchSize int
+	}
+
+	// ImportReport defines what Collection.Import has done
+	ImportReport struct {
+		// Read is the number of documents read
+		Read int
+		// Written is the number of documents saved and Skipped the ones not saved because of ImportSkip
+		Written, Skipped int
+	}
+
+	// SyncStrategy defines how DB.Sync resolves the conflicting writes
+	SyncStrategy int
+
+	// SyncOptions defines how DB.Sync resolves the conflicts
+	SyncOptions struct {
+		// Strategy is SyncLastWriterWins by default
+		Strategy SyncStrategy
+		// Merge is called by SyncMerge with the two versions of the document, the contents
+		// are nil for the deletes. It returns the content to save or nil to delete the document.
+		// It must return the same content on both peers.
+		Merge func(collection, id string, local, remote *SyncVersion) ([]byte, error)
+		// Filter if set sends to the peer only the documents it selects, the ones which
+		// are not selected anymore are sent as deleted. The documents written while
+		// they are not selected are not sent once they are.
+		Filter *ChangeFilter
+	}
+
+	// SyncVersion is a conflicting version of a document given to SyncOptions.Merge
+	SyncVersion struct {
+		Content []byte
+		// Bin is true if the content has been saved from bytes
+		Bin bool
+		// Time is the time of the write and Peer the ID of the database which did it
+		Time time.Time
+		Peer string
+	}
+
+	// SyncReport defines what DB.Sync has done
+	SyncReport struct {
+		// Sent and Received are the numbers of documents sent to and received from the peer
+		Sent, Received int
+		// Applied is the number of received writes saved, with the resolved conflicts
+		Applied int
+		// Conflicts is the number of documents written on both peers since their last sync
+		Conflicts int
+	}
+
+	// syncState is the version vector of a document with its last write.
+	// Updated is the version of the local change log when it changed.
+	syncState struct {
+		Vector  map[string]uint64
+		Time    time.Time
+		Peer    string
+		Updated uint64
+	}
+
+	// syncHello is sent first by the two peers of DB.Sync
+	syncHello struct {
+		PeerID string
+	}
+
+	// syncItem is a document sent by DB.Sync, the last one has Done set
+	syncItem struct {
+		Collection, ID string
+		Content        []byte `json:",omitempty"`
+		Bin            bool   `json:",omitempty"`
+		Deleted        bool   `json:",omitempty"`
+		Vector         map[string]uint64
+		Time           time.Time
+		Peer           string
+		Done           bool `json:",omitempty"`
+	}
+
+	// importedDocument is a document read by Collection.Import waiting to be saved
+	importedDocument struct {
+		id      string
+		content interface{}
+	}
+
+	// exportRecord is one line of the JSON lines export
+	exportRecord struct {
+		ID      string
+		Content map[string]interface{}
+	}
+
+	// Version is a stored version of a document returned by Collection.History.
+	// Deleted is true if the version is a deletion, Content is then nil.
+	// Time is the time of the write, it is zero for the deletions and the values
+	// saved before the times were saved. Meta are the metadata given to PutWithMeta.
+	Version struct {
+		Timestamp uint64
+		Time      time.Time
+		Meta      map[string]string
+		Deleted   bool
+		Content   []byte
+	}
+
+	// ArchiveManifest describes an archive written by Collection.Archive
+	ArchiveManifest struct {
+		Collection string
+		Time       time.Time
+		Indexes    []*IndexInfo
+		// Documents is the number of archived documents and MaxVersions the most versions of one of them
+		Documents, MaxVersions int
+	}
+
+	// AttachedArchive is an archive opened with DB.AttachArchive.
+	// It is read only, its documents can be read, queried and their history is kept.
+	AttachedArchive struct {
+		Manifest *ArchiveManifest
+		Path     string
+
+		parent       *DB
+		closeArchive func()
+		// files are the files of the documents by name and documents the same in the archive order
+		files     map[string]*zip.File
+		documents []*zip.File
+		// indexes are built from the manifest to run the filters like the collection did
+		indexes []*indexType
+	}
 
+	// DataPack is a database built before and attached read only with DB.AttachPack.
+	// Its collections are returned by DB.Use.
+	DataPack struct {
+		Path string
+
+		parent *DB
+		packDB *DB
+	}
+
+	// PackOptions defines the keys a pack has been built with, given to DB.AttachPack
+	PackOptions struct {
+		// EncryptionKey is the key of an encrypted pack
+		EncryptionKey []byte
+		// BlindTokenKey and AnonymizationKey are needed if the pack uses them
+		BlindTokenKey, AnonymizationKey []byte
+	}
+
+	// archivedDocument is a document saved into an archive with its versions from the oldest
+	archivedDocument struct {
+		ID       string
+		Versions []*archivedVersion
+	}
+
+	archivedVersion struct {
+		Time    time.Time
+		Meta    map[string]string `json:",omitempty"`
+		Deleted bool              `json:",omitempty"`
+		Bin     bool              `json:",omitempty"`
+		Content []byte            `json:",omitempty"`
+	}
+
+	// storedVersion is a version of a key as it is saved into the store
+	storedVersion struct {
+		meta    byte
+		value   []byte
+		deleted bool
+		discard bool
+		time    time.Time
+	}
+
+	// WriteBatch saves many writes to commit them together with Write.
+	// It is built with Collection.NewBatch.
+	WriteBatch struct {
+		c          *Collection
+		operations []*batchOperation
+		// replicated is set for the writes received from the primary by a replica
+		replicated bool
+		// origin is the peer of the writes saved by DB.Sync
+		origin string
+	}
+
+	// Tx groups writes done on many collections to save them together.
+	// It is built with DB.Begin.
+	Tx struct {
+		db  *DB
 		ctx context.Context
+
+		collections map[string]*TxCollection
+		done        bool
+		// mutex protects the collections and the writes until the end of the transaction
+		mutex sync.Mutex
+	}
+
+	// TxCollection is a collection used inside a transaction
+	TxCollection struct {
+		tx    *Tx
+		name  string
+		batch *WriteBatch
+		// reads is the version of the IDs read from the collection, checked by Commit
+		reads map[string]uint64
+		// missing is set if the collection does not exist, the batch is moved
+		// to the collection created by Commit
+		missing bool
+		// err is returned by all the calls if the collection can't be used
+		err error
+	}
+
+	// Snapshot is a read-only view of the database at the time it was taken.
+	// It is built with DB.Snapshot and released with Close.
+	Snapshot struct {
+		db     *DB
+		txn    *badger.Txn
+		endTxn func()
+
+		collections map[string]*SnapshotCollection
+		closed      bool
+		// mutex makes the reads run one after the other
+		mutex sync.Mutex
+	}
+
+	// SnapshotCollection is a collection as it was when the snapshot was taken
+	SnapshotCollection struct {
+		snapshot *Snapshot
+		c        *Collection
+		tx       *bolt.Tx
+		// endTx ends the index transaction
+		endTx func()
+		// txMutex protects the index transaction used by the queries of many indexes
+		txMutex sync.Mutex
+	}
+
+	// batchOperation defines one write of a batch
+	batchOperation struct {
+		id               string
+		contentInterface interface{}
+		contentAsBytes   []byte
+		bin, delete      bool
+		// value and meta are the encoded content as it is saved
+		value []byte
+		meta  byte
+	}
+
+	// metrics calls the Metrics of the options, its methods do nothing on a nil pointer
+	metrics struct {
+		m Metrics
+	}
+
+	// diskSpace caches the free space of the database directory
+	diskSpace struct {
+		options *Options
+
+		mutex     sync.Mutex
+		lastCheck time.Time
+		freeSpace uint64
+		readOnly  bool
+		// refused is returned for all the writes but the replication ones,
+		// like ErrReplica for the replicas
+		refused error
+
+		// events receives the quota warnings
+		events *eventBus
+
+		// size is the size of the database files read at lastSizeCheck
+		size          uint64
+		lastSizeCheck time.Time
+	}
+
+	// LowDiskSpaceError is returned by the writes when the free space is under Options.MinFreeSpace
+	LowDiskSpaceError struct {
+		FreeSpace, MinFreeSpace uint64
+	}
+
+	// Codec compresses or encodes the contents of a collection before they are saved into the store.
+	// The ID is saved with every value to decode it, so it must never change.
+	// The ID 0 is reserved for the uncompressed values and the IDs from 64 are not valid.
+	Codec interface {
+		ID() byte
+		Name() string
+		Encode(content []byte) ([]byte, error)
+		Decode(encoded []byte) ([]byte, error)
+	}
+
+	// ValueCodec is a Codec which encodes the documents from their Go values instead of their JSON.
+	// EncodeValue must be read back by Decode as the JSON of encoding/json.
+	// Both return ErrNotEncodable to save the content as it is.
+	ValueCodec interface {
+		Codec
+		EncodeValue(value interface{}) ([]byte, error)
+	}
+
+	// CollectionOptions defines the settings of a collection given to DB.Use or Collection.SetOptions
+	CollectionOptions struct {
+		// Codec encodes the next contents of the collection, nil saves them as JSON.
+		// NewMsgpackCodec, NewCBORCodec, NewProtobufCodec and NewGobCodec save them in binary.
+		Codec Codec
+	}
+
+	// flateCodec is the codec built with NewFlateCodec
+	flateCodec struct {
+		level int
+	}
+
+	// msgpackCodec is the codec built with NewMsgpackCodec
+	msgpackCodec struct{}
+	// cborCodec is the codec built with NewCBORCodec
+	cborCodec struct{}
+	// protobufCodec is the codec built with NewProtobufCodec
+	protobufCodec struct{}
+	// gobCodec is the codec built with NewGobCodec
+	gobCodec struct{}
+
+	// documentWriter writes the values of a document in a binary format.
+	// The maps give the key of each value with writeKey and every map or array ends with close.
+	documentWriter interface {
+		writeNull()
+		writeBool(value bool)
+		writeInt(value int64) error
+		writeUint(value uint64) error
+		writeFloat(value float64, bits int) error
+		writeString(value string)
+		writeKey(key string)
+		openMap(n int)
+		openArray(n int)
+		close()
+	}
+
+	// documentObject is a JSON object read by readDocument, its members keep their order
+	documentObject []documentMember
+	documentMember struct {
+		Key   string
+		Value interface{}
+	}
+	// documentArray is a JSON array read by readDocument
+	documentArray []interface{}
+
+	// documentFields caches the JSON fields of a struct type.
+	// viaJSON is set for the structs encoding/json marshals with rules which are not copied.
+	documentFields struct {
+		fields  []documentField
+		viaJSON bool
+	}
+	documentField struct {
+		index     int
+		name      string
+		omitEmpty bool
+	}
+
+	// msgpackWriter, cborWriter and protobufWriter write the documents in their format
+	msgpackWriter struct {
+		buffer *bytes.Buffer
+	}
+	cborWriter struct {
+		buffer *bytes.Buffer
+	}
+	protobufWriter struct {
+		// stack holds the maps and the arrays being written
+		stack  []*protobufFrame
+		output []byte
+	}
+	protobufFrame struct {
+		content []byte
+		isMap   bool
+		key     string
+	}
+
+	// treeWriter builds the document tree saved by the gob codec
+	treeWriter struct {
+		stack []*treeFrame
+		root  interface{}
+	}
+	treeFrame struct {
+		object documentObject
+		array  documentArray
+		isMap  bool
+		key    string
+	}
+	// gobDocument holds the tree to encode the null documents too
+	gobDocument struct {
+		Value interface{}
+	}
+
+	// CompressionStats defines the sizes of the saved contents of a collection
+	CompressionStats struct {
+		// Codec is the name of the codec of the collection, empty if there is none
+		Codec string
+		// Documents is the number of saved contents and Compressed the ones saved with a codec
+		Documents, Compressed int
+		// RawSize is the size of the contents and StoredSize the size of the saved values
+		RawSize, StoredSize int64
+	}
+
+	// Stats describes the state of the database, returned by DB.Stats
+	Stats struct {
+		Time time.Time
+		// OpenTransactions is the number of transactions begun and not committed or rolled back
+		OpenTransactions int
+		// StoreTransactions is the number of open transactions of the value store, the ones
+		// of the writes, the queries, the iterators and the snapshots.
+		// IndexTransactions is the number of open read transactions of the index files,
+		// each file has at most one write transaction more.
+		StoreTransactions, IndexTransactions int
+		// PendingWrites is the number of writes waiting in the queues of the collections
+		PendingWrites int
+		Collections   []*CollectionStats
+		// LSMSize and ValueLogSize are the sizes of the value store files and
+		// FilesSize the size of all the files of the database
+		LSMSize, ValueLogSize int64
+		FilesSize             uint64
+		// Backup is the result of the last backup of the BackupSchedule of the options
+		Backup BackupStatus
+	}
+
+	// CollectionStats describes the state of a collection
+	CollectionStats struct {
+		Name string
+		// Documents is the number of saved documents and Size the size of their values with their history.
+		// They and the indexes are counted at CountedAt.
+		Documents int
+		Size      uint64
+		CountedAt time.Time
+		// PendingWrites is the number of writes waiting in the queues of the collection
+		PendingWrites int
+		// IndexFileSize is the size of the file of the indexes
+		IndexFileSize int64
+		// IndexTransactions is the number of open read transactions of the index file
+		IndexTransactions int
+		Indexes           []*IndexStats
+	}
+
+	// IndexStats describes the content of an index
+	IndexStats struct {
+		Name string
+		// Values is the number of indexed values and Size the bytes of their keys and references
+		Values int
+		Size   int64
+	}
+
+	// IterOptions defines the elements returned by Collection.Iterate
+	IterOptions struct {
+		// Prefix if set returns only the IDs starting with it
+		Prefix string
+		// StartAfter if set starts the iteration after this ID, it can be the last ID
+		// of the previous page. The ID itself is not returned.
+		StartAfter string
+		// Reverse iterates in the descending order of the IDs
+		Reverse bool
+		// Limit if set is the maximum number of elements returned
+		Limit int
+	}
+
+	// Iterator returns lazily the IDs and the values of a collection.
+	// It is built with Collection.Iterate.
+	Iterator struct {
+		c       *Collection
+		txn     *badger.Txn
+		endTxn  func()
+		iter    *badger.Iterator
+		options IterOptions
+		prefix  []byte
+
+		started, closed bool
+		count           int
+		item            *badger.Item
+		id              string
+	}
+
+	// Subscription receives the events of the documents which enter or leave the result of a query.
+	// It is built with Collection.Subscribe.
+	Subscription struct {
+		c       *Collection
+		query   *Query
+		events  chan *SubscriptionEvent
+		dropped uint64
+	}
+
+	// SubscriptionEvent is sent when a document enters or leaves the result of a subscription.
+	// Content is the new content when the document enters and the previous one when it leaves.
+	SubscriptionEvent struct {
+		ID      string
+		Entered bool
+		Content []byte
+	}
+
+	// EventType defines the kind of a lifecycle Event
+	EventType string
+
+	// Event is sent to the subscriptions of DB.SubscribeEvents when something
+	// happens in the life of the database. The fields depend on the type.
+	Event struct {
+		Type EventType
+		Time time.Time
+		// Collection and Index are set for the events of a collection or an index
+		Collection, Index string
+		// Version is the version of the last value of a completed backup
+		Version uint64
+		// Size and Quota are set for the quota warnings, Collection is empty for MaxSizeBytes
+		Size, Quota uint64
+		// Err is the error of a failed backup or compaction
+		Err error
+	}
+
+	// EventSubscription receives the events of the database.
+	// It is built with DB.SubscribeEvents.
+	EventSubscription struct {
+		bus     *eventBus
+		types   []EventType
+		events  chan *Event
+		dropped uint64
+	}
+
+	// eventBus sends the events to the subscriptions
+	eventBus struct {
+		mutex         sync.RWMutex
+		subscriptions []*EventSubscription
+		// closed is set when the database is closed, the new subscriptions are then closed too
+		closed bool
+	}
+
+	// ChangeOperation defines the kind of write of a ChangeEvent
+	ChangeOperation string
+
+	// ChangeEvent is sent to the watchers after every write of the collection.
+	// Previous is nil if the document was not saved and Content is nil for the deletes.
+	ChangeEvent struct {
+		Operation         ChangeOperation
+		ID                string
+		Previous, Content []byte
+		Time              time.Time
+	}
+
+	// ChangeRecord is a write saved into the change log of the database.
+	// The versions grow by one at every write of any collection.
+	// Content is only set for the puts if the options have ChangeLogContents.
+	ChangeRecord struct {
+		Version    uint64
+		Collection string
+		ID         string
+		Operation  ChangeOperation
+		Time       time.Time
+		Content    []byte `json:",omitempty"`
+		// Bin is true if the content was saved with PutBin
+		Bin bool `json:",omitempty"`
+		// Origin is the ID of the peer the write comes from if it has been saved by DB.Sync
+		Origin string `json:",omitempty"`
+		// ExpiresAt is the Unix time the content expires at if it has been saved with a TTL
+		ExpiresAt uint64 `json:",omitempty"`
+	}
+
+	// replicationHello is sent by the replica with the last version of the primary it has
+	replicationHello struct {
+		Version uint64
+		Filter  *ChangeFilter `json:",omitempty"`
+		// Primary is the primary the version comes from
+		Primary *replicationIdentity `json:",omitempty"`
+	}
+
+	// replicationIdentity identifies the change log of a primary. ID is the PeerID of the
+	// primary and Epoch grows every time a backup is loaded, because the versions of the
+	// change log start again from the ones of the backup.
+	replicationIdentity struct {
+		ID    string
+		Epoch uint64
+	}
+
+	// replicationMessage is sent by the primary to the replica.
+	// Sync starts a full copy, the replica removes its documents and sets the indexes
+	// of the collections. If Version is set the replica is up to date with this version
+	// of the primary once the record is applied, otherwise the message is part of a copy.
+	// A message without a record is a heartbeat or the end of a copy.
+	replicationMessage struct {
+		Sync        bool             `json:",omitempty"`
+		Collections []CollectionInfo `json:",omitempty"`
+		Record      *ChangeRecord    `json:",omitempty"`
+		Version     uint64           `json:",omitempty"`
+		// Primary is sent with Sync, the replica follows it once the copy is done
+		Primary *replicationIdentity `json:",omitempty"`
+	}
+
+	// changeLog gives the versions of the change log records
+	changeLog struct {
+		// version is the version of the last record
+		version uint64
+		mutex   sync.Mutex
+	}
+
+	// changeLogWriter adds the records of the writes of a store transaction to the change log
+	changeLogWriter struct {
+		l         *changeLog
+		records   []*ChangeRecord
+		size      uint64
+		retention time.Duration
+		aead      cipher.AEAD
+		// version is the version of the last record before the ones of the writer
+		version uint64
+	}
+
+	// WatchOptions defines the events sent by Collection.Watch
+	WatchOptions struct {
+		// Prefix if set sends only the events of the IDs starting with it
+		Prefix string
+		// BufferSize is the size of the channel, DefaultSubscriptionBufferSize if zero
+		BufferSize int
+		// Block makes the writes wait for the watcher when the channel is full and as many
+		// events are waiting to be sent. Otherwise the events are dropped.
+		Block bool
+		// Query if set sends only the events of the documents matching it before or after
+		// the write. The filters use the indexes of the collection like the subscriptions.
+		Query *Query
+		// Filter if set sends only the events of the documents it selects before or after the write
+		Filter *ChangeFilter
+	}
+
+	// ChangeFilter selects the documents sent by the change feeds, like the documents
+	// of one tenant. The documents need to match all the fields which are set.
+	ChangeFilter struct {
+		// Collections if set are the names of the collections sent
+		Collections []string
+		// Prefix if set sends only the IDs starting with it
+		Prefix string
+		// Selector if set sends only the documents whose value at the selector
+		// is one of the Values. The values are compared as JSON.
+		Selector []string
+		Values   []interface{}
+		// Filters if set sends only the documents in the result of all of them, like the
+		// filters of a Query but without the indexes. A filter on an array selects
+		// the documents with one of the elements in its result.
+		Filters []*Filter `json:",omitempty"`
+	}
+
+	// watcher is registered by Collection.Watch
+	watcher struct {
+		ctx     context.Context
+		options *WatchOptions
+		events  chan *ChangeEvent
+		// sending counts the notifications in progress, the channel is closed after them
+		sending sync.WaitGroup
+
+		// pending are the deliveries of the events not sent yet, in the order of the writes.
+		// A job of the workers runs them, scheduled is set while it is queued and running
+		// while the deliveries run. closed is set once the channel is about to be closed.
+		pending      []func()
+		scheduled    bool
+		running      bool
+		closed       bool
+		pendingMutex sync.Mutex
+		// pendingCond is signaled when a delivery is taken or when they stop running
+		pendingCond *sync.Cond
+	}
+
+	// Hooks defines the functions called around the writes of a collection.
+	// All of them are optional.
+	Hooks struct {
+		// BeforePut returns the content to save, it can be the given one modified or an other one.
+		// The write is canceled if it returns an error.
+		BeforePut func(id string, content interface{}) (interface{}, error)
+		// AfterPut receives the content as it is saved
+		AfterPut func(id string, contentAsBytes []byte)
+		// BeforeDelete cancels the delete if it returns an error
+		BeforeDelete func(id string) error
+		AfterDelete  func(id string)
+	}
+
+	// SchemaField defines a selector which must be present in the documents with the given type
+	SchemaField struct {
+		Selector []string
+		Type     IndexType
+	}
+
+	// SchemaError is returned when a document does not have a field required by the schema
+	SchemaError struct {
+		ID       string
+		Selector []string
+		Type     IndexType
+		// Missing is true if the field is not present, otherwise it has the wrong type
+		Missing bool
+	}
+
+	// MaintenanceOptions defines how the writes are handled while the collection is in maintenance
+	MaintenanceOptions struct {
+		// Queue makes the writes wait for the end of the maintenance up to the transaction timeout.
+		// Otherwise they are rejected immediately.
+		Queue bool
+		// RetryAfter is the duration given to the rejected writers
+		RetryAfter time.Duration
+	}
+
+	// Maintenance is returned when a collection is set in maintenance mode.
+	// Its writes are not blocked, this is where the migration job writes.
+	Maintenance struct {
+		c       *Collection
+		options *MaintenanceOptions
+		done    chan struct{}
+	}
+
+	// MaintenanceError is returned by the writes rejected because of a maintenance
+	MaintenanceError struct {
+		Collection string
+		RetryAfter time.Duration
 	}
 
 	// Filter defines the way the query will be performed
@@ -55,6 +1191,22 @@ type (
 		equal        bool
 	}
 
+	// filterJSON is the JSON form of Filter
+	filterJSON struct {
+		Selector []string
+		Operator FilterOperator
+		Equal    bool `json:",omitempty"`
+		Values   []*filterValueJSON
+	}
+
+	// filterValueJSON is the JSON form of filterValue. Unsigned is set for the unsigned
+	// integers, which are not saved into the indexes like the signed ones.
+	filterValueJSON struct {
+		Type     IndexType
+		Value    json.RawMessage
+		Unsigned bool `json:",omitempty"`
+	}
+
 	// IndexType defines what kind of field the index is scanning
 	IndexType int
 
@@ -64,16 +1216,66 @@ type (
 		Type  IndexType
 	}
 
+	// IndexOptions defines the optional configuration of an index
+	IndexOptions struct {
+		// Collation defines the language used to order the values of a StringIndex
+		// like "fr" or "de-CH". The comparison ignores the case.
+		// If empty the values are compared as lower case bytes.
+		Collation string
+		// BlindToken defines that the indexed field holds a token built with DB.BlindToken
+		// instead of the clear value. The Equal filters are converted into tokens
+		// so the queries use the clear values. Only StringIndex supports it.
+		BlindToken bool
+		// Descending saves the index in the reverse order. The queries ordered
+		// by the index in descending order and the Less filters scan it forward.
+		Descending bool
+		// MaxPostingSize is the maximum number of IDs saved together for one indexed value.
+		// If zero the list of the IDs of a value has no limit and is saved again
+		// at every write, which is slow for the fields with few different values.
+		MaxPostingSize int
+		// Overflow defines what is done when the list of a value is full
+		Overflow OverflowStrategy
+	}
+
+	// OverflowStrategy defines what an index does when the list of IDs of a value
+	// has more IDs than IndexOptions.MaxPostingSize
+	OverflowStrategy int
+
 	// Index defines the struct to manage indexation
 	indexType struct {
 		Name         string
 		Selector     []string
 		SelectorHash uint64
 		Type         IndexType
+		Collation    string
+		BlindToken   bool
+		Descending   bool
+		Extractor    bool
+
+		MaxPostingSize int
+		Overflow       OverflowStrategy
 
 		options *Options
+		// cipher is shared with the collection to encrypt the index
+		cipher *indexCipher
+
+		extractor func(doc []byte) ([]byte, bool)
+
+		collator      *collate.Collator
+		collatorMutex sync.Mutex
 
-		getTx func(update bool) (*bolt.Tx, error)
+		// beginView starts a read transaction of the index file, the returned function ends it
+		beginView func() (*bolt.Tx, func(), error)
+	}
+
+	// indexFileLock lets the index transactions share the index file and the compaction
+	// replace it. A waiting compaction does not block the new shares, so a transaction
+	// can start an other one inside it.
+	indexFileLock struct {
+		mutex     sync.Mutex
+		cond      *sync.Cond
+		shares    int
+		replacing bool
 	}
 
 	// refs defines an struct to manage the references of a given object
@@ -99,6 +1301,174 @@ type (
 		responseChan     chan error
 		ctx              context.Context
 		bin              bool
+		// value and meta are the encoded content as it is saved,
+		// it is encoded by the caller before the value can change
+		value []byte
+		meta  byte
+
+		// condition if set is checked just before the write,
+		// the write is not done if it returns an error
+		condition func() error
+		// operation is given to the watchers
+		operation ChangeOperation
+		// ttl if set is the time before the content expires
+		ttl time.Duration
+		// metadata are saved with the version
+		metadata map[string]string
+	}
+
+	// streamManifest is saved under the ID of a streamed content to find its chunks
+	streamManifest struct {
+		Token  []byte
+		Chunks uint32
+		Size   int64
+	}
+
+	// streamReader reads the chunks of a streamed content one by one
+	streamReader struct {
+		c        *Collection
+		txn      *badger.Txn
+		endTxn   func()
+		manifest *streamManifest
+		next     uint32
+		buf      []byte
+	}
+
+	// asyncWrite is a write of PutAsync or a call of Flush if flushed is set
+	asyncWrite struct {
+		operation *batchOperation
+		errChan   chan error
+		// flushed is closed once the previous writes are saved
+		flushed chan struct{}
+	}
+
+	// putOptions defines the optional behaviors of a put
+	putOptions struct {
+		condition func() error
+		// operation is ChangePut if empty
+		operation ChangeOperation
+		ttl       time.Duration
+		metadata  map[string]string
+		// replicated is set for the writes received from the primary by a replica
+		replicated bool
+	}
+
+	// IndexReport defines the result of the verification of the collection indexes.
+	// Missing and Dangling are lists of IDs by index name.
+	IndexReport struct {
+		// Documents is the number of stored documents which have been checked
+		Documents int
+		// Missing are the documents which should be indexed but are not
+		Missing map[string][]string
+		// Dangling are the references which do not match any stored document
+		Dangling map[string][]string
+	}
+
+	// GCReport defines what DB.GC has found, or cleaned if it is not a dry run
+	GCReport struct {
+		DryRun bool
+		// StaleRefs is the number of references of documents which are not stored
+		StaleRefs int
+		// StaleIndexIDs is the number of IDs in the indexes of documents which are not stored
+		StaleIndexIDs int
+		// Tombstones is the number of deleted or expired values kept by the store
+		Tombstones int
+		// ReclaimableBytes is an estimation of the space used by all of them and
+		// by the free pages of the index files
+		ReclaimableBytes int64
+		// PurgedVersions is the number of versions older than the HistoryRetention removed
+		PurgedVersions int
+		// ReclaimedBytes is the size of the database files freed by the cleaning
+		ReclaimedBytes int64
+	}
+
+	// seedRecord defines one line of the seed files
+	seedRecord struct {
+		ID      string
+		Content json.RawMessage
+	}
+
+	// indexDumpEntry is one key of an exported index with the IDs saved under it.
+	// The first element of a dump has only the Index field set.
+	indexDumpEntry struct {
+		Index *indexType `json:",omitempty"`
+		Key   []byte     `json:",omitempty"`
+		IDs   []string   `json:",omitempty"`
+	}
+
+	// BackupManifest describes a backup checked by VerifyBackup
+	BackupManifest struct {
+		StartTime, EndTime time.Time
+		// Timestamp is the version of the last saved value and Since the version
+		// the incremental backup starts from, 0 for the full ones
+		Timestamp, Since uint64
+		// Collections is the number of documents by collection name
+		Collections map[string]int
+		// Values is the number of values checked, with the history and the trash
+		Values int
+		// Indexes is the number of index dumps checked
+		Indexes int
+	}
+
+	// backupVerifier checks the files of a backup archive while it is read.
+	// The configuration is at the end of the archive, so the values are counted
+	// by collection prefix until it tells which prefixes are collections.
+	backupVerifier struct {
+		c         *Collection
+		config    *archive
+		manifest  *BackupManifest
+		prefixes  map[string]*verifiedPrefix
+		hasValues bool
+	}
+
+	// verifiedPrefix counts the values of a collection prefix and keeps the first problem
+	verifiedPrefix struct {
+		documents, values int
+		err               error
+	}
+
+	// zipStreamFile is a file of the zip archive read by readZipStream
+	zipStreamFile struct {
+		name  string
+		crc32 uint32
+	}
+
+	// zipStreamContent computes the checksum and the size of the content of a zip file
+	zipStreamContent struct {
+		r    io.Reader
+		hash hash.Hash32
+		size uint64
+	}
+
+	// byteCounter counts the bytes read from a buffered reader.
+	// It is a io.ByteReader so flate does not read after the end of the compressed files.
+	byteCounter struct {
+		r *bufio.Reader
+		n uint64
+	}
+
+	// contextWriter stops the writes once the context is done
+	contextWriter struct {
+		ctx context.Context
+		w   io.Writer
+	}
+
+	// contextReader stops the reads once the context is done
+	contextReader struct {
+		ctx context.Context
+		r   io.Reader
+	}
+
+	// loadProgress counts the bytes of the archive files read by Load
+	loadProgress struct {
+		loaded, total int64
+		hook          func(loaded, total int64)
+	}
+
+	// progressReader adds the bytes read to the progress
+	progressReader struct {
+		progress *loadProgress
+		r        io.Reader
 	}
 
 	// Archive defines the way archives are saved inside the zip file
@@ -106,7 +1476,10 @@ type (
 		StartTime, EndTime time.Time
 		Indexes            map[string][]*indexType
 		Collections        []string
+		CollectionIDs      map[string]string
 		Timestamp          uint64
+		// Since is the version the incremental archive starts from, 0 for the full ones
+		Since uint64
 
 		file *os.File
 	}
@@ -0,0 +1,151 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Subscribe registers the query and returns the subscription which receives the events
+// of the documents entering or leaving the query result. The documents are checked
+// at every write, the ones matching before the subscription are not sent.
+// The limits and the order of the query are not used.
+func (c *Collection) Subscribe(q *Query) (*Subscription, error) {
+	if q == nil || len(q.filters) == 0 {
+		return nil, fmt.Errorf("query has not get action")
+	}
+
+	for _, filter := range q.filters {
+		found := false
+		for _, index := range c.indexes {
+			if index.doesFilterApplyToIndex(filter) {
+				if err := index.checkFilter(filter); err != nil {
+					return nil, err
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no index for the selector %v", filter.selector)
+		}
+	}
+
+	s := &Subscription{
+		c:      c,
+		query:  q,
+		events: make(chan *SubscriptionEvent, DefaultSubscriptionBufferSize),
+	}
+
+	c.subscriptionsMutex.Lock()
+	c.subscriptions = append(c.subscriptions, s)
+	c.subscriptionsMutex.Unlock()
+
+	return s, nil
+}
+
+// Events returns the channel of the events. It is closed by Close.
+func (s *Subscription) Events() <-chan *SubscriptionEvent {
+	return s.events
+}
+
+// Dropped returns the number of events which have been dropped because the channel was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	c := s.c
+	c.subscriptionsMutex.Lock()
+	defer c.subscriptionsMutex.Unlock()
+
+	for i, subscription := range c.subscriptions {
+		if subscription == s {
+			copy(c.subscriptions[i:], c.subscriptions[i+1:])
+			c.subscriptions[len(c.subscriptions)-1] = nil
+			c.subscriptions = c.subscriptions[:len(c.subscriptions)-1]
+			close(s.events)
+			return
+		}
+	}
+}
+
+// hasListeners is used to read the previous content only when needed
+func (c *Collection) hasListeners() bool {
+	c.subscriptionsMutex.RLock()
+	defer c.subscriptionsMutex.RUnlock()
+	return len(c.subscriptions) != 0 || len(c.watchers) != 0
+}
+
+// getPrevious returns the saved content of the ID if there is any subscription or watcher
+func (c *Collection) getPrevious(id string) []byte {
+	if !c.hasListeners() {
+		return nil
+	}
+
+	previous, err := c.get(context.Background(), id)
+	if err != nil {
+		return nil
+	}
+	return previous[0]
+}
+
+// notifySubscriptions sends the events for the document which was previous and is now content.
+// A nil content means that the document is not saved.
+func (c *Collection) notifySubscriptions(id string, previous, content []byte) {
+	c.subscriptionsMutex.RLock()
+	defer c.subscriptionsMutex.RUnlock()
+
+	for _, s := range c.subscriptions {
+		before := previous != nil && c.matchQuery(s.query, previous)
+		after := content != nil && c.matchQuery(s.query, content)
+
+		var event *SubscriptionEvent
+		if !before && after {
+			event = &SubscriptionEvent{ID: id, Entered: true, Content: content}
+		} else if before && !after {
+			event = &SubscriptionEvent{ID: id, Entered: false, Content: previous}
+		} else {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// matchQuery returns true if the saved content is in the result of all the filters of the query
+func (c *Collection) matchQuery(q *Query, contentAsBytes []byte) bool {
+	stored, _ := decodeStored(contentAsBytes)
+
+	for _, filter := range q.filters {
+		if match, _ := matchFilterWithIndexes(c.indexes, filter, stored, contentAsBytes); !match {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFilterWithIndexes returns true if one of the indexes running the filter selects
+// the saved content. applied is false if none of the indexes runs the filter.
+func matchFilterWithIndexes(indexes []*indexType, filter *Filter, stored map[string]interface{}, contentAsBytes []byte) (match, applied bool) {
+	for _, index := range indexes {
+		if !index.doesFilterApplyToIndex(filter) {
+			continue
+		}
+		applied = true
+
+		candidates, ok := index.applyToStored(stored, contentAsBytes)
+		if !ok {
+			continue
+		}
+		candidate, ok := index.filterCandidate(filter, stored, candidates)
+		if ok && index.matchFilter(filter, candidate) {
+			return true, true
+		}
+	}
+	return false, applied
+}
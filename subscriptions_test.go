@@ -0,0 +1,127 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := c.Subscribe(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Unknown").CompareTo("a"))); err == nil {
+		t.Errorf("the subscription must fail without index")
+		return
+	}
+
+	subscription, err := c.Subscribe(NewQuery().SetFilter(NewFilter(Greater).SetSelector("Balance").CompareTo(100)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkEvent := func(id string, entered bool) bool {
+		select {
+		case event := <-subscription.Events():
+			if event.ID != id || event.Entered != entered {
+				t.Errorf("expected event %q %v but had %q %v", id, entered, event.ID, event.Entered)
+				return false
+			}
+		default:
+			t.Errorf("expected event %q %v but had none", id, entered)
+			return false
+		}
+		return true
+	}
+	checkNoEvent := func() bool {
+		select {
+		case event := <-subscription.Events():
+			t.Errorf("expected no event but had %q %v", event.ID, event.Entered)
+			return false
+		default:
+		}
+		return true
+	}
+
+	c.Put("1", &User{ID: "1", Balance: 50})
+	if !checkNoEvent() {
+		return
+	}
+	c.Put("1", &User{ID: "1", Balance: 150})
+	if !checkEvent("1", true) {
+		return
+	}
+	c.Put("1", &User{ID: "1", Balance: 200})
+	if !checkNoEvent() {
+		return
+	}
+	c.Put("1", &User{ID: "1", Balance: 20})
+	if !checkEvent("1", false) {
+		return
+	}
+
+	c.Put("2", &User{ID: "2", Balance: 300})
+	if !checkEvent("2", true) {
+		return
+	}
+	c.Delete("2")
+	if !checkEvent("2", false) {
+		return
+	}
+
+	batch := c.NewBatch()
+	batch.Put("3", &User{ID: "3", Balance: 500})
+	batch.Put("1", &User{ID: "1", Balance: 101})
+	batch.Delete("3")
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+	if !checkEvent("3", true) || !checkEvent("1", true) || !checkEvent("3", false) || !checkNoEvent() {
+		return
+	}
+
+	subscription.Close()
+	if _, open := <-subscription.Events(); open {
+		t.Errorf("the channel must be closed")
+		return
+	}
+	c.Put("4", &User{ID: "4", Balance: 500})
+
+	// The integers are compared as signed values only, 500 is not lower than 100
+	subscription, err = c.Subscribe(NewQuery().SetFilter(NewFilter(Less).SetSelector("Balance").CompareTo(100)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer subscription.Close()
+
+	c.Put("5", &User{ID: "5", Balance: 500})
+	if !checkNoEvent() {
+		return
+	}
+	c.Put("5", &User{ID: "5", Balance: 50})
+	if !checkEvent("5", true) {
+		return
+	}
+	c.Put("5", &User{ID: "5", Balance: 1000})
+	if !checkEvent("5", false) {
+		return
+	}
+}
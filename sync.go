@@ -0,0 +1,511 @@
+package gotinydb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	// peerIDKey saves the ID of the database used by Sync
+	peerIDKey = []byte("\x00peer")
+	// syncFoldedKey saves the version of the change log up to which the local writes are in the version vectors
+	syncFoldedKey = []byte("\x00syncFolded")
+	// syncPeerPrefix is followed by the ID of a peer and saves the version of the change log it has received
+	syncPeerPrefix = []byte("\x00syncPeer_")
+	// syncStatePrefix is followed by the collection name, a zero byte and the ID of the document
+	syncStatePrefix = []byte("\x00syncState_")
+)
+
+// Sync exchanges the writes done since the last sync with the peer at the other end of conn,
+// which calls Sync at the same time. Both databases need the change log.
+//
+// Every document has a version vector, the version of the change log of every peer at
+// its last write of the document. A received write is saved if its vector is newer than
+// the local one. If the two vectors are not older or newer than the other, the document
+// has been written on both peers since their last sync and the conflict is resolved with
+// the strategy of the options, nil for SyncLastWriterWins. Both peers resolve the
+// conflicts the same way so they have the same documents after the sync.
+//
+// If an error is returned the connection needs to be closed, the writes already
+// received are saved and the next sync sends all the others again.
+func (d *DB) Sync(ctx context.Context, conn io.ReadWriter, options *SyncOptions) (*SyncReport, error) {
+	if d.options.ChangeLogSize <= 0 {
+		return nil, ErrChangeLogDisabled
+	}
+	if options == nil {
+		options = new(SyncOptions)
+	}
+	if options.Strategy == SyncMerge && options.Merge == nil {
+		return nil, ErrSyncMergeMissing
+	}
+
+	d.syncMutex.Lock()
+	defer d.syncMutex.Unlock()
+
+	self, err := d.PeerID()
+	if err != nil {
+		return nil, err
+	}
+	startVersion, err := d.foldSyncChanges(self)
+	if err != nil {
+		return nil, err
+	}
+
+	// The two peers write at the same time, the connection can be unbuffered
+	helloSent := make(chan error, 1)
+	go func() {
+		helloSent <- json.NewEncoder(conn).Encode(&syncHello{PeerID: self})
+	}()
+	decoder := json.NewDecoder(conn)
+	hello := new(syncHello)
+	if err := decoder.Decode(hello); err != nil {
+		return nil, err
+	}
+	if err := <-helloSent; err != nil {
+		return nil, err
+	}
+	if hello.PeerID == "" || hello.PeerID == self {
+		return nil, fmt.Errorf("the peer ID %q is not valid", hello.PeerID)
+	}
+
+	since, err := d.getStoredVersion(buildSyncPeerKey(hello.PeerID))
+	if err != nil {
+		return nil, err
+	}
+
+	type sendResult struct {
+		sent int
+		err  error
+	}
+	// The states are read before the ones received are saved
+	txn, endTxn := d.valueStore.begin(false)
+	defer endTxn()
+	sent := make(chan *sendResult, 1)
+	go func() {
+		n, err := d.sendSyncStates(ctx, txn, conn, since, startVersion, options.Filter)
+		sent <- &sendResult{n, err}
+	}()
+
+	report := new(SyncReport)
+	if err := d.receiveSyncStates(ctx, decoder, hello.PeerID, options, report); err != nil {
+		return report, err
+	}
+	result := <-sent
+	report.Sent = result.sent
+	if result.err != nil {
+		return report, result.err
+	}
+
+	return report, d.setStoredVersion(buildSyncPeerKey(hello.PeerID), startVersion)
+}
+
+// PeerID returns the ID of the database used by Sync, it is built at the first call
+func (d *DB) PeerID() (id string, _ error) {
+	return id, d.valueStore.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(peerIDKey)
+		if err == nil {
+			value, err := item.Value()
+			id = string(value)
+			return err
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		random := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, random); err != nil {
+			return err
+		}
+		id = hex.EncodeToString(random)
+		return txn.Set(peerIDKey, []byte(id))
+	})
+}
+
+// foldSyncChanges saves the local writes of the change log done since the last sync
+// into the version vectors. If the log does not have all the writes or at the first sync,
+// all the documents are considered written. It returns the last version of the change log.
+func (d *DB) foldSyncChanges(self string) (uint64, error) {
+	folded, err := d.getStoredVersion(syncFoldedKey)
+	if err != nil {
+		return 0, err
+	}
+
+	version := d.changeLog.lastVersion()
+
+	records, err := d.Changes(folded)
+	if err != nil && err != ErrChangeLogTruncated {
+		return 0, err
+	}
+	for _, record := range records {
+		if record.Version > version {
+			version = record.Version
+		}
+	}
+
+	states := map[string]*syncState{}
+	update := func(collection, id string, writeTime time.Time, writeVersion uint64) error {
+		key := string(buildSyncStateKey(collection, id))
+		state, ok := states[key]
+		if !ok {
+			var err error
+			if state, err = d.getSyncState(collection, id); err != nil {
+				return err
+			}
+			states[key] = state
+		}
+		state.Vector[self] = writeVersion
+		state.Time = writeTime
+		state.Peer = self
+		state.Updated = writeVersion
+		return nil
+	}
+
+	if folded == 0 || err == ErrChangeLogTruncated {
+		now := time.Now()
+		// The deleted documents are in the states only
+		if err := d.valueStore.View(func(txn *badger.Txn) error {
+			return d.forEachSyncState(txn, func(collection, id string, _ *syncState) error {
+				return update(collection, id, now, version)
+			})
+		}); err != nil {
+			return 0, err
+		}
+		for _, c := range d.getCollections() {
+			iter := c.Iterate(IterOptions{})
+			for iter.Next() {
+				if err := update(c.name, iter.ID(), now, version); err != nil {
+					iter.Close()
+					return 0, err
+				}
+			}
+			iter.Close()
+		}
+	} else {
+		for _, record := range records {
+			// The writes of the peers have their vectors already
+			if record.Origin != "" {
+				continue
+			}
+			if err := update(record.Collection, record.ID, record.Time, record.Version); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := d.saveSyncStates(states); err != nil {
+		return 0, err
+	}
+	return version, d.setStoredVersion(syncFoldedKey, version)
+}
+
+// sendSyncStates writes the documents whose vectors changed between the given versions
+// of the change log and returns how many there are.
+// The ones changed by the current sync are sent at the next one.
+// The documents not selected by the filter anymore are sent as deleted.
+func (d *DB) sendSyncStates(ctx context.Context, txn *badger.Txn, w io.Writer, since, until uint64, filter *ChangeFilter) (sent int, _ error) {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+
+	if err := d.forEachSyncState(txn, func(collection, id string, state *syncState) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if state.Updated <= since || state.Updated > until || !filter.matchID(collection, id) {
+			return nil
+		}
+
+		item := &syncItem{
+			Collection: collection,
+			ID:         id,
+			Vector:     state.Vector,
+			Time:       state.Time,
+			Peer:       state.Peer,
+		}
+		content, bin, err := d.getSyncContent(txn, collection, id)
+		if err != nil {
+			return err
+		}
+		if content != nil && !filter.matchContent(content) {
+			content, bin = nil, false
+		}
+		item.Content, item.Bin, item.Deleted = content, bin, content == nil
+
+		sent++
+		return encoder.Encode(item)
+	}); err != nil {
+		return sent, err
+	}
+
+	if err := encoder.Encode(&syncItem{Done: true}); err != nil {
+		return sent, err
+	}
+	return sent, writer.Flush()
+}
+
+// receiveSyncStates saves the documents of the peer which are newer and resolves the conflicts
+func (d *DB) receiveSyncStates(ctx context.Context, decoder *json.Decoder, peer string, options *SyncOptions, report *SyncReport) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := new(syncItem)
+		if err := decoder.Decode(item); err != nil {
+			return err
+		}
+		if item.Done {
+			return nil
+		}
+		report.Received++
+
+		local, err := d.getSyncState(item.Collection, item.ID)
+		if err != nil {
+			return err
+		}
+		localNewer, remoteNewer := compareVectors(local.Vector, item.Vector)
+		if !remoteNewer {
+			continue
+		}
+
+		remote := &syncState{Vector: item.Vector, Time: item.Time, Peer: item.Peer}
+		if !localNewer {
+			if err := d.saveSyncWrite(item.Collection, item.ID, item.Content, item.Bin, item.Deleted, remote, peer); err != nil {
+				return err
+			}
+			report.Applied++
+			continue
+		}
+
+		report.Conflicts++
+		if err := d.resolveSyncConflict(item, local, remote, peer, options); err != nil {
+			return err
+		}
+		report.Applied++
+	}
+}
+
+// resolveSyncConflict saves the version given by the strategy with the merged vectors
+func (d *DB) resolveSyncConflict(item *syncItem, local, remote *syncState, peer string, options *SyncOptions) error {
+	var localContent []byte
+	var localBin bool
+	if err := d.valueStore.View(func(txn *badger.Txn) error {
+		var err error
+		localContent, localBin, err = d.getSyncContent(txn, item.Collection, item.ID)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	merged := &syncState{Vector: map[string]uint64{}}
+	for _, vector := range []map[string]uint64{local.Vector, remote.Vector} {
+		for peerID, version := range vector {
+			if version > merged.Vector[peerID] {
+				merged.Vector[peerID] = version
+			}
+		}
+	}
+
+	// The last writer is the same on both peers
+	remoteWins := remote.Time.After(local.Time) || (remote.Time.Equal(local.Time) && remote.Peer > local.Peer)
+	winner, loser := local, remote
+	winnerContent, winnerBin, loserContent, loserBin := localContent, localBin, item.Content, item.Bin
+	if remoteWins {
+		winner, loser = remote, local
+		winnerContent, winnerBin, loserContent, loserBin = item.Content, item.Bin, localContent, localBin
+	}
+	merged.Time, merged.Peer = winner.Time, winner.Peer
+
+	switch options.Strategy {
+	case SyncMerge:
+		var err error
+		winnerContent, err = options.Merge(item.Collection, item.ID,
+			&SyncVersion{Content: localContent, Bin: localBin, Time: local.Time, Peer: local.Peer},
+			&SyncVersion{Content: item.Content, Bin: item.Bin, Time: remote.Time, Peer: remote.Peer},
+		)
+		if err != nil {
+			return err
+		}
+		// The merged content is saved as bytes if the versions it comes from are
+		winnerBin = (localContent == nil || localBin) && (item.Content == nil || item.Bin)
+	case SyncKeepBoth:
+		if loserContent != nil {
+			loserID := item.ID + SyncConflictSeparator + loser.Peer
+			loserState := &syncState{Vector: merged.Vector, Time: loser.Time, Peer: loser.Peer}
+			if err := d.saveSyncWrite(item.Collection, loserID, loserContent, loserBin, false, loserState, peer); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The local content is not written again
+	if options.Strategy != SyncMerge && !remoteWins {
+		merged.Updated = d.changeLog.lastVersion()
+		return d.saveSyncStates(map[string]*syncState{string(buildSyncStateKey(item.Collection, item.ID)): merged})
+	}
+	return d.saveSyncWrite(item.Collection, item.ID, winnerContent, winnerBin, winnerContent == nil, merged, peer)
+}
+
+// saveSyncWrite saves the document written by the peer with its state
+func (d *DB) saveSyncWrite(collection, id string, content []byte, bin, deleted bool, state *syncState, peer string) error {
+	c, err := d.use(collection, "")
+	if err != nil {
+		return err
+	}
+
+	batch := c.NewBatch()
+	batch.origin = peer
+	switch {
+	case deleted:
+		err = batch.Delete(id)
+	case bin:
+		err = batch.Put(id, content)
+	default:
+		err = batch.Put(id, json.RawMessage(content))
+	}
+	if err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	// The state is sent to the other peers at their next sync
+	state.Updated = d.changeLog.lastVersion()
+	return d.saveSyncStates(map[string]*syncState{string(buildSyncStateKey(collection, id)): state})
+}
+
+// getSyncContent returns the saved content of the document, nil if it is not saved
+func (d *DB) getSyncContent(txn *badger.Txn, collection, id string) (content []byte, bin bool, _ error) {
+	var c *Collection
+	for _, col := range d.getCollections() {
+		if col.name == collection {
+			c = col
+			break
+		}
+	}
+	if c == nil {
+		return nil, false, nil
+	}
+
+	contents, err := c.getFromTxn(d.ctx, txn, id)
+	if err == ErrNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	// The flag saved with the value tells if the content has been saved from bytes
+	item, err := txn.Get(c.buildStoreID(id))
+	if err != nil {
+		return nil, false, err
+	}
+	return contents[0], isBinaryMeta(item.UserMeta()), nil
+}
+
+// compareVectors returns if a has versions newer than b and if b has versions newer than a
+func compareVectors(a, b map[string]uint64) (aNewer, bNewer bool) {
+	for peer, version := range a {
+		if version > b[peer] {
+			aNewer = true
+		}
+	}
+	for peer, version := range b {
+		if version > a[peer] {
+			bNewer = true
+		}
+	}
+	return
+}
+
+// getSyncState returns the state of the document, an empty one if it has none
+func (d *DB) getSyncState(collection, id string) (*syncState, error) {
+	state := &syncState{Vector: map[string]uint64{}}
+	return state, d.valueStore.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildSyncStateKey(collection, id))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		value, err := item.Value()
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(value, state)
+	})
+}
+
+// saveSyncStates saves the states by their keys, by batches of ImportBatchSize
+func (d *DB) saveSyncStates(states map[string]*syncState) error {
+	txn, endTxn := d.valueStore.begin(true)
+	defer func() {
+		endTxn()
+	}()
+
+	n := 0
+	for key, state := range states {
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(key), value); err != nil {
+			return err
+		}
+
+		n++
+		if n%ImportBatchSize == 0 {
+			if err := txn.Commit(nil); err != nil {
+				return err
+			}
+			endTxn()
+			txn, endTxn = d.valueStore.begin(true)
+		}
+	}
+	return txn.Commit(nil)
+}
+
+// forEachSyncState calls fn with all the states of the documents of the transaction in the order of their keys
+func (d *DB) forEachSyncState(txn *badger.Txn, fn func(collection, id string, state *syncState) error) error {
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	for iter.Seek(syncStatePrefix); iter.ValidForPrefix(syncStatePrefix); iter.Next() {
+		key := iter.Item().Key()[len(syncStatePrefix):]
+		separator := bytes.IndexByte(key, 0)
+		if separator < 0 {
+			continue
+		}
+
+		value, err := iter.Item().Value()
+		if err != nil {
+			return err
+		}
+		state := &syncState{Vector: map[string]uint64{}}
+		if err := json.Unmarshal(value, state); err != nil {
+			return err
+		}
+
+		if err := fn(string(key[:separator]), string(key[separator+1:]), state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildSyncPeerKey(peer string) []byte {
+	return append(append([]byte{}, syncPeerPrefix...), peer...)
+}
+
+func buildSyncStateKey(collection, id string) []byte {
+	ret := append([]byte{}, syncStatePrefix...)
+	ret = append(ret, collection...)
+	ret = append(ret, 0)
+	return append(ret, id...)
+}
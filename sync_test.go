@@ -0,0 +1,202 @@
+package gotinydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbs := make([]*DB, 2)
+	cols := make([]*Collection, 2)
+	for i := range dbs {
+		testPath := <-getTestPathChan
+		defer os.RemoveAll(testPath)
+		options := NewDefaultOptions(testPath)
+		options.ChangeLogSize = 1000
+		db, openDBErr := Open(ctx, options)
+		if openDBErr != nil {
+			t.Error(openDBErr)
+			return
+		}
+		defer db.Close()
+		dbs[i] = db
+		cols[i], _ = db.Use("testCol")
+	}
+
+	syncPeers := func(options *SyncOptions) (reports [2]*SyncReport, ok bool) {
+		connA, connB := net.Pipe()
+		defer connA.Close()
+		defer connB.Close()
+
+		errs := make(chan error, 1)
+		go func() {
+			var err error
+			reports[0], err = dbs[0].Sync(ctx, connA, options)
+			errs <- err
+		}()
+		var err error
+		reports[1], err = dbs[1].Sync(ctx, connB, options)
+		if err != nil {
+			t.Error(err)
+			return reports, false
+		}
+		if err := <-errs; err != nil {
+			t.Error(err)
+			return reports, false
+		}
+		return reports, true
+	}
+	// checkContent returns false if one of the databases does not have the content
+	checkContent := func(id string, user *User) bool {
+		for i, c := range cols {
+			retrieved := new(User)
+			err := c.Get(id, retrieved)
+			if user == nil {
+				if err != ErrNotFound {
+					t.Errorf("%q must be deleted on %d but got %v", id, i, err)
+					return false
+				}
+				continue
+			}
+			if err != nil || retrieved.Email != user.Email {
+				t.Errorf("%q on %d is %+v and %v but expected %+v", id, i, retrieved, err, user)
+				return false
+			}
+		}
+		return true
+	}
+
+	users := unmarshalDataSet(dataSet1)[:10]
+	for _, user := range users[:2] {
+		if err := cols[0].Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := cols[1].Put(users[2].ID, users[2]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	reports, ok := syncPeers(nil)
+	if !ok {
+		return
+	}
+	if reports[0].Sent != 2 || reports[1].Received != 2 || reports[0].Applied != 1 || reports[1].Applied != 2 {
+		t.Errorf("unexpected reports %+v %+v", reports[0], reports[1])
+		return
+	}
+	for _, user := range users[:3] {
+		if !checkContent(user.ID, user) {
+			return
+		}
+	}
+
+	// Nothing is written again
+	if reports, ok = syncPeers(nil); !ok {
+		return
+	}
+	if reports[0].Applied != 0 || reports[1].Applied != 0 {
+		t.Errorf("unexpected reports %+v %+v", reports[0], reports[1])
+		return
+	}
+
+	// The last write wins
+	if err := cols[0].Put(users[0].ID, users[3]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := cols[1].Put(users[0].ID, users[4]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := cols[0].Delete(users[1].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if reports, ok = syncPeers(nil); !ok {
+		return
+	}
+	if reports[0].Conflicts != 1 || reports[1].Conflicts != 1 {
+		t.Errorf("unexpected reports %+v %+v", reports[0], reports[1])
+		return
+	}
+	if !checkContent(users[0].ID, users[4]) || !checkContent(users[1].ID, nil) {
+		return
+	}
+
+	// Both versions are kept
+	peerA, _ := dbs[0].PeerID()
+	if err := cols[0].Put(users[2].ID, users[5]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := cols[1].Put(users[2].ID, users[6]); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok = syncPeers(&SyncOptions{Strategy: SyncKeepBoth}); !ok {
+		return
+	}
+	if !checkContent(users[2].ID, users[6]) || !checkContent(users[2].ID+SyncConflictSeparator+peerA, users[5]) {
+		return
+	}
+
+	// The merge function gives the content
+	merge := func(collection, id string, local, remote *SyncVersion) ([]byte, error) {
+		if bytes.Compare(local.Content, remote.Content) > 0 {
+			return local.Content, nil
+		}
+		return remote.Content, nil
+	}
+	if err := cols[0].Put(users[7].ID, users[7]); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := cols[1].Put(users[7].ID, users[8]); err != nil {
+		t.Error(err)
+		return
+	}
+	if reports, ok = syncPeers(&SyncOptions{Strategy: SyncMerge, Merge: merge}); !ok {
+		return
+	}
+	if reports[0].Conflicts != 1 || reports[1].Conflicts != 1 {
+		t.Errorf("unexpected reports %+v %+v", reports[0], reports[1])
+		return
+	}
+	expected := users[8]
+	asBytes7, _ := json.Marshal(users[7])
+	asBytes8, _ := json.Marshal(users[8])
+	if bytes.Compare(asBytes7, asBytes8) > 0 {
+		expected = users[7]
+	}
+	if !checkContent(users[7].ID, expected) {
+		return
+	}
+
+	// The binary contents stay binary even if they look like JSON
+	if err := cols[0].Put("bin", []byte(`{"Email":"bin"}`)); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok = syncPeers(nil); !ok {
+		return
+	}
+	iter := cols[1].Iterate(IterOptions{Prefix: "bin"})
+	defer iter.Close()
+	if !iter.Next() || !iter.Binary() {
+		t.Errorf("the synced content must be binary")
+		return
+	}
+
+	if _, err := dbs[0].Sync(ctx, nil, &SyncOptions{Strategy: SyncMerge}); err != ErrSyncMergeMissing {
+		t.Errorf("expected %v but got %v", ErrSyncMergeMissing, err)
+	}
+}
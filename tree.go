@@ -0,0 +1,133 @@
+package gotinydb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// GetSubtree returns the document of the given ID and all the documents with IDs
+// starting with the ID followed by TreeSeparator, like "folder" and "folder/file".
+// The documents are read with a prefix scan of the store and returned in the ID order.
+func (c *Collection) GetSubtree(root string) ([]*ResponseElem, error) {
+	if root == "" {
+		return nil, ErrEmptyID
+	}
+	c.touch()
+
+	return c.getSubtree(root)
+}
+
+// MoveSubtree changes the root of the subtree, "a/b/c" moved from "a/b" to "x" becomes "x/c".
+// The writes are done in batches of SubtreeBatchSize so a big subtree is not moved in one transaction.
+func (c *Collection) MoveSubtree(from, to string) error {
+	if from == "" || to == "" {
+		return ErrEmptyID
+	}
+	if to == from || isInSubtree(from, to) {
+		return fmt.Errorf("can't move %q into itself", from)
+	}
+
+	elems, err := c.getSubtree(from)
+	if err != nil {
+		return err
+	}
+
+	return c.subtreeBatches(elems, func(batch *WriteBatch, elem *ResponseElem) error {
+		newID := to + strings.TrimPrefix(elem.GetID(), from)
+
+		var putErr error
+		if json.Valid(elem.ContentAsBytes) {
+			putErr = batch.Put(newID, json.RawMessage(elem.ContentAsBytes))
+		} else {
+			putErr = batch.Put(newID, elem.ContentAsBytes)
+		}
+		if putErr != nil {
+			return putErr
+		}
+		return batch.Delete(elem.GetID())
+	})
+}
+
+// DeleteSubtree removes the document of the given ID and all its children.
+// The deletes are done in batches of SubtreeBatchSize.
+func (c *Collection) DeleteSubtree(root string) error {
+	if root == "" {
+		return ErrEmptyID
+	}
+
+	elems, err := c.getSubtree(root)
+	if err != nil {
+		return err
+	}
+
+	return c.subtreeBatches(elems, func(batch *WriteBatch, elem *ResponseElem) error {
+		return batch.Delete(elem.GetID())
+	})
+}
+
+func (c *Collection) getSubtree(root string) ([]*ResponseElem, error) {
+	ret := []*ResponseElem{}
+
+	err := c.store.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		prefix := c.buildStoreID(root)
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+
+			id := string(item.Key()[5:])
+			// The IDs which only start like the root are not children: "ab" for "a"
+			if id != root && !isInSubtree(root, id) {
+				continue
+			}
+
+			contentAsBytes, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			contentAsBytes, err = c.getAndCheckContent(item.Key(), item.UserMeta(), contentAsBytes)
+			if err != nil {
+				return err
+			}
+
+			elem := &ResponseElem{ID: new(idType), ContentAsBytes: contentAsBytes}
+			elem.ID.ID = id
+			ret = append(ret, elem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// subtreeBatches calls fn for every element and writes the batch every SubtreeBatchSize elements
+func (c *Collection) subtreeBatches(elems []*ResponseElem, fn func(batch *WriteBatch, elem *ResponseElem) error) error {
+	batch := c.NewBatch()
+	for _, elem := range elems {
+		if err := fn(batch, elem); err != nil {
+			return err
+		}
+
+		if batch.Len() >= SubtreeBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Write()
+}
+
+// isInSubtree returns true if the ID is a child of the root at any depth
+func isInSubtree(root, id string) bool {
+	return strings.HasPrefix(id, root+TreeSeparator)
+}
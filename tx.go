@@ -0,0 +1,475 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// txIntentPrefix is the prefix of the records saved with the values of a transaction
+// until the indexes of all its collections are committed
+var txIntentPrefix = []byte("\x00txIntent_")
+
+// Begin starts a transaction. The writes done with the collections of the transaction
+// are saved together by Commit, if one of them fails none is saved.
+// The values of all the collections are saved in one store transaction with the list
+// of the collections written. Every collection has its own index file, their transactions
+// are committed right after it. If one of them fails the indexes of the collections are
+// built again from the saved values, at the commit or at the next Open.
+// The IDs read by the transaction are checked by Commit which returns ErrConflict if
+// one of them has been written since.
+// The context stops the commit if it is done before.
+func (d *DB) Begin(ctx context.Context) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&d.openTransactions, 1)
+	return &Tx{
+		db:          d,
+		ctx:         ctx,
+		collections: map[string]*TxCollection{},
+	}, nil
+}
+
+// RunInTransaction runs the function in a new transaction and commits it.
+// If the function or the commit fails with a conflict, a timeout or a transient storage error
+// everything is done again in a new transaction with the backoff of the TxRetry policy of the options.
+// The function must not have side effects out of the transaction. It stops when the context is done.
+func (d *DB) RunInTransaction(ctx context.Context, fn func(tx *Tx) error) error {
+	policy := DefaultTxRetryPolicy
+	if d.options.TxRetry != nil {
+		policy = d.options.TxRetry
+	}
+
+	txPolicy := *policy
+	txPolicy.IsTransient = func(err error) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		if retryErr, ok := err.(*RetryError); ok {
+			err = retryErr.Last()
+		}
+		return err == ErrConflict || err == ErrTimeOut || policy.isTransient(err)
+	}
+
+	return txPolicy.do(ctx, func() error {
+		tx, err := d.Begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// Collection returns the collection of the given name inside the transaction.
+// If it does not exist the collection is created by Commit, only if it is written.
+func (tx *Tx) Collection(name string) *TxCollection {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if txCollection, ok := tx.collections[name]; ok {
+		return txCollection
+	}
+
+	txCollection := &TxCollection{tx: tx, name: name, reads: map[string]uint64{}}
+	if !tx.db.hasCollection(name) {
+		// The writes are checked again by the collection created at the commit
+		txCollection.missing = true
+		txCollection.batch = (&Collection{name: name}).NewBatch()
+	} else if c, err := tx.db.Use(name); err != nil {
+		txCollection.err = err
+	} else {
+		txCollection.batch = c.NewBatch()
+	}
+
+	tx.collections[name] = txCollection
+	return txCollection
+}
+
+// hasCollection returns true if the database or one of its data packs has the collection
+func (d *DB) hasCollection(name string) bool {
+	for _, col := range d.getCollections() {
+		if col.name == name {
+			return true
+		}
+	}
+	return d.packCollection(name) != nil
+}
+
+// resolve moves the writes of a collection which did not exist at the beginning of the
+// transaction to the batch of the collection, created if it is written
+func (txc *TxCollection) resolve() error {
+	if !txc.missing || (txc.batch.Len() == 0 && !txc.tx.db.hasCollection(txc.name)) {
+		return nil
+	}
+
+	c, err := txc.tx.db.Use(txc.name)
+	if err != nil {
+		return err
+	}
+	batch := c.NewBatch()
+	for _, operation := range txc.batch.operations {
+		if operation.delete {
+			err = batch.Delete(operation.id)
+		} else {
+			err = batch.Put(operation.id, operation.contentInterface)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	txc.batch = batch
+	txc.missing = false
+	return nil
+}
+
+// checkReads returns ErrConflict if one of the IDs read by the transaction has been written since.
+// The reads are done in the store transaction so a write committed meanwhile makes it fail.
+func (txc *TxCollection) checkReads(txn *badger.Txn) error {
+	if txc.missing {
+		return nil
+	}
+
+	for id, readVersion := range txc.reads {
+		version := uint64(0)
+		item, err := txn.Get(txc.batch.c.buildStoreID(id))
+		if err == nil {
+			if !item.IsDeletedOrExpired() {
+				version = item.Version()
+			}
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		if version != readVersion {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// Commit saves all the writes of the transaction. The values are saved in one
+// store transaction and the indexes are updated before it is committed.
+// The transaction can't be used after.
+func (tx *Tx) Commit() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	atomic.AddInt64(&tx.db.openTransactions, -1)
+
+	batches := []*WriteBatch{}
+	readers := []*TxCollection{}
+	for _, txCollection := range tx.collections {
+		if txCollection.err != nil {
+			return txCollection.err
+		}
+		if err := txCollection.resolve(); err != nil {
+			return err
+		}
+		if txCollection.batch.Len() != 0 {
+			batches = append(batches, txCollection.batch)
+		}
+		if len(txCollection.reads) != 0 {
+			readers = append(readers, txCollection)
+		}
+	}
+	if len(batches) == 0 {
+		return nil
+	}
+	// The index transactions are always started in the same order to not lock
+	// an other transaction doing the same
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].c.id < batches[j].c.id
+	})
+
+	ctx, cancel := context.WithTimeout(tx.ctx, tx.db.options.TransactionTimeOut)
+	defer cancel()
+
+	previousContents := make([][][]byte, len(batches))
+	for i, batch := range batches {
+		if err := batch.prepare(ctx); err != nil {
+			return err
+		}
+		previousContents[i] = batch.getPreviousContents()
+	}
+
+	// The collections are locked in the order of the batches, like the index transactions
+	for _, batch := range batches {
+		batch.c.writeMutex.Lock()
+	}
+	err := tx.write(ctx, batches, readers)
+	for _, batch := range batches {
+		batch.c.writeMutex.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, batch := range batches {
+		batch.afterWrite(previousContents[i])
+	}
+	return nil
+}
+
+// Rollback drops all the writes of the transaction.
+// The transaction can't be used after.
+func (tx *Tx) Rollback() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	atomic.AddInt64(&tx.db.openTransactions, -1)
+	tx.collections = map[string]*TxCollection{}
+	return nil
+}
+
+// write checks the reads and saves the values of all the batches in one store transaction
+// and updates the indexes of every collection. Only the store transaction is retried.
+func (tx *Tx) write(ctx context.Context, batches []*WriteBatch, readers []*TxCollection) error {
+	indexTxs := make([]*bolt.Tx, len(batches))
+	defer func() {
+		for _, indexTx := range indexTxs {
+			if indexTx != nil {
+				indexTx.Rollback()
+			}
+		}
+	}()
+
+	for i, batch := range batches {
+		indexTx, err := batch.c.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		indexTxs[i] = indexTx
+
+		if err := batch.updateIndexes(ctx, indexTx); err != nil {
+			return err
+		}
+	}
+
+	collectionIDs := make([]string, len(batches))
+	for i, batch := range batches {
+		collectionIDs[i] = batch.c.id
+	}
+	intent, err := json.Marshal(collectionIDs)
+	if err != nil {
+		return err
+	}
+	intentKey := tx.db.newTxIntentKey()
+
+	// The records of all the collections are committed with the values
+	records := []*ChangeRecord{}
+	for _, batch := range batches {
+		records = append(records, batch.changeRecords()...)
+	}
+	changes := batches[0].c.beginChanges(records...)
+
+	err = tx.db.options.Retry.do(ctx, tx.db.metrics.countRetries(func() error {
+		return tx.db.valueStore.Update(func(txn *badger.Txn) error {
+			for _, reader := range readers {
+				if err := reader.checkReads(txn); err != nil {
+					return err
+				}
+			}
+			for _, batch := range batches {
+				if err := batch.writeValues(txn); err != nil {
+					return err
+				}
+			}
+			if err := changes.add(txn); err != nil {
+				return err
+			}
+			return txn.Set(intentKey, intent)
+		})
+	}))
+	changes.end(err == nil)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		batch.c.addSize(batch.writtenSize())
+	}
+
+	// The transaction is saved with the values, the index transactions can't be canceled anymore
+	var commitErr error
+	for i, indexTx := range indexTxs {
+		indexTxs[i] = nil
+		if commitErr != nil {
+			indexTx.Rollback()
+			continue
+		}
+		commitErr = indexTx.Commit()
+	}
+	if commitErr != nil {
+		tx.db.options.log(LogError, "transaction index commit failed, the indexes are built again", "error", commitErr)
+	}
+	// The transaction is saved, a record left is replayed at the next opening
+	if err := tx.db.replayTxIntent(intentKey, intent, commitErr != nil); err != nil {
+		tx.db.options.log(LogError, "transaction record not replayed", "error", err)
+	}
+	return nil
+}
+
+// newTxIntentKey returns a new key for the record of a transaction,
+// unique even for the records left by an other run
+func (d *DB) newTxIntentKey() []byte {
+	key := make([]byte, len(txIntentPrefix)+16)
+	copy(key, txIntentPrefix)
+	binary.BigEndian.PutUint64(key[len(txIntentPrefix):], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(key[len(txIntentPrefix)+8:], atomic.AddUint64(&d.txIntents, 1))
+	return key
+}
+
+// replayTxIntent builds again the indexes of the collections of the record if rebuild
+// is true and removes the record. The record stays if the indexes can't be built.
+func (d *DB) replayTxIntent(key, intent []byte, rebuild bool) error {
+	if rebuild {
+		collectionIDs := []string{}
+		if err := json.Unmarshal(intent, &collectionIDs); err != nil {
+			return err
+		}
+		for _, c := range d.getCollections() {
+			for _, id := range collectionIDs {
+				if c.id != id {
+					continue
+				}
+				if err := c.buildIndexes(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return d.valueStore.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// replayTxIntents builds again the indexes of the transactions whose records are left
+// by a failure between the saving of the values and the commit of the indexes
+func (d *DB) replayTxIntents() error {
+	keys, intents := [][]byte{}, [][]byte{}
+	err := d.valueStore.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(txIntentPrefix); iter.ValidForPrefix(txIntentPrefix); iter.Next() {
+			intent, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, iter.Item().KeyCopy(nil))
+			intents = append(intents, intent)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if err := d.replayTxIntent(key, intents[i], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put adds the saving of the content to the transaction
+func (txc *TxCollection) Put(id string, content interface{}) error {
+	if txc.err != nil {
+		return txc.err
+	}
+	txc.tx.mutex.Lock()
+	defer txc.tx.mutex.Unlock()
+	if txc.tx.done {
+		return ErrTxDone
+	}
+
+	return txc.batch.Put(id, content)
+}
+
+// Delete adds the removal of the ID to the transaction
+func (txc *TxCollection) Delete(id string) error {
+	if txc.err != nil {
+		return txc.err
+	}
+	txc.tx.mutex.Lock()
+	defer txc.tx.mutex.Unlock()
+	if txc.tx.done {
+		return ErrTxDone
+	}
+
+	return txc.batch.Delete(id)
+}
+
+// Get decodes the content of the ID into dest like Collection.Get.
+// The writes of the transaction are returned before they are committed.
+func (txc *TxCollection) Get(id string, dest interface{}) error {
+	contentAsBytes, err := txc.GetRaw(id)
+	if err != nil {
+		return err
+	}
+	return unmarshalContent(contentAsBytes, dest)
+}
+
+// GetRaw returns the content of the ID like Collection.GetRaw.
+// The writes of the transaction are returned before they are committed.
+// The version of the IDs read from the collection is checked by Commit.
+func (txc *TxCollection) GetRaw(id string) ([]byte, error) {
+	if txc.err != nil {
+		return nil, txc.err
+	}
+	txc.tx.mutex.Lock()
+	defer txc.tx.mutex.Unlock()
+	if txc.tx.done {
+		return nil, ErrTxDone
+	}
+
+	for i := len(txc.batch.operations) - 1; i >= 0; i-- {
+		operation := txc.batch.operations[i]
+		if operation.id != id {
+			continue
+		}
+		if operation.delete {
+			return nil, fmt.Errorf("content of %q is empty or not present", id)
+		}
+		return operation.contentAsBytes, nil
+	}
+
+	if txc.missing {
+		txc.reads[id] = 0
+		return nil, fmt.Errorf("content of %q is empty or not present", id)
+	}
+
+	// The version is read before the content, a write in between makes the commit fail
+	if _, read := txc.reads[id]; !read {
+		version, err := txc.batch.c.getVersion(id)
+		if err != nil {
+			return nil, err
+		}
+		txc.reads[id] = version
+	}
+	return txc.batch.c.GetRaw(id)
+}
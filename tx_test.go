@@ -0,0 +1,352 @@
+package gotinydb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+func TestTx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	users, _ := db.Use("users")
+	if err := setIndexes(users); err != nil {
+		t.Error(err)
+		return
+	}
+	logs, _ := db.Use("logs")
+	if err := logs.SetWriteOnce(true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dataSet := unmarshalDataSet(dataSet1)
+	user, updatedUser := dataSet[0], unmarshalDataSet(dataSet2)[0]
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := tx.Collection("users").Put(user.ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := tx.Collection("logs").Put("0", []byte("created")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The transaction returns its own writes but the collections do not
+	retrievedUser := new(User)
+	if err := tx.Collection("users").Get(user.ID, retrievedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if retrievedUser.Email != user.Email {
+		t.Errorf("expected %q but had %q", user.Email, retrievedUser.Email)
+		return
+	}
+	if err := users.Get(user.ID, nil); err == nil {
+		t.Errorf("the write is saved before the commit")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Errorf("expected %v but had %v", ErrTxDone, err)
+		return
+	}
+	if err := users.Get(user.ID, retrievedUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if content, err := logs.GetRaw("0"); err != nil || string(content) != "created" {
+		t.Errorf("expected %q but had %q and %v", "created", content, err)
+		return
+	}
+	if response, err := users.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(user.Email))); err != nil || response.Len() != 1 {
+		t.Errorf("the index is not updated by the commit")
+		return
+	}
+
+	// The log already exists so none of the writes is saved
+	tx, _ = db.Begin(ctx)
+	tx.Collection("users").Put(updatedUser.ID, updatedUser)
+	tx.Collection("logs").Put("0", []byte("updated"))
+	if err := tx.Commit(); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+	if err := users.Get(user.ID, retrievedUser); err != nil || retrievedUser.Email != user.Email {
+		t.Errorf("the user is updated by a failed transaction")
+		return
+	}
+
+	// Nothing is saved after a rollback
+	tx, _ = db.Begin(ctx)
+	tx.Collection("users").Delete(user.ID)
+	if err := tx.Collection("users").Get(user.ID, nil); err == nil {
+		t.Errorf("the transaction returns a deleted ID")
+		return
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := tx.Collection("users").Put(user.ID, user); err != ErrTxDone {
+		t.Errorf("expected %v but had %v", ErrTxDone, err)
+		return
+	}
+	if err := users.Get(user.ID, nil); err != nil {
+		t.Errorf("the user is deleted by a rolled back transaction")
+		return
+	}
+
+	// The collections are created only by the commit of their writes
+	tx, _ = db.Begin(ctx)
+	tx.Collection("orders").Put("0", user)
+	tx.Rollback()
+	if len(db.Collections()) != 2 {
+		t.Errorf("a rolled back transaction created a collection: %v", db.Collections())
+		return
+	}
+	tx, _ = db.Begin(ctx)
+	tx.Collection("orders").Put("0", user)
+	if err := tx.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+	orders, _ := db.Use("orders")
+	if err := orders.Get("0", retrievedUser); err != nil || retrievedUser.Email != user.Email {
+		t.Errorf("the write to the new collection is not saved: %v", err)
+		return
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	users, _ := db.Use("users")
+	if err := users.Put("id", &User{ID: "id", Balance: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The user is updated after the transaction read it
+	tx, _ := db.Begin(ctx)
+	user := new(User)
+	if err := tx.Collection("users").Get("id", user); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := users.Put("id", &User{ID: "id", Balance: 10}); err != nil {
+		t.Error(err)
+		return
+	}
+	user.Balance++
+	tx.Collection("users").Put("id", user)
+	if err := tx.Commit(); err != ErrConflict {
+		t.Errorf("expected %v but had %v", ErrConflict, err)
+		return
+	}
+	if err := users.Get("id", user); err != nil || user.Balance != 10 {
+		t.Errorf("the conflicting transaction is saved: %v", user)
+		return
+	}
+
+	// The concurrent increments are retried after a conflict so none is lost
+	nbWriters := 5
+	errs := make(chan error, nbWriters)
+	for i := 0; i < nbWriters; i++ {
+		go func() {
+			errs <- db.RunInTransaction(ctx, func(tx *Tx) error {
+				user := new(User)
+				if err := tx.Collection("users").Get("id", user); err != nil {
+					return err
+				}
+				user.Balance++
+				return tx.Collection("users").Put("id", user)
+			})
+		}()
+	}
+	for i := 0; i < nbWriters; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := users.Get("id", user); err != nil || user.Balance != 10+nbWriters {
+		t.Errorf("expected a balance of %d but had %d", 10+nbWriters, user.Balance)
+		return
+	}
+
+	// The missing IDs read are checked too, even if the write is in an other collection
+	tx, _ = db.Begin(ctx)
+	if err := tx.Collection("users").Get("other", nil); err == nil {
+		t.Errorf("the transaction returns a missing ID")
+		return
+	}
+	users.Put("other", &User{ID: "other"})
+	tx.Collection("logs").Put("0", []byte("other"))
+	if err := tx.Commit(); err != ErrConflict {
+		t.Errorf("expected %v but had %v", ErrConflict, err)
+		return
+	}
+}
+
+func TestTxReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+
+	users, _ := db.Use("users")
+	if err := setIndexes(users); err != nil {
+		t.Error(err)
+		return
+	}
+	user := unmarshalDataSet(dataSet1)[0]
+	if err := users.Put(user.ID, user); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The values are saved with the record but the indexes are not committed
+	if err := users.db.Update(func(tx *bolt.Tx) error {
+		return users.unindexDocument(ctx, tx, user.ID)
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	intent, _ := json.Marshal([]string{users.id})
+	if err := db.valueStore.Update(func(txn *badger.Txn) error {
+		return txn.Set(db.newTxIntentKey(), intent)
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	db.Close()
+
+	// The indexes are built again by the opening
+	db, openDBErr = Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	users, _ = db.Use("users")
+	if response, err := users.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(user.Email))); err != nil || response.Len() != 1 {
+		t.Errorf("the indexes are not built again from the record")
+		return
+	}
+	if err := db.valueStore.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		if iter.Seek(txIntentPrefix); iter.ValidForPrefix(txIntentPrefix) {
+			t.Errorf("the record is not removed")
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestRunInTransaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	users := unmarshalDataSet(dataSet1)
+
+	// The conflicts are retried and only the writes of the last attempt are saved
+	attempts := 0
+	if err := db.RunInTransaction(ctx, func(tx *Tx) error {
+		attempts++
+		if err := tx.Collection("users").Put(users[attempts].ID, users[attempts]); err != nil {
+			return err
+		}
+		if attempts < DefaultTxRetryMaxAttempts {
+			return ErrConflict
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if attempts != DefaultTxRetryMaxAttempts {
+		t.Errorf("expected %d attempts but had %d", DefaultTxRetryMaxAttempts, attempts)
+		return
+	}
+
+	c, _ := db.Use("users")
+	ids, _ := c.GetIDs("", 10)
+	if len(ids) != 1 || ids[0] != users[attempts].ID {
+		t.Errorf("expected only %q but had %v", users[attempts].ID, ids)
+		return
+	}
+
+	// The other errors are returned right away
+	attempts = 0
+	if err := db.RunInTransaction(ctx, func(tx *Tx) error {
+		attempts++
+		return ErrNotFound
+	}); err != ErrNotFound || attempts != 1 {
+		t.Errorf("expected %v after 1 attempt but had %v after %d", ErrNotFound, err, attempts)
+		return
+	}
+
+	// Nothing runs after the end of the context
+	canceledCtx, cancelTx := context.WithCancel(ctx)
+	cancelTx()
+	if err := db.RunInTransaction(canceledCtx, func(tx *Tx) error {
+		t.Errorf("the function must not run")
+		return nil
+	}); err != context.Canceled {
+		t.Errorf("expected %v but had %v", context.Canceled, err)
+		return
+	}
+}
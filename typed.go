@@ -0,0 +1,50 @@
+//go:build go1.18
+// +build go1.18
+
+package gotinydb
+
+// TypedCollection wraps a collection to save and return the documents as values of type T.
+// The other methods of the collection are available as they are.
+type TypedCollection[T any] struct {
+	*Collection
+}
+
+// NewTypedCollection returns the given collection with its documents typed as T
+func NewTypedCollection[T any](c *Collection) *TypedCollection[T] {
+	return &TypedCollection[T]{Collection: c}
+}
+
+// UseTyped does the same as DB.Use but returns a typed collection
+func UseTyped[T any](d *DB, colName string) (*TypedCollection[T], error) {
+	c, err := d.Use(colName)
+	if err != nil {
+		return nil, err
+	}
+	return NewTypedCollection[T](c), nil
+}
+
+// Put saves the given document
+func (tc *TypedCollection[T]) Put(id string, content T) error {
+	return tc.Collection.Put(id, content)
+}
+
+// Get returns the document saved under the given ID
+func (tc *TypedCollection[T]) Get(id string) (T, error) {
+	var ret T
+	err := tc.Collection.Get(id, &ret)
+	return ret, err
+}
+
+// Query returns the documents matching the query in the response order
+func (tc *TypedCollection[T]) Query(q *Query) ([]T, error) {
+	response, err := tc.Collection.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []T{}
+	if err := response.Decode(&ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
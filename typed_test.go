@@ -0,0 +1,67 @@
+//go:build go1.18
+// +build go1.18
+
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestTypedCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, useErr := UseTyped[*User](db, "testCol")
+	if useErr != nil {
+		t.Error(useErr)
+		return
+	}
+	if err := setIndexes(c.Collection); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:20]
+	for _, user := range users {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	user, getErr := c.Get(users[3].ID)
+	if getErr != nil {
+		t.Error(getErr)
+		return
+	}
+	if user.Email != users[3].Email || user.Address.City != users[3].Address.City {
+		t.Errorf("expected %v but had %v", users[3], user)
+		return
+	}
+
+	results, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(users[5].Email)))
+	if queryErr != nil {
+		t.Error(queryErr)
+		return
+	}
+	if len(results) != 1 || results[0].ID != users[5].ID {
+		t.Errorf("expected the user %q but had %v", users[5].ID, results)
+		return
+	}
+
+	if _, err := c.Get("not found"); err == nil {
+		t.Errorf("expected an error")
+		return
+	}
+}
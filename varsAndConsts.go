@@ -17,6 +17,36 @@ var (
 	DefaultQueryLimit         = 100
 	DefaultInternalQueryLimit = 1000
 
+	DefaultCompactionCheckInterval = time.Minute
+	DefaultCompactionDiscardRatio  = 0.5
+	DefaultDiskSpaceCheckInterval  = time.Second
+	DefaultSubscriptionBufferSize  = 100
+	DefaultExpirationCheckInterval = time.Second * 10
+	DefaultStatsInterval           = time.Second * 30
+
+	DefaultHistoryDepth                  = 9
+	DefaultHistoryRetentionCheckInterval = time.Hour
+
+	DefaultAsyncQueueSize = 1000
+
+	// ReplicationPollInterval is the time the primary waits for new changes when the replica is up to date
+	ReplicationPollInterval = time.Millisecond * 100
+	// ReplicationHeartbeatInterval is the time between two messages of the primary to an up to date replica.
+	// The replica reconnects if it receives nothing for three intervals.
+	ReplicationHeartbeatInterval = time.Second
+	// ReplicationRetryInterval is the time the replica waits before connecting again to the primary
+	ReplicationRetryInterval = time.Second
+
+	DefaultWorkerPoolSize  = 2
+	DefaultWorkerQueueSize = 100
+
+	DefaultRetryMaxAttempts    = 3
+	DefaultRetryInitialBackoff = time.Millisecond * 10
+
+	// DefaultTxRetryMaxAttempts is the number of times RunInTransaction runs the function
+	// with DefaultTxRetryPolicy, every conflict gives one more transaction the time to commit
+	DefaultTxRetryMaxAttempts = 10
+
 	DefaultBadgerOptions = &badger.Options{
 		DoNotCompact:        false,
 		LevelOneSize:        256 << 20,
@@ -41,6 +71,19 @@ var (
 	}
 
 	DefaultBoltOptions = bolt.DefaultOptions
+
+	// DefaultRetryPolicy is a retry policy which can be set to Options.Retry
+	DefaultRetryPolicy = &RetryPolicy{
+		MaxAttempts:    DefaultRetryMaxAttempts,
+		InitialBackoff: DefaultRetryInitialBackoff,
+		MaxBackoff:     time.Millisecond * 100,
+	}
+	// DefaultTxRetryPolicy is the retry policy of RunInTransaction if Options.TxRetry is nil
+	DefaultTxRetryPolicy = &RetryPolicy{
+		MaxAttempts:    DefaultTxRetryMaxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond * 50,
+	}
 )
 
 // NewDefaultOptions build default options with a path
@@ -50,6 +93,7 @@ func NewDefaultOptions(path string) *Options {
 		TransactionTimeOut: DefaultTransactionTimeOut,
 		QueryTimeOut:       DefaultQueryTimeOut,
 		InternalQueryLimit: DefaultQueryLimit,
+		HistoryDepth:       DefaultHistoryDepth,
 
 		BadgerOptions: DefaultBadgerOptions,
 		BoltOptions:   DefaultBoltOptions,
@@ -57,6 +101,45 @@ func NewDefaultOptions(path string) *Options {
 }
 
 var (
+	// QuotaWarningRatio is the part of a quota over which EventQuotaWarning is sent
+	QuotaWarningRatio = 0.9
+	// DefaultEventBufferSize is the number of events kept by an EventSubscription before they are dropped
+	DefaultEventBufferSize = 100
+
+	// NopLogger discards the logs, like in the tests
+	NopLogger Logger = nopLogger{}
+
+	// MetricsNamespace is the prefix of the names of the metrics
+	MetricsNamespace = "gotinydb"
+	// TreeSeparator is the separator of the parent and child IDs used by the subtree functions
+	TreeSeparator = "/"
+	// SubtreeBatchSize is the number of writes done in one transaction by the subtree functions
+	SubtreeBatchSize = 1000
+	// DeleteManyBatchSize is the number of deletes done in one transaction by DeleteMany
+	DeleteManyBatchSize = 1000
+	// UpdateManyBatchSize is the number of writes done in one transaction by UpdateMany
+	UpdateManyBatchSize = 1000
+	// CopyBatchSize is the number of writes done in one transaction by CopyCollection
+	CopyBatchSize = 1000
+	// AsyncWriteBatchSize is the maximum number of writes of PutAsync saved in one transaction
+	AsyncWriteBatchSize = 1000
+	// StreamChunkSize is the size of the chunks saved by PutReader
+	StreamChunkSize = 1 << 20
+	// CompactionChunkSize is the maximum size of the value log files if the compaction
+	// has a maximum throughput. A file is rewritten at once, the pauses are done between them.
+	CompactionChunkSize int64 = 64 << 20
+	// ImportBatchSize is the default number of documents saved by Collection.Import in one transaction
+	ImportBatchSize = 1000
+	// StreamDeleteBatchSize is the number of chunks removed in one transaction
+	StreamDeleteBatchSize = 1000
+	// EmptyTrashBatchSize is the number of soft deleted documents removed in one transaction by EmptyTrash
+	EmptyTrashBatchSize = 1000
+	// FetchBatchSize is the number of documents read with one iterator when the
+	// documents of a query are fetched
+	FetchBatchSize = 256
+	// ParquetRowGroupSize is the number of documents of a row group of ExportParquet
+	ParquetRowGroupSize = 10000
+
 	// FilePermission defines the database file permission
 	FilePermission os.FileMode = 0740 // u -> rwx | g -> r-- | o -> ---
 
@@ -64,12 +147,110 @@ var (
 	ErrWrongType = fmt.Errorf("wrong type")
 	// ErrNotFound defines error when the asked ID is not found
 	ErrNotFound = fmt.Errorf("not found")
+	// ErrCollectionName defines the error when a collection name holds the separator of the hash shards
+	ErrCollectionName = fmt.Errorf("the collection names can't hold %q", hashShardSeparator)
 	// ErrEmptyID defines error when the given id is empty
 	ErrEmptyID = fmt.Errorf("empty ID")
 	// ErrTimeOut defines the error when the query is timed out
 	ErrTimeOut = fmt.Errorf("timed out")
 	// ErrDataCorrupted defines the error when the checksum is not valid
 	ErrDataCorrupted = fmt.Errorf("content corrupted")
+	// ErrDecryption defines the error when encrypted content can't be decrypted with the given key
+	ErrDecryption = fmt.Errorf("decryption failed")
+	// ErrBlindTokenRange defines the error when a range filter is used on a blind token index
+	ErrBlindTokenRange = fmt.Errorf("only equal filters are supported by blind token indexes")
+	// ErrHashIndexRange defines the error when a range filter is used on a hash index
+	ErrHashIndexRange = fmt.Errorf("only equal filters are supported by hash indexes")
+	// ErrMissingKey defines the error when encrypted content is found but no key is set
+	ErrMissingKey = fmt.Errorf("the content is encrypted but no key is set")
+	// ErrMissingEncryptionKey defines the error when an encrypted database is opened without EncryptionKey
+	ErrMissingEncryptionKey = fmt.Errorf("the database is encrypted but no EncryptionKey is set")
+	// ErrEncryptedIndexRange defines the error when a range filter is used on an index of an
+	// encrypted database, the order of the values is not saved
+	ErrEncryptedIndexRange = fmt.Errorf("only equal filters are supported by the indexes of an encrypted database")
+	// ErrEncryptedIndexDump defines the error when an index of an encrypted database is exported
+	// or imported, its keys can't be turned back into values
+	ErrEncryptedIndexDump = fmt.Errorf("the indexes of an encrypted database can't be exported or imported")
+	// ErrMissingBlindTokenKey defines the error when a blind token is needed but no BlindTokenKey is set
+	ErrMissingBlindTokenKey = fmt.Errorf("no blind token key is set")
+	// ErrMissingAnonymizationKey defines the error when values must be hashed but no AnonymizationKey is set
+	ErrMissingAnonymizationKey = fmt.Errorf("no anonymization key is set")
+	// ErrInvalidArgument defines the error when a required argument is nil or empty
+	ErrInvalidArgument = fmt.Errorf("invalid argument")
+	// ErrProcedureExists defines the error when a procedure is registered twice with the same name
+	ErrProcedureExists = fmt.Errorf("the procedure is already registered")
+
+	// ErrMaintenanceOngoing defines the error when a maintenance is started twice on the same collection
+	ErrMaintenanceOngoing = fmt.Errorf("a maintenance is already ongoing")
+
+	// ErrIDExists defines the error when PutIfAbsent is called with an ID already saved
+	ErrIDExists = fmt.Errorf("the ID already exists")
+	// ErrVersionMismatch defines the error when the saved version is not the expected one
+	ErrVersionMismatch = fmt.Errorf("the saved version is not the expected one")
+
+	// ErrConflict defines the error when a transaction is committed or PutRev is called and a content it read was updated since
+	ErrConflict = fmt.Errorf("the content was updated after it was read")
+
+	// ErrUnknownExportFormat defines the error when the format of the export is not supported
+	ErrUnknownExportFormat = fmt.Errorf("unknown export format")
+	// ErrUnknownImportFormat defines the error when the format of the import is not supported
+	ErrUnknownImportFormat = fmt.Errorf("unknown import format")
+	// ErrUnknownCodec defines the error when the codec is not registered
+	ErrUnknownCodec = fmt.Errorf("unknown codec")
+	// ErrCodecExists defines the error when an other codec is registered with the same ID
+	ErrCodecExists = fmt.Errorf("an other codec is registered with this ID")
+	// ErrNotEncodable defines the error when a content can't be read back the same from the format of a codec.
+	// The content is saved as it is.
+	ErrNotEncodable = fmt.Errorf("the content can't be encoded by the codec")
+
+	// ErrCollectionExists defines the error when a collection is renamed with the name of an other one
+	ErrCollectionExists = fmt.Errorf("a collection already has this name")
+
+	// ErrPostingListFull defines the error when an index with the OverflowReject strategy
+	// has already the maximum number of IDs for the value
+	ErrPostingListFull = fmt.Errorf("the index has too many IDs for this value")
+
+	// ErrHistoryTooShort defines the error when the versions of the asked time are not kept anymore
+	ErrHistoryTooShort = fmt.Errorf("the history does not have the versions of this time")
+
+	// ErrWriteOnce defines the error when an operation updating the documents is done on a write-once collection
+	ErrWriteOnce = fmt.Errorf("not supported by write-once collections")
+
+	// ErrShardExpired defines the error when a document is saved with a time older than the retention
+	ErrShardExpired = fmt.Errorf("the shard of this time is expired")
+	// ErrShardCount defines the error when a hash sharded collection is used with an other number of shards
+	ErrShardCount = fmt.Errorf("the collection is split into an other number of shards")
+
+	// ErrNotModified defines the error when the query result has the fingerprint given to QueryIfChanged
+	ErrNotModified = fmt.Errorf("not modified")
+
+	// ErrTxDone defines the error when a transaction is used after Commit or Rollback
+	ErrTxDone = fmt.Errorf("the transaction is already committed or rolled back")
+
+	// ErrClosed defines the error of the queued writes when the context of the database is done
+	ErrClosed = fmt.Errorf("the database is closed")
+
+	// ErrSnapshotClosed defines the error when a snapshot is used after Close
+	ErrSnapshotClosed = fmt.Errorf("the snapshot is closed")
+
+	// ErrQuotaExceeded defines the error when a put is done on a database or a collection over its quota
+	ErrQuotaExceeded = fmt.Errorf("the quota is exceeded")
+
+	// ErrSyncMergeMissing defines the error when DB.Sync is called with SyncMerge and no Merge function
+	ErrSyncMergeMissing = fmt.Errorf("SyncMerge needs the Merge function")
+
+	// ErrChangeLogDisabled defines the error when the change log is needed but Options.ChangeLogSize is zero
+	ErrChangeLogDisabled = fmt.Errorf("the change log is disabled")
+	// ErrReplicationTLSMissing defines the error when the replication is started without Options.ReplicationTLS
+	ErrReplicationTLSMissing = fmt.Errorf("the replication needs the ReplicationTLS of the options")
+	// ErrReplica defines the error when a write is done on a replica
+	ErrReplica = fmt.Errorf("the database is a read only replica")
+	// ErrReadOnly defines the error when a write is done on a database opened with Options.ReadOnly
+	ErrReadOnly = fmt.Errorf("the database is read only")
+	// ErrArchiveNotAttached defines the error when an archive is detached twice
+	ErrArchiveNotAttached = fmt.Errorf("the archive is not attached")
+	// ErrChangeLogTruncated defines the error when the changes asked by DB.Changes are not in the change log anymore
+	ErrChangeLogTruncated = fmt.Errorf("the changes are not in the change log anymore")
 
 	// ErrTheResponseIsOver defines error when *Response.One is called and all response has been returned
 	ErrTheResponseIsOver = fmt.Errorf("the response has no more values")
@@ -88,4 +269,126 @@ const (
 	StringIndex IndexType = iota
 	IntIndex
 	TimeIndex
+	// HashIndex saves a fixed size hash of the string values.
+	// It only supports Equal filters.
+	HashIndex
+)
+
+// Those define the strategies of the indexes when the list of IDs of a value is full
+const (
+	// OverflowSegment saves the full list as a segment and starts a new one.
+	// A write only saves the last segment again.
+	OverflowSegment OverflowStrategy = iota
+	// OverflowReject returns ErrPostingListFull and the write is not done
+	OverflowReject
 )
+
+// Those define the types of the lifecycle events
+const (
+	EventCollectionCreated  EventType = "collectionCreated"
+	EventIndexBuilt         EventType = "indexBuilt"
+	EventCompactionStarted  EventType = "compactionStarted"
+	EventCompactionFinished EventType = "compactionFinished"
+	EventBackupCompleted    EventType = "backupCompleted"
+	EventBackupFailed       EventType = "backupFailed"
+	// EventQuotaWarning is sent when the size read again is over QuotaWarningRatio of the quota
+	EventQuotaWarning EventType = "quotaWarning"
+)
+
+// Those define the levels of the logs
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+// Those define the periods of the shards of a ShardedCollection
+const (
+	ShardDaily   ShardPeriod = "daily"
+	ShardMonthly ShardPeriod = "monthly"
+)
+
+// Those define the transformations of the exported values
+const (
+	// AnonymizeHash replaces the value by the hexadecimal HMAC-SHA256 of its JSON encoding,
+	// keyed by the AnonymizationKey of the options. The same values have the same hashes.
+	AnonymizeHash Anonymization = "hash"
+	// AnonymizeRedact replaces the value by null
+	AnonymizeRedact Anonymization = "redact"
+	// AnonymizeMonth replaces the date by the first day of its month
+	AnonymizeMonth Anonymization = "month"
+	// AnonymizeYear replaces the date by the first day of its year
+	AnonymizeYear Anonymization = "year"
+)
+
+// Those define the formats of Collection.Export
+const (
+	// ExportJSONLines writes one JSON object by line
+	ExportJSONLines ExportFormat = "jsonl"
+	// ExportCSV writes the values of the columns as comma separated values
+	ExportCSV ExportFormat = "csv"
+)
+
+// Those define the formats of Collection.Import
+const (
+	// ImportJSONLines reads one JSON object by line
+	ImportJSONLines ImportFormat = "jsonl"
+	// ImportCSV reads the documents from comma separated values
+	ImportCSV ImportFormat = "csv"
+)
+
+// Those define what Collection.Import does with the IDs which are already saved
+const (
+	// ImportOverwrite replaces the saved documents
+	ImportOverwrite ImportConflict = iota
+	// ImportSkip keeps the saved documents
+	ImportSkip
+	// ImportFail stops the import with ErrIDExists
+	ImportFail
+)
+
+// Those define how DB.Sync resolves the conflicting writes
+const (
+	// SyncLastWriterWins keeps the version written last, or the one of the highest peer ID at the same time
+	SyncLastWriterWins SyncStrategy = iota
+	// SyncKeepBoth keeps the version written last and saves the other one under
+	// its ID followed by SyncConflictSeparator and the ID of its peer.
+	// The deleted versions are not kept.
+	SyncKeepBoth
+	// SyncMerge saves the content returned by SyncOptions.Merge
+	SyncMerge
+)
+
+// SyncConflictSeparator separates the ID and the peer ID of the versions kept by SyncKeepBoth
+const SyncConflictSeparator = "~conflict~"
+
+// Those define the kinds of write sent to the watchers
+const (
+	ChangePut      ChangeOperation = "put"
+	ChangeDelete   ChangeOperation = "delete"
+	ChangeRollback ChangeOperation = "rollback"
+)
+
+// timedValueFlag is set in the meta of the values starting with the time of the write.
+// The other bits are the ID of the codec and binaryValueFlag.
+const timedValueFlag byte = 0x80
+
+// binaryValueFlag is set in the meta of the values saved from bytes, they are not documents
+const binaryValueFlag byte = 0x40
+
+// FlateCodecID is the ID of the codec built with NewFlateCodec.
+// The IDs up to 15 are reserved for the codecs of the package.
+const FlateCodecID byte = 1
+
+// MsgpackCodecID is the ID of the codec built with NewMsgpackCodec
+const MsgpackCodecID byte = 2
+
+// CBORCodecID is the ID of the codec built with NewCBORCodec
+const CBORCodecID byte = 3
+
+// ProtobufCodecID is the ID of the codec built with NewProtobufCodec
+const ProtobufCodecID byte = 4
+
+// GobCodecID is the ID of the codec built with NewGobCodec
+const GobCodecID byte = 5
@@ -0,0 +1,223 @@
+package gotinydb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watch returns a channel receiving the changes of the collection until the context is done.
+// The channel is closed after that. The options can be nil.
+// The events are sent after the writes are committed, in the order of the writes.
+func (c *Collection) Watch(ctx context.Context, options *WatchOptions) (<-chan *ChangeEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if options == nil {
+		options = new(WatchOptions)
+	}
+	bufferSize := options.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultSubscriptionBufferSize
+	}
+
+	w := &watcher{
+		ctx:     ctx,
+		options: options,
+		events:  make(chan *ChangeEvent, bufferSize),
+	}
+	w.pendingCond = sync.NewCond(&w.pendingMutex)
+
+	c.subscriptionsMutex.Lock()
+	c.watchers = append(c.watchers, w)
+	c.subscriptionsMutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		c.subscriptionsMutex.Lock()
+		for i, registered := range c.watchers {
+			if registered == w {
+				copy(c.watchers[i:], c.watchers[i+1:])
+				c.watchers[len(c.watchers)-1] = nil
+				c.watchers = c.watchers[:len(c.watchers)-1]
+				break
+			}
+		}
+		c.subscriptionsMutex.Unlock()
+
+		// The notifications started before the removal stop with the context
+		w.sending.Wait()
+		// The deliveries which have not started are dropped
+		w.pendingMutex.Lock()
+		w.closed = true
+		for w.running {
+			w.pendingCond.Wait()
+		}
+		w.pending = nil
+		w.pendingMutex.Unlock()
+		close(w.events)
+	}()
+
+	return w.events, nil
+}
+
+// notifyChange sends the change to the subscriptions and the watchers.
+// A nil content means that the document is not saved anymore.
+func (c *Collection) notifyChange(operation ChangeOperation, id string, previous, content []byte, bin bool) {
+	// The binary contents are not documents for the subscriptions
+	if bin {
+		c.notifySubscriptions(id, previous, nil)
+	} else {
+		c.notifySubscriptions(id, previous, content)
+	}
+
+	// The lock is not held during the sends, a blocking watcher would stop the other writes
+	c.subscriptionsMutex.RLock()
+	watchers := make([]*watcher, len(c.watchers))
+	for i, w := range c.watchers {
+		w.sending.Add(1)
+		watchers[i] = w
+	}
+	c.subscriptionsMutex.RUnlock()
+
+	if len(watchers) == 0 {
+		return
+	}
+
+	event := &ChangeEvent{
+		Operation: operation,
+		ID:        id,
+		Previous:  previous,
+		Content:   content,
+		Time:      time.Now(),
+	}
+
+	// The binary contents are not documents for the queries and the filters
+	document := content
+	if bin {
+		document = nil
+	}
+
+	for _, w := range watchers {
+		if strings.HasPrefix(id, w.options.Prefix) {
+			w := w
+			w.push(c.workers, func() {
+				if w.matchDocuments(c, id, previous, document) {
+					w.send(event)
+				}
+			})
+		}
+		w.sending.Done()
+	}
+}
+
+// push queues the delivery of an event and starts a job of the workers to run the
+// deliveries if none is queued or running. If the queue of the workers is full the
+// deliveries run in the calling goroutine.
+// The writes wait for the blocking watchers when more than the size of the channel
+// is waiting to be delivered. They run the deliveries if the job has not started.
+func (w *watcher) push(workers *workerPool, delivery func()) {
+	w.pendingMutex.Lock()
+	if w.closed {
+		w.pendingMutex.Unlock()
+		return
+	}
+	w.pending = append(w.pending, delivery)
+
+	for w.options.Block && len(w.pending) > cap(w.events) && !w.closed && w.ctx.Err() == nil {
+		if w.running {
+			w.pendingCond.Wait()
+			continue
+		}
+		w.running = true
+		w.pendingMutex.Unlock()
+		w.deliver()
+		w.pendingMutex.Lock()
+	}
+
+	if w.scheduled || w.running {
+		w.pendingMutex.Unlock()
+		return
+	}
+	w.scheduled = true
+	w.pendingMutex.Unlock()
+
+	if !workers.trySubmit(w.startDelivery) {
+		w.startDelivery()
+	}
+}
+
+// startDelivery runs the pending deliveries unless an other goroutine already does
+func (w *watcher) startDelivery() {
+	w.pendingMutex.Lock()
+	w.scheduled = false
+	if w.running || w.closed {
+		w.pendingMutex.Unlock()
+		return
+	}
+	w.running = true
+	w.pendingMutex.Unlock()
+
+	w.deliver()
+}
+
+// deliver runs the pending deliveries in their order until there are no more.
+// running must be set by the caller, it is unset at the end.
+func (w *watcher) deliver() {
+	for {
+		w.pendingMutex.Lock()
+		if len(w.pending) == 0 {
+			w.running = false
+			w.pendingCond.Broadcast()
+			w.pendingMutex.Unlock()
+			return
+		}
+		delivery := w.pending[0]
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+		w.pendingCond.Broadcast()
+		w.pendingMutex.Unlock()
+
+		delivery()
+	}
+}
+
+// send gives the event to the watcher, it waits for a free place only if the watcher blocks
+func (w *watcher) send(event *ChangeEvent) {
+	if w.options.Block {
+		select {
+		case w.events <- event:
+		case <-w.ctx.Done():
+		}
+		return
+	}
+
+	select {
+	case w.events <- event:
+	default:
+	}
+}
+
+// matchDocuments returns if the query and the filter of the options select
+// the previous or the new version of the document
+func (w *watcher) matchDocuments(c *Collection, id string, previous, content []byte) bool {
+	filter := w.options.Filter
+	if !filter.matchID(c.name, id) {
+		return false
+	}
+
+	for _, document := range [][]byte{previous, content} {
+		if document == nil && (w.options.Query != nil || filter != nil && (len(filter.Selector) != 0 || len(filter.Filters) != 0)) {
+			continue
+		}
+		if w.options.Query != nil && !c.matchQuery(w.options.Query, document) {
+			continue
+		}
+		if filter.matchContent(document) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package gotinydb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	events, watchErr := c.Watch(watchCtx, &WatchOptions{Prefix: "user/", Block: true})
+	if watchErr != nil {
+		t.Error(watchErr)
+		return
+	}
+
+	c.Put("other", map[string]int{"V": 0})
+	c.Put("user/1", map[string]int{"V": 1})
+	c.Put("user/1", map[string]int{"V": 2})
+	if _, err := c.Rollback("user/1", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	batch := c.NewBatch()
+	batch.Put("user/2", []byte("bin"))
+	batch.Delete("user/1")
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+	c.Delete("user/2")
+	// Nothing is sent for the IDs which are not saved
+	c.Delete("user/3")
+
+	expected := []struct {
+		operation         ChangeOperation
+		id                string
+		previous, content string
+	}{
+		{ChangePut, "user/1", "", `{"V":1}`},
+		{ChangePut, "user/1", `{"V":1}`, `{"V":2}`},
+		{ChangeRollback, "user/1", `{"V":2}`, `{"V":1}`},
+		{ChangePut, "user/2", "", "bin"},
+		{ChangeDelete, "user/1", `{"V":1}`, ""},
+		{ChangeDelete, "user/2", "bin", ""},
+	}
+
+	for i, e := range expected {
+		select {
+		case event := <-events:
+			if event.Operation != e.operation || event.ID != e.id || string(event.Previous) != e.previous || string(event.Content) != e.content || event.Time.IsZero() {
+				t.Errorf("event %d: expected %v but had %+v", i, e, event)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Errorf("event %d not received", i)
+			return
+		}
+	}
+
+	stopWatching()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Errorf("unexpected event %+v", event)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the channel is not closed")
+		return
+	}
+
+	if _, err := c.Watch(watchCtx, nil); err == nil {
+		t.Errorf("expected an error with a done context")
+		return
+	}
+}
+
+func TestWatchFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := c.SetIndex("v", IntIndex, "V"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	byTenant, err := c.Watch(ctx, &WatchOptions{Block: true, Filter: &ChangeFilter{Selector: []string{"Tenant"}, Values: []interface{}{"a"}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	byQuery, err := c.Watch(ctx, &WatchOptions{Block: true, Query: NewQuery().SetFilter(NewFilter(Greater).SetSelector("V").CompareTo(10))})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	byFilters, err := c.Watch(ctx, &WatchOptions{Block: true, Filter: &ChangeFilter{Filters: []*Filter{
+		NewFilter(Between).SetSelector("V").CompareTo(5).CompareTo(25),
+		NewFilter(Equal).SetSelector("Tags").CompareTo("x"),
+	}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	c.Put("1", map[string]interface{}{"Tenant": "a", "V": 1, "Tags": []string{"x"}})
+	c.Put("2", map[string]interface{}{"Tenant": "b", "V": 20, "Tags": []string{"y", "x"}})
+	// The document leaves the tenant and enters the query
+	c.Put("1", map[string]interface{}{"Tenant": "b", "V": 30})
+	c.Delete("2")
+
+	for _, test := range []struct {
+		events <-chan *ChangeEvent
+		ids    []string
+	}{
+		{byTenant, []string{"1", "1"}},
+		{byQuery, []string{"2", "1", "2"}},
+		{byFilters, []string{"2", "2"}},
+	} {
+		for i, id := range test.ids {
+			select {
+			case event := <-test.events:
+				if event.ID != id {
+					t.Errorf("event %d: expected %q but had %+v", i, id, event)
+					return
+				}
+			case <-time.After(time.Second):
+				t.Errorf("event %d not received", i)
+				return
+			}
+		}
+		select {
+		case event := <-test.events:
+			t.Errorf("unexpected event %+v", event)
+			return
+		default:
+		}
+	}
+}
+
+func TestWatchBlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+
+	blockedCtx, stopBlocked := context.WithCancel(ctx)
+	defer stopBlocked()
+	if _, err := c.Watch(blockedCtx, &WatchOptions{Block: true, BufferSize: 1}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The first event fills the buffer, the second one waits to be sent and the third
+	// one is queued. The fourth write waits for the watcher.
+	for i := 1; i <= 3; i++ {
+		c.Put(fmt.Sprint(i), map[string]int{"V": i})
+	}
+	written := make(chan error, 1)
+	go func() {
+		written <- c.Put("4", map[string]int{"V": 4})
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// The waiting write does not hold the lock of the watchers
+	watched := make(chan struct{})
+	go func() {
+		c.Watch(ctx, nil)
+		close(watched)
+	}()
+	select {
+	case <-watched:
+	case <-time.After(time.Second):
+		t.Errorf("Watch is blocked by the write waiting for an other watcher")
+		return
+	}
+
+	stopBlocked()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the write still waits after the end of the watcher")
+		return
+	}
+}
@@ -0,0 +1,130 @@
+package gotinydb
+
+import (
+	"context"
+
+	"github.com/boltdb/bolt"
+	"github.com/dgraph-io/badger"
+)
+
+// SetWriteOnce defines that the documents of the collection are never updated,
+// like the events of an append only collection. A Put is then one write into
+// the store, without the previous versions, and the ID added to the indexes.
+// The references of the documents to their indexed values are not saved.
+//
+// A Put with an ID already saved returns ErrIDExists. The deleted documents are
+// removed from the indexes with the values of their content. PutWithTTL and Rollback
+// return ErrWriteOnce. The setting is saved with the collection.
+func (c *Collection) SetWriteOnce(writeOnce bool) error {
+	value := []byte{0}
+	if writeOnce {
+		value[0] = 1
+	}
+
+	if err := c.updateIndex(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("config")).Put([]byte("writeOnce"), value)
+	}); err != nil {
+		return err
+	}
+
+	c.writeOnceMutex.Lock()
+	c.writeOnce = writeOnce
+	c.writeOnceMutex.Unlock()
+	return nil
+}
+
+// IsWriteOnce returns true if the documents of the collection can't be updated
+func (c *Collection) IsWriteOnce() bool {
+	c.writeOnceMutex.RLock()
+	defer c.writeOnceMutex.RUnlock()
+	return c.writeOnce
+}
+
+func (c *Collection) loadWriteOnce() {
+	writeOnce := false
+	c.viewIndex(func(tx *bolt.Tx) error {
+		saved := tx.Bucket([]byte("config")).Get([]byte("writeOnce"))
+		writeOnce = len(saved) == 1 && saved[0] == 1
+		return nil
+	})
+
+	c.writeOnceMutex.Lock()
+	c.writeOnce = writeOnce
+	c.writeOnceMutex.Unlock()
+}
+
+// writeOnceCondition returns ErrIDExists if the ID is saved and calls next if any
+func (c *Collection) writeOnceCondition(id string, next func() error) func() error {
+	return func() error {
+		version, err := c.getVersion(id)
+		if err != nil {
+			return err
+		}
+		if version != 0 {
+			return ErrIDExists
+		}
+
+		if next != nil {
+			return next()
+		}
+		return nil
+	}
+}
+
+// writeOnceContent returns the saved content of the ID if the collection is write-once.
+// It is read before a delete to know the values to remove from the indexes.
+func (c *Collection) writeOnceContent(ctx context.Context, id string) []byte {
+	if !c.IsWriteOnce() {
+		return nil
+	}
+
+	contents, err := c.get(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return contents[0]
+}
+
+// indexWriteOnce adds the ID to the indexes without saving the references
+func (c *Collection) indexWriteOnce(tx *bolt.Tx, id string, contentInterface interface{}, contentAsBytes []byte) error {
+	for _, index := range c.indexes {
+		if indexedValue, apply := index.applyToDocument(contentInterface, contentAsBytes); apply {
+			if err := index.addToPosting(tx, index.storageKey(indexedValue), id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unindexContent removes the ID from the indexes with the values of the given content
+func (c *Collection) unindexContent(tx *bolt.Tx, id string, contentAsBytes []byte) error {
+	object, _ := decodeStored(contentAsBytes)
+	for _, index := range c.indexes {
+		candidates, apply := index.applyToStored(object, contentAsBytes)
+		if !apply {
+			continue
+		}
+
+		for _, candidate := range candidates {
+			if err := index.rmFromPosting(tx, index.storageKey(candidate), id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkAbsent returns ErrIDExists if the key is saved in the transaction
+func checkAbsent(txn *badger.Txn, storeID []byte) error {
+	item, err := txn.Get(storeID)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !item.IsDeletedOrExpired() {
+		return ErrIDExists
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package gotinydb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestWriteOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPath := <-getTestPathChan
+	defer os.RemoveAll(testPath)
+	db, openDBErr := Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	defer db.Close()
+
+	c, _ := db.Use("testCol")
+	if err := setIndexes(c); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SetWriteOnce(true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := unmarshalDataSet(dataSet1)[:20]
+	for _, user := range users[:10] {
+		if err := c.Put(user.ID, user); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	batch := c.NewBatch()
+	for _, user := range users[10:] {
+		batch.Put(user.ID, user)
+	}
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The documents can't be updated
+	if err := c.Put(users[0].ID, users[1]); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+	batch.Put(users[10].ID, users[1])
+	if err := batch.Write(); err != ErrIDExists {
+		t.Errorf("expected %v but had %v", ErrIDExists, err)
+		return
+	}
+	if err := c.PutWithTTL("ttl", users[0], time.Hour); err != ErrWriteOnce {
+		t.Errorf("expected %v but had %v", ErrWriteOnce, err)
+		return
+	}
+
+	// No reference is saved
+	c.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("refs")).Stats().KeyN; n != 0 {
+			t.Errorf("expected no reference but had %d", n)
+		}
+		return nil
+	})
+
+	if err := c.Delete(users[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := c.SoftDelete(users[1].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	batch = c.NewBatch()
+	batch.Delete(users[2].ID)
+	if err := batch.Write(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, user := range users[:4] {
+		response, queryErr := c.Query(NewQuery().SetFilter(NewFilter(Equal).SetSelector("Email").CompareTo(user.Email)))
+		if queryErr != nil {
+			t.Error(queryErr)
+			return
+		}
+		if i < 3 && response.Len() != 0 {
+			t.Errorf("the deleted document %q is still indexed", user.ID)
+			return
+		} else if i == 3 && response.Len() != 1 {
+			t.Errorf("the document %q is not indexed", user.ID)
+			return
+		}
+	}
+
+	// A deleted ID can be saved again
+	if err := c.Put(users[0].ID, users[0]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, verifyErr := c.VerifyIndexes()
+	if verifyErr != nil {
+		t.Error(verifyErr)
+		return
+	}
+	if !report.OK() {
+		t.Errorf("the indexes are not valid: %+v", report)
+		return
+	}
+
+	// The setting is saved
+	db.Close()
+	db, openDBErr = Open(ctx, NewDefaultOptions(testPath))
+	if openDBErr != nil {
+		t.Error(openDBErr)
+		return
+	}
+	c, _ = db.Use("testCol")
+	if !c.IsWriteOnce() {
+		t.Errorf("the collection is not write-once after the opening")
+		return
+	}
+	if _, err := c.Rollback(users[3].ID, 0); err != ErrWriteOnce {
+		t.Errorf("expected %v but had %v", ErrWriteOnce, err)
+		return
+	}
+}
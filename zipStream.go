@@ -0,0 +1,239 @@
+package gotinydb
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// The signatures of the records of a zip archive
+const (
+	zipLocalHeaderSignature     = 0x04034b50
+	zipDataDescriptorSignature  = 0x08074b50
+	zipCentralHeaderSignature   = 0x02014b50
+	zipDirectory64EndSignature  = 0x06064b50
+	zipDirectory64LocSignature  = 0x07064b50
+	zipDirectoryEndSignature    = 0x06054b50
+	zipDataDescriptorFlag       = 0x8
+	zipMaxUint32                = 1<<32 - 1
+	zipDirectoryEntriesOverflow = 1<<16 - 1
+)
+
+// readZipStream reads the zip archive from r in one pass and gives the content of its files
+// to fn in their order. The checksum and the sizes of every file are verified after fn returns,
+// then the central directory at the end is checked against the files read.
+// The files with their sizes after the content need to be compressed to find their end,
+// which is the case of the files created by zip.Writer.
+func readZipStream(r io.Reader, fn func(name string, content io.Reader) error) error {
+	br := &byteCounter{r: bufio.NewReader(r)}
+
+	files := []*zipStreamFile{}
+	directoryEntries := 0
+	for {
+		signature, err := readZipUint32(br)
+		if err != nil {
+			return err
+		}
+
+		switch signature {
+		case zipLocalHeaderSignature:
+			if directoryEntries != 0 {
+				return zip.ErrFormat
+			}
+			file, err := readZipStreamFile(br, fn)
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+		case zipCentralHeaderSignature:
+			if directoryEntries >= len(files) {
+				return zip.ErrFormat
+			}
+			if err := checkZipDirectoryHeader(br, files[directoryEntries]); err != nil {
+				return err
+			}
+			directoryEntries++
+		case zipDirectory64EndSignature:
+			var size uint64
+			if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+				return zipUnexpectedEOF(err)
+			}
+			if err := skipZipBytes(br, int64(size)); err != nil {
+				return err
+			}
+		case zipDirectory64LocSignature:
+			if err := skipZipBytes(br, 16); err != nil {
+				return err
+			}
+		case zipDirectoryEndSignature:
+			end := make([]byte, 18)
+			if _, err := io.ReadFull(br, end); err != nil {
+				return zipUnexpectedEOF(err)
+			}
+			entries := int(binary.LittleEndian.Uint16(end[6:]))
+			if directoryEntries != len(files) || (entries != len(files) && entries != zipDirectoryEntriesOverflow) {
+				return fmt.Errorf("the zip directory has %d files but %d have been read", entries, len(files))
+			}
+			return skipZipBytes(br, int64(binary.LittleEndian.Uint16(end[16:])))
+		default:
+			return zip.ErrFormat
+		}
+	}
+}
+
+// readZipStreamFile reads the file following the local header signature
+func readZipStreamFile(br *byteCounter, fn func(name string, content io.Reader) error) (*zipStreamFile, error) {
+	header := make([]byte, 26)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, zipUnexpectedEOF(err)
+	}
+	flags := binary.LittleEndian.Uint16(header[2:])
+	method := binary.LittleEndian.Uint16(header[4:])
+	crc := binary.LittleEndian.Uint32(header[10:])
+	compressedSize := uint64(binary.LittleEndian.Uint32(header[14:]))
+	size := uint64(binary.LittleEndian.Uint32(header[18:]))
+
+	name := make([]byte, binary.LittleEndian.Uint16(header[22:]))
+	if _, err := io.ReadFull(br, name); err != nil {
+		return nil, zipUnexpectedEOF(err)
+	}
+	if err := skipZipBytes(br, int64(binary.LittleEndian.Uint16(header[24:]))); err != nil {
+		return nil, err
+	}
+
+	hasDescriptor := flags&zipDataDescriptorFlag != 0
+	if hasDescriptor && method != zip.Deflate {
+		return nil, fmt.Errorf("the end of the zip file %q can't be found without its size", name)
+	}
+	if !hasDescriptor && (compressedSize == zipMaxUint32 || size == zipMaxUint32) {
+		return nil, fmt.Errorf("the zip file %q is too big to be read as a stream", name)
+	}
+
+	var raw io.Reader = br
+	if !hasDescriptor {
+		raw = io.LimitReader(br, int64(compressedSize))
+	}
+	start := br.n
+
+	content := &zipStreamContent{hash: crc32.NewIEEE()}
+	switch method {
+	case zip.Store:
+		content.r = raw
+	case zip.Deflate:
+		decompressor := flate.NewReader(raw)
+		defer decompressor.Close()
+		content.r = decompressor
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+
+	if err := fn(string(name), content); err != nil {
+		return nil, err
+	}
+	// The checksum is computed on all the content even if fn does not read it
+	if _, err := io.Copy(ioutil.Discard, content); err != nil {
+		return nil, zipUnexpectedEOF(err)
+	}
+	readCompressedSize := br.n - start
+
+	if hasDescriptor {
+		var err error
+		if crc, err = readZipUint32(br); err != nil {
+			return nil, err
+		}
+		// The signature of the descriptor is optional
+		if crc == zipDataDescriptorSignature {
+			if crc, err = readZipUint32(br); err != nil {
+				return nil, err
+			}
+		}
+
+		// The sizes are saved on 8 bytes if they do not fit on 4
+		sizes := []uint64{0, 0}
+		if readCompressedSize >= zipMaxUint32 || content.size >= zipMaxUint32 {
+			err = binary.Read(br, binary.LittleEndian, sizes)
+		} else {
+			shortSizes := []uint32{0, 0}
+			err = binary.Read(br, binary.LittleEndian, shortSizes)
+			sizes[0], sizes[1] = uint64(shortSizes[0]), uint64(shortSizes[1])
+		}
+		if err != nil {
+			return nil, zipUnexpectedEOF(err)
+		}
+		compressedSize, size = sizes[0], sizes[1]
+	}
+
+	if crc != content.hash.Sum32() || compressedSize != readCompressedSize || size != content.size {
+		return nil, zip.ErrChecksum
+	}
+	return &zipStreamFile{name: string(name), crc32: crc}, nil
+}
+
+// checkZipDirectoryHeader reads the header of the central directory following its
+// signature and checks it describes the given file
+func checkZipDirectoryHeader(br *byteCounter, file *zipStreamFile) error {
+	header := make([]byte, 42)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return zipUnexpectedEOF(err)
+	}
+
+	name := make([]byte, binary.LittleEndian.Uint16(header[24:]))
+	if _, err := io.ReadFull(br, name); err != nil {
+		return zipUnexpectedEOF(err)
+	}
+	if !bytes.Equal(name, []byte(file.name)) || binary.LittleEndian.Uint32(header[12:]) != file.crc32 {
+		return fmt.Errorf("the zip directory does not match the file %q", file.name)
+	}
+
+	extraAndComment := int64(binary.LittleEndian.Uint16(header[26:])) + int64(binary.LittleEndian.Uint16(header[28:]))
+	return skipZipBytes(br, extraAndComment)
+}
+
+func readZipUint32(r io.Reader) (uint32, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return 0, zipUnexpectedEOF(err)
+	}
+	return n, nil
+}
+
+func skipZipBytes(r io.Reader, n int64) error {
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	return zipUnexpectedEOF(err)
+}
+
+// zipUnexpectedEOF returns io.ErrUnexpectedEOF in place of io.EOF,
+// the archive can only end after the end of the directory
+func zipUnexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (c *zipStreamContent) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.size += uint64(n)
+	return n, err
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += uint64(n)
+	return n, err
+}
+
+func (b *byteCounter) ReadByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err == nil {
+		b.n++
+	}
+	return c, err
+}